@@ -1,4 +1,9 @@
-package main
+// Package cgivar converts between PASTA streams and the Complete
+// Genomics CGI-Var format, and reads masterVar.  CGIRefVar
+// implements pasta.RefVarPrinter.  The two are registered as the
+// "cgivar" and "mastervar" (reader only) formats.
+//
+package cgivar
 
 import "fmt"
 import "strconv"
@@ -14,6 +19,23 @@ import "github.com/abeconnelly/pasta"
 const PASTA_CGIVAR_SOFT_VER = "0.1.0"
 const PASTA_CGIVAR_FMT_VER_STR = "2.5"
 
+func init() {
+  pasta.RegisterFormat(pasta.Format{
+    Name: "cgivar",
+    Description: "Complete Genomics CGI-Var",
+    NewReader: func() pasta.VariantReader { g := &CGIRefVar{} ; g.Init() ; return g },
+    NewWriter: func() pasta.VariantWriter { g := &CGIRefVar{} ; g.Init() ; return g },
+  })
+
+  // masterVar can only be read
+  //
+  pasta.RegisterFormat(pasta.Format{
+    Name: "mastervar",
+    Description: "Complete Genomics masterVar",
+    NewReader: func() pasta.VariantReader { m := &MasterVarRefVar{} ; m.Init() ; return m },
+  })
+}
+
 //var __g_debug bool = false
 
 type CGIRefVar struct {
@@ -54,6 +76,27 @@ type CGIRefVar struct {
 
   InitState bool
   CGIVarLine string
+
+  // Annotation message body to write out ahead of the
+  // current locus, if AnnotUpdate is set.
+  //
+  Annot string
+  AnnotUpdate bool
+
+  // Annotation in effect on the stream being printed,
+  // used to fill in the score columns.
+  //
+  VarAnnot map[string]string
+
+  // Last hapLink identifier handed out and the identifier
+  // in use for each strand (0 if none yet).
+  //
+  HapLinkId int
+  HapLinkBlock []int
+
+  // Phase set of the last variant printed.
+  //
+  PhaseSet int
 }
 
 func (g *CGIRefVar) Init() {
@@ -88,11 +131,16 @@ func (g *CGIRefVar) Init() {
 
   g.InitState = true
   g.LCounter = 0
+
+  g.VarAnnot = nil
+  g.HapLinkId = 0
+  g.HapLinkBlock = []int{0,0}
+  g.PhaseSet = 0
 }
 
 func (g *CGIRefVar) Chrom(chrom string) {
+  if chrom != g.ChromStr { g.ChromUpdate = true }
   g.ChromStr = chrom
-  g.ChromUpdate = true
 }
 
 func (g *CGIRefVar) Pos(pos int) {
@@ -100,6 +148,8 @@ func (g *CGIRefVar) Pos(pos int) {
   g.CGIVarRefPos = pos
 }
 
+func (g *CGIRefVar) GetRefPos() int { return g.CGIVarRefPos }
+
 func (g *CGIRefVar) Header(out *bufio.Writer) error {
   var header = []string{}
 
@@ -141,21 +191,80 @@ func (g *CGIRefVar) _strip_seqs(refseq []byte, altseq [][]byte) ([]byte, [][]byt
 }
 
 
-func (g *CGIRefVar) PrintAltAlleles(vartype int, ref_start, ref_len int, refseq []byte, altseq [][]byte, out *bufio.Writer) error {
+// Value of a score column from the annotation.  Allele lines
+// take the allele level value (e.g. "allele2VarScoreVAF") if
+// present, falling back to the locus level value (e.g. "varScoreVAF").
+// Lines for both alleles fall back to the first allele's value.
+//
+func (g *CGIRefVar) _annot_field(allele_str string, key string) string {
+  if g.VarAnnot==nil { return "" }
 
+  allele_key := "allele" + allele_str + strings.ToUpper(key[:1]) + key[1:]
+  if allele_str=="all" { allele_key = "allele1" + strings.ToUpper(key[:1]) + key[1:] }
+
+  if v,ok := g.VarAnnot[allele_key] ; ok && (allele_str!="all") { return v }
+  if v,ok := g.VarAnnot[key] ; ok { return v }
+  if v,ok := g.VarAnnot[allele_key] ; ok { return v }
+  return ""
+}
+
+// hapLink identifier for the allele.  Alleles on the same
+// strand share an identifier until the chromosome or phase
+// set changes.
+//
+func (g *CGIRefVar) _haplink(strand int) string {
+  if g.HapLinkBlock[strand]==0 {
+    g.HapLinkId++
+    g.HapLinkBlock[strand] = g.HapLinkId
+  }
+  return fmt.Sprintf("%d", g.HapLinkBlock[strand])
+}
+
+// Write a single CGI-Var line.  Score columns are filled in from the
+// annotation for called lines, with `varfilter` used if the annotation
+// has none.
+//
+func (g *CGIRefVar) _write_line(allele_str string, beg, end int, vartype_str, refseq, alleleseq, varfilter, haplink string, out *bufio.Writer) {
   varscorevaf := ""
   varscoreeaf := ""
-  varfilter := ""
-  haplink := ""
   xref := ""
   allelefreq := ""
   altcalls := ""
 
+  if (vartype_str!="no-call") && (vartype_str!="no-ref") {
+    varscorevaf = g._annot_field(allele_str, "varScoreVAF")
+    varscoreeaf = g._annot_field(allele_str, "varScoreEAF")
+    if f := g._annot_field(allele_str, "varFilter") ; len(f)>0 { varfilter = f }
+  }
+
+  //                           0   1   2   3   4   5   6   7   8   9   10  11  12  13  14  15
+  out.WriteString(fmt.Sprintf("%d\t%d\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+    g.Locus, g.Ploidy, allele_str, g.ChromStr,
+    beg, end,
+    vartype_str,
+    refseq, alleleseq,
+    varscorevaf, varscoreeaf, varfilter, haplink, xref, allelefreq, altcalls))
+}
+
+func (g *CGIRefVar) PrintAltAlleles(vartype int, ref_start, ref_len int, refseq []byte, altseq [][]byte, out *bufio.Writer) error {
+
+  varfilter := ""
+
   ref,alt := g._strip_seqs(refseq, altseq)
 
+  // Alleles of heterozygous loci in a phase set ("PS") are
+  // linked to the others on their strand.  Without a phase set
+  // there's nothing to say the alleles are phased.
+  //
+  _,phased := g.VarAnnot["PS"]
+  het := (len(alt)==2) && (string(alt[0])!=string(alt[1]))
+
   for strand:=0; strand<len(alt); strand++ {
     allele_str := fmt.Sprintf("%d", strand+1)
 
+    haplink := ""
+    if het && phased { haplink = g._haplink(strand) }
+
     cur_start := 0
     min_len := len(ref)
     if min_len > len(alt[strand]) { min_len = len(alt[strand]) }
@@ -170,14 +279,11 @@ func (g *CGIRefVar) PrintAltAlleles(vartype int, ref_start, ref_len int, refseq
 
       if noc_pfx_len>0 {
 
-        //                           0   1   2   3   4   5   6   7   8   9   10  11  12  13  14  15
-        out.WriteString(fmt.Sprintf("%d\t%d\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-          g.Locus, g.Ploidy, allele_str, g.ChromStr,
+        g._write_line(allele_str,
           ref_start+cur_start, ref_start+cur_start+noc_pfx_len,
           "no-call",
-          //refseq, "?",
           "=", "?",
-          varscorevaf, varscoreeaf, varfilter, haplink, xref, allelefreq, altcalls))
+          varfilter, "", out)
 
         cur_start += noc_pfx_len
         min_len -= noc_pfx_len
@@ -192,13 +298,11 @@ func (g *CGIRefVar) PrintAltAlleles(vartype int, ref_start, ref_len int, refseq
 
       if ref_pfx_len>0 {
 
-        //                           0   1   2   3   4   5   6   7   8   9   10  11  12  13  14  15
-        out.WriteString(fmt.Sprintf("%d\t%d\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-          g.Locus, g.Ploidy, allele_str, g.ChromStr,
+        g._write_line(allele_str,
           ref_start+cur_start, ref_start+cur_start+ref_pfx_len,
           "ref",
           "=", "=",
-          varscorevaf, varscoreeaf, varfilter, haplink, xref, allelefreq, altcalls))
+          varfilter, "", out)
 
         cur_start += ref_pfx_len
         min_len -= ref_pfx_len
@@ -216,14 +320,12 @@ func (g *CGIRefVar) PrintAltAlleles(vartype int, ref_start, ref_len int, refseq
         vartype_str := "snp"
         if sub_pfx_len > 1 { vartype_str = "sub" }
 
-        //                           0   1   2   3   4   5   6   7   8   9   10  11  12  13  14  15
-        out.WriteString(fmt.Sprintf("%d\t%d\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-          g.Locus, g.Ploidy, allele_str, g.ChromStr,
+        g._write_line(allele_str,
           ref_start+cur_start, ref_start+cur_start+sub_pfx_len,
           vartype_str,
-          ref[cur_start:cur_start+sub_pfx_len],
-          alt[strand][cur_start:cur_start+sub_pfx_len],
-          varscorevaf, varscoreeaf, varfilter, haplink, xref, allelefreq, altcalls))
+          string(ref[cur_start:cur_start+sub_pfx_len]),
+          string(alt[strand][cur_start:cur_start+sub_pfx_len]),
+          varfilter, haplink, out)
 
         cur_start += sub_pfx_len
         min_len -= sub_pfx_len
@@ -234,26 +336,21 @@ func (g *CGIRefVar) PrintAltAlleles(vartype int, ref_start, ref_len int, refseq
 
     if cur_start<len(ref) {
 
-      //                           0   1   2   3   4   5   6   7   8   9   10  11  12  13  14  15
-      out.WriteString(fmt.Sprintf("%d\t%d\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-        g.Locus, g.Ploidy, allele_str, g.ChromStr,
+      g._write_line(allele_str,
         ref_start+cur_start, ref_start+cur_start+len(ref[cur_start:]),
         "del",
-        ref[cur_start:],
+        string(ref[cur_start:]),
         "",
-        varscorevaf, varscoreeaf, varfilter, haplink, xref, allelefreq, altcalls))
+        varfilter, haplink, out)
 
     } else if cur_start<len(alt[strand]) {
 
-      //                           0   1   2   3   4   5   6   7   8   9   10  11  12  13  14  15
-      out.WriteString(fmt.Sprintf("%d\t%d\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-        g.Locus, g.Ploidy, allele_str, g.ChromStr,
-        //ref_start+cur_start, ref_start+cur_start+len(alt[strand]),
+      g._write_line(allele_str,
         ref_start+cur_start, ref_start+cur_start,
         "ins",
         "",
-        alt[strand][cur_start:],
-        varscorevaf, varscoreeaf, varfilter, haplink, xref, allelefreq, altcalls))
+        string(alt[strand][cur_start:]),
+        varfilter, haplink, out)
 
     }
 
@@ -264,52 +361,52 @@ func (g *CGIRefVar) PrintAltAlleles(vartype int, ref_start, ref_len int, refseq
   return nil
 }
 
-func (g *CGIRefVar) Print(vartype int, ref_start, ref_len int, refseq []byte, altseq [][]byte, out *bufio.Writer) error {
+func (g *CGIRefVar) Print(v *pasta.Variant, out *bufio.Writer) error {
+  vartype,ref_start,ref_len := v.Type, v.Pos, v.RefLen
+  refseq,altseq := v.Ref, v.Alleles
 
   if g.PrintHeader {
     g.Header(out)
     g.PrintHeader = false
   }
 
-  ploidy := g.Ploidy
-  allele_str := "all"
-  chrom := g.ChromStr
-
-  varscorevaf := ""
-  varscoreeaf := ""
-  varfilter := "PASS"
-  haplink := ""
-  xref := ""
-  allelefreq := ""
-  altcalls := ""
+  // Loci don't span chromosomes and phasing
+  // doesn't carry over to a new chromosome.
+  //
+  if g.ChromUpdate {
+    g.HapLinkBlock = []int{0,0}
+    g.ChromUpdate = false
+  }
 
-  vartype_str := "no-ref"
+  // Take the annotation in effect for the score columns.  A
+  // change of phase set starts a new hapLink block.
+  //
+  if v.PhaseSet != g.PhaseSet {
+    g.HapLinkBlock = []int{0,0}
+  }
+  g.PhaseSet = v.PhaseSet
+  g.VarAnnot = v.Annot
 
-  ref,alt := g._strip_seqs(refseq, altseq) ;  _ = ref ; _ = alt
+  allele_str := "all"
+  varfilter := "PASS"
 
   if vartype == pasta.NOREF {
-    vartype_str = "no-ref"
 
-    //                           0   1   2   3   4   5   6   7   8   9   10  11  12  13  14  15
-    out.WriteString(fmt.Sprintf("%d\t%d\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-      g.Locus, ploidy, allele_str, chrom,
+    g._write_line(allele_str,
       ref_start, ref_start+ref_len,
-      vartype_str,
+      "no-ref",
       "=", "?",
-      varscorevaf, varscoreeaf, varfilter, haplink, xref, allelefreq, altcalls))
+      varfilter, "", out)
 
     g.Locus++
 
   } else if vartype == pasta.REF {
-    vartype_str = "ref"
 
-    //                           0   1   2   3   4   5   6   7   8   9   10  11  12  13  14  15
-    out.WriteString(fmt.Sprintf("%d\t%d\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-      g.Locus, ploidy, allele_str, chrom,
+    g._write_line(allele_str,
       ref_start, ref_start+ref_len,
-      vartype_str,
+      "ref",
       "=", "=",
-      varscorevaf, varscoreeaf, varfilter, haplink, xref, allelefreq, altcalls))
+      varfilter, "", out)
 
     g.Locus++
 
@@ -387,7 +484,16 @@ func (g *CGIRefVar) RefByte(strand int, ref_stream *bufio.Reader) (byte, error)
   if strand<=0 {
     ref_bp,e := ref_stream.ReadByte()
     if e!=nil { return ref_bp, e }
-    for (ref_bp=='\n') || (ref_bp==' ') || (ref_bp=='\r') || (ref_bp=='\t') {
+
+    // Control messages in the reference stream (e.g. the
+    // chromosome boundaries of a concatenated reference)
+    // are skipped.
+    //
+    for (ref_bp=='\n') || (ref_bp==' ') || (ref_bp=='\r') || (ref_bp=='\t') || (ref_bp=='>') {
+      if ref_bp=='>' {
+        _,e = pasta.ControlMessageProcess(ref_stream)
+        if e!=nil { return ref_bp, e }
+      }
       ref_bp,e = ref_stream.ReadByte()
       if e!=nil { return ref_bp, e }
     }
@@ -484,6 +590,11 @@ func (g *CGIRefVar) Pasta(cgivar_line string, ref_stream *bufio.Reader, out *buf
   if chrom != g.ChromStr {
     g.ChromStr = chrom
     g.ChromUpdate = true
+
+    // Positions start over on the new chromosome
+    //
+    g.CGIVarRefPos = _beg
+    g.RefPosUpdate = true
   }
 
   if g.PrevLocus != locus {
@@ -649,6 +760,14 @@ func (g *CGIRefVar) Pasta(cgivar_line string, ref_stream *bufio.Reader, out *buf
 
   }
 
+  // The annotation for this locus goes after the previous
+  // locus has been written out.
+  //
+  if g.AnnotUpdate {
+    out.WriteString( fmt.Sprintf(">A{%s}", g.Annot) )
+    g.AnnotUpdate = false
+  }
+
   // Case analysis for each type:
   //   no-ref, ref, no-call, snp, sub, ins, del
   //
@@ -692,7 +811,8 @@ func (g *CGIRefVar) Pasta(cgivar_line string, ref_stream *bufio.Reader, out *buf
 
       if ii<len(alleleseq) {
 
-        pasta_ch,ok := pasta.SubMap[ref_bp][_tolch(alleleseq[ii])]
+        pasta_ch := pasta.SubMap[ref_bp][pasta.ToLower(alleleseq[ii])]
+        ok := pasta_ch!=0
         if !ok { return fmt.Errorf(fmt.Sprintf("bad sub map from [%c,%c] (%d,%d)", ref_bp, alleleseq[ii], ref_bp, alleleseq[ii]))}
 
         for a:=0; a<len(seq_idx); a++ {
@@ -704,7 +824,8 @@ func (g *CGIRefVar) Pasta(cgivar_line string, ref_stream *bufio.Reader, out *buf
 
       } else {
 
-        pasta_ch,ok := pasta.DelMap[_tolch(ref_bp)]
+        pasta_ch := pasta.DelMap[pasta.ToLower(ref_bp)]
+        ok := pasta_ch!=0
         if !ok { panic("cp sub-del") }
 
         for a:=0; a<len(seq_idx); a++ {
@@ -718,7 +839,8 @@ func (g *CGIRefVar) Pasta(cgivar_line string, ref_stream *bufio.Reader, out *buf
 
     for ii:=dn; ii<len(alleleseq); ii++ {
 
-      pasta_ch,ok := pasta.InsMap[_tolch(alleleseq[ii])]
+      pasta_ch := pasta.InsMap[pasta.ToLower(alleleseq[ii])]
+      ok := pasta_ch!=0
       if !ok { panic("cp sub-ins") }
 
       for a:=0; a<len(seq_idx); a++ {
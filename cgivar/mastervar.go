@@ -0,0 +1,175 @@
+package cgivar
+
+// Complete Genomics masterVar to PASTA.
+//
+// A masterVar record holds both alleles of a locus on one line.  Each
+// record is split into the equivalent per allele CGI-Var lines and
+// handed to the CGI-Var converter.  Columns are found by name from
+// the '>' column header line, so differing masterVar versions can be
+// read as long as the basic columns are present.  Quality columns are
+// carried through as annotation messages (">A{...}") ahead of each
+// locus.
+//
+
+import "fmt"
+import "bufio"
+import "strings"
+
+import "github.com/abeconnelly/pasta"
+
+var gMasterVarRequiredFields = []string{
+  "locus", "ploidy", "chromosome", "begin", "end",
+  "varType", "reference", "allele1Seq", "allele2Seq" }
+
+var gMasterVarQualityFields = []string{
+  "zygosity", "varQuality",
+  "allele1VarQuality", "allele2VarQuality",
+  "allele1VarScoreVAF", "allele2VarScoreVAF",
+  "allele1VarScoreEAF", "allele2VarScoreEAF",
+  "allele1VarFilter", "allele2VarFilter",
+  "allele1ReadCount", "allele2ReadCount",
+  "referenceAlleleReadCount", "totalReadCount" }
+
+type MasterVarRefVar struct {
+  CGI CGIRefVar
+
+  // Column index, by name
+  //
+  Column map[string]int
+
+  QualityFields []string
+
+  LastAnnot string
+}
+
+func (m *MasterVarRefVar) Init() {
+  m.CGI = CGIRefVar{}
+  m.CGI.Init()
+  m.Column = nil
+  m.QualityFields = gMasterVarQualityFields
+  m.LastAnnot = ""
+}
+
+func (m *MasterVarRefVar) PastaBegin(out *bufio.Writer) error {
+  return m.CGI.PastaBegin(out)
+}
+
+func (m *MasterVarRefVar) PastaEnd(out *bufio.Writer) error {
+  return m.CGI.PastaEnd(out)
+}
+
+// Parse the '>' column header line.
+//
+func (m *MasterVarRefVar) Header(header_line string) error {
+  m.Column = make(map[string]int)
+
+  cols := strings.Split(strings.TrimRight(header_line[1:], "\r\n"), "\t")
+  for ii:=0; ii<len(cols); ii++ {
+    m.Column[strings.TrimSpace(cols[ii])] = ii
+  }
+
+  for ii:=0; ii<len(gMasterVarRequiredFields); ii++ {
+    if _,ok := m.Column[gMasterVarRequiredFields[ii]] ; !ok {
+      return fmt.Errorf("masterVar header missing column '%s'", gMasterVarRequiredFields[ii])
+    }
+  }
+
+  return nil
+}
+
+func (m *MasterVarRefVar) _field(fields []string, name string) string {
+  idx,ok := m.Column[name]
+  if !ok || (idx>=len(fields)) { return "" }
+  return fields[idx]
+}
+
+// CGI-Var type for a single allele of a masterVar record.
+// Partially called alleles are taken as no-calls.
+//
+func _mastervar_allele_vartype(vartype, refseq, alleleseq string) string {
+  if (vartype == "ref") || (vartype == "no-ref") { return vartype }
+
+  if (vartype != "snp") &&
+     (vartype != "sub") &&
+     (vartype != "ins") &&
+     (vartype != "del") &&
+     (vartype != "complex") {
+    return "no-call"
+  }
+
+  if strings.ContainsAny(alleleseq, "?Nn") { return "no-call" }
+  if (alleleseq == "=") || (alleleseq == refseq) { return "ref" }
+  return "sub"
+}
+
+// Annotation message body from the quality columns of the record.
+// Read depth is also reported as DP.
+//
+func (m *MasterVarRefVar) _annotation(fields []string) string {
+  annot := make(map[string]string)
+
+  for ii:=0; ii<len(m.QualityFields); ii++ {
+    v := strings.TrimSpace(m._field(fields, m.QualityFields[ii]))
+    if len(v)==0 { continue }
+    v = strings.Replace(v, ";", ",", -1)
+    v = strings.Replace(v, "=", ":", -1)
+    v = strings.Replace(v, "}", "", -1)
+    annot[m.QualityFields[ii]] = v
+  }
+
+  if v,ok := annot["totalReadCount"] ; ok { annot["DP"] = v }
+
+  if len(annot)==0 { return "" }
+  return pasta.AnnotationString(annot)
+}
+
+func (m *MasterVarRefVar) Pasta(line string, ref_stream *bufio.Reader, out *bufio.Writer) error {
+  if len(line)==0 || line[0]=='#' || line[0]=='\n' { return nil }
+  if line[0]=='>' { return m.Header(line) }
+  if m.Column==nil { return fmt.Errorf("masterVar record before column header") }
+
+  fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+
+  locus := m._field(fields, "locus")
+  ploidy := m._field(fields, "ploidy")
+  chrom := m._field(fields, "chromosome")
+  beg := m._field(fields, "begin")
+  end := m._field(fields, "end")
+  vartype := m._field(fields, "varType")
+  refseq := m._field(fields, "reference")
+
+  allele_seq := []string{ m._field(fields, "allele1Seq"), m._field(fields, "allele2Seq") }
+
+  // Haploid records only have the first allele
+  //
+  if ploidy == "1" {
+    allele_seq[1] = allele_seq[0]
+  }
+
+  allele_type := []string{
+    _mastervar_allele_vartype(vartype, refseq, allele_seq[0]),
+    _mastervar_allele_vartype(vartype, refseq, allele_seq[1]) }
+
+  annot := m._annotation(fields)
+  if annot != m.LastAnnot {
+    m.CGI.Annot = annot
+    m.CGI.AnnotUpdate = true
+    m.LastAnnot = annot
+  }
+
+  cgivar_line := func(allele string, aa int) string {
+    return strings.Join([]string{ locus, "2", allele, chrom, beg, end,
+      allele_type[aa], refseq, allele_seq[aa] }, "\t")
+  }
+
+  if (allele_type[0] == allele_type[1]) && (allele_seq[0] == allele_seq[1]) {
+    return m.CGI.Pasta(cgivar_line("all", 0), ref_stream, out)
+  }
+
+  for aa:=0; aa<2; aa++ {
+    e := m.CGI.Pasta(cgivar_line(fmt.Sprintf("%d", aa+1), aa), ref_stream, out)
+    if e!=nil { return e }
+  }
+
+  return nil
+}
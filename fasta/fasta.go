@@ -1,4 +1,8 @@
-package main
+// Package fasta converts between PASTA streams and FASTA.
+// FASTAInfo implements pasta.RefVarPrinter and is registered
+// as the "fasta" format (writer only).
+//
+package fasta
 
 import "io"
 import "bufio"
@@ -7,6 +11,17 @@ import "strings"
 
 import "github.com/abeconnelly/pasta"
 
+// Only registered as a writer, the reader makes a haploid
+// PASTA stream rather than rotini.
+//
+func init() {
+  pasta.RegisterFormat(pasta.Format{
+    Name: "fasta",
+    Description: "FASTA (first allele)",
+    NewWriter: func() pasta.VariantWriter { g := &FASTAInfo{} ; g.Init() ; return g },
+  })
+}
+
 type FASTAInfo struct {
 
   Allele int
@@ -50,15 +65,24 @@ func (g *FASTAInfo) Pos(pos int) {
   g.RefPos = pos
 }
 
+func (g *FASTAInfo) GetRefPos() int { return g.RefPos }
+
 func (g *FASTAInfo) Header(out *bufio.Writer) error {
   out.WriteString(">" + g.Name + "\n")
   return nil
 }
 
-func (g *FASTAInfo) Print(vartype int, ref_start, ref_len int, refseq []byte, altseq [][]byte, out *bufio.Writer) error {
+// Reference records are written out from their reference sequence
+//
+func (g *FASTAInfo) FullRefSeq() bool { return true }
+
+func (g *FASTAInfo) Print(v *pasta.Variant, out *bufio.Writer) error {
+  seq := v.Ref
+  if g.Allele < len(v.Alleles) { seq = v.Alleles[g.Allele] }
 
-  for ii:=0; ii<len(altseq[g.Allele]); ii++ {
-    e := g.WriteFASTAByte(altseq[g.Allele][ii], out)
+  for ii:=0; ii<len(seq); ii++ {
+    if seq[ii]=='-' { continue }
+    e := g.WriteFASTAByte(seq[ii], out)
     if e!=nil { return e }
   }
 
@@ -98,14 +122,16 @@ func (g *FASTAInfo) Stream(pasta_stream *bufio.Reader, out *bufio.Writer) error
     }
 
     if g.Allele==0 {
-      alt_ch,ok := pasta.AltMap[ch]
+      alt_ch := pasta.AltMap[ch]
+      ok := alt_ch!=0
       if ok {
-        g.WriteFASTAByte(_tolch(alt_ch), out)
+        g.WriteFASTAByte(pasta.ToLower(alt_ch), out)
       }
     } else {
-      ref_ch,ok := pasta.RefMap[ch]
+      ref_ch := pasta.RefMap[ch]
+      ok := ref_ch!=0
       if ok {
-        g.WriteFASTAByte(_tolch(ref_ch), out)
+        g.WriteFASTAByte(pasta.ToLower(ref_ch), out)
       }
     }
 
@@ -139,7 +165,7 @@ func (g *FASTAInfo) PastaRaw(fasta_line string, out *bufio.Writer) error {
   if line[0]=='>' { return nil }
 
   for ii:=0; ii<len(fasta_line); ii++ {
-    e := g.WriteFASTAByte(_tolch(fasta_line[ii]), out)
+    e := g.WriteFASTAByte(pasta.ToLower(fasta_line[ii]), out)
     if e!=nil { return e }
   }
 
@@ -170,14 +196,15 @@ func (g *FASTAInfo) Pasta(fasta_line string, ref_stream *bufio.Reader, out *bufi
   if line[0]=='>' { return nil }
 
   for ii:=0; ii<len(fasta_line); ii++ {
-    ch := _tolch(fasta_line[ii])
+    ch := pasta.ToLower(fasta_line[ii])
 
     ref_ch,e := g.ReadRefByte(ref_stream)
     if e!=nil { return e }
 
-    ref_ch = _tolch(ref_ch)
+    ref_ch = pasta.ToLower(ref_ch)
 
-    pasta_ch,ok := pasta.SubMap[ref_ch][ch]
+    pasta_ch := pasta.SubMap[ref_ch][ch]
+    ok := pasta_ch!=0
     if !ok {
       return fmt.Errorf(fmt.Sprintf("FASTA Pasta conversion, bad mapping from '%c'->'%c' (%d->%d)", ref_ch, ch, ref_ch, ch))
     }
@@ -0,0 +1,236 @@
+package fastj
+
+import "github.com/abeconnelly/memz"
+
+// Anchor-and-extend alignment for sequences too long to hand
+// to dynamic programming directly.
+//
+// Exact k-mer matches that are unique in both sequences are used as
+// seeds.  The longest chain of seeds that is co-linear in both sequences
+// is taken as the backbone of the alignment, each seed extended forward
+// while the sequences agree.  The (hopefully short) stretches between
+// seeds are aligned recursively, falling back to dynamic programming once
+// they're under `gAlignDPBound` and to a gapped end-to-end alignment if no
+// seeds can be found at all.
+//
+// Space is linear in the length of the sequences.
+//
+
+var gAlignKmer int = 20
+var gAlignDPBound int = 10000
+
+type _align_seed struct {
+  ref_pos int
+  alt_pos int
+}
+
+// Map of k-mer to position in `s`, -1 if the k-mer occurs more than once.
+//
+func _align_kmer_pos(s []byte, k int) map[string]int {
+  m := make(map[string]int)
+  for ii:=0; ii+k<=len(s); ii++ {
+    key := string(s[ii:ii+k])
+    if _,ok := m[key] ; ok {
+      m[key] = -1
+    } else {
+      m[key] = ii
+    }
+  }
+  return m
+}
+
+// Seeds unique in both `ref` and `alt`, ordered by reference position,
+// reduced to the longest chain that's increasing in the alt position.
+//
+func _align_seed_chain(ref, alt []byte, k int) []_align_seed {
+  if (len(ref)<k) || (len(alt)<k) { return nil }
+
+  alt_kmer := _align_kmer_pos(alt, k)
+  ref_kmer := _align_kmer_pos(ref, k)
+
+  seeds := []_align_seed{}
+  for ii:=0; ii+k<=len(ref); ii++ {
+    key := string(ref[ii:ii+k])
+    if ref_kmer[key] != ii { continue }
+    if p,ok := alt_kmer[key] ; ok && (p>=0) {
+      seeds = append(seeds, _align_seed{ii, p})
+    }
+  }
+
+  if len(seeds)==0 { return nil }
+
+  // Longest strictly increasing subsequence on alt position
+  // (patience sorting).
+  //
+  tail := []int{}
+  prev := make([]int, len(seeds))
+  for ii:=0; ii<len(seeds); ii++ {
+    lo,hi := 0, len(tail)
+    for lo<hi {
+      mid := (lo+hi)/2
+      if seeds[tail[mid]].alt_pos < seeds[ii].alt_pos {
+        lo = mid+1
+      } else {
+        hi = mid
+      }
+    }
+
+    prev[ii] = -1
+    if lo>0 { prev[ii] = tail[lo-1] }
+
+    if lo==len(tail) {
+      tail = append(tail, ii)
+    } else {
+      tail[lo] = ii
+    }
+  }
+
+  chain := make([]_align_seed, len(tail))
+  for ii,idx := len(tail)-1, tail[len(tail)-1]; ii>=0; ii,idx = ii-1, prev[idx] {
+    chain[ii] = seeds[idx]
+  }
+
+  return chain
+}
+
+func _align_append_gap(ref_align, alt_align []byte, ref, alt []byte) ([]byte, []byte) {
+  for ii:=0; ii<len(ref); ii++ {
+    ref_align = append(ref_align, ref[ii])
+    alt_align = append(alt_align, '-')
+  }
+  for ii:=0; ii<len(alt); ii++ {
+    ref_align = append(ref_align, '-')
+    alt_align = append(alt_align, alt[ii])
+  }
+  return ref_align, alt_align
+}
+
+func (g *FastJInfo) _anchor_align(ref, alt []byte, ref_align, alt_align []byte) ([]byte, []byte) {
+
+  // Exact common prefix and suffix can be taken as is
+  //
+  pfx := 0
+  for (pfx<len(ref)) && (pfx<len(alt)) && (ref[pfx]==alt[pfx]) { pfx++ }
+  for ii:=0; ii<pfx; ii++ {
+    ref_align = append(ref_align, ref[ii])
+    alt_align = append(alt_align, alt[ii])
+  }
+  ref = ref[pfx:]
+  alt = alt[pfx:]
+
+  sfx := 0
+  for (sfx<len(ref)) && (sfx<len(alt)) && (ref[len(ref)-sfx-1]==alt[len(alt)-sfx-1]) { sfx++ }
+  ref_sfx := ref[len(ref)-sfx:]
+  alt_sfx := alt[len(alt)-sfx:]
+  ref = ref[:len(ref)-sfx]
+  alt = alt[:len(alt)-sfx]
+
+  if (len(ref)==0) || (len(alt)==0) {
+    ref_align,alt_align = _align_append_gap(ref_align, alt_align, ref, alt)
+  } else if (len(ref)<=gAlignDPBound) && (len(alt)<=gAlignDPBound) {
+    r,a,_ := memz.Hirschberg(ref, alt)
+    ref_align = append(ref_align, r...)
+    alt_align = append(alt_align, a...)
+  } else {
+
+    k := gAlignKmer
+    chain := _align_seed_chain(ref, alt, k)
+
+    if len(chain)==0 {
+      r,a := g.ClumsyAlign(ref, alt)
+      ref_align = append(ref_align, r...)
+      alt_align = append(alt_align, a...)
+    } else {
+
+      ref_end,alt_end := 0,0
+      for ii:=0; ii<len(chain); ii++ {
+        r,a := chain[ii].ref_pos, chain[ii].alt_pos
+
+        // Seeds overlapping the previous (extended) match are clipped
+        //
+        d := 0
+        if ref_end-r > d { d = ref_end-r }
+        if alt_end-a > d { d = alt_end-a }
+        if d >= k { continue }
+        r += d
+        a += d
+
+        ref_align,alt_align = g._anchor_align(ref[ref_end:r], alt[alt_end:a], ref_align, alt_align)
+
+        n := k-d
+        for (r+n<len(ref)) && (a+n<len(alt)) && (ref[r+n]==alt[a+n]) { n++ }
+        for jj:=0; jj<n; jj++ {
+          ref_align = append(ref_align, ref[r+jj])
+          alt_align = append(alt_align, alt[a+jj])
+        }
+
+        ref_end = r+n
+        alt_end = a+n
+      }
+
+      ref_align,alt_align = g._anchor_align(ref[ref_end:], alt[alt_end:], ref_align, alt_align)
+    }
+  }
+
+  for ii:=0; ii<sfx; ii++ {
+    ref_align = append(ref_align, ref_sfx[ii])
+    alt_align = append(alt_align, alt_sfx[ii])
+  }
+
+  return ref_align, alt_align
+}
+
+// Align `alt` to `ref`, seeding with exact k-mer matches when the
+// sequences are too long for dynamic programming.  The result is
+// left normalized.
+//
+func (g *FastJInfo) AnchorAlign(ref, alt []byte) ([]byte, []byte) {
+  ref_align := make([]byte, 0, len(ref)+len(ref)/8)
+  alt_align := make([]byte, 0, len(ref)+len(ref)/8)
+
+  ref_align,alt_align = g._anchor_align(ref, alt, ref_align, alt_align)
+  AlignLeftNormalize(ref_align, alt_align)
+
+  return ref_align, alt_align
+}
+
+// Shift every gap in the alignment as far left as it will go,
+// in place, so that equivalent alignments are reported the same
+// way regardless of how they were found.
+//
+// A run of gaps can move one position to the left if the column
+// before it is a match and the base in that column is the same as
+// the last base of the run.
+//
+func AlignLeftNormalize(ref_align, alt_align []byte) {
+  n := len(ref_align)
+  if len(alt_align) < n { n = len(alt_align) }
+
+  for ii:=0; ii<n; {
+    if (ref_align[ii]!='-') && (alt_align[ii]!='-') { ii++ ; continue }
+
+    // Gaps in the alt (deletion) or in the ref (insertion).
+    // The other sequence holds the bases.
+    //
+    gap,seq := alt_align, ref_align
+    if ref_align[ii]=='-' { gap,seq = ref_align, alt_align }
+
+    jj := ii
+    for (jj<n) && (gap[jj]=='-') && (seq[jj]!='-') { jj++ }
+    if jj==ii { ii++ ; continue }
+
+    s,e := ii,jj
+    for s>0 {
+      c := seq[s-1]
+      if (c=='-') || (gap[s-1]!=c) { break }
+      if seq[e-1]!=c { break }
+
+      gap[s-1] = '-'
+      gap[e-1] = c
+      s--
+      e--
+    }
+
+    ii = jj
+  }
+}
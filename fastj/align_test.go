@@ -0,0 +1,197 @@
+package fastj
+
+import "bytes"
+import "math/rand"
+import "testing"
+
+func _align_rand_seq(n int, seed int64) []byte {
+  r := rand.New(rand.NewSource(seed))
+  s := make([]byte, n)
+  for ii:=0; ii<n; ii++ { s[ii] = "acgt"[r.Intn(4)] }
+  return s
+}
+
+func _align_ungap(s []byte) []byte {
+  return bytes.Replace(s, []byte("-"), nil, -1)
+}
+
+// Change the base at `pos` to a different one.
+//
+func _align_snp(s []byte, pos int) {
+  s[pos] = "cgta"[bytes.IndexByte([]byte("acgt"), s[pos])]
+}
+
+// Run `f` with the dynamic programming fallback and again with the
+// seed and extend path forced by a small DP bound.  Sequences need a
+// difference near either end for the seeds to be used, as the common
+// prefix and suffix are taken off first.
+//
+func _align_both_paths(t *testing.T, f func(t *testing.T)) {
+  kmer,bound := gAlignKmer, gAlignDPBound
+  defer func() { gAlignKmer,gAlignDPBound = kmer,bound }()
+
+  t.Run("dp", f)
+
+  gAlignKmer,gAlignDPBound = 8, 4
+  t.Run("seed", f)
+}
+
+func TestAnchorAlignIdentical(t *testing.T) {
+  ref := _align_rand_seq(300, 1)
+
+  _align_both_paths(t, func(t *testing.T) {
+    g := FastJInfo{}
+    r,a := g.AnchorAlign(ref, ref)
+    if !bytes.Equal(r, ref) || !bytes.Equal(a, ref) {
+      t.Errorf("identical sequences:\n  got ref %s\n      alt %s", r, a)
+    }
+  })
+}
+
+func TestAnchorAlignSNP(t *testing.T) {
+  ref := _align_rand_seq(300, 2)
+
+  alt := append([]byte{}, ref...)
+  _align_snp(alt, 150)
+
+  _align_both_paths(t, func(t *testing.T) {
+    g := FastJInfo{}
+    r,a := g.AnchorAlign(ref, alt)
+    if !bytes.Equal(r, ref) || !bytes.Equal(a, alt) {
+      t.Errorf("single SNP, expected no gaps:\n  got ref %s\n      alt %s", r, a)
+    }
+  })
+
+  alt_ends := append([]byte{}, alt...)
+  _align_snp(alt_ends, 10)
+  _align_snp(alt_ends, 290)
+
+  _align_both_paths(t, func(t *testing.T) {
+    g := FastJInfo{}
+    r,a := g.AnchorAlign(ref, alt_ends)
+    if !bytes.Equal(r, ref) || !bytes.Equal(a, alt_ends) {
+      t.Errorf("SNPs, expected no gaps:\n  got ref %s\n      alt %s", r, a)
+    }
+  })
+}
+
+// A "ca" deleted from the middle of a "ca" repeat ends up at the
+// left end of the repeat.
+//
+func TestAnchorAlignRepeatIndel(t *testing.T) {
+  pfx := _align_rand_seq(100, 3)
+  sfx := _align_rand_seq(100, 4)
+
+  ref := []byte{}
+  ref = append(ref, pfx...)
+  ref = append(ref, []byte("gcacacacacacag")...)
+  ref = append(ref, sfx...)
+
+  alt := []byte{}
+  alt = append(alt, pfx...)
+  alt = append(alt, []byte("gcacacacacag")...)
+  alt = append(alt, sfx...)
+
+  exp := []byte{}
+  exp = append(exp, pfx...)
+  exp = append(exp, []byte("g--cacacacacag")...)
+  exp = append(exp, sfx...)
+
+  // SNPs near either end so the seeds are used
+  //
+  _align_snp(alt, 10)
+  _align_snp(alt, len(alt)-10)
+  _align_snp(exp, 10)
+  _align_snp(exp, len(exp)-10)
+
+  _align_both_paths(t, func(t *testing.T) {
+    g := FastJInfo{}
+    r,a := g.AnchorAlign(ref, alt)
+    if !bytes.Equal(r, ref) || !bytes.Equal(a, exp) {
+      t.Errorf("indel in repeat:\n  got ref %s\n      alt %s\n expected %s", r, a, exp)
+    }
+  })
+}
+
+// Sequences without a k-mer in common still give an alignment
+// of the two.
+//
+func TestAnchorAlignNoSeeds(t *testing.T) {
+  ref := bytes.Repeat([]byte("ac"), 50)
+  alt := bytes.Repeat([]byte("gt"), 40)
+
+  _align_both_paths(t, func(t *testing.T) {
+    g := FastJInfo{}
+    r,a := g.AnchorAlign(ref, alt)
+    if len(r)!=len(a) {
+      t.Fatalf("aligned lengths differ (%d, %d)", len(r), len(a))
+    }
+    if !bytes.Equal(_align_ungap(r), ref) || !bytes.Equal(_align_ungap(a), alt) {
+      t.Errorf("no shared seeds, alignment doesn't spell out the sequences:\n  got ref %s\n      alt %s", r, a)
+    }
+  })
+}
+
+func TestAlignSeedChain(t *testing.T) {
+  ref := _align_rand_seq(200, 5)
+
+  chain := _align_seed_chain(ref, ref, 8)
+  if len(chain)==0 { t.Fatalf("identical sequences, no seeds") }
+  for ii:=0; ii<len(chain); ii++ {
+    if chain[ii].ref_pos != chain[ii].alt_pos {
+      t.Errorf("identical sequences, seed %d at ref %d alt %d", ii, chain[ii].ref_pos, chain[ii].alt_pos)
+    }
+    if (ii>0) && (chain[ii].ref_pos <= chain[ii-1].ref_pos) {
+      t.Errorf("seed %d out of order", ii)
+    }
+  }
+
+  // Seeds after an insertion are offset by its length
+  //
+  alt := []byte{}
+  alt = append(alt, ref[:100]...)
+  alt = append(alt, []byte("tttt")...)
+  alt = append(alt, ref[100:]...)
+
+  chain = _align_seed_chain(ref, alt, 8)
+  if len(chain)==0 { t.Fatalf("insertion, no seeds") }
+  for ii:=0; ii<len(chain); ii++ {
+    d := chain[ii].alt_pos - chain[ii].ref_pos
+    if (chain[ii].ref_pos+8 <= 100) && (d!=0) {
+      t.Errorf("insertion, seed %d before it offset by %d", ii, d)
+    }
+    if (chain[ii].ref_pos >= 100) && (d!=4) {
+      t.Errorf("insertion, seed %d after it offset by %d", ii, d)
+    }
+  }
+
+  if c := _align_seed_chain(bytes.Repeat([]byte("ac"), 50), bytes.Repeat([]byte("gt"), 50), 8) ; len(c)!=0 {
+    t.Errorf("no shared k-mers, got %d seeds", len(c))
+  }
+  if c := _align_seed_chain(ref[:4], ref, 8) ; len(c)!=0 {
+    t.Errorf("sequence shorter than k, got %d seeds", len(c))
+  }
+}
+
+func TestAlignLeftNormalize(t *testing.T) {
+  cases := []struct {
+    ref, alt string
+    exp_ref, exp_alt string
+  }{
+    { "aacccgt", "aacc-gt", "aacccgt", "aa-ccgt" },
+    { "aac--gt", "aacacgt", "a--acgt", "aacacgt" },
+    { "acacacg", "ac--acg", "acacacg", "--acacg" },
+    { "acgt", "acgt", "acgt", "acgt" },
+    { "ac-t", "acgt", "ac-t", "acgt" },
+  }
+
+  for ii:=0; ii<len(cases); ii++ {
+    r := []byte(cases[ii].ref)
+    a := []byte(cases[ii].alt)
+    AlignLeftNormalize(r, a)
+    if (string(r)!=cases[ii].exp_ref) || (string(a)!=cases[ii].exp_alt) {
+      t.Errorf("case %d: %s/%s normalized to %s/%s, expected %s/%s", ii,
+        cases[ii].ref, cases[ii].alt, r, a, cases[ii].exp_ref, cases[ii].exp_alt)
+    }
+  }
+}
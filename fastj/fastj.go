@@ -1,4 +1,8 @@
-package main
+// Package fastj converts between rotini streams and FastJ, the
+// tiled representation of a genome, and builds and checks tile
+// libraries.
+//
+package fastj
 
 // Convert a pasta stream to FastJ
 
@@ -58,6 +62,16 @@ type FastJInfo struct {
 
   LibraryVersion int
 
+  // Tile library used to assign variant IDs.  If nil, the
+  // variant ID is the allele.
+  //
+  Library *TileLibrary
+
+  // Write an annotation message (">A{TILEID=...}") ahead of
+  // each stretch of the PASTA stream with the tile IDs it came from.
+  //
+  AnnotateTileId bool
+
   RefPos int
 
   RefBuild string
@@ -247,40 +261,44 @@ func _m5sum_str(b []byte) string {
 }
 
 // In order to give a unique MD5SUM even for sequences that have nocalls
-// in them, the beginning and end tags are 'masked' with the sequence,
-// capitalizing the base if the sequence is a no-call and keeping it
-// the same otherwise.  For sequences without no-calls, the 'tagmask_md5sum'
-// should be identical to the 'md5sum'.  For sequences with 'no-calls',
-// this ensures a unique MD5SUM as the tags are chosen to be unique
-// sequences.
+// in them, the no-calls are 'masked': in the beginning and end tags the
+// no-call is replaced by the capitalized tag base and in the body of the
+// tile it's capitalized ('N').  Sequences that differ only in how their
+// no-calls are written ('n' or 'N') then get the same sum.  For sequences
+// without no-calls, the 'tagmask_md5sum' should be identical to the
+// 'md5sum'.  For sequences with 'no-calls', this ensures a unique MD5SUM
+// as the tags are chosen to be unique sequences.
 //
 func _m5sum_tagmask_str(orig_b []byte, beg_tag, end_tag string) string {
-  b := []byte{}
+  b := make([]byte, 0, len(orig_b))
 
-  if len(beg_tag)>0 {
-    for ii:=0; ii<len(beg_tag); ii++ {
-      if orig_b[ii]=='n' {
-        b = append(b, _tou_ch(beg_tag[ii]))
-      } else {
-        b = append(b, beg_tag[ii])
-      }
+  _noc := func(c byte) bool { return (c=='n') || (c=='N') }
+
+  for ii:=0; ii<len(beg_tag); ii++ {
+    if _noc(orig_b[ii]) {
+      b = append(b, pasta.ToUpper(beg_tag[ii]))
+    } else {
+      b = append(b, beg_tag[ii])
     }
   }
 
-  if len(end_tag)>0 {
-    n := len(end_tag)
-    m := len(orig_b)
+  n := len(end_tag)
+  m := len(orig_b)
 
-    b = append(b, orig_b[len(beg_tag):m-n]...)
-    for ii:=0; ii<n; ii++ {
-      if orig_b[m-n+ii]=='n' {
-        b = append(b, _tou_ch(end_tag[ii]))
-      } else {
-        b = append(b, end_tag[ii])
-      }
+  for ii:=len(beg_tag); ii<m-n; ii++ {
+    if _noc(orig_b[ii]) {
+      b = append(b, 'N')
+    } else {
+      b = append(b, orig_b[ii])
+    }
+  }
+
+  for ii:=0; ii<n; ii++ {
+    if _noc(orig_b[m-n+ii]) {
+      b = append(b, pasta.ToUpper(end_tag[ii]))
+    } else {
+      b = append(b, end_tag[ii])
     }
-  } else {
-    b = append(b, orig_b[len(beg_tag):]...)
   }
 
   dat := md5.Sum(b)
@@ -343,6 +361,15 @@ func (g *FastJInfo) Write(b []byte) (n int, err error) {
 
 
 
+// Variant ID of the tile at `step` for allele `allele`.  With a tile
+// library the variant ID of a known tile is reused and unknown tiles
+// are added to the library.
+//
+func (g *FastJInfo) TileVarId(step int, allele int, seq []byte) int {
+  if g.Library == nil { return allele }
+  return g.Library.VarId(g.TagPath, step, _m5sum_str(seq))
+}
+
 //--
 
 func (g *FastJInfo) Convert(pasta_stream *bufio.Reader, tag_stream *bufio.Reader, assembly_stream *bufio.Reader, out *bufio.Writer) error {
@@ -455,9 +482,10 @@ func (g *FastJInfo) Convert(pasta_stream *bufio.Reader, tag_stream *bufio.Reader
 
 
         out.WriteString(fmt.Sprintf(`>{"tileID":"%04x.%02x.%04x.%03x"`,
-          g.TagPath, g.LibraryVersion, step_pos[0], 0))
+          g.TagPath, g.LibraryVersion, step_pos[0], g.TileVarId(step_pos[0], 0, alt_seq[0])))
         out.WriteString(fmt.Sprintf(`,"md5sum":"%s"`, _m5sum_str(alt_seq[0])))
         out.WriteString(fmt.Sprintf(`,"tagmask_md5sum":"%s"`, _m5sum_tagmask_str(alt_seq[0], beg_tag, end_tag)))
+        if g.Library!=nil { out.WriteString(fmt.Sprintf(`,"allele":%d`, 0)) }
         out.WriteString(fmt.Sprintf(`,"locus":[{"build":"%s %s %d %d"}]`, g.RefBuild, g.Chrom, g.AssemblyPrevEndPos+d_beg, g.AssemblyEndPos))
         out.WriteString(fmt.Sprintf(`,"n":%d`, len(alt_seq[0])))
         out.WriteString(fmt.Sprintf(`,"seedTileLength":%d`, seed_tile_length[0]))
@@ -527,9 +555,10 @@ func (g *FastJInfo) Convert(pasta_stream *bufio.Reader, tag_stream *bufio.Reader
         if start_tile_flag { d_beg = 0 }
 
         out.WriteString(fmt.Sprintf(`>{"tileID":"%04x.%02x.%04x.%03x"`,
-          g.TagPath, g.LibraryVersion, step_pos[1], 1))
+          g.TagPath, g.LibraryVersion, step_pos[1], g.TileVarId(step_pos[1], 1, alt_seq[1])))
         out.WriteString(fmt.Sprintf(`,"md5sum":"%s"`, _m5sum_str(alt_seq[1])))
         out.WriteString(fmt.Sprintf(`,"tagmask_md5sum":"%s"`, _m5sum_tagmask_str(alt_seq[1], beg_tag, end_tag)))
+        if g.Library!=nil { out.WriteString(fmt.Sprintf(`,"allele":%d`, 1)) }
 
         out.WriteString(fmt.Sprintf(`,"locus":[{"build":"%s %s %d %d"}]`, g.RefBuild, g.Chrom, g.AssemblyPrevEndPos+d_beg, g.AssemblyEndPos))
 
@@ -707,9 +736,10 @@ func (g *FastJInfo) Convert(pasta_stream *bufio.Reader, tag_stream *bufio.Reader
 
 
       out.WriteString(fmt.Sprintf(`>{"tileID":"%04x.%02x.%04x.%03x"`,
-        g.TagPath, g.LibraryVersion, step_pos[aa], aa))
+        g.TagPath, g.LibraryVersion, step_pos[aa], g.TileVarId(step_pos[aa], aa, alt_seq[aa])))
       out.WriteString(fmt.Sprintf(`,"md5sum":"%s"`, _m5sum_str(alt_seq[aa])))
       out.WriteString(fmt.Sprintf(`,"tagmask_md5sum":"%s"`, _m5sum_tagmask_str(alt_seq[aa], beg_tag, end_tag)))
+      if g.Library!=nil { out.WriteString(fmt.Sprintf(`,"allele":%d`, aa)) }
       out.WriteString(fmt.Sprintf(`,"locus":[{"build":"%s %s %d %d"}]`, g.RefBuild, g.Chrom, g.AssemblyPrevEndPos, g.AssemblyEndPos))
       out.WriteString(fmt.Sprintf(`,"n":%d`, len(alt_seq[aa])))
       out.WriteString(fmt.Sprintf(`,"seedTileLength":%d`, seed_tile_length[aa]))
@@ -786,7 +816,9 @@ func _noc_eq(x, y []byte) bool {
 //   * http://bmcbioinformatics.biomedcentral.com/articles/10.1186/1471-2105-10-S1-S10
 //   * https://github.com/drpowell/sequence-alignment-checkpointing
 //
-// Instead, do a clumsy alignment of the strings.
+// Instead, do a clumsy alignment of the strings.  This is now only used
+// as a last resort by `AnchorAlign` (see align.go) when no seeds
+// can be found.
 //
 func (g *FastJInfo) ClumsyAlign(ref, alt []byte) ([]byte, []byte) {
   ref_align := []byte{}
@@ -813,7 +845,7 @@ func (g *FastJInfo) ClumsyAlign(ref, alt []byte) ([]byte, []byte) {
 }
 
 func (g *FastJInfo) EmitAlignedInterleave(ref, alt0, alt1 []byte, out *bufio.Writer) {
-  length_bound := 10000
+  length_bound := gAlignDPBound
 
   if len(ref)==0 { return }
 
@@ -826,10 +858,11 @@ func (g *FastJInfo) EmitAlignedInterleave(ref, alt0, alt1 []byte, out *bufio.Wri
   if !_noc_eq(ref, alt0) {
 
     if (len(ref) > length_bound) || (len(alt0) > length_bound) {
-      ref0,algn0 := g.ClumsyAlign(ref, alt0)
+      ref0,algn0 := g.AnchorAlign(ref, alt0)
       for ii:=0; ii<len(ref0); ii++ { p0 = append(p0, pasta.SubMap[ref0[ii]][algn0[ii]]) }
     } else {
       ref0,algn0,sc0 := memz.Hirschberg(ref, alt0) ; _ = sc0
+      AlignLeftNormalize(ref0, algn0)
       for ii:=0; ii<len(ref0); ii++ { p0 = append(p0, pasta.SubMap[ref0[ii]][algn0[ii]]) }
     }
 
@@ -840,10 +873,11 @@ func (g *FastJInfo) EmitAlignedInterleave(ref, alt0, alt1 []byte, out *bufio.Wri
   if !_noc_eq(ref, alt1) {
 
     if (len(ref) > length_bound) || (len(alt1) > length_bound) {
-      ref1,algn1 := g.ClumsyAlign(ref, alt1)
+      ref1,algn1 := g.AnchorAlign(ref, alt1)
       for ii:=0; ii<len(ref1); ii++ { p1 = append(p1, pasta.SubMap[ref1[ii]][algn1[ii]]) }
     } else {
       ref1,algn1,sc1 := memz.Hirschberg(ref, alt1) ; _ = sc1
+      AlignLeftNormalize(ref1, algn1)
       for ii:=0; ii<len(ref1); ii++ { p1 = append(p1, pasta.SubMap[ref1[ii]][algn1[ii]]) }
     }
 
@@ -858,6 +892,11 @@ func (g *FastJInfo) EmitAlignedInterleave(ref, alt0, alt1 []byte, out *bufio.Wri
   pasta.InterleaveStreams(r0, r1, g)
 }
 
+func (g *FastJInfo) _write_tile_id_annotation(tile_id []string, out *bufio.Writer) {
+  if !g.AnnotateTileId || (len(tile_id)==0) { return }
+  out.WriteString(">A{TILEID=" + strings.Join(tile_id, ",") + "}")
+}
+
 // Take in a FastJ stream and a reference stream to produce a PASTA stream.
 // Assumes each variant 'class' is ordered.
 //
@@ -896,6 +935,8 @@ func (g *FastJInfo) Pasta(fastj_stream *bufio.Reader, ref_stream *bufio.Reader,
   knot_len[0] = 0
   knot_len[1] = 0
 
+  knot_tile_id := []string{}
+
   for {
 
     line,e := fastj_stream.ReadBytes('\n')
@@ -920,6 +961,7 @@ func (g *FastJInfo) Pasta(fastj_stream *bufio.Reader, ref_stream *bufio.Reader,
           n1 := len(alt_seq[1])-24
 
           if n>=24 {
+            g._write_tile_id_annotation(knot_tile_id, out)
             g.EmitAlignedInterleave(ref_seq[:n], alt_seq[0][:n0], alt_seq[1][:n1], out)
           } else {
             return fmt.Errorf("sanity error, no tag")
@@ -936,6 +978,8 @@ func (g *FastJInfo) Pasta(fastj_stream *bufio.Reader, ref_stream *bufio.Reader,
         knot_len[0] = 0
         knot_len[1] = 0
 
+        knot_tile_id = knot_tile_id[0:0]
+
         for aa:=0; aa<2; aa++ {
           n := len(alt_seq[aa])
           if n>24 {
@@ -961,9 +1005,17 @@ func (g *FastJInfo) Pasta(fastj_stream *bufio.Reader, ref_stream *bufio.Reader,
       if e!=nil { return fmt.Errorf(fmt.Sprintf("error parsing tileID: %v",e)) }
       _ = p ; _  = s
 
+      // Tiles with variant IDs from a tile library record
+      // the allele separately.
+      //
+      if a,ok := sj.O["allele"] ; ok { v = int(a.P) }
+      if (v<0) || (v>1) { return fmt.Errorf(fmt.Sprintf("invalid allele %d for tile %s", v, sj.O["tileID"].S)) }
+
       stl := int(sj.O["seedTileLength"].P)
       tile_len[v] += stl
 
+      knot_tile_id = append(knot_tile_id, sj.O["tileID"].S)
+
       skip_prefix[v] = 0
       if knot_len[v]>0 {
         skip_prefix[v]=24
@@ -1048,6 +1100,7 @@ func (g *FastJInfo) Pasta(fastj_stream *bufio.Reader, ref_stream *bufio.Reader,
   if tile_len[0]==tile_len[1] {
 
     if len(ref_seq)>=24 {
+      g._write_tile_id_annotation(knot_tile_id, out)
       g.EmitAlignedInterleave(ref_seq, alt_seq[0], alt_seq[1], out)
     } else {
       return fmt.Errorf("sanity, no tag")
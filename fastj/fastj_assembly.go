@@ -0,0 +1,193 @@
+package fastj
+
+// FastJ to PASTA over a whole assembly.  The FastJ stream is split
+// by tile path and each path converted in turn, following the
+// assembly headers across paths and chromosomes.  Chromosome and
+// position messages are written at the start of each path so the
+// resulting stream is self describing.
+//
+
+import "io"
+import "fmt"
+import "bytes"
+import "bufio"
+import "strconv"
+import "strings"
+
+import "github.com/abeconnelly/pasta"
+import "github.com/abeconnelly/sloppyjson"
+
+// Reads FastJ one tile path at a time.
+//
+type _fastj_path_reader struct {
+  stream *bufio.Reader
+
+  // Header line read ahead, and its path (-1 at end of stream)
+  //
+  header string
+  path int
+}
+
+func (r *_fastj_path_reader) _next_header() error {
+  r.header = ""
+  r.path = -1
+
+  for {
+    l,e := r.stream.ReadString('\n')
+    if (e!=nil) && (e!=io.EOF) { return e }
+
+    line := strings.TrimSpace(l)
+    if (len(line)>0) && (line[0]=='>') {
+      sj,err := sloppyjson.Loads(line[1:])
+      if err!=nil { return fmt.Errorf("error parsing FastJ header: %v", err) }
+      path,_,_,_,err := parse_tile(sj.O["tileID"].S)
+      if err!=nil { return fmt.Errorf("error parsing tileID: %v", err) }
+
+      r.header = line
+      r.path = path
+      return nil
+    }
+
+    if e==io.EOF { return nil }
+  }
+}
+
+// All the tiles on the current path.
+//
+func (r *_fastj_path_reader) _section() ([]byte, error) {
+  path := r.path
+  b := []byte{}
+
+  for r.path==path {
+    b = append(b, r.header...)
+    b = append(b, '\n')
+
+    for {
+      p,e := r.stream.Peek(1)
+      if (e!=nil) || (p[0]=='>') { break }
+
+      l,e := r.stream.ReadString('\n')
+      if (e!=nil) && (e!=io.EOF) { return nil, e }
+      b = append(b, l...)
+      if (len(l)>0) && (l[len(l)-1]!='\n') { b = append(b, '\n') }
+      if e==io.EOF { break }
+    }
+
+    e := r._next_header()
+    if e!=nil { return nil, e }
+  }
+
+  return b, nil
+}
+
+// Skip `n` reference bases.
+//
+func _ref_stream_skip(ref_stream *bufio.Reader, n int) error {
+  for n>0 {
+    ch,e := ref_stream.ReadByte()
+    if e!=nil { return e }
+    if ch=='\n' || ch==' ' || ch=='\t' || ch=='\r' { continue }
+
+    if ch=='>' {
+      _,e := pasta.ControlMessageProcess(ref_stream)
+      if e!=nil { return fmt.Errorf("error processing control message: %v", e) }
+      continue
+    }
+    n--
+  }
+  return nil
+}
+
+// Skip the reference stream ahead to the chromosome message
+// for `chrom`.
+//
+func _ref_stream_seek_chrom(ref_stream *bufio.Reader, chrom string) error {
+  for {
+    ch,e := ref_stream.ReadByte()
+    if e==io.EOF { return fmt.Errorf("chromosome %s not found in reference stream", chrom) }
+    if e!=nil { return e }
+    if ch!='>' { continue }
+
+    msg,e := pasta.ControlMessageProcess(ref_stream)
+    if e!=nil { return fmt.Errorf("error processing control message: %v", e) }
+    if (msg.Type == pasta.CHROM) && (msg.Chrom == chrom) { return nil }
+  }
+}
+
+// Convert every tile path in the FastJ stream, in assembly order.
+// `g.RefPos` is the start of the first path.  Paths in the assembly
+// without any tiles are skipped over in the reference stream.
+//
+func (g *FastJInfo) PastaAssembly(fastj_stream *bufio.Reader, ref_stream *bufio.Reader, assembly_stream *bufio.Reader, out *bufio.Writer) error {
+  fjr := _fastj_path_reader{}
+  fjr.stream = fastj_stream
+  e := fjr._next_header()
+  if e!=nil { return e }
+
+  cur_chrom := ""
+  chrom_end := make(map[string]int)
+  first_path := true
+
+  for fjr.path >= 0 {
+    header,body,e := _read_section(assembly_stream)
+    if e==io.EOF { return fmt.Errorf("tile path %04x not in assembly", fjr.path) }
+    if e!=nil { return e }
+
+    parts := strings.Split(strings.Trim(header, " \t>"), ":")
+    if len(parts)<3 { return fmt.Errorf("invalid assembly header '%s'", header) }
+    _path,e := strconv.ParseUint(parts[2], 16, 64)
+    if e!=nil { return fmt.Errorf("invalid assembly header '%s': %v", header, e) }
+    path := int(_path)
+    chrom := parts[1]
+
+    start,ok := chrom_end[chrom]
+    if !ok && first_path { start = g.RefPos }
+    end := start
+
+    lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+    for ii:=len(lines)-1; ii>=0; ii-- {
+      fields := strings.Fields(lines[ii])
+      if len(fields)<2 { continue }
+      _pos,e := strconv.ParseUint(fields[1], 10, 64)
+      if e!=nil { return fmt.Errorf("invalid assembly line '%s': %v", lines[ii], e) }
+      end = int(_pos)
+      break
+    }
+    chrom_end[chrom] = end
+
+    if (chrom != cur_chrom) && !first_path {
+      e = _ref_stream_seek_chrom(ref_stream, chrom)
+      if e!=nil { return e }
+    }
+    first_path = false
+
+    if fjr.path < path { return fmt.Errorf("tile path %04x not in assembly", fjr.path) }
+
+    if fjr.path > path {
+      e = _ref_stream_skip(ref_stream, end-start)
+      if e!=nil { return fmt.Errorf("skipping path %04x in reference stream: %v", path, e) }
+      cur_chrom = chrom
+      continue
+    }
+
+    tiles,e := fjr._section()
+    if e!=nil { return e }
+
+    if chrom != cur_chrom { out.WriteString(fmt.Sprintf(">C{%s}", chrom)) }
+    out.WriteString(fmt.Sprintf(">P{%d}\n", start))
+    cur_chrom = chrom
+
+    fji := FastJInfo{}
+    fji.RefPos = start
+    fji.Chrom = chrom
+    fji.AnnotateTileId = g.AnnotateTileId
+
+    e = fji.Pasta(bufio.NewReader(bytes.NewReader(tiles)),
+      ref_stream,
+      bufio.NewReader(bytes.NewReader(append([]byte(header+"\n"), body...))),
+      out)
+    if e!=nil { return fmt.Errorf("path %04x: %v", path, e) }
+  }
+
+  return out.Flush()
+}
@@ -0,0 +1,303 @@
+package fastj
+
+// Consistency checks for FastJ.  Every problem found is reported
+// with the tile ID it was found on.
+//
+
+import "io"
+import "fmt"
+import "bufio"
+import "strconv"
+import "strings"
+
+import "github.com/abeconnelly/sloppyjson"
+
+import "github.com/abeconnelly/pasta"
+
+type FastJChecker struct {
+
+  // Tags, per path, indexed by the step they end
+  //
+  Tag map[int][]string
+
+  // Assembly end position, per path, indexed by step
+  //
+  AssemblyEndPos map[int]map[int]int
+  AssemblyLastStep map[int]int
+
+  // Set if the FastJ is a tile library rather than a
+  // sample, so its tiles don't follow on from each other
+  //
+  Library bool
+
+  // Next step expected, keyed by "path:allele"
+  //
+  next_step map[string]int
+
+  NTile int
+  NError int
+}
+
+func (fjc *FastJChecker) Init() {
+  fjc.next_step = make(map[string]int)
+  fjc.NTile = 0
+  fjc.NError = 0
+}
+
+func (fjc *FastJChecker) LoadTag(tag_stream *bufio.Reader) error {
+  fjc.Tag = make(map[int][]string)
+
+  for {
+    header,body,e := _read_section(tag_stream)
+    if e==io.EOF { break }
+    if e!=nil { return e }
+
+    _path,e := strconv.ParseUint(strings.Split(header[1:], ".")[0], 16, 64)
+    if e!=nil { return fmt.Errorf("invalid tag header '%s': %v", header, e) }
+
+    tags := []string{}
+    for _,l := range strings.Split(string(body), "\n") {
+      l = strings.TrimSpace(l)
+      if len(l)>0 { tags = append(tags, l) }
+    }
+    fjc.Tag[int(_path)] = tags
+  }
+
+  return nil
+}
+
+func (fjc *FastJChecker) LoadAssembly(assembly_stream *bufio.Reader) error {
+  fjc.AssemblyEndPos = make(map[int]map[int]int)
+  fjc.AssemblyLastStep = make(map[int]int)
+
+  for {
+    header,body,e := _read_section(assembly_stream)
+    if e==io.EOF { break }
+    if e!=nil { return e }
+
+    parts := strings.Split(strings.Trim(header, " \t>"), ":")
+    if len(parts)<3 { return fmt.Errorf("invalid assembly header '%s'", header) }
+    _path,e := strconv.ParseUint(parts[2], 16, 64)
+    if e!=nil { return fmt.Errorf("invalid assembly header '%s': %v", header, e) }
+    path := int(_path)
+
+    fjc.AssemblyEndPos[path] = make(map[int]int)
+    fjc.AssemblyLastStep[path] = -1
+
+    for _,l := range strings.Split(string(body), "\n") {
+      fields := strings.Fields(l)
+      if len(fields)<2 { continue }
+
+      _step,e := strconv.ParseUint(fields[0], 16, 64)
+      if e!=nil { return fmt.Errorf("invalid assembly line '%s': %v", l, e) }
+      _pos,e := strconv.ParseUint(fields[1], 10, 64)
+      if e!=nil { return fmt.Errorf("invalid assembly line '%s': %v", l, e) }
+
+      fjc.AssemblyEndPos[path][int(_step)] = int(_pos)
+      if int(_step) > fjc.AssemblyLastStep[path] { fjc.AssemblyLastStep[path] = int(_step) }
+    }
+  }
+
+  return nil
+}
+
+// Tags are compared ignoring case, with no-call positions
+// in the sequence matching any base.
+//
+func _tag_match(seq []byte, tag string) bool {
+  if len(seq)!=len(tag) { return false }
+  for ii:=0; ii<len(seq); ii++ {
+    if (seq[ii]=='n') || (seq[ii]=='N') { continue }
+    if pasta.ToLower(seq[ii]) != pasta.ToLower(tag[ii]) { return false }
+  }
+  return true
+}
+
+func (fjc *FastJChecker) _report(out *bufio.Writer, tile_id string, format string, a ...interface{}) {
+  fjc.NError++
+  out.WriteString(fmt.Sprintf("%s\t%s\n", tile_id, fmt.Sprintf(format, a...)))
+}
+
+func (fjc *FastJChecker) _check_tile(sj *sloppyjson.SloppyJSON, seq []byte, out *bufio.Writer) {
+  fjc.NTile++
+
+  tile_id := ""
+  if x,ok := sj.O["tileID"] ; ok { tile_id = x.S }
+
+  path,_,step,varid,e := parse_tile(tile_id)
+  if e!=nil {
+    fjc._report(out, tile_id, "invalid tileID: %v", e)
+    return
+  }
+
+  _str := func(key string) (string, bool) {
+    x,ok := sj.O[key]
+    if !ok { return "", false }
+    return x.S, true
+  }
+  _int := func(key string) (int, bool) {
+    x,ok := sj.O[key]
+    if !ok { return 0, false }
+    return int(x.P), true
+  }
+  _bool := func(key string) (bool, bool) {
+    x,ok := sj.O[key]
+    if !ok { return false, false }
+    return x.Y=="true", true
+  }
+
+  if md5sum,ok := _str("md5sum") ; ok {
+    if s := _m5sum_str(seq) ; s!=md5sum {
+      fjc._report(out, tile_id, "md5sum %s does not match sequence (%s)", md5sum, s)
+    }
+  } else {
+    fjc._report(out, tile_id, "missing md5sum")
+  }
+
+  if n,ok := _int("n") ; ok && (n!=len(seq)) {
+    fjc._report(out, tile_id, "n %d does not match sequence length %d", n, len(seq))
+  }
+
+  if noc,ok := _int("nocallCount") ; ok {
+    if c := _noc_count(seq) ; c!=noc {
+      fjc._report(out, tile_id, "nocallCount %d does not match sequence (%d)", noc, c)
+    }
+  }
+
+  beg_tag,_ := _str("startTag")
+  end_tag,_ := _str("endTag")
+
+  if len(beg_tag)+len(end_tag) > len(seq) {
+    fjc._report(out, tile_id, "sequence length %d shorter than tags", len(seq))
+    return
+  }
+
+  if !_tag_match(seq[:len(beg_tag)], beg_tag) {
+    fjc._report(out, tile_id, "startTag %s does not match start of sequence %s", beg_tag, seq[:len(beg_tag)])
+  }
+  if !_tag_match(seq[len(seq)-len(end_tag):], end_tag) {
+    fjc._report(out, tile_id, "endTag %s does not match end of sequence %s", end_tag, seq[len(seq)-len(end_tag):])
+  }
+
+  if tm,ok := _str("tagmask_md5sum") ; ok {
+    if s := _m5sum_tagmask_str(seq, beg_tag, end_tag) ; s!=tm {
+      fjc._report(out, tile_id, "tagmask_md5sum %s does not match sequence (%s)", tm, s)
+    }
+  }
+
+  start_tile,ok_s := _bool("startTile")
+  end_tile,ok_e := _bool("endTile")
+  if ok_s && (start_tile != (len(beg_tag)==0)) {
+    fjc._report(out, tile_id, "startTile %v inconsistent with startTag '%s'", start_tile, beg_tag)
+  }
+  if ok_e && (end_tile != (len(end_tag)==0)) {
+    fjc._report(out, tile_id, "endTile %v inconsistent with endTag '%s'", end_tile, end_tag)
+  }
+  if ok_s && start_tile && (step!=0) {
+    fjc._report(out, tile_id, "startTile set on step %04x", step)
+  }
+
+  stl,ok := _int("seedTileLength")
+  if !ok || (stl<1) {
+    fjc._report(out, tile_id, "invalid seedTileLength")
+    return
+  }
+  last_step := step+stl-1
+
+  // Tiles of the same allele should follow on from
+  // each other.
+  //
+  allele := varid
+  if a,ok := _int("allele") ; ok { allele = a }
+  if !fjc.Library && ((allele<0) || (allele>1)) {
+    fjc._report(out, tile_id, "invalid allele %d", allele)
+  } else if !fjc.Library {
+    key := fmt.Sprintf("%04x:%d", path, allele)
+    if nxt,ok := fjc.next_step[key] ; ok && (nxt!=step) {
+      fjc._report(out, tile_id, "expected step %04x for allele %d, got %04x", nxt, allele, step)
+    }
+    fjc.next_step[key] = step+stl
+  }
+
+  if fjc.Tag!=nil {
+    if tags,ok := fjc.Tag[path] ; !ok {
+      fjc._report(out, tile_id, "no tags for path %04x", path)
+    } else {
+      if (len(beg_tag)>0) && ((step<1) || (step-1>=len(tags)) || (tags[step-1]!=beg_tag)) {
+        fjc._report(out, tile_id, "startTag %s does not match tag set", beg_tag)
+      }
+      if (len(end_tag)>0) && ((last_step>=len(tags)) || (tags[last_step]!=end_tag)) {
+        fjc._report(out, tile_id, "endTag %s does not match tag set", end_tag)
+      }
+      if ok_e && (end_tile != (last_step==len(tags))) {
+        fjc._report(out, tile_id, "endTile %v inconsistent with tag set (step %04x of %04x)", end_tile, last_step, len(tags))
+      }
+    }
+  }
+
+  if fjc.AssemblyEndPos!=nil {
+    if endpos,ok := fjc.AssemblyEndPos[path] ; !ok {
+      fjc._report(out, tile_id, "no assembly for path %04x", path)
+    } else {
+      if last_step > fjc.AssemblyLastStep[path] {
+        fjc._report(out, tile_id, "seedTileLength %d runs past the last assembly step %04x", stl, fjc.AssemblyLastStep[path])
+      } else if ok_e && (end_tile != (last_step==fjc.AssemblyLastStep[path])) {
+        fjc._report(out, tile_id, "endTile %v inconsistent with assembly", end_tile)
+      }
+
+      // Locus end should be the assembly end of the last step
+      // the tile covers.
+      //
+      if x,ok := sj.O["locus"] ; ok && (len(x.L)>0) {
+        if y,ok := x.L[0].O["build"] ; ok {
+          f := strings.Fields(y.S)
+          if len(f)>=4 {
+            locus_end,e := strconv.Atoi(f[len(f)-1])
+            if asm_end,ok := endpos[last_step] ; (e==nil) && ok && (locus_end!=asm_end) {
+              fjc._report(out, tile_id, "locus end %d does not match assembly end %d for seedTileLength %d", locus_end, asm_end, stl)
+            }
+          }
+        }
+      }
+    }
+  }
+
+}
+
+// Check every tile in the FastJ stream, writing one line per
+// problem found.
+//
+func (fjc *FastJChecker) Check(fastj_stream *bufio.Reader, out *bufio.Writer) error {
+  var sj *sloppyjson.SloppyJSON
+  seq := make([]byte, 0, 1024)
+
+  line_no := 0
+  for {
+    l,e := fastj_stream.ReadString('\n')
+    if (e!=nil) && (e!=io.EOF) { return e }
+    line_no++
+
+    line := strings.TrimSpace(l)
+
+    if (len(line)>0) && (line[0]=='>') {
+      if sj!=nil { fjc._check_tile(sj, seq, out) }
+
+      var err error
+      sj,err = sloppyjson.Loads(line[1:])
+      if err!=nil {
+        fjc.NError++
+        out.WriteString(fmt.Sprintf("line %d\terror parsing FastJ header: %v\n", line_no, err))
+        sj = nil
+      }
+      seq = seq[0:0]
+    } else if len(line)>0 {
+      seq = append(seq, line...)
+    }
+
+    if e==io.EOF { break }
+  }
+
+  if sj!=nil { fjc._check_tile(sj, seq, out) }
+
+  return out.Flush()
+}
@@ -0,0 +1,358 @@
+package fastj
+
+// Convert a whole genome rotini stream to FastJ, one tile path
+// per job, fanning the jobs out over a pool of workers.
+//
+// The assembly and tag set are read path by path in lockstep with the
+// rotini stream, so only the paths currently being worked on are held
+// in memory.  Both are expected to list tile paths in the same order
+// as the rotini stream visits them.
+//
+
+import "io"
+import "os"
+import "fmt"
+import "sync"
+import "bytes"
+import "bufio"
+import "strconv"
+import "strings"
+import "path/filepath"
+
+import "github.com/abeconnelly/pasta"
+
+type FastJPathJob struct {
+  Index int
+
+  Path int
+  Build string
+  Chrom string
+
+  // Reference range of the path, [Start,End)
+  //
+  Start int
+  End int
+
+  Assembly []byte
+  Tag []byte
+  Rotini []byte
+
+  Out bytes.Buffer
+  Err error
+}
+
+type FastJParallel struct {
+  Workers int
+
+  // Reference position the rotini stream, and the first
+  // path of the assembly, start at.
+  //
+  RefStart int
+
+  RefBuild string
+  LibraryVersion int
+  Library *TileLibrary
+
+  // If set, each path is written to its own file in this
+  // directory, otherwise paths are written in order to the
+  // output stream.
+  //
+  OutDir string
+
+  assembly_stream *bufio.Reader
+  tag_stream *bufio.Reader
+
+  tag_header string
+  tag_body []byte
+
+  chrom_end map[string]int
+  n_job int
+}
+
+func (p *FastJParallel) Init(assembly_stream, tag_stream *bufio.Reader) {
+  if p.Workers < 1 { p.Workers = 1 }
+  p.assembly_stream = assembly_stream
+  p.tag_stream = tag_stream
+  p.chrom_end = make(map[string]int)
+  p.n_job = 0
+}
+
+// Read a '>' header line and the lines that follow it up to
+// the next header.
+//
+func _read_section(stream *bufio.Reader) (string, []byte, error) {
+  header := ""
+  body := []byte{}
+
+  for {
+    if len(header)>0 {
+      b,e := stream.Peek(1)
+      if (e!=nil) || (b[0]=='>') { break }
+    }
+
+    l,e := stream.ReadString('\n')
+    if (e!=nil) && (e!=io.EOF) { return header, body, e }
+
+    if len(header)==0 {
+      line := strings.TrimSpace(l)
+      if len(line)>0 {
+        if line[0]!='>' { return header, body, fmt.Errorf("expected header, got '%s'", line) }
+        header = line
+      }
+    } else {
+      body = append(body, l...)
+    }
+
+    if e==io.EOF { break }
+  }
+
+  if len(header)==0 { return header, body, io.EOF }
+  return header, body, nil
+}
+
+// Next path from the assembly, along with its tags.
+//
+func (p *FastJParallel) _next_job() (*FastJPathJob, error) {
+  header,body,e := _read_section(p.assembly_stream)
+  if e!=nil { return nil, e }
+
+  parts := strings.Split(strings.Trim(header, " \t>"), ":")
+  if len(parts)<3 { return nil, fmt.Errorf("invalid assembly header '%s'", header) }
+  _path,e := strconv.ParseUint(parts[2], 16, 64)
+  if e!=nil { return nil, fmt.Errorf("invalid assembly header '%s': %v", header, e) }
+
+  job := &FastJPathJob{}
+  job.Index = p.n_job
+  job.Path = int(_path)
+  job.Build = parts[0]
+  if len(p.RefBuild)>0 { job.Build = p.RefBuild }
+  job.Chrom = parts[1]
+  start,ok := p.chrom_end[job.Chrom]
+  if !ok && (p.n_job==0) { start = p.RefStart }
+  job.Start = start
+  job.End = job.Start
+
+  lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+  for ii:=len(lines)-1; ii>=0; ii-- {
+    fields := strings.Fields(lines[ii])
+    if len(fields)<2 { continue }
+    _pos,e := strconv.ParseUint(fields[1], 10, 64)
+    if e!=nil { return nil, fmt.Errorf("invalid assembly line '%s': %v", lines[ii], e) }
+    job.End = int(_pos)
+    break
+  }
+  p.chrom_end[job.Chrom] = job.End
+
+  job.Assembly = append([]byte(header+"\n"), body...)
+
+  // Skip tag sections for paths not in the assembly
+  //
+  for {
+    if len(p.tag_header)==0 {
+      p.tag_header,p.tag_body,e = _read_section(p.tag_stream)
+      if e==io.EOF { return nil, fmt.Errorf("no tags for path %04x", job.Path) }
+      if e!=nil { return nil, e }
+    }
+
+    _tag_path,e := strconv.ParseUint(strings.Split(p.tag_header[1:], ".")[0], 16, 64)
+    if e!=nil { return nil, fmt.Errorf("invalid tag header '%s': %v", p.tag_header, e) }
+
+    if int(_tag_path) > job.Path { return nil, fmt.Errorf("no tags for path %04x", job.Path) }
+
+    if int(_tag_path) == job.Path {
+      job.Tag = append([]byte(p.tag_header+"\n"), p.tag_body...)
+      p.tag_header = ""
+      break
+    }
+    p.tag_header = ""
+  }
+
+  p.n_job++
+  return job, nil
+}
+
+func (p *FastJParallel) _convert(job *FastJPathJob) {
+  fji := FastJInfo{}
+  fji.RefPos = job.Start
+  fji.AssemblyEndPos = job.Start
+  fji.RefBuild = job.Build
+  fji.Chrom = job.Chrom
+  fji.TagPath = job.Path
+  fji.LibraryVersion = p.LibraryVersion
+  fji.Library = p.Library
+
+  out := bufio.NewWriter(&job.Out)
+  job.Err = fji.Convert(bufio.NewReader(bytes.NewReader(job.Rotini)),
+    bufio.NewReader(bytes.NewReader(job.Tag)),
+    bufio.NewReader(bytes.NewReader(job.Assembly)),
+    out)
+  if job.Err!=nil { job.Err = fmt.Errorf("path %04x: %v", job.Path, job.Err) }
+  out.Flush()
+
+  job.Rotini = nil
+  job.Tag = nil
+  job.Assembly = nil
+}
+
+func (p *FastJParallel) _write(job *FastJPathJob, out *bufio.Writer) error {
+  if len(p.OutDir)==0 {
+    _,e := out.Write(job.Out.Bytes())
+    return e
+  }
+
+  fp,e := os.Create(filepath.Join(p.OutDir, fmt.Sprintf("%04x.fj", job.Path)))
+  if e!=nil { return e }
+  defer fp.Close()
+  _,e = fp.Write(job.Out.Bytes())
+  return e
+}
+
+// Split the rotini stream by tile path and convert each path to FastJ.
+// Paths are written out in assembly order.
+//
+func (p *FastJParallel) Convert(rotini_stream *bufio.Reader, out *bufio.Writer) error {
+  job_ch := make(chan *FastJPathJob, p.Workers)
+  done_ch := make(chan *FastJPathJob, p.Workers)
+
+  // Bound the number of paths held in memory, including
+  // finished paths waiting on an earlier one to be written.
+  //
+  slot := make(chan bool, 2*p.Workers)
+
+  var wg sync.WaitGroup
+  for ii:=0; ii<p.Workers; ii++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for job := range job_ch {
+        p._convert(job)
+        done_ch <- job
+      }
+    }()
+  }
+
+  // Write finished paths in order
+  //
+  write_err := make(chan error, 1)
+  go func() {
+    var err error
+    pending := make(map[int]*FastJPathJob)
+    next_idx := 0
+    for job := range done_ch {
+      pending[job.Index] = job
+      for {
+        j,ok := pending[next_idx]
+        if !ok { break }
+        delete(pending, next_idx)
+        next_idx++
+
+        if err==nil { err = j.Err }
+        if err==nil { err = p._write(j, out) }
+        <-slot
+      }
+    }
+    if err==nil { err = out.Flush() }
+    write_err <- err
+  }()
+
+  split_err := p._split(rotini_stream, job_ch, slot)
+
+  close(job_ch)
+  wg.Wait()
+  close(done_ch)
+
+  err := <-write_err
+  if split_err!=nil { return split_err }
+  return err
+}
+
+func (p *FastJParallel) _split(stream *bufio.Reader, job_ch chan *FastJPathJob, slot chan bool) error {
+  var job *FastJPathJob
+  var e error
+
+  // Jobs are renumbered so that only paths with
+  // rotini data are sent to be converted.
+  //
+  n_sent := 0
+  send := func() {
+    if (job==nil) || (len(job.Rotini)==0) { return }
+    job.Index = n_sent
+    n_sent++
+    slot <- true
+    job_ch <- job
+  }
+
+  job,e = p._next_job()
+  if e==io.EOF { return nil }
+  if e!=nil { return e }
+
+  chrom := ""
+  ref_pos := p.RefStart
+
+  // Advance to the path holding `ref_pos` on `chrom`,
+  // sending the paths passed over.  Returns false if
+  // the assembly has run out.
+  //
+  advance := func() (bool, error) {
+    for (job.Chrom != chrom) || (ref_pos >= job.End) {
+      if (job.Chrom == chrom) && (ref_pos < job.Start) { return true, nil }
+      send()
+      job,e = p._next_job()
+      if e==io.EOF { job = nil ; return false, nil }
+      if e!=nil { return false, e }
+    }
+    return true, nil
+  }
+
+  for {
+    ch0,e0 := stream.ReadByte()
+    for (e0==nil) && ((ch0=='\n') || (ch0==' ') || (ch0=='\r') || (ch0=='\t')) {
+      ch0,e0 = stream.ReadByte()
+    }
+    if e0!=nil { break }
+
+    if ch0=='>' {
+      msg,e := pasta.ControlMessageProcess(stream)
+      if e!=nil { return fmt.Errorf("invalid control message") }
+
+      // An unknown chromosome ("Unk") is taken to be the
+      // chromosome of the current path.
+      //
+      if msg.Type == pasta.CHROM {
+        c := msg.Chrom
+        if c == "Unk" { c = "" }
+        if (len(chrom)>0) && (c!=chrom) { ref_pos = 0 }
+        chrom = c
+      } else if msg.Type == pasta.POS {
+        ref_pos = msg.RefPos
+      }
+      continue
+    }
+
+    ch1,e1 := stream.ReadByte()
+    for (e1==nil) && ((ch1=='\n') || (ch1==' ') || (ch1=='\r') || (ch1=='\t')) {
+      ch1,e1 = stream.ReadByte()
+    }
+    if e1!=nil { break }
+
+    // Streams without a chromosome name are taken to be
+    // on the chromosome of the current path.
+    //
+    if len(chrom)==0 { chrom = job.Chrom }
+
+    ok,e := advance()
+    if e!=nil { return e }
+    if !ok { break }
+
+    if (job.Chrom==chrom) && (ref_pos>=job.Start) {
+      job.Rotini = append(job.Rotini, ch0, ch1)
+    }
+
+    if (ch0=='.') && (ch1=='.') { continue }
+    if (pasta.BPState[ch0]==pasta.INS) || (pasta.BPState[ch1]==pasta.INS) { continue }
+    ref_pos++
+  }
+
+  send()
+  return nil
+}
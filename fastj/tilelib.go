@@ -0,0 +1,372 @@
+package fastj
+
+// Tile library: a table of known tile variants, keyed by tile
+// position (path and step) and the MD5 sum of the tile sequence.
+
+import "io"
+import "fmt"
+import "sort"
+import "strings"
+import "bufio"
+import "sync"
+
+import "github.com/abeconnelly/sloppyjson"
+
+type TileLibraryEntry struct {
+  Path int
+  Version int
+  Step int
+  VarId int
+  Md5Sum string
+}
+
+type TileLibrary struct {
+  Version int
+
+  // Keyed by "path.step:md5sum"
+  //
+  VarIdMap map[string]int
+
+  // Next free variant ID, keyed by "path.step"
+  //
+  NextVarId map[string]int
+
+  Entry []TileLibraryEntry
+
+  mu sync.Mutex
+}
+
+func (lib *TileLibrary) Init() {
+  lib.Version = 0
+  lib.VarIdMap = make(map[string]int)
+  lib.NextVarId = make(map[string]int)
+  lib.Entry = []TileLibraryEntry{}
+}
+
+func _tile_pos_key(path, step int) string {
+  return fmt.Sprintf("%04x.%04x", path, step)
+}
+
+func (lib *TileLibrary) _add(ent TileLibraryEntry) {
+  pos_key := _tile_pos_key(ent.Path, ent.Step)
+  lib.VarIdMap[pos_key + ":" + ent.Md5Sum] = ent.VarId
+  if ent.VarId >= lib.NextVarId[pos_key] { lib.NextVarId[pos_key] = ent.VarId+1 }
+  lib.Entry = append(lib.Entry, ent)
+}
+
+// Load a tile library.  Either FastJ, where the "tileID" and "md5sum"
+// fields are taken from each header line and the sequence is ignored,
+// or a table of whitespace separated "tileID md5sum" lines.
+//
+func (lib *TileLibrary) Load(stream *bufio.Reader) error {
+  line_no := 0
+  for {
+    l,e := stream.ReadString('\n')
+    if (e!=nil) && (e!=io.EOF) { return e }
+    line_no++
+
+    line := strings.TrimSpace(l)
+
+    tile_id := ""
+    md5sum := ""
+
+    if strings.HasPrefix(line, ">{") {
+      sj,err := sloppyjson.Loads(line[1:])
+      if err!=nil { return fmt.Errorf("error parsing FastJ header at line %d: %v", line_no, err) }
+      tile_id = sj.O["tileID"].S
+      md5sum = sj.O["md5sum"].S
+    } else if (len(line)>0) && (line[0]!='#') {
+      fields := strings.Fields(line)
+      if len(fields)>=2 && strings.Count(fields[0], ".")==3 {
+        tile_id = fields[0]
+        md5sum = fields[1]
+      }
+    }
+
+    if len(tile_id)>0 {
+      path,ver,step,varid,err := parse_tile(tile_id)
+      if err!=nil { return fmt.Errorf("invalid tileID '%s' at line %d: %v", tile_id, line_no, err) }
+      if ver > lib.Version { lib.Version = ver }
+
+      if _,ok := lib.VarIdMap[_tile_pos_key(path, step) + ":" + md5sum] ; !ok {
+        lib._add(TileLibraryEntry{path, ver, step, varid, md5sum})
+      }
+    }
+
+    if e==io.EOF { break }
+  }
+
+  return nil
+}
+
+// Variant ID for the tile at `path` and `step` with MD5 sum `md5sum`.
+// New tiles are added to the library with the next free variant ID.
+// Safe to call from several goroutines.
+//
+func (lib *TileLibrary) VarId(path, step int, md5sum string) int {
+  lib.mu.Lock()
+  defer lib.mu.Unlock()
+
+  pos_key := _tile_pos_key(path, step)
+  if varid,ok := lib.VarIdMap[pos_key + ":" + md5sum] ; ok { return varid }
+
+  varid := lib.NextVarId[pos_key]
+  lib._add(TileLibraryEntry{path, lib.Version, step, varid, md5sum})
+  return varid
+}
+
+// Write the library as a "tileID md5sum" table, ordered by
+// tile position then variant ID.
+//
+func (lib *TileLibrary) Write(out *bufio.Writer) error {
+  ent := make([]TileLibraryEntry, len(lib.Entry))
+  copy(ent, lib.Entry)
+
+  sort.SliceStable(ent, func(i, j int) bool {
+    if ent[i].Path != ent[j].Path { return ent[i].Path < ent[j].Path }
+    if ent[i].Step != ent[j].Step { return ent[i].Step < ent[j].Step }
+    return ent[i].VarId < ent[j].VarId
+  })
+
+  for ii:=0; ii<len(ent); ii++ {
+    _,e := out.WriteString(fmt.Sprintf("%04x.%02x.%04x.%03x\t%s\n",
+      ent[ii].Path, ent[ii].Version, ent[ii].Step, ent[ii].VarId, ent[ii].Md5Sum))
+    if e!=nil { return e }
+  }
+
+  return out.Flush()
+}
+
+//--
+
+// A tile variant seen while building a library from sample FastJ.
+//
+type _tile_lib_var struct {
+  step int
+  md5sum string
+  tagmask_md5sum string
+
+  seed_tile_length int
+  start_tag string
+  end_tag string
+  locus string
+
+  seq []byte
+  count int
+  varid int
+}
+
+// Build a tile library for a single tile path from the FastJ of
+// many samples.  Tiles are deduplicated on their tag masked MD5 sum
+// and given variant IDs in order of decreasing frequency, ties broken
+// by MD5 sum so that the assignment doesn't depend on the order the
+// samples are read in.
+//
+type TileLibraryBuilder struct {
+  Path int
+  Version int
+
+  SampleName []string
+
+  // Keyed by "step:tagmask_md5sum"
+  //
+  Tile map[string]*_tile_lib_var
+
+  // Per sample and allele, tile start step to tile key
+  //
+  SampleTile [][2]map[int]string
+
+  MinStep int
+  MaxStep int
+}
+
+func (b *TileLibraryBuilder) Init(path, version int) {
+  b.Path = path
+  b.Version = version
+  b.SampleName = []string{}
+  b.Tile = make(map[string]*_tile_lib_var)
+  b.SampleTile = [][2]map[int]string{}
+  b.MinStep = -1
+  b.MaxStep = -1
+}
+
+func (b *TileLibraryBuilder) _add_tile(sample_idx int, sj *sloppyjson.SloppyJSON, seq []byte) error {
+  tile_id := sj.O["tileID"].S
+  path,_,step,v,e := parse_tile(tile_id)
+  if e!=nil { return fmt.Errorf("invalid tileID '%s': %v", tile_id, e) }
+  if path!=b.Path { return fmt.Errorf("tile %s not on tile path %04x", tile_id, b.Path) }
+
+  if a,ok := sj.O["allele"] ; ok { v = int(a.P) }
+  if (v<0) || (v>1) { return fmt.Errorf("invalid allele %d for tile %s", v, tile_id) }
+
+  stl := 1
+  if x,ok := sj.O["seedTileLength"] ; ok { stl = int(x.P) }
+
+  beg_tag,end_tag := "",""
+  if x,ok := sj.O["startTag"] ; ok { beg_tag = x.S }
+  if x,ok := sj.O["endTag"] ; ok { end_tag = x.S }
+
+  if len(seq) < len(beg_tag)+len(end_tag) {
+    return fmt.Errorf("tile %s sequence shorter than its tags", tile_id)
+  }
+
+  tagmask_md5sum := _m5sum_tagmask_str(seq, beg_tag, end_tag)
+  key := fmt.Sprintf("%04x:%s", step, tagmask_md5sum)
+
+  tv,ok := b.Tile[key]
+  if !ok {
+    tv = &_tile_lib_var{}
+    tv.step = step
+    tv.md5sum = _m5sum_str(seq)
+    tv.tagmask_md5sum = tagmask_md5sum
+    tv.seed_tile_length = stl
+    tv.start_tag = beg_tag
+    tv.end_tag = end_tag
+    if x,ok := sj.O["locus"] ; ok && len(x.L)>0 {
+      if y,ok := x.L[0].O["build"] ; ok { tv.locus = y.S }
+    }
+    tv.seq = append([]byte{}, seq...)
+    tv.varid = -1
+    b.Tile[key] = tv
+  }
+  tv.count++
+
+  b.SampleTile[sample_idx][v][step] = key
+
+  if (b.MinStep<0) || (step<b.MinStep) { b.MinStep = step }
+  if step+stl-1 > b.MaxStep { b.MaxStep = step+stl-1 }
+
+  return nil
+}
+
+// Add the tiles of one sample's FastJ.
+//
+func (b *TileLibraryBuilder) AddSample(name string, fastj_stream *bufio.Reader) error {
+  sample_idx := len(b.SampleName)
+  b.SampleName = append(b.SampleName, name)
+  b.SampleTile = append(b.SampleTile, [2]map[int]string{ make(map[int]string), make(map[int]string) })
+
+  var sj *sloppyjson.SloppyJSON
+  seq := make([]byte, 0, 1024)
+
+  line_no := 0
+  for {
+    l,e := fastj_stream.ReadString('\n')
+    if (e!=nil) && (e!=io.EOF) { return e }
+    line_no++
+
+    line := strings.TrimSpace(l)
+
+    if (len(line)>0) && (line[0]=='>') {
+      if sj!=nil {
+        err := b._add_tile(sample_idx, sj, seq)
+        if err!=nil { return fmt.Errorf("%s: %v", name, err) }
+      }
+
+      var err error
+      sj,err = sloppyjson.Loads(line[1:])
+      if err!=nil { return fmt.Errorf("%s: error parsing FastJ header at line %d: %v", name, line_no, err) }
+      seq = seq[0:0]
+    } else if len(line)>0 {
+      seq = append(seq, line...)
+    }
+
+    if e==io.EOF { break }
+  }
+
+  if sj!=nil {
+    err := b._add_tile(sample_idx, sj, seq)
+    if err!=nil { return fmt.Errorf("%s: %v", name, err) }
+  }
+
+  return nil
+}
+
+func (b *TileLibraryBuilder) _step_tiles() map[int][]*_tile_lib_var {
+  step_tiles := make(map[int][]*_tile_lib_var)
+  for _,tv := range b.Tile {
+    step_tiles[tv.step] = append(step_tiles[tv.step], tv)
+  }
+  return step_tiles
+}
+
+// Assign variant IDs at each step, most frequent tile first.
+//
+func (b *TileLibraryBuilder) AssignVarIds() {
+  for _,tiles := range b._step_tiles() {
+    sort.Slice(tiles, func(i, j int) bool {
+      if tiles[i].count != tiles[j].count { return tiles[i].count > tiles[j].count }
+      return tiles[i].tagmask_md5sum < tiles[j].tagmask_md5sum
+    })
+    for ii:=0; ii<len(tiles); ii++ { tiles[ii].varid = ii }
+  }
+}
+
+// Write the library as FastJ, ordered by step then variant ID.
+//
+func (b *TileLibraryBuilder) WriteFastJ(out *bufio.Writer) error {
+  tiles := make([]*_tile_lib_var, 0, len(b.Tile))
+  for _,tv := range b.Tile { tiles = append(tiles, tv) }
+  sort.Slice(tiles, func(i, j int) bool {
+    if tiles[i].step != tiles[j].step { return tiles[i].step < tiles[j].step }
+    return tiles[i].varid < tiles[j].varid
+  })
+
+  fji := FastJInfo{}
+
+  for ii:=0; ii<len(tiles); ii++ {
+    tv := tiles[ii]
+
+    s_epos := 24
+    if s_epos > len(tv.seq) { s_epos = len(tv.seq) }
+    e_spos := len(tv.seq)-24
+    if e_spos < 0 { e_spos = 0 }
+
+    out.WriteString(fmt.Sprintf(`>{"tileID":"%04x.%02x.%04x.%03x"`, b.Path, b.Version, tv.step, tv.varid))
+    out.WriteString(fmt.Sprintf(`,"md5sum":"%s"`, tv.md5sum))
+    out.WriteString(fmt.Sprintf(`,"tagmask_md5sum":"%s"`, tv.tagmask_md5sum))
+    out.WriteString(fmt.Sprintf(`,"locus":[{"build":"%s"}]`, tv.locus))
+    out.WriteString(fmt.Sprintf(`,"n":%d`, len(tv.seq)))
+    out.WriteString(fmt.Sprintf(`,"seedTileLength":%d`, tv.seed_tile_length))
+    out.WriteString(fmt.Sprintf(`,"startTile":%s`, _tf_val(len(tv.start_tag)==0)))
+    out.WriteString(fmt.Sprintf(`,"endTile":%s`, _tf_val(len(tv.end_tag)==0)))
+    out.WriteString(fmt.Sprintf(`,"startSeq":"%s","endSeq":"%s"`, tv.seq[0:s_epos], tv.seq[e_spos:]))
+    out.WriteString(fmt.Sprintf(`,"startTag":"%s"`, tv.start_tag))
+    out.WriteString(fmt.Sprintf(`,"endTag":"%s"`, tv.end_tag))
+    out.WriteString(fmt.Sprintf(`,"nocallCount":%d`, _noc_count(tv.seq)))
+    out.WriteString(fmt.Sprintf(`,"notes":["count %d"]`, tv.count))
+    out.WriteString("}\n")
+
+    fji.WriteFastJSeq(tv.seq, out)
+    out.WriteByte('\n')
+  }
+
+  return out.Flush()
+}
+
+// Write the per sample tile variant vectors, one row per sample
+// allele and one column per step.  Steps covered by a spanning tile
+// that starts at an earlier step are -1.
+//
+func (b *TileLibraryBuilder) WriteVectors(out *bufio.Writer) error {
+  out.WriteString("#sample\tallele")
+  for step:=b.MinStep; (step>=0) && (step<=b.MaxStep); step++ {
+    out.WriteString(fmt.Sprintf("\t%04x.%04x", b.Path, step))
+  }
+  out.WriteString("\n")
+
+  for ii:=0; ii<len(b.SampleName); ii++ {
+    for aa:=0; aa<2; aa++ {
+      out.WriteString(fmt.Sprintf("%s\t%d", b.SampleName[ii], aa))
+      for step:=b.MinStep; (step>=0) && (step<=b.MaxStep); step++ {
+        varid := -1
+        if key,ok := b.SampleTile[ii][aa][step] ; ok { varid = b.Tile[key].varid }
+        out.WriteString(fmt.Sprintf("\t%d", varid))
+      }
+      _,e := out.WriteString("\n")
+      if e!=nil { return e }
+    }
+  }
+
+  return out.Flush()
+}
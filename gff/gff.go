@@ -1,4 +1,9 @@
-package main
+// Package gff converts between PASTA streams and GFF, and
+// GVF (the GFF3 Genome Variation Format).  GFFRefVar and
+// GVFRefVar implement pasta.RefVarPrinter and are registered as
+// the "gff" and "gvf" formats.
+//
+package gff
 
 import "fmt"
 import "strconv"
@@ -12,6 +17,21 @@ import "time"
 
 import "github.com/abeconnelly/pasta"
 
+func init() {
+  pasta.RegisterFormat(pasta.Format{
+    Name: "gff",
+    Description: "GFF",
+    NewReader: func() pasta.VariantReader { g := &GFFRefVar{} ; g.Init() ; return g },
+    NewWriter: func() pasta.VariantWriter { g := &GFFRefVar{} ; g.Init() ; return g },
+  })
+  pasta.RegisterFormat(pasta.Format{
+    Name: "gvf",
+    Description: "GVF (GFF3 Genome Variation Format)",
+    NewReader: func() pasta.VariantReader { g := &GVFRefVar{} ; g.Init() ; return g },
+    NewWriter: func() pasta.VariantWriter { g := &GVFRefVar{} ; g.Init() ; return g },
+  })
+}
+
 type GFFRefVar struct {
   Type int
   MessageType int
@@ -75,6 +95,8 @@ func (g *GFFRefVar) Pos(pos int) {
   g.RefPosUpdate = true
 }
 
+func (g *GFFRefVar) GetRefPos() int { return g.RefPos }
+
 func (g *GFFRefVar) Header(out *bufio.Writer) error {
 
   header := []string{}
@@ -100,7 +122,9 @@ func _bcount(b []byte, c byte) int {
 }
 
 
-func (g *GFFRefVar) Print(vartype int, ref_start, ref_len int, refseq []byte, altseq [][]byte, out *bufio.Writer) error {
+func (g *GFFRefVar) Print(v *pasta.Variant, out *bufio.Writer) error {
+  vartype,ref_start,ref_len := v.Type, v.Pos, v.RefLen
+  refseq,altseq := v.Ref, v.Alleles
 
   if g.PrintHeader {
     g.PrintHeader = false
@@ -578,7 +602,7 @@ func (g *GFFRefVar) Pasta(gff_line string, ref_stream *bufio.Reader, out *bufio.
       var bp_alt byte = '-'
       if i<len(allele_str[a]) { bp_alt = allele_str[a][i] }
 
-      pasta_ch := pasta.SubMap[bp_ref][_tolch(bp_alt)]
+      pasta_ch := pasta.SubMap[bp_ref][pasta.ToLower(bp_alt)]
       if pasta_ch == 0 { return fmt.Errorf("invalid character SubMap[%c][%c] -> '%c' (%d)", bp_ref, bp_alt, pasta_ch, pasta_ch) }
 
       if (g.LFMod>0) && (g.OCounter > 0) && ((g.OCounter%g.LFMod)==0) {
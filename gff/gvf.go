@@ -0,0 +1,498 @@
+package gff
+
+import "fmt"
+import "strconv"
+import "strings"
+import "bufio"
+import "io"
+
+import "time"
+
+import "github.com/abeconnelly/pasta"
+
+// GVF (GFF3 Genome Variation Format, version 1.10) writer and reader.
+//
+// Only variant lines are reported.  Regions not covered by a line are
+// taken to be reference.  Nocall regions are reported as
+// `sequence_alteration` with a `Variant_seq` of `~` (unknown sequence).
+//
+// Each line carries `Reference_seq`, `Variant_seq` (the distinct alleles
+// of the individual, reference included if present) and `Genotype`, the
+// allele indices into `Variant_seq` separated by ':'.
+//
+// Coordinates are 1-based and end inclusive.  Insertions have start equal
+// to end with the insertion to the right of the indicated base.
+//
+type GVFRefVar struct {
+  Type int
+  MessageType int
+  RefSeqFlag bool
+  NocSeqFlag bool
+  Out io.Writer
+  Msg pasta.ControlMessage
+  RefBP byte
+  Allele int
+
+  ChromStr string
+  SrcStr string
+  RefPos int
+
+  PrevChromStr string
+  PrevRefPos int
+
+  OCounter int
+  LFMod int
+
+  PrintHeader bool
+  ChromUpdate bool
+  RefPosUpdate bool
+  Reference string
+
+  VarCount int
+
+  FirstFlag bool
+}
+
+func (g *GVFRefVar) Init() {
+  g.PrintHeader = true
+  g.Reference = "unk"
+
+  g.ChromStr = "Unk"
+  g.SrcStr = "pasta"
+  g.RefPos = 0
+  g.Allele = 2
+
+  g.PrevChromStr = "Unk"
+  g.PrevRefPos = 0
+
+  g.OCounter = 0
+  g.LFMod = 50
+
+  g.ChromUpdate = false
+  g.RefPosUpdate = false
+
+  g.VarCount = 0
+}
+
+func (g *GVFRefVar) Chrom(chr string) {
+  g.ChromStr = chr
+  g.ChromUpdate = true
+}
+
+func (g *GVFRefVar) Pos(pos int) {
+  g.RefPos = pos
+  g.PrevRefPos = pos
+  g.RefPosUpdate = true
+}
+
+func (g *GVFRefVar) GetRefPos() int { return g.RefPos }
+
+func (g *GVFRefVar) Header(out *bufio.Writer) error {
+  header := []string{}
+
+  t := time.Now()
+
+  header = append(header, "##gff-version 3")
+  header = append(header, "##gvf-version 1.10")
+  header = append(header, fmt.Sprintf("##file-date %s", t.Format("2006-01-02")))
+  header = append(header, fmt.Sprintf("##genome-build %s", g.Reference))
+
+  out.WriteString( strings.Join(header, "\n") + "\n" )
+
+  return nil
+}
+
+// Sequence Ontology type for the alleles in `alts` (reference alleles
+// already removed) against `ref`.
+//
+func _gvf_so_type(ref string, alts []string) string {
+  if len(alts)==0 { return "sequence_alteration" }
+
+  if len(ref)==0 { return "insertion" }
+
+  all_del := true
+  all_sub := true
+  for ii:=0; ii<len(alts); ii++ {
+    if alts[ii] == "~" { return "sequence_alteration" }
+    if len(alts[ii])!=0 { all_del = false }
+    if len(alts[ii])!=len(ref) { all_sub = false }
+  }
+
+  if all_del { return "deletion" }
+  if all_sub {
+    if len(ref)==1 { return "SNV" }
+    return "MNP"
+  }
+  return "indel"
+}
+
+func _gvf_seq(s string) string {
+  if len(s)==0 { return "-" }
+  return strings.ToUpper(s)
+}
+
+func (g *GVFRefVar) Print(v *pasta.Variant, out *bufio.Writer) error {
+  vartype,ref_start,ref_len := v.Type, v.Pos, v.RefLen
+  refseq,altseq := v.Ref, v.Alleles
+
+  if g.PrintHeader {
+    g.PrintHeader = false
+    e := g.Header(out)
+    if e!=nil { return e }
+  }
+
+  // No-call runs (">N{...}") carry no sequence so both the
+  // reference and the alleles are written as unknown ('~').
+  //
+  if (vartype == pasta.MSG_REF_NOC) && (v.Msg.Type == pasta.NOC) {
+    if ref_len==0 { return nil }
+    return g._print_nocall_run(ref_start, ref_len, out)
+  }
+
+  if (vartype != pasta.NOC) && (vartype != pasta.ALT) { return nil }
+
+  ref := string(refseq)
+  if (len(ref)>0) && (ref[0]=='-') { ref = "" }
+
+  // Distinct alleles, in allele order, with nocall
+  // alleles spanning the reference reported as '~'.
+  //
+  alleles := []string{}
+  genotype := []string{}
+  alts := []string{}
+  idx_map := make(map[string]int)
+
+  for a:=0; a<len(altseq); a++ {
+    s := string(altseq[a])
+    if (len(s)>0) && (s[0]=='-') { s = "" }
+
+    if (len(s)>0) && (len(s)==len(ref)) && (_bcount(altseq[a], 'n')==len(s)) { s = "~" }
+
+    if _,ok := idx_map[s] ; !ok {
+      idx_map[s] = len(alleles)
+      alleles = append(alleles, s)
+      if s != ref { alts = append(alts, s) }
+    }
+    genotype = append(genotype, fmt.Sprintf("%d", idx_map[s]))
+  }
+
+  if len(alts)==0 { return nil }
+
+  zygosity := "heterozygous"
+  if len(alleles)==1 { zygosity = "homozygous" }
+
+  variant_seq := []string{}
+  for ii:=0; ii<len(alleles); ii++ {
+    if alleles[ii]=="~" {
+      variant_seq = append(variant_seq, "~")
+    } else {
+      variant_seq = append(variant_seq, _gvf_seq(alleles[ii]))
+    }
+  }
+
+  // GFF3 is 1-based, end inclusive.  Zero length features (insertions)
+  // have start equal to end, with the feature to the right of that base.
+  //
+  start := ref_start+1
+  end := ref_start+ref_len
+  if ref_len==0 { start = ref_start }
+
+  g.VarCount++
+
+  attr := []string{}
+  attr = append(attr, fmt.Sprintf("ID=%s:%d:%d", g.ChromStr, start, g.VarCount))
+  attr = append(attr, fmt.Sprintf("Variant_seq=%s", strings.Join(variant_seq, ",")))
+  attr = append(attr, fmt.Sprintf("Reference_seq=%s", _gvf_seq(ref)))
+  attr = append(attr, fmt.Sprintf("Genotype=%s", strings.Join(genotype, ":")))
+  attr = append(attr, fmt.Sprintf("Zygosity=%s", zygosity))
+
+  out.WriteString( fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t.\t+\t.\t%s\n",
+    g.ChromStr, g.SrcStr, _gvf_so_type(ref, alts), start, end, strings.Join(attr, ";")) )
+
+  return nil
+}
+
+func (g *GVFRefVar) _print_nocall_run(ref_start, ref_len int, out *bufio.Writer) error {
+  start := ref_start+1
+  end := ref_start+ref_len
+
+  genotype := []string{}
+  for a:=0; a<g.Allele; a++ { genotype = append(genotype, "0") }
+
+  g.VarCount++
+
+  attr := []string{}
+  attr = append(attr, fmt.Sprintf("ID=%s:%d:%d", g.ChromStr, start, g.VarCount))
+  attr = append(attr, "Variant_seq=~")
+  attr = append(attr, "Reference_seq=~")
+  attr = append(attr, fmt.Sprintf("Genotype=%s", strings.Join(genotype, ":")))
+  attr = append(attr, "Zygosity=homozygous")
+
+  out.WriteString( fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t.\t+\t.\t%s\n",
+    g.ChromStr, g.SrcStr, "sequence_alteration", start, end, strings.Join(attr, ";")) )
+
+  return nil
+}
+
+func (g *GVFRefVar) PrintEnd(out *bufio.Writer) error {
+  if g.PrintHeader {
+    g.PrintHeader = false
+    g.Header(out)
+  }
+  out.Flush()
+  return nil
+}
+
+//---
+
+func (g *GVFRefVar) PastaBegin(out *bufio.Writer) error {
+  g.FirstFlag = true
+  return nil
+}
+
+func (g *GVFRefVar) PastaEnd(out *bufio.Writer) error {
+  out.WriteByte('\n')
+  out.Flush()
+  return nil
+}
+
+func (g *GVFRefVar) _write_pasta_byte(pasta_ch byte, out *bufio.Writer) {
+  if (g.LFMod>0) && (g.OCounter > 0) && ((g.OCounter%g.LFMod)==0) {
+    out.WriteByte('\n')
+  }
+  g.OCounter++
+  out.WriteByte(pasta_ch)
+}
+
+func _gvf_read_ref_bp(ref_stream *bufio.Reader) (byte, error) {
+  b,e := ref_stream.ReadByte()
+  if e!=nil { return b, e }
+  for b == '\n' || b == ' ' || b == '\t' || b == '\r' {
+    b,e = ref_stream.ReadByte()
+    if e!=nil { return b, e }
+  }
+  return b, nil
+}
+
+// Emit reference for `n` bases from the reference stream
+//
+func (g *GVFRefVar) _pasta_ref(n int, ref_stream *bufio.Reader, out *bufio.Writer) error {
+  for ii:=0; ii<n; ii++ {
+    b,e := _gvf_read_ref_bp(ref_stream)
+    if e!=nil { return e }
+    for a:=0; a<g.Allele; a++ { g._write_pasta_byte(b, out) }
+  }
+  return nil
+}
+
+func (g *GVFRefVar) _pasta_header(out *bufio.Writer) {
+  if g.FirstFlag {
+    g.ChromUpdate = true
+    g.RefPosUpdate = true
+  }
+
+  if g.ChromUpdate { out.WriteString( fmt.Sprintf(">C{%s}", g.ChromStr) ) }
+  if g.RefPosUpdate { out.WriteString( fmt.Sprintf(">P{%d}", g.RefPos) ) }
+  if g.ChromUpdate || g.RefPosUpdate { out.WriteByte('\n') }
+
+  g.ChromUpdate = false
+  g.RefPosUpdate = false
+  g.FirstFlag = false
+}
+
+// Handle the rest of the reference stream.  Positions not
+// covered by a GVF line are reference.
+//
+func (g *GVFRefVar) PastaRefEnd(ref_stream *bufio.Reader, out *bufio.Writer) error {
+  g._pasta_header(out)
+
+  for {
+    b,e := _gvf_read_ref_bp(ref_stream)
+    if e==io.EOF { return e }
+    if e!=nil { return fmt.Errorf(fmt.Sprintf("ref_stream error: %v", e)) }
+    for a:=0; a<g.Allele; a++ { g._write_pasta_byte(b, out) }
+  }
+}
+
+// Parse the GVF attribute column into a map
+//
+func _gvf_attributes(attr_str string) map[string]string {
+  attr := make(map[string]string)
+  parts := strings.Split(attr_str, ";")
+  for ii:=0; ii<len(parts); ii++ {
+    kv := strings.SplitN(strings.TrimSpace(parts[ii]), "=", 2)
+    if len(kv)!=2 { continue }
+    attr[kv[0]] = kv[1]
+  }
+  return attr
+}
+
+// Allele sequences, one per allele, from the `Variant_seq` and
+// `Genotype` (or `Zygosity`) attributes.  Unknown ('~') alleles
+// are returned as nil.
+//
+func (g *GVFRefVar) _gvf_alleles(attr map[string]string) ([][]byte, error) {
+  vs,ok := attr["Variant_seq"]
+  if !ok { return nil, fmt.Errorf("no 'Variant_seq' found") }
+
+  variant_seq := [][]byte{}
+  parts := strings.Split(vs, ",")
+  for ii:=0; ii<len(parts); ii++ {
+    if parts[ii] == "~" || parts[ii] == "!" {
+      variant_seq = append(variant_seq, nil)
+    } else if parts[ii] == "-" {
+      variant_seq = append(variant_seq, []byte{})
+    } else {
+      variant_seq = append(variant_seq, []byte(_tol(parts[ii])))
+    }
+  }
+
+  gt_idx := []int{}
+  if gt,ok := attr["Genotype"] ; ok {
+    gt_parts := strings.FieldsFunc(gt, func(r rune) bool { return r==':' || r=='/' || r=='|' })
+    for ii:=0; ii<len(gt_parts); ii++ {
+      v,e := strconv.Atoi(gt_parts[ii])
+      if e!=nil { return nil, e }
+      if (v<0) || (v>=len(variant_seq)) { return nil, fmt.Errorf("Genotype index %d out of range", v) }
+      gt_idx = append(gt_idx, v)
+    }
+  } else {
+
+    // No genotype, fall back to zygosity.  A single heterozygous
+    // variant sequence is paired with the reference.
+    //
+    if (attr["Zygosity"] == "heterozygous") && (len(variant_seq)==1) {
+      ref := []byte{}
+      if rs,ok := attr["Reference_seq"] ; ok && rs!="-" { ref = []byte(_tol(rs)) }
+      variant_seq = append(variant_seq, ref)
+    }
+    for ii:=0; ii<g.Allele; ii++ {
+      if ii < len(variant_seq) {
+        gt_idx = append(gt_idx, ii)
+      } else {
+        gt_idx = append(gt_idx, 0)
+      }
+    }
+  }
+
+  if len(gt_idx)==0 { return nil, fmt.Errorf("no alleles found") }
+  for len(gt_idx) < g.Allele { gt_idx = append(gt_idx, gt_idx[0]) }
+
+  res := [][]byte{}
+  for a:=0; a<g.Allele; a++ { res = append(res, variant_seq[gt_idx[a]]) }
+  return res, nil
+}
+
+// Called on each GVF line
+//
+func (g *GVFRefVar) Pasta(gvf_line string, ref_stream *bufio.Reader, out *bufio.Writer) error {
+
+  if len(gvf_line)==0 { return nil }
+  if gvf_line[0] == '#' { return nil }
+
+  line_parts := strings.Split(gvf_line, "\t")
+  if len(line_parts)<9 {
+    return fmt.Errorf(fmt.Sprintf("could not parse GVF line '%s'", gvf_line))
+  }
+
+  chrom := line_parts[0]
+
+  beg_1ref,e := strconv.Atoi(line_parts[3])
+  if e!=nil { return fmt.Errorf(fmt.Sprintf("ERROR parsing beg int %s", line_parts[3])) }
+
+  end_1ref,e := strconv.Atoi(line_parts[4])
+  if e!=nil { return fmt.Errorf(fmt.Sprintf("ERROR parsing end int %s", line_parts[4])) }
+
+  attr := _gvf_attributes(line_parts[8])
+
+  // An unknown ('~') reference is taken from the reference
+  // stream as is.
+  //
+  ref_str := ""
+  ref_unknown := (attr["Reference_seq"] == "~")
+  if rs,ok := attr["Reference_seq"] ; ok && rs!="-" && !ref_unknown { ref_str = _tol(rs) }
+
+  // Insertions (zero length features) sit to the right of the
+  // indicated base.
+  //
+  beg_0ref := beg_1ref-1
+  n := end_1ref-beg_1ref+1
+  if (len(ref_str)==0) && !ref_unknown {
+    beg_0ref = beg_1ref
+    n = 0
+  }
+
+  if (len(ref_str)>0) && (len(ref_str)!=n) {
+    return fmt.Errorf(fmt.Sprintf("ref sequence length mismatch (len(%s) = %d) != (%d)", ref_str, len(ref_str), n))
+  }
+
+  // A new sequence starts over from its beginning
+  //
+  if chrom!=g.ChromStr {
+    if !g.FirstFlag {
+      g.PrevRefPos = 0
+      g.RefPos = 0
+      g.RefPosUpdate = true
+    }
+    g.ChromUpdate = true
+    g.ChromStr = chrom
+  }
+
+  g._pasta_header(out)
+
+  // Everything between lines is reference
+  //
+  if beg_0ref < g.PrevRefPos {
+    return fmt.Errorf(fmt.Sprintf("GVF lines out of order (%d < %d)", beg_0ref, g.PrevRefPos))
+  }
+  e = g._pasta_ref(beg_0ref - g.PrevRefPos, ref_stream, out)
+  if e!=nil { return e }
+
+  g.PrevRefPos = beg_0ref + n
+  g.PrevChromStr = chrom
+
+  allele_seq,e := g._gvf_alleles(attr)
+  if e!=nil { return e }
+
+  ref_bases := make([]byte, n)
+  for ii:=0; ii<n; ii++ {
+    ref_bases[ii],e = _gvf_read_ref_bp(ref_stream)
+    if e!=nil { return e }
+    if ref_unknown { continue }
+    if ref_bases[ii]!=ref_str[ii] {
+      return fmt.Errorf( fmt.Sprintf("ref stream to GVF ref mismatch (ref stream %c != GVF ref %c @ %d, line '%s')",
+        ref_bases[ii], ref_str[ii], beg_0ref+ii, gvf_line) )
+    }
+  }
+
+  mM := n
+  for a:=0; a<len(allele_seq); a++ {
+    if mM < len(allele_seq[a]) { mM = len(allele_seq[a]) }
+  }
+
+  // Reference shifted to the left, substitutions followed by
+  // insertions and/or deletions.  Unknown alleles are nocalls
+  // over the reference.
+  //
+  for i:=0; i<mM; i++ {
+    for a:=0; a<len(allele_seq); a++ {
+
+      var bp_ref byte = '-'
+      if i<n { bp_ref = ref_bases[i] }
+
+      var bp_alt byte = '-'
+      if allele_seq[a]==nil {
+        if i<n { bp_alt = 'n' }
+      } else if i<len(allele_seq[a]) {
+        bp_alt = pasta.ToLower(allele_seq[a][i])
+      }
+
+      pasta_ch := pasta.SubMap[bp_ref][bp_alt]
+      if pasta_ch == 0 { return fmt.Errorf("invalid character SubMap[%c][%c]", bp_ref, bp_alt) }
+
+      g._write_pasta_byte(pasta_ch, out)
+    }
+  }
+
+  return nil
+}
@@ -14,6 +14,15 @@ import "github.com/abeconnelly/pasta"
 
 var VERSION string = "0.1.0"
 
+func init() {
+  pasta.RegisterFormat(pasta.Format{
+    Name: "gvcf",
+    Description: "gVCF (single sample)",
+    NewReader: func() pasta.VariantReader { g := &GVCFRefVar{} ; g.Init() ; return g },
+    NewWriter: func() pasta.VariantWriter { g := &GVCFRefVar{} ; g.Init() ; return g },
+  })
+}
+
 type GVCFRefVarInfo struct {
   chrom string
 
@@ -26,6 +35,34 @@ type GVCFRefVarInfo struct {
   stream_ref_pos int
 
   right_anchor bool
+
+  // Quality annotations in effect when the line was
+  // recorded (-1 if not known).  `dp_sum` and `dp_len` are
+  // the depth summed over, and the length of, the bases with
+  // a known DP so merged reference blocks can report the mean.
+  //
+  gq int
+  dp int
+  min_dp int
+  dp_sum int
+  dp_len int
+  annot map[string]string
+
+  // Which alleles had a no-call.
+  //
+  nocall []bool
+
+  // Genotype of the stream record, nil once the line has been
+  // combined with another variant.
+  //
+  gt []int
+
+  // Joint (multi-sample) records carry the alleles of every
+  // sample over the record, and which of them had a no-call,
+  // in place of `altseq`.
+  //
+  sample_altseq [][]string
+  sample_nocall [][]bool
 }
 
 type GVCFRefVar struct {
@@ -45,6 +82,14 @@ type GVCFRefVar struct {
   LFMod int
 
   PrintHeader bool
+
+  // Report GQ, DP and MIN_DP from the stream annotations, band
+  // reference blocks on them and declare them in the header.  It
+  // has to be set before the first line is written, so the header
+  // matches every line that follows.
+  //
+  QualityFields bool
+
   Reference string
   DataSource string
 
@@ -72,6 +117,30 @@ type GVCFRefVar struct {
   StateHistory []GVCFRefVarInfo
 
   StreamRefPos int
+
+  // Sample to read when converting (g)VCF to rotini, either
+  // a name from the '#CHROM' header line or a 0-based index.
+  // Empty means the first sample column.
+  //
+  Sample string
+  SampleNames []string
+  SampleFieldPos int
+
+  // Reference blocks are split into GQ (and optionally DP) bands,
+  // GATK style, when QualityFields is set.
+  // Each value is the inclusive lower bound of a band.
+  //
+  GQBands []int
+  DPBands []int
+
+  // Annotation keys, other than GQ and DP, to be passed
+  // through as FORMAT fields.
+  //
+  FormatPassthrough []string
+
+  // Annotation keys to be passed through as INFO fields.
+  //
+  InfoPassthrough []string
 }
 
 func (g *GVCFRefVar) Init() {
@@ -97,12 +166,24 @@ func (g *GVCFRefVar) Init() {
 
   g.StreamRefPos = 0
 
+  g.Sample = ""
+  g.SampleFieldPos = 9
+
+  g.GQBands = []int{5, 20, 60}
+  g.DPBands = []int{}
+  g.FormatPassthrough = []string{}
+  g.InfoPassthrough = []string{}
+
   g.State = pasta.BEG
 }
 
 func (g *GVCFRefVar) Chrom(chr string) { g.ChromStr = chr }
 func (g *GVCFRefVar) Pos(pos int) { g.RefPos = pos }
 func (g *GVCFRefVar) GetRefPos() int { return g.RefPos }
+
+// The full reference sequence is needed for beginning and ending bases
+//
+func (g *GVCFRefVar) FullRefSeq() bool { return true }
 func (g *GVCFRefVar) Header(out *bufio.Writer) error {
 
   hdr := []string{};
@@ -112,12 +193,127 @@ func (g *GVCFRefVar) Header(out *bufio.Writer) error {
   hdr = append(hdr, fmt.Sprintf("##reference=\"%s\"", g.Reference))
   hdr = append(hdr, "##FILTER=<ID=NOCALL,Description=\"Some or all of this record had no sequence calls\">")
   hdr = append(hdr, "##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">")
+  if g.QualityFields {
+    hdr = append(hdr, "##FORMAT=<ID=GQ,Number=1,Type=Integer,Description=\"Genotype Quality\">")
+    hdr = append(hdr, "##FORMAT=<ID=DP,Number=1,Type=Integer,Description=\"Approximate read depth\">")
+    hdr = append(hdr, "##FORMAT=<ID=MIN_DP,Number=1,Type=Integer,Description=\"Minimum DP observed within the GVCF block\">")
+  }
+  for ii:=0; ii<len(g.FormatPassthrough); ii++ {
+    hdr = append(hdr, fmt.Sprintf("##FORMAT=<ID=%s,Number=.,Type=String,Description=\"Passed through from PASTA stream annotation\">", g.FormatPassthrough[ii]))
+  }
   hdr = append(hdr, "##INFO=<ID=END,Number=1,Type=Integer,Description=\"Stop position of the interval\">")
-  hdr = append(hdr, "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tSAMPLE")
+  for ii:=0; ii<len(g.InfoPassthrough); ii++ {
+    hdr = append(hdr, fmt.Sprintf("##INFO=<ID=%s,Number=.,Type=String,Description=\"Passed through from PASTA stream annotation\">", g.InfoPassthrough[ii]))
+  }
+
+  // GQ values below the first bound get a band of their own
+  //
+  if g.QualityFields && (len(g.GQBands)>0) && (g.GQBands[0]>0) {
+    hdr = append(hdr, fmt.Sprintf("##GVCFBlock0-%d=minGQ=0(inclusive),maxGQ=%d(exclusive)", g.GQBands[0], g.GQBands[0]))
+  }
+  for ii:=0; g.QualityFields && (ii<len(g.GQBands)); ii++ {
+    if ii+1 < len(g.GQBands) {
+      hdr = append(hdr, fmt.Sprintf("##GVCFBlock%d-%d=minGQ=%d(inclusive),maxGQ=%d(exclusive)",
+        g.GQBands[ii], g.GQBands[ii+1], g.GQBands[ii], g.GQBands[ii+1]))
+    } else {
+      hdr = append(hdr, fmt.Sprintf("##GVCFBlock%d-100=minGQ=%d(inclusive),maxGQ=100(exclusive)", g.GQBands[ii], g.GQBands[ii]))
+    }
+  }
 
-  out.WriteString( strings.Join(hdr, "\n") + "\n" )
+  sample_col := "SAMPLE"
+  if len(g.SampleNames)>0 { sample_col = strings.Join(g.SampleNames, "\t") }
+  hdr = append(hdr, "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t" + sample_col)
 
-  return nil
+  _,e := out.WriteString( strings.Join(hdr, "\n") + "\n" )
+  return e
+}
+
+func _annot_int(annot map[string]string, key string) int {
+  if annot==nil { return -1 }
+  s,ok := annot[key]
+  if !ok { return -1 }
+  v,e := strconv.Atoi(s)
+  if e!=nil { return -1 }
+  return v
+}
+
+// Band index for `v`, -1 if `v` is unknown or there are no bands.
+// Values below the first band's lower bound fall in their own band.
+//
+func _band_idx(v int, bands []int) int {
+  if (v<0) || (len(bands)==0) { return -1 }
+  idx := 0
+  for ii:=0; ii<len(bands); ii++ {
+    if v >= bands[ii] { idx = ii+1 }
+  }
+  return idx
+}
+
+// Two reference lines can be merged into a single block if they're
+// contiguous, carry quality annotations and fall in the same GQ
+// (and DP) band.
+//
+func (g *GVCFRefVar) _ref_band_match(a, b GVCFRefVarInfo) bool {
+  if (a.vartype != pasta.REF) || (b.vartype != pasta.REF) { return false }
+  if a.chrom != b.chrom { return false }
+  if a.ref_start+a.ref_len != b.ref_start { return false }
+  if (a.gq<0) || (b.gq<0) { return false }
+
+  if _band_idx(a.gq, g.GQBands) != _band_idx(b.gq, g.GQBands) { return false }
+  if _band_idx(a.min_dp, g.DPBands) != _band_idx(b.min_dp, g.DPBands) { return false }
+
+  return true
+}
+
+// Construct the FORMAT and sample fields.  Without any quality
+// annotations this is just the genotype.  Reference blocks report
+// the mean DP and MIN_DP over the block, variant lines report DP.
+//
+func (g *GVCFRefVar) _format_sample_fields(info GVCFRefVarInfo, gt_field string, ref_block bool) (string, string) {
+  fmt_keys := []string{g.Format}
+  samp_vals := []string{gt_field}
+
+  if info.gq>=0 {
+    fmt_keys = append(fmt_keys, "GQ")
+    samp_vals = append(samp_vals, fmt.Sprintf("%d", info.gq))
+  }
+
+  if info.dp>=0 {
+    fmt_keys = append(fmt_keys, "DP")
+    samp_vals = append(samp_vals, fmt.Sprintf("%d", info.dp))
+  }
+
+  if ref_block && (info.min_dp>=0) {
+    fmt_keys = append(fmt_keys, "MIN_DP")
+    samp_vals = append(samp_vals, fmt.Sprintf("%d", info.min_dp))
+  }
+
+  if info.annot!=nil {
+    for ii:=0; ii<len(g.FormatPassthrough); ii++ {
+      fmt_keys = append(fmt_keys, g.FormatPassthrough[ii])
+      if v,ok := info.annot[g.FormatPassthrough[ii]] ; ok && len(v)>0 {
+        samp_vals = append(samp_vals, v)
+      } else {
+        samp_vals = append(samp_vals, ".")
+      }
+    }
+  }
+
+  return strings.Join(fmt_keys, ":"), strings.Join(samp_vals, ":")
+}
+
+// INFO fields passed through from the stream annotation, each
+// prefixed with the ';' separator.
+//
+func (g *GVCFRefVar) _info_annot_fields(info GVCFRefVarInfo) string {
+  if info.annot==nil { return "" }
+  s := ""
+  for ii:=0; ii<len(g.InfoPassthrough); ii++ {
+    if v,ok := info.annot[g.InfoPassthrough[ii]] ; ok && len(v)>0 {
+      s += fmt.Sprintf(";%s=%s", g.InfoPassthrough[ii], v)
+    }
+  }
+  return s
 }
 
 //---
@@ -147,9 +343,10 @@ func (g *GVCFRefVar) _construct_sample_field() string {
 
 // return reference string, array of alt strings (unique) and the gt string (e.g. "0/0")
 //
-func (g *GVCFRefVar) _ref_alt_gt_fields(refseq string, altseq []string) (string,[]string,string) {
+func (g *GVCFRefVar) _ref_alt_gt_fields(refseq string, altseq []string, gt []int) (string,[]string,string) {
   local_debug := false
   _allele_n := 0
+  n_allele := len(altseq)
 
   _refseq := ""
   if len(refseq)>0 && refseq[0]!='-' {
@@ -200,21 +397,71 @@ func (g *GVCFRefVar) _ref_alt_gt_fields(refseq string, altseq []string) (string,
 
   gt_field := strings.Join(gt_idx_str, "/") ; _ = gt_field
 
+  // Take the genotype from the stream record when it has one
+  // for every allele.  It numbers the alleles the same way as
+  // above.  No-call alleles are spelled out in the ALT field so
+  // keep the genotype found from the sequences for those.
+  //
+  if (len(gt)>0) && (len(gt)==n_allele) {
+    gt_str := []string{}
+    for ii:=0; (ii<len(gt)) && (gt[ii]>=0); ii++ {
+      gt_str = append(gt_str, fmt.Sprintf("%d", gt[ii]))
+    }
+    if len(gt_str)==len(gt) { gt_field = strings.Join(gt_str, "/") }
+  }
+
   return _refseq, altseq_uniq, gt_field
 }
 
+// As above but for the alleles of several samples, returning the
+// genotype of each sample as a tab separated list.  No-call alleles
+// are reported as '.' and aren't added to the alt strings.
+//
+func (g *GVCFRefVar) _joint_ref_alt_gt_fields(refseq string, sample_altseq [][]string, sample_nocall [][]bool) (string,[]string,string) {
+  altseq_uniq := []string{}
+  _set := make(map[string]int)
+  _set[refseq] = 0
+
+  gt_field := []string{}
+  for ii:=0; ii<len(sample_altseq); ii++ {
+    gt_idx_str := []string{}
+    for a:=0; a<len(sample_altseq[ii]); a++ {
+      if (ii<len(sample_nocall)) && (a<len(sample_nocall[ii])) && sample_nocall[ii][a] {
+        gt_idx_str = append(gt_idx_str, ".")
+        continue
+      }
+
+      ts := sample_altseq[ii][a]
+      if _,ok := _set[ts] ; !ok {
+        _set[ts] = len(altseq_uniq)+1
+        altseq_uniq = append(altseq_uniq, ts)
+      }
+      gt_idx_str = append(gt_idx_str, fmt.Sprintf("%d", _set[ts]))
+    }
+    gt_field = append(gt_field, strings.Join(gt_idx_str, "/"))
+  }
+
+  return refseq, altseq_uniq, strings.Join(gt_field, "\t")
+}
+
 
 
 func (g *GVCFRefVar) _emit_alt_left_anchor(info GVCFRefVarInfo, out *bufio.Writer) {
   local_debug := false
 
-  a_refseq,a_alt,a_gt_field := g._ref_alt_gt_fields(info.refseq, info.altseq)
-  _ = a_alt
+  var a_refseq, a_gt_field string
+  var a_alt []string
+  if info.sample_altseq!=nil {
+    a_refseq,a_alt,a_gt_field = g._joint_ref_alt_gt_fields(info.refseq, info.sample_altseq, info.sample_nocall)
+  } else {
+    a_refseq,a_alt,a_gt_field = g._ref_alt_gt_fields(info.refseq, info.altseq, info.gt)
+  }
 
   a_start := info.ref_start+1
   a_len := info.ref_len
 
   alt_field := strings.Join(a_alt, ",")
+  if len(alt_field)==0 { alt_field = "." }
 
   a_ref_bp := byte('.')
   //if len(a_refseq)>0 { a_ref_bp = a_refseq[0] }
@@ -247,9 +494,12 @@ func (g *GVCFRefVar) _emit_alt_left_anchor(info GVCFRefVarInfo, out *bufio.Write
 
 
 
+  a_info_field += g._info_annot_fields(info)
+  a_fmt_field,a_samp_field := g._format_sample_fields(info, a_gt_field, false)
+
   //                            0   1   2   3   4   5    6  7   8   9
   out.WriteString( fmt.Sprintf("%s\t%d\t%s\t%c\t%s\t%s\t%s\t%s\t%s\t%s\n",
-    g.ChromStr,
+    info.chrom,
     a_start,
     g.Id,
     a_ref_bp,
@@ -257,8 +507,8 @@ func (g *GVCFRefVar) _emit_alt_left_anchor(info GVCFRefVarInfo, out *bufio.Write
     g.Qual,
     a_filt_field,
     a_info_field,
-    g.Format,
-    a_gt_field) )
+    a_fmt_field,
+    a_samp_field) )
 
 }
 
@@ -269,14 +519,20 @@ func (g *GVCFRefVar) _emit_ref_left_anchor(info GVCFRefVarInfo, out *bufio.Write
   a_ref_bp := byte('.')
   if len(a_r_seq)>0 { a_ref_bp = a_r_seq[0] }
   a_gt_field := "0/0"
+  if info.sample_altseq!=nil {
+    _,_,a_gt_field = g._joint_ref_alt_gt_fields(info.refseq, info.sample_altseq, info.sample_nocall)
+  }
 
   a_filt_field := "PASS"
   //a_info_field := fmt.Sprintf("END=%d", a_start+a_len)
   a_info_field := fmt.Sprintf("END=%d", a_start+a_len-1)
 
+  a_info_field += g._info_annot_fields(info)
+  a_fmt_field,a_samp_field := g._format_sample_fields(info, a_gt_field, true)
+
   //                            0   1   2   3   4   5    6  7   8   9
   out.WriteString( fmt.Sprintf("%s\t%d\t%s\t%c\t%s\t%s\t%s\t%s\t%s\t%s\n",
-    g.ChromStr,
+    info.chrom,
     a_start,
     g.Id,
     a_ref_bp,
@@ -284,8 +540,8 @@ func (g *GVCFRefVar) _emit_ref_left_anchor(info GVCFRefVarInfo, out *bufio.Write
     g.Qual,
     a_filt_field,
     a_info_field,
-    g.Format,
-    a_gt_field) )
+    a_fmt_field,
+    a_samp_field) )
 
 }
 
@@ -301,7 +557,7 @@ func (g *GVCFRefVar) _emit_alt_left_anchor_p(info GVCFRefVarInfo, z byte, out *b
   local_debug := false
 
   b_r_seq := info.refseq
-  b_refseq,b_alt,b_gt_field := g._ref_alt_gt_fields(b_r_seq, info.altseq)
+  b_refseq,b_alt,b_gt_field := g._ref_alt_gt_fields(b_r_seq, info.altseq, info.gt)
 
   _ = b_refseq
 
@@ -336,9 +592,12 @@ func (g *GVCFRefVar) _emit_alt_left_anchor_p(info GVCFRefVarInfo, z byte, out *b
   }
 
 
+  b_info_field += g._info_annot_fields(info)
+  b_fmt_field,b_samp_field := g._format_sample_fields(info, b_gt_field, false)
+
   //                            0   1   2   3   4   5    6  7   8   9
   out.WriteString( fmt.Sprintf("%s\t%d\t%s\t%c\t%s\t%s\t%s\t%s\t%s\t%s\n",
-    g.ChromStr,
+    info.chrom,
     b_start,
     g.Id,
     b_ref_bp,
@@ -346,8 +605,8 @@ func (g *GVCFRefVar) _emit_alt_left_anchor_p(info GVCFRefVarInfo, z byte, out *b
     g.Qual,
     b_filt_field,
     b_info_field,
-    g.Format,
-    b_gt_field) )
+    b_fmt_field,
+    b_samp_field) )
 
 }
 
@@ -357,7 +616,7 @@ func (g *GVCFRefVar) _emit_alt_right_anchor(info GVCFRefVarInfo, z byte, out *bu
   local_debug := false
 
   b_r_seq := info.refseq
-  b_refseq,b_alt,b_gt_field := g._ref_alt_gt_fields(b_r_seq, info.altseq)
+  b_refseq,b_alt,b_gt_field := g._ref_alt_gt_fields(b_r_seq, info.altseq, info.gt)
 
   if local_debug {
     fmt.Printf("#  eara: z: %c, b_refseq %v, b_alt %v, b_gt_field %v, b_r_seq %v, info.altseq %v\n",
@@ -387,9 +646,12 @@ func (g *GVCFRefVar) _emit_alt_right_anchor(info GVCFRefVarInfo, z byte, out *bu
   //
   b_info_field += fmt.Sprintf(":REF_ANCHOR_AT_END=TRUE")
 
+  b_info_field += g._info_annot_fields(info)
+  b_fmt_field,b_samp_field := g._format_sample_fields(info, b_gt_field, false)
+
   //                            0   1   2   3   4   5    6  7   8   9
   out.WriteString( fmt.Sprintf("%s\t%d\t%s\t%c\t%s\t%s\t%s\t%s\t%s\t%s\n",
-    g.ChromStr,
+    info.chrom,
     b_start,
     g.Id,
     b_ref_bp,
@@ -397,8 +659,8 @@ func (g *GVCFRefVar) _emit_alt_right_anchor(info GVCFRefVarInfo, z byte, out *bu
     g.Qual,
     b_filt_field,
     b_info_field,
-    g.Format,
-    b_gt_field) )
+    b_fmt_field,
+    b_samp_field) )
 
 }
 
@@ -454,12 +716,16 @@ func (g *GVCFRefVar) _emit_alt_right_anchor(info GVCFRefVarInfo, z byte, out *bu
 // field in the INFO column of `REF_ANCHOR_AT_END=TRUE`.
 //
 //
-func (g *GVCFRefVar) Print(vartype int, ref_start, ref_len int, refseq []byte, altseq [][]byte, out *bufio.Writer) error {
+func (g *GVCFRefVar) Print(v *pasta.Variant, out *bufio.Writer) error {
   local_debug := false
 
+  vartype,ref_start,ref_len := v.Type, v.Pos, v.RefLen
+  refseq,altseq := v.Ref, v.Alleles
+
   if g.PrintHeader {
-    g.Header(out)
     g.PrintHeader = false
+    e := g.Header(out)
+    if e!=nil { return e }
   }
 
   vi := GVCFRefVarInfo{}
@@ -470,8 +736,26 @@ func (g *GVCFRefVar) Print(vartype int, ref_start, ref_len int, refseq []byte, a
   for ii:=0; ii<len(altseq); ii++ {
     vi.altseq = append(vi.altseq, string(altseq[ii]))
   }
-  vi.chrom = g.ChromStr
+  vi.chrom = v.Chrom
   vi.stream_ref_pos = g.StreamRefPos
+  vi.gt = append(vi.gt, v.Genotype...)
+
+  // Quality annotations come from the annotation control
+  // message (e.g. ">A{GQ=30;DP=12}") in effect.
+  //
+  vi.gq, vi.dp, vi.min_dp = -1, -1, -1
+  if g.QualityFields {
+    vi.gq = _annot_int(v.Annot, "GQ")
+    vi.dp = _annot_int(v.Annot, "DP")
+    vi.min_dp = _annot_int(v.Annot, "MIN_DP")
+  }
+  if vi.dp<0 { vi.dp = vi.min_dp }
+  if vi.min_dp<0 { vi.min_dp = vi.dp }
+  if vi.dp>=0 {
+    vi.dp_sum = vi.dp*ref_len
+    vi.dp_len = ref_len
+  }
+  vi.annot = v.Annot
 
   g.StreamRefPos += ref_len
 
@@ -499,13 +783,34 @@ func (g *GVCFRefVar) Print(vartype int, ref_start, ref_len int, refseq []byte, a
 
       if g.StateHistory[idx].vartype==pasta.REF {
 
+        // Merge reference lines that fall in the same quality band
+        // into a single block, keeping the minimum GQ and MIN_DP
+        // and the mean DP.
+        //
+        if g._ref_band_match(g.StateHistory[idx-1], g.StateHistory[idx]) {
+          prv := g.StateHistory[idx-1]
+          cur := g.StateHistory[idx]
+
+          prv.ref_len += cur.ref_len
+          prv.refseq += cur.refseq
+          if cur.gq < prv.gq { prv.gq = cur.gq }
+          if (cur.min_dp>=0) && ((prv.min_dp<0) || (cur.min_dp < prv.min_dp)) { prv.min_dp = cur.min_dp }
+          prv.dp_sum += cur.dp_sum
+          prv.dp_len += cur.dp_len
+          if prv.dp_len>0 { prv.dp = (prv.dp_sum + prv.dp_len/2) / prv.dp_len }
+
+          g.StateHistory[idx] = prv
+          g.StateHistory = g.StateHistory[idx:]
+          continue
+        }
+
         g._emit_ref_left_anchor(g.StateHistory[idx-1], out)
         g.StateHistory = g.StateHistory[idx:]
         continue
 
       } else if g.StateHistory[idx].vartype==pasta.NOC {
 
-        b_ref,b_alt,_ := g._ref_alt_gt_fields(g.StateHistory[idx].refseq, g.StateHistory[idx].altseq)
+        b_ref,b_alt,_ := g._ref_alt_gt_fields(g.StateHistory[idx].refseq, g.StateHistory[idx].altseq, nil)
 
         // b_alt == 0 -> it's a nocall for both reference and alt
         //
@@ -556,7 +861,7 @@ func (g *GVCFRefVar) Print(vartype int, ref_start, ref_len int, refseq []byte, a
 
       } else if g.StateHistory[idx].vartype==pasta.ALT {
 
-        _,b_alt,_ := g._ref_alt_gt_fields(g.StateHistory[idx].refseq, g.StateHistory[idx].altseq)
+        _,b_alt,_ := g._ref_alt_gt_fields(g.StateHistory[idx].refseq, g.StateHistory[idx].altseq, nil)
 
         min_alt_len := len(b_alt[0])
         for ii:=1; ii<len(b_alt); ii++ {
@@ -614,7 +919,7 @@ func (g *GVCFRefVar) Print(vartype int, ref_start, ref_len int, refseq []byte, a
         // base reported or know to look at the INFO field.
         //
 
-        _,a_alt,_ := g._ref_alt_gt_fields(g.StateHistory[idx-1].refseq, g.StateHistory[idx-1].altseq)
+        _,a_alt,_ := g._ref_alt_gt_fields(g.StateHistory[idx-1].refseq, g.StateHistory[idx-1].altseq, nil)
         prv_min_alt_len := len(a_alt[0])
         for ii:=1; ii<len(a_alt); ii++ {
           if prv_min_alt_len > len(a_alt[ii]) { prv_min_alt_len = len(a_alt[ii]) }
@@ -685,6 +990,7 @@ func (g *GVCFRefVar) Print(vartype int, ref_start, ref_len int, refseq []byte, a
             g.StateHistory[idx].vartype = g.StateHistory[idx-1].vartype
 
             //g.StateHistory[idx].altseq = g.StateHistory[idx-1].altseq
+            g.StateHistory[idx].gt = g.StateHistory[idx-1].gt
             g.StateHistory[idx].altseq = g.StateHistory[idx].altseq[0:0]
             for ii:=0; ii<len(g.StateHistory[idx-1].altseq) ; ii++ {
               if g.StateHistory[idx-1].altseq[ii] != "-" {
@@ -733,6 +1039,7 @@ func (g *GVCFRefVar) Print(vartype int, ref_start, ref_len int, refseq []byte, a
 
             // subsume the reference into the ALT line
             //
+            g.StateHistory[idx].gt = g.StateHistory[idx-1].gt
             g.StateHistory[idx].altseq = g.StateHistory[idx].altseq[0:0]
             for ii:=0; ii<len(g.StateHistory[idx-1].altseq) ; ii++ {
               if g.StateHistory[idx-1].altseq[ii] != "-" {
@@ -792,6 +1099,7 @@ func (g *GVCFRefVar) Print(vartype int, ref_start, ref_len int, refseq []byte, a
         g.StateHistory[idx].ref_len += g.StateHistory[idx-1].ref_len
         g.StateHistory[idx].stream_ref_pos = g.StateHistory[idx-1].stream_ref_pos
 
+        g.StateHistory[idx].gt = nil
         g.StateHistory[idx].altseq = g.StateHistory[idx].altseq[0:0]
         for ii:=0; ii<len(alt_seqs); ii++ {
           g.StateHistory[idx].altseq = append(g.StateHistory[idx].altseq, alt_seqs[ii])
@@ -843,6 +1151,7 @@ func (g *GVCFRefVar) Print(vartype int, ref_start, ref_len int, refseq []byte, a
         g.StateHistory[idx].ref_len += g.StateHistory[idx-1].ref_len
         g.StateHistory[idx].stream_ref_pos = g.StateHistory[idx-1].stream_ref_pos
 
+        g.StateHistory[idx].gt = nil
         g.StateHistory[idx].altseq = g.StateHistory[idx].altseq[0:0]
         /*
         for ii:=0; ii<len(a_alt); ii++ {
@@ -925,6 +1234,7 @@ func (g *GVCFRefVar) Print(vartype int, ref_start, ref_len int, refseq []byte, a
         g.StateHistory[idx].refseq = string(ref_b[:ref_b_pos])
 
 
+        g.StateHistory[idx].gt = nil
         g.StateHistory[idx].altseq = []string{}
         for ii:=0; ii<len(a_seqs); ii++ {
           g.StateHistory[idx].altseq = append(g.StateHistory[idx].altseq, a_seqs[ii] + b_seqs[ii])
@@ -935,7 +1245,7 @@ func (g *GVCFRefVar) Print(vartype int, ref_start, ref_len int, refseq []byte, a
 
       } else if g.StateHistory[idx].vartype == pasta.NOC {
 
-        _,a_alt,_ := g._ref_alt_gt_fields(g.StateHistory[idx-1].refseq, g.StateHistory[idx-1].altseq)
+        _,a_alt,_ := g._ref_alt_gt_fields(g.StateHistory[idx-1].refseq, g.StateHistory[idx-1].altseq, nil)
 
         if len(a_alt)==0 {
           g._emit_alt_left_anchor(g.StateHistory[idx-1], out)
@@ -1056,7 +1366,10 @@ func (g *GVCFRefVar) _parse_info_field_value(info_line string, field string, sep
   sa := strings.Split(info_line, sep)
   for ii:=0; ii<len(sa); ii++ {
     fv := strings.Split(sa[ii], "=")
-    if len(fv)!=2 { return "", fmt.Errorf("invalud field") }
+
+    // Flag fields (no value) can't be what we're looking for
+    //
+    if len(fv)!=2 { continue }
 
     if fv[0] == field { return fv[1], nil }
   }
@@ -1071,12 +1384,22 @@ func (g *GVCFRefVar) _parameter_index(line string, field string, sep string) (in
   return -1, fmt.Errorf("field not found")
 }
 
+// A missing allele ('.') in the GT field is returned as -1
+// so that it can be emitted as a nocall.
+//
+func _gt_allele_val(s string) (int, error) {
+  if s == "." { return -1, nil }
+  return strconv.Atoi(s)
+}
+
 func (g *GVCFRefVar) _get_gt_array(gt_str string, ploidy int) ([]int, error) {
   gt_array := []int{}
   if !strings.ContainsAny(gt_str, "|/") {
-    v,e := strconv.Atoi(gt_str)
+    v,e := _gt_allele_val(gt_str)
     if e!=nil { return nil, e }
-    gt_array = append(gt_array, v)
+    for ii:=0; ii<ploidy; ii++ {
+      gt_array = append(gt_array, v)
+    }
     return gt_array, nil
   }
 
@@ -1089,7 +1412,7 @@ func (g *GVCFRefVar) _get_gt_array(gt_str string, ploidy int) ([]int, error) {
 
   for ii:=0; ii<ploidy; ii++ {
     if ii < len(_sa) {
-      v,e := strconv.Atoi(_sa[ii])
+      v,e := _gt_allele_val(_sa[ii])
       if e!=nil { return nil, e }
       gt_array = append(gt_array, v)
     } else {
@@ -1100,8 +1423,208 @@ func (g *GVCFRefVar) _get_gt_array(gt_str string, ploidy int) ([]int, error) {
   return gt_array, nil
 }
 
+// Sample names as listed in the '#CHROM' header line (the columns
+// after FORMAT).
+//
+func SampleNames(header_line string) ([]string, error) {
+  FORMAT_FIELD_POS := 8
+
+  line := strings.TrimRight(header_line, "\r\n")
+  if !strings.HasPrefix(line, "#CHROM") {
+    return nil, fmt.Errorf("not a '#CHROM' header line")
+  }
+
+  line_part := strings.Split(line, "\t")
+  if len(line_part) <= FORMAT_FIELD_POS+1 {
+    return nil, fmt.Errorf("no sample columns in header")
+  }
+
+  return line_part[FORMAT_FIELD_POS+1:], nil
+}
+
+// Find the field position of a sample from the '#CHROM' header line.
+// `sample` is either the sample name as it appears in the header or
+// a 0-based index into the sample columns.  Names take precedence so
+// that samples named with integers can still be selected.
+//
+func SampleFieldPos(header_line string, sample string) (int, error) {
+  SAMPLE0_FIELD_POS := 9
+
+  names,e := SampleNames(header_line)
+  if e!=nil { return -1, e }
+
+  for ii:=0; ii<len(names); ii++ {
+    if names[ii] == sample { return SAMPLE0_FIELD_POS+ii, nil }
+  }
+
+  idx,e := strconv.Atoi(sample)
+  if e!=nil { return -1, fmt.Errorf("sample '%s' not found in header", sample) }
+  if (idx<0) || (idx>=len(names)) {
+    return -1, fmt.Errorf("sample index %d out of range (%d samples in header)", idx, len(names))
+  }
+
+  return SAMPLE0_FIELD_POS+idx, nil
+}
+
+// Process header lines, picking out the sample column if
+// a sample was requested.
+//
+func (g *GVCFRefVar) _pasta_header_line(gvcf_line string) error {
+  if !strings.HasPrefix(gvcf_line, "#CHROM") { return nil }
+
+  names,e := SampleNames(gvcf_line)
+  if e!=nil { return e }
+  g.SampleNames = names
+
+  if len(g.Sample)==0 { return nil }
+
+  pos,e := SampleFieldPos(gvcf_line, g.Sample)
+  if e!=nil { return e }
+  g.SampleFieldPos = pos
+
+  return nil
+}
+
+// Number of reference bases a record covers.  For gVCF records this
+// is given by the END INFO field.  Plain VCF records have no END, so
+// fall back to the length of the REF field.
+//
+func (g *GVCFRefVar) _record_ref_len(line_part []string) (int, error) {
+  START_FIELD_POS := 1
+  REF_FIELD_POS := 3
+  INFO_FIELD_POS := 7
+
+  if len(line_part) <= INFO_FIELD_POS {
+    return 0, fmt.Errorf("not enough fields in record")
+  }
+
+  _start,e := strconv.Atoi(line_part[START_FIELD_POS])
+  if e!=nil { return 0, e }
+
+  _end := -1
+  _end_str,e := g._parse_info_field_value(line_part[INFO_FIELD_POS], "END", ":")
+  if e!=nil {
+    _end_str,e = g._parse_info_field_value(line_part[INFO_FIELD_POS], "END", ";")
+  }
+  if e==nil {
+    _end,e = strconv.Atoi(_end_str)
+    if e!=nil { return 0, e }
+  }
+  // Symbolic deletions without an END give their length in SVLEN
+  //
+  if (_end==-1) && _has_symbolic_deletion(line_part) {
+    _svlen_str,e := g._parse_info_field_value(line_part[INFO_FIELD_POS], "SVLEN", ";")
+    if e==nil {
+      _svlen,e := strconv.Atoi(strings.Split(_svlen_str, ",")[0])
+      if e!=nil { return 0, e }
+      if _svlen<0 { _svlen = -_svlen }
+      _end = _start + _svlen
+    }
+  }
+
+  if _end==-1 { _end = _start + len(line_part[REF_FIELD_POS]) - 1 }
+
+  return (_end + 1) - _start, nil
+}
+
+// Symbolic alleles are enclosed in angle brackets (e.g. "<NON_REF>",
+// "<*>", "<DEL>").
+//
+func _is_symbolic_allele(alt string) bool {
+  return (len(alt)>=2) && (alt[0]=='<') && (alt[len(alt)-1]=='>')
+}
+
+// "<DEL>" and its sub-types (e.g. "<DEL:ME:ALU>").
+//
+func _is_symbolic_deletion(alt string) bool {
+  return (alt == "<DEL>") || strings.HasPrefix(alt, "<DEL:")
+}
+
+func _has_symbolic_deletion(line_part []string) bool {
+  ALT_FIELD_POS := 4
+  if len(line_part) <= ALT_FIELD_POS { return false }
+  alt_seq := strings.Split(line_part[ALT_FIELD_POS], ",")
+  for ii:=0; ii<len(alt_seq); ii++ {
+    if _is_symbolic_deletion(alt_seq[ii]) { return true }
+  }
+  return false
+}
+
+// Resolve the ALT alleles into sequences over `ref_bases`.  Symbolic
+// deletions keep only the (left) padding base, everything after it being
+// deleted.  Other symbolic alleles ("<NON_REF>", "<*>", "<INS>", etc.)
+// have no sequence we can recover so are flagged to be emitted as nocalls.
+//
+func _resolve_alt_alleles(alt_seq []string, ref_bases []byte) ([]string, []bool) {
+  resolved := make([]string, len(alt_seq))
+  nocall := make([]bool, len(alt_seq))
+
+  for ii:=0; ii<len(alt_seq); ii++ {
+    if !_is_symbolic_allele(alt_seq[ii]) {
+      resolved[ii] = alt_seq[ii]
+      continue
+    }
+
+    if _is_symbolic_deletion(alt_seq[ii]) && (len(ref_bases)>0) {
+      resolved[ii] = string(ref_bases[0:1])
+      continue
+    }
+
+    resolved[ii] = ""
+    nocall[ii] = true
+  }
+
+  return resolved, nocall
+}
+
+// Read `n` reference bases from the reference stream, skipping whitespace.
+//
+func _read_ref_bases(ref_stream *bufio.Reader, n int) ([]byte, error) {
+  ref_bases := make([]byte, 0, n)
+  for ii:=0; ii<n; ii++ {
+    stream_ref_bp,e := ref_stream.ReadByte()
+    if e!=nil { return nil, e }
+    for stream_ref_bp == '\n' || stream_ref_bp == ' ' || stream_ref_bp == '\t' || stream_ref_bp == '\r' {
+      stream_ref_bp,e = ref_stream.ReadByte()
+      if e!=nil { return nil, e }
+    }
+    ref_bases = append(ref_bases, stream_ref_bp)
+  }
+  return ref_bases, nil
+}
+
+func (g *GVCFRefVar) _write_pasta_byte(pasta_ch byte, out *bufio.Writer) {
+  if (g.LFMod>0) && (g.OCounter > 0) && ((g.OCounter%g.LFMod)==0) {
+    out.WriteByte('\n')
+  }
+  g.OCounter++
+  out.WriteByte(pasta_ch)
+}
+
 func (g *GVCFRefVar) Pasta(gvcf_line string, ref_stream *bufio.Reader, out *bufio.Writer) error {
-  var err error
+
+  // empty line or comment
+  //
+  if len(gvcf_line)==0 { return nil }
+  if gvcf_line[0]=='#' { return g._pasta_header_line(gvcf_line) }
+
+  line_part := strings.Split(gvcf_line, "\t")
+
+  refn,e := g._record_ref_len(line_part)
+  if e!=nil { return e }
+
+  ref_bases,e := _read_ref_bases(ref_stream, refn)
+  if e!=nil { return e }
+
+  return g.PastaRecord(line_part, ref_bases, out)
+}
+
+// Emit the rotini stream for a single, already split, (g)VCF record
+// for the sample at `g.SampleFieldPos`.  `ref_bases` holds the reference
+// sequence the record covers.
+//
+func (g *GVCFRefVar) PastaRecord(line_part []string, ref_bases []byte, out *bufio.Writer) error {
+  var e error
   CHROM_FIELD_POS := 0 ; _ = CHROM_FIELD_POS
   START_FIELD_POS := 1 ; _ = START_FIELD_POS
   ID_FIELD_POS := 2 ; _ = ID_FIELD_POS
@@ -1111,26 +1634,15 @@ func (g *GVCFRefVar) Pasta(gvcf_line string, ref_stream *bufio.Reader, out *bufi
   FILTER_FIELD_POS := 6 ; _ = FILTER_FIELD_POS
   INFO_FIELD_POS := 7 ; _ = INFO_FIELD_POS
   FORMAT_FIELD_POS := 8 ; _ = FORMAT_FIELD_POS
-  SAMPLE0_FIELD_POS := 9 ; _ = SAMPLE0_FIELD_POS
-
-  // empty line or comment
-  //
-  if (len(gvcf_line)==0) || (gvcf_line[0]=='#') { return nil }
+  SAMPLE_FIELD_POS := g.SampleFieldPos
 
-
-  line_part := strings.Split(gvcf_line, "\t")
+  if SAMPLE_FIELD_POS >= len(line_part) {
+    return fmt.Errorf("sample field %d not found in record (%d fields)", SAMPLE_FIELD_POS, len(line_part))
+  }
 
   _start,e := strconv.Atoi(line_part[START_FIELD_POS])
   if e!=nil { return e }
 
-  _end_str,e := g._parse_info_field_value(line_part[INFO_FIELD_POS], "END", ":")
-  _end := -1
-  if e==nil {
-    _end,err = strconv.Atoi(_end_str)
-    if err!=nil { return err }
-  }
-  if _end==-1 { _end = _start+1 }
-
   ref_anchor_on_left := true
   _,er := g._parse_info_field_value(line_part[INFO_FIELD_POS], "REF_ANCHOR_AT_END", ":")
   if er==nil {
@@ -1141,11 +1653,12 @@ func (g *GVCFRefVar) Pasta(gvcf_line string, ref_stream *bufio.Reader, out *bufi
   if line_part[ALT_FIELD_POS]!="." {
     alt_seq = strings.Split(line_part[ALT_FIELD_POS], ",")
   }
+  alt_seq,alt_nocall := _resolve_alt_alleles(alt_seq, ref_bases)
 
   gt_samp_idx,e := g._parameter_index(line_part[FORMAT_FIELD_POS], "GT", ":")
   if e!=nil { return e }
 
-  samp_part := strings.Split(line_part[SAMPLE0_FIELD_POS], ":")
+  samp_part := strings.Split(line_part[SAMPLE_FIELD_POS], ":")
   if gt_samp_idx >= len(samp_part) { return fmt.Errorf("GT index overflow") }
 
   n_allele := 2
@@ -1153,31 +1666,21 @@ func (g *GVCFRefVar) Pasta(gvcf_line string, ref_stream *bufio.Reader, out *bufi
   samp_seq_idx,e := g._get_gt_array(samp_str, n_allele)
   if e!=nil { return e }
 
+  for a:=0; a<n_allele; a++ {
+    if samp_seq_idx[a] > len(alt_seq) {
+      return fmt.Errorf("GT allele %d out of range (%d alternates) at position %d", samp_seq_idx[a], len(alt_seq), _start)
+    }
+  }
+
   ref_anchor_base := line_part[REF_FIELD_POS]
-  //refn := _end - _start
-  refn := (_end + 1) - _start
+  refn := len(ref_bases)
 
   if (samp_seq_idx[0] == samp_seq_idx[1]) && (samp_seq_idx[0] == 0) {
 
     for ii:=0; ii<refn; ii++ {
-      stream_ref_bp,e := ref_stream.ReadByte()
-      if e!=nil { return e }
-      for stream_ref_bp == '\n' || stream_ref_bp == ' ' || stream_ref_bp == '\t' || stream_ref_bp == '\r' {
-        stream_ref_bp,e = ref_stream.ReadByte()
-        if e!=nil { return e }
-      }
-
-
       for a:=0; a<n_allele; a++ {
-
-        if (g.LFMod>0) && (g.OCounter > 0) && ((g.OCounter%g.LFMod)==0) {
-          out.WriteByte('\n')
-        }
-        g.OCounter++
-
-        out.WriteByte(stream_ref_bp)
+        g._write_pasta_byte(ref_bases[ii], out)
       }
-
     }
 
     return nil
@@ -1186,18 +1689,18 @@ func (g *GVCFRefVar) Pasta(gvcf_line string, ref_stream *bufio.Reader, out *bufi
   mM := refn
   for ii:=0; ii<n_allele; ii++ {
 
-    // reference
+    // reference or nocall
     //
-    if samp_seq_idx[ii]==0 { continue }
+    if samp_seq_idx[ii]<=0 { continue }
 
     // find maximum of alt sequence lengths
     //
     a_idx := samp_seq_idx[ii]-1
+    if alt_nocall[a_idx] { continue }
     if mM < len(alt_seq[a_idx]) { mM = len(alt_seq[a_idx]) }
   }
 
 
-
   // Loop through, emitting the appropriate substitution
   // if we have a reference, a deletion if the alt sequence
   // has run out or an insertion if the reference sequence has
@@ -1212,44 +1715,31 @@ func (g *GVCFRefVar) Pasta(gvcf_line string, ref_stream *bufio.Reader, out *bufi
     // Get the reference base
     //
     var stream_ref_bp byte
-    if i<refn {
-
-      stream_ref_bp,e = ref_stream.ReadByte()
-      if e!=nil { return e }
-      for stream_ref_bp == '\n' || stream_ref_bp == ' ' || stream_ref_bp == '\t' || stream_ref_bp == '\r' {
-        stream_ref_bp,e = ref_stream.ReadByte()
-        if e!=nil { return e }
-      }
-
-    }
-
+    if i<refn { stream_ref_bp = ref_bases[i] }
 
     if ref_anchor_on_left {
       if (refn>0) && (i==0) && (stream_ref_bp!=ref_anchor_base[0]) {
         return fmt.Errorf(fmt.Sprintf("stream reference (%c) does not match VCF ref base (%c) at position %d\n", stream_ref_bp, ref_anchor_base[0], _start))
       }
     }
-    _ = stream_ref_bp
 
     // Emit a symbol per alt sequence
     //
     for a:=0; a<n_allele; a++ {
 
       var bp_ref byte = '-'
-      if i<refn {
-        bp_ref = stream_ref_bp
-
-        if ref_anchor_on_left {
-          if bp_ref != stream_ref_bp {
-            return fmt.Errorf( fmt.Sprintf("ref stream to vcf ref mismatch (ref stream %c != vcf ref %c @ %d)", stream_ref_bp, bp_ref, g.RefPos) )
-          }
-        }
-
-      }
+      if i<refn { bp_ref = stream_ref_bp }
 
       var bp_alt byte = '-'
       if samp_seq_idx[a]==0 {
         bp_alt = bp_ref
+      } else if (samp_seq_idx[a]<0) || alt_nocall[samp_seq_idx[a]-1] {
+
+        // Missing allele ('.') or a symbolic allele without
+        // sequence, nocall over the reference
+        //
+        if i<refn { bp_alt = 'n' }
+
       } else {
         a_idx := samp_seq_idx[a]-1
         if i<len(alt_seq[a_idx]) { bp_alt = alt_seq[a_idx][i] }
@@ -1258,15 +1748,96 @@ func (g *GVCFRefVar) Pasta(gvcf_line string, ref_stream *bufio.Reader, out *bufi
       pasta_ch := pasta.SubMap[bp_ref][bp_alt]
       if pasta_ch == 0 { return fmt.Errorf("invalid character") }
 
-      if (g.LFMod>0) && (g.OCounter > 0) && ((g.OCounter%g.LFMod)==0) {
-        out.WriteByte('\n')
-      }
-      g.OCounter++
+      g._write_pasta_byte(pasta_ch, out)
+    }
 
+  }
 
-      out.WriteByte(pasta_ch)
-    }
+  return nil
+}
+
+//---
+
+// GVCFMultiRefVar converts every sample of a multi-sample (joint called)
+// gVCF or VCF into its own rotini stream in a single pass over the input.
+// Each record's reference bases are read once from the reference stream
+// and shared by all samples.
+//
+type GVCFMultiRefVar struct {
+  SampleNames []string
+  Sample []GVCFRefVar
+
+  HeaderSeen bool
+}
+
+func (m *GVCFMultiRefVar) Init() {
+  m.SampleNames = []string{}
+  m.Sample = []GVCFRefVar{}
+  m.HeaderSeen = false
+}
+
+// Set up one converter per sample column from the '#CHROM' header line.
+//
+func (m *GVCFMultiRefVar) Header(header_line string) error {
+  names,e := SampleNames(header_line)
+  if e!=nil { return e }
+
+  m.SampleNames = names
+  m.Sample = make([]GVCFRefVar, len(names))
+  for ii:=0; ii<len(names); ii++ {
+    m.Sample[ii].Init()
+    m.Sample[ii].SampleNames = names
+    m.Sample[ii].SampleFieldPos += ii
+  }
+  m.HeaderSeen = true
+
+  return nil
+}
+
+func (m *GVCFMultiRefVar) PastaBegin(out []*bufio.Writer) error {
+  for ii:=0; ii<len(m.Sample); ii++ {
+    e := m.Sample[ii].PastaBegin(out[ii])
+    if e!=nil { return e }
+  }
+  return nil
+}
+
+func (m *GVCFMultiRefVar) PastaEnd(out []*bufio.Writer) error {
+  for ii:=0; ii<len(m.Sample); ii++ {
+    e := m.Sample[ii].PastaEnd(out[ii])
+    if e!=nil { return e }
+  }
+  return nil
+}
+
+// Process a single (g)VCF line, writing to each of the sample
+// streams in `out` (indexed as in `SampleNames`).  The '#CHROM' header
+// line must be processed, via `Header`, before any records.
+//
+func (m *GVCFMultiRefVar) Pasta(gvcf_line string, ref_stream *bufio.Reader, out []*bufio.Writer) error {
+  if (len(gvcf_line)==0) || (gvcf_line[0]=='#') { return nil }
+
+  if !m.HeaderSeen { return fmt.Errorf("record found before '#CHROM' header line") }
+  if len(out) < len(m.Sample) {
+    return fmt.Errorf("not enough output streams (%d) for samples (%d)", len(out), len(m.Sample))
+  }
+
+  line_part := strings.Split(gvcf_line, "\t")
+  if len(line_part) < len(m.Sample)+9 {
+    return fmt.Errorf("record has %d fields, expected %d", len(line_part), len(m.Sample)+9)
+  }
+
+  if len(m.Sample)==0 { return nil }
+
+  refn,e := m.Sample[0]._record_ref_len(line_part)
+  if e!=nil { return e }
+
+  ref_bases,e := _read_ref_bases(ref_stream, refn)
+  if e!=nil { return e }
 
+  for ii:=0; ii<len(m.Sample); ii++ {
+    e = m.Sample[ii].PastaRecord(line_part, ref_bases, out[ii])
+    if e!=nil { return fmt.Errorf("sample %s: %v", m.SampleNames[ii], e) }
   }
 
   return nil
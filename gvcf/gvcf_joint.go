@@ -0,0 +1,621 @@
+package gvcf
+
+import "fmt"
+import "strings"
+import "bytes"
+import "bufio"
+import "io/ioutil"
+
+import "github.com/abeconnelly/pasta"
+
+// GVCFJointSample collects the difference stream of a single rotini
+// stream and hands each line off to the joint writer.  It's fed by
+// `pasta.InterleaveToDiffInterface` and is run in its own goroutine,
+// one per sample.
+//
+type GVCFJointSample struct {
+  Name string
+  ChromStr string
+  RefPos int
+
+  Rec chan GVCFRefVarInfo
+  Err error
+
+  closed bool
+  quit chan bool
+}
+
+func (s *GVCFJointSample) Init() {
+  s.ChromStr = "Unk"
+  s.RefPos = 0
+  s.Rec = make(chan GVCFRefVarInfo, 1024)
+  s.Err = nil
+  s.closed = false
+  s.quit = make(chan bool)
+}
+
+func (s *GVCFJointSample) Chrom(chr string) { s.ChromStr = chr }
+func (s *GVCFJointSample) Pos(pos int) { s.RefPos = pos }
+func (s *GVCFJointSample) GetRefPos() int { return s.RefPos }
+func (s *GVCFJointSample) FullRefSeq() bool { return true }
+func (s *GVCFJointSample) Header(out *bufio.Writer) error { return nil }
+
+func (s *GVCFJointSample) Print(v *pasta.Variant, out *bufio.Writer) error {
+  vi := GVCFRefVarInfo{}
+  vi.vartype = v.Type
+  vi.ref_start = v.Pos
+  vi.ref_len = v.RefLen
+  vi.refseq = string(v.Ref)
+  vi.chrom = v.Chrom
+  vi.gq = -1
+  vi.dp = -1
+  vi.min_dp = -1
+
+  // Run length messages (">R{}", ">N{}") carry no alleles.  The
+  // reference under a no-call run isn't known.
+  //
+  if v.Type == pasta.MSG_REF_NOC {
+    vi.vartype = pasta.REF
+    if v.Msg.Type == pasta.NOC {
+      vi.vartype = pasta.NOC
+      vi.refseq = ""
+    }
+  }
+
+  for ii:=0; ii<len(v.Alleles); ii++ {
+    if (len(v.Alleles[ii])==0) || (v.Alleles[ii][0]=='-') {
+      vi.altseq = append(vi.altseq, "")
+    } else {
+      vi.altseq = append(vi.altseq, string(v.Alleles[ii]))
+    }
+  }
+  if (len(vi.refseq)>0) && (vi.refseq[0]=='-') { vi.refseq = "" }
+  vi.nocall = append(vi.nocall, v.NoCall...)
+
+  select {
+  case s.Rec <- vi:
+  case <-s.quit:
+    return fmt.Errorf("cancelled")
+  }
+  return nil
+}
+
+func (s *GVCFJointSample) PrintEnd(out *bufio.Writer) error {
+  if !s.closed {
+    close(s.Rec)
+    s.closed = true
+  }
+  return nil
+}
+
+func (s *GVCFJointSample) Pasta(line string, ref_stream *bufio.Reader, out *bufio.Writer) error {
+  return fmt.Errorf("not implemented")
+}
+func (s *GVCFJointSample) PastaBegin(out *bufio.Writer) error { return nil }
+func (s *GVCFJointSample) PastaEnd(out *bufio.Writer) error { return nil }
+
+// Read the stream to the end, closing the record channel
+// even if the stream is invalid.
+//
+func (s *GVCFJointSample) Run(stream *bufio.Reader) {
+  s.Err = pasta.InterleaveToDiffInterface(stream, s, ioutil.Discard)
+  s.PrintEnd(nil)
+}
+
+// Stop Run early, for when the records are no longer wanted.
+// Must only be called once.
+//
+func (s *GVCFJointSample) Cancel() {
+  close(s.quit)
+}
+
+// GVCFJointRefVar writes a single multi-sample gVCF from several rotini
+// streams, one per sample, that share the same reference.
+//
+// Non-reference lines from any of the samples are collected into
+// 'clusters' of overlapping reference intervals.  Each cluster is
+// reported as one record, with the allele of each sample spelled out
+// over the whole cluster, and the stretches between clusters are reported
+// as reference blocks.  Reference blocks are therefore split at every
+// sample's variant boundary.
+//
+// The streams can hold several chromosomes as long as they all hold
+// the same ones, in the same order.  Each chromosome is written out
+// once every sample has moved on from it.
+//
+// Records follow the same conventions as `GVCFRefVar`: the REF column holds
+// the first reference base, the END INFO field gives the extent and the
+// ALT alleles replace the whole interval.  Records with an empty allele
+// (e.g. a straight deletion) get a left anchor base or, at the very
+// beginning of the stream, a right anchor base flagged with
+// `REF_ANCHOR_AT_END=TRUE`.  A sample allele that's a no-call over the
+// whole record is written as '.' in the genotype (e.g. "./."), anything
+// partially called is spelled out and the record marked NOCALL.
+//
+type GVCFJointRefVar struct {
+  G GVCFRefVar
+  Sample []*GVCFJointSample
+
+  // Per sample line buffers, holding lines that extend past `emitted`.
+  // `done` is set once a sample has no more lines on the current
+  // chromosome, with `next` holding its first line on the next one
+  // (nil at the end of the stream).
+  //
+  buf [][]GVCFRefVarInfo
+  done []bool
+  next []*GVCFRefVarInfo
+
+  emitted int
+  ref_end int
+
+  chrom string
+}
+
+func (j *GVCFJointRefVar) Init(sample_names []string) {
+  j.G = GVCFRefVar{}
+  j.G.Init()
+  j.G.SampleNames = sample_names
+
+  j.Sample = make([]*GVCFJointSample, len(sample_names))
+  for ii:=0; ii<len(sample_names); ii++ {
+    j.Sample[ii] = &GVCFJointSample{}
+    j.Sample[ii].Init()
+    j.Sample[ii].Name = sample_names[ii]
+  }
+
+  j.buf = make([][]GVCFRefVarInfo, len(sample_names))
+  j.done = make([]bool, len(sample_names))
+  j.next = make([]*GVCFRefVarInfo, len(sample_names))
+  j.emitted = 0
+  j.ref_end = 0
+  j.chrom = ""
+}
+
+type _joint_cluster struct {
+  start int
+  end int
+  valid bool
+  right_anchor bool
+}
+
+// Pull the next line for sample `idx` into its buffer.
+// Returns false if the sample's stream is exhausted or
+// has moved on to the next chromosome.
+//
+func (j *GVCFJointRefVar) _pull(idx int) (bool, error) {
+  if j.done[idx] { return false, nil }
+
+  vi,ok := <-j.Sample[idx].Rec
+  if !ok {
+    j.done[idx] = true
+    if j.Sample[idx].Err!=nil { return false, fmt.Errorf("sample %s: %v", j.Sample[idx].Name, j.Sample[idx].Err) }
+    return false, nil
+  }
+
+  if j.chrom == "" { j.chrom = vi.chrom }
+  if vi.chrom != j.chrom {
+    j.done[idx] = true
+    j.next[idx] = &vi
+    return false, nil
+  }
+
+  if vi.ref_start+vi.ref_len > j.ref_end { j.ref_end = vi.ref_start+vi.ref_len }
+
+  j.buf[idx] = append(j.buf[idx], vi)
+  return true, nil
+}
+
+// Reference interval a non-reference line occupies.  Insertions
+// are attached to the reference base before them (or after them
+// if they're at the beginning of the stream).
+//
+func _joint_span(vi GVCFRefVarInfo) (int, int) {
+  if vi.ref_len > 0 { return vi.ref_start, vi.ref_start+vi.ref_len }
+  if vi.ref_start == 0 { return 0, 1 }
+  return vi.ref_start-1, vi.ref_start
+}
+
+// Does line `vi` belong to the cluster [start,end)?
+//
+func _joint_in_cluster(vi GVCFRefVarInfo, start, end int) bool {
+  if vi.ref_len > 0 {
+    return (vi.ref_start < end) && (vi.ref_start+vi.ref_len > start)
+  }
+  if vi.ref_start == 0 { return start == 0 }
+  return (start < vi.ref_start) && (vi.ref_start <= end)
+}
+
+// Find the next cluster that starts at or after `from`, extending it
+// until none of the samples have a non-reference line overlapping it.
+//
+func (j *GVCFJointRefVar) _next_cluster(from int) (_joint_cluster, error) {
+  c := _joint_cluster{}
+
+  // Find the earliest non-reference line in any sample
+  //
+  for ii:=0; ii<len(j.Sample); ii++ {
+    for {
+      found := false
+      for kk:=0; kk<len(j.buf[ii]); kk++ {
+        vi := j.buf[ii][kk]
+        if vi.vartype == pasta.REF { continue }
+        s,e := _joint_span(vi)
+        if e <= from { continue }
+        if !c.valid || (s < c.start) { c.start = s ; c.end = e ; c.valid = true }
+        found = true
+        break
+      }
+      if found { break }
+
+      ok,err := j._pull(ii)
+      if err!=nil { return c, err }
+      if !ok { break }
+    }
+  }
+
+  if !c.valid { return c, nil }
+
+  e := j._extend_cluster(&c)
+  return c, e
+}
+
+// Grow the cluster until it's stable.  Every sample's buffer is filled
+// until it has a line starting at or past the cluster end.
+//
+func (j *GVCFJointRefVar) _extend_cluster(c *_joint_cluster) error {
+  for changed := true ; changed ; {
+    changed = false
+
+    for ii:=0; ii<len(j.Sample); ii++ {
+      for {
+        n := len(j.buf[ii])
+        if (n>0) && (j.buf[ii][n-1].ref_start > c.end) { break }
+        ok,err := j._pull(ii)
+        if err!=nil { return err }
+        if !ok { break }
+      }
+
+      for kk:=0; kk<len(j.buf[ii]); kk++ {
+        vi := j.buf[ii][kk]
+        if vi.vartype == pasta.REF { continue }
+        s,e := _joint_span(vi)
+        if (s < c.end) && (e > c.start) {
+          if s < c.start { c.start = s ; changed = true }
+          if e > c.end { c.end = e ; changed = true }
+        }
+      }
+    }
+  }
+
+  return nil
+}
+
+// Reference base at `pos`, taken from whichever buffered line covers
+// it and carries the reference there.  No-call runs (">N{}") don't.
+//
+func (j *GVCFJointRefVar) _ref_bp(pos int) byte {
+  for ii:=0; ii<len(j.buf); ii++ {
+    for kk:=0; kk<len(j.buf[ii]); kk++ {
+      vi := j.buf[ii][kk]
+      if (pos < vi.ref_start) || (pos >= vi.ref_start+vi.ref_len) { continue }
+      if len(vi.refseq) != vi.ref_len { continue }
+      if vi.refseq[pos-vi.ref_start] == 'n' { continue }
+      return vi.refseq[pos-vi.ref_start]
+    }
+  }
+  return 'n'
+}
+
+// Sequence of allele `allele` of sample `idx` over the cluster.  The
+// second return value is true if any of it was a nocall.  No-call runs
+// (">N{}") are spelled out as 'n' over the reference.
+//
+func (j *GVCFJointRefVar) _sample_allele(idx, allele int, c _joint_cluster) (string, bool) {
+  seq := []byte{}
+  nocall := false
+
+  for kk:=0; kk<len(j.buf[idx]); kk++ {
+    vi := j.buf[idx][kk]
+    if !_joint_in_cluster(vi, c.start, c.end) { continue }
+
+    if vi.vartype == pasta.REF {
+      s := vi.ref_start
+      if s < c.start { s = c.start }
+      e := vi.ref_start+vi.ref_len
+      if e > c.end { e = c.end }
+      for p:=s; p<e; p++ {
+        if len(vi.refseq)==vi.ref_len {
+          seq = append(seq, vi.refseq[p-vi.ref_start])
+        } else {
+          seq = append(seq, j._ref_bp(p))
+        }
+      }
+      continue
+    }
+
+    if (allele<len(vi.nocall)) && vi.nocall[allele] { nocall = true }
+
+    if (vi.vartype == pasta.NOC) && (len(vi.altseq)==0) {
+      seq = append(seq, bytes.Repeat([]byte{'n'}, vi.ref_len)...)
+      continue
+    }
+
+    a := allele
+    if a >= len(vi.altseq) { a = len(vi.altseq)-1 }
+    if a >= 0 { seq = append(seq, vi.altseq[a]...) }
+  }
+
+  return string(seq), nocall
+}
+
+// Alleles of every sample over the cluster, and which of them are
+// missing, that is no-call over the whole of the cluster and so
+// reported as '.' rather than spelled out.  `nocall` is true if any
+// of the alleles had a no-call and `anchor` is true if any of them
+// are empty and the cluster needs an anchor base.
+//
+func (j *GVCFJointRefVar) _cluster_alleles(c _joint_cluster) ([][]string, [][]bool, bool, bool) {
+  alleles := make([][]string, len(j.Sample))
+  missing := make([][]bool, len(j.Sample))
+  nocall := false
+  anchor := false
+
+  for ii:=0; ii<len(j.Sample); ii++ {
+    for a:=0; a<j.G.Allele; a++ {
+      seq,noc := j._sample_allele(ii, a, c)
+      if noc { nocall = true }
+      if len(seq)==0 { anchor = true }
+      alleles[ii] = append(alleles[ii], seq)
+      missing[ii] = append(missing[ii], (len(seq)==c.end-c.start) && (strings.Trim(seq, "n")==""))
+    }
+  }
+
+  return alleles, missing, nocall, anchor
+}
+
+// Reference block [start,end), as a line for `_emit_ref_left_anchor`.
+// Every sample is reference over it.
+//
+func (j *GVCFJointRefVar) _ref_block_info(start, end int) GVCFRefVarInfo {
+  vi := GVCFRefVarInfo{}
+  vi.chrom = j.chrom
+  vi.vartype = pasta.REF
+  vi.ref_start = start
+  vi.ref_len = end-start
+  vi.refseq = string(j._ref_bp(start))
+  vi.gq = -1
+  vi.dp = -1
+  vi.min_dp = -1
+
+  vi.sample_altseq = make([][]string, len(j.Sample))
+  for ii:=0; ii<len(j.Sample); ii++ {
+    for a:=0; a<j.G.Allele; a++ {
+      vi.sample_altseq[ii] = append(vi.sample_altseq[ii], vi.refseq)
+    }
+  }
+
+  return vi
+}
+
+// Cluster `c`, as a line for `_emit_alt_left_anchor`.  Clusters at
+// the beginning of the stream that had to take their anchor base from
+// the right are reported as the first line of a single sample stream
+// would be, with `REF_ANCHOR_AT_END=TRUE`.
+//
+func (j *GVCFJointRefVar) _cluster_info(c _joint_cluster) GVCFRefVarInfo {
+  alleles,missing,nocall,_ := j._cluster_alleles(c)
+
+  ref := []byte{}
+  for p:=c.start; p<c.end; p++ { ref = append(ref, j._ref_bp(p)) }
+
+  vi := GVCFRefVarInfo{}
+  vi.chrom = j.chrom
+  vi.vartype = pasta.ALT
+  vi.ref_start = c.start
+  vi.ref_len = c.end-c.start
+  vi.refseq = string(ref)
+  vi.gq = -1
+  vi.dp = -1
+  vi.min_dp = -1
+  vi.sample_altseq = alleles
+  vi.sample_nocall = missing
+
+  vi.stream_ref_pos = c.end
+  if c.right_anchor { vi.stream_ref_pos = 0 }
+
+  if nocall { vi.vartype = pasta.NOC }
+
+  return vi
+}
+
+func (j *GVCFJointRefVar) _emit_ref_block(start, end int, out *bufio.Writer) {
+  if end <= start { return }
+  j.G._emit_ref_left_anchor(j._ref_block_info(start, end), out)
+}
+
+func (j *GVCFJointRefVar) _emit_cluster(c _joint_cluster, out *bufio.Writer) {
+  j.G._emit_alt_left_anchor(j._cluster_info(c), out)
+}
+
+// Add an anchor base to clusters that have an empty allele, merging
+// with the previous (pending) cluster if they then overlap.
+//
+func (j *GVCFJointRefVar) _anchor_cluster(c *_joint_cluster, prv *_joint_cluster) (bool, error) {
+  merged := false
+
+  for {
+    _,_,_,anchor := j._cluster_alleles(*c)
+    if !anchor { return merged, nil }
+
+    if c.start > 0 {
+      c.start--
+      if prv.valid && (prv.end > c.start) {
+        c.start = prv.start
+        prv.valid = false
+        merged = true
+      }
+    } else {
+      c.end++
+      c.right_anchor = true
+    }
+
+    e := j._extend_cluster(c)
+    if e!=nil { return merged, e }
+  }
+}
+
+// Drop lines that lie entirely before `pos`.
+//
+func (j *GVCFJointRefVar) _prune(pos int) {
+  for ii:=0; ii<len(j.buf); ii++ {
+    kk := 0
+    for (kk < len(j.buf[ii])) && (j.buf[ii][kk].ref_start+j.buf[ii][kk].ref_len <= pos) &&
+        ((j.buf[ii][kk].ref_len>0) || (j.buf[ii][kk].ref_start < pos)) {
+      kk++
+    }
+    j.buf[ii] = j.buf[ii][kk:]
+  }
+}
+
+// Read all sample streams, in parallel, and write the joint gVCF to `out`.
+// `streams` are in the same order as the sample names given to `Init`.
+// On error the sample goroutines are cancelled and waited for.
+//
+func (j *GVCFJointRefVar) Run(streams []*bufio.Reader, out *bufio.Writer) error {
+  if len(streams) != len(j.Sample) {
+    return fmt.Errorf("number of streams (%d) does not match number of samples (%d)", len(streams), len(j.Sample))
+  }
+
+  for ii:=0; ii<len(streams); ii++ {
+    go j.Sample[ii].Run(streams[ii])
+  }
+
+  e := j._run(out)
+  if e!=nil {
+    for ii:=0; ii<len(j.Sample); ii++ {
+      j.Sample[ii].Cancel()
+      for range j.Sample[ii].Rec { }
+    }
+  }
+  return e
+}
+
+func (j *GVCFJointRefVar) _run(out *bufio.Writer) error {
+  e := j.G.Header(out)
+  if e!=nil { return e }
+
+  for {
+    e = j._run_chrom(out)
+    if e!=nil { return e }
+
+    more,e := j._next_chrom()
+    if e!=nil { return e }
+    if !more { break }
+  }
+
+  return out.Flush()
+}
+
+// Write the records of the current chromosome.  Once no cluster is
+// left every sample has been read to the end of the chromosome, so
+// only the last cluster and the reference after it remain.
+//
+func (j *GVCFJointRefVar) _run_chrom(out *bufio.Writer) error {
+  e := j._start_chrom()
+  if e!=nil { return e }
+
+  prv := _joint_cluster{}
+
+  for {
+    from := j.emitted
+    if prv.valid { from = prv.end }
+
+    c,e := j._next_cluster(from)
+    if e!=nil { return e }
+    if !c.valid { break }
+
+    _,e = j._anchor_cluster(&c, &prv)
+    if e!=nil { return e }
+
+    if prv.valid {
+      j._emit_ref_block(j.emitted, prv.start, out)
+      j._emit_cluster(prv, out)
+      j.emitted = prv.end
+      j._prune(j.emitted)
+    }
+
+    prv = c
+  }
+
+  if prv.valid {
+    j._emit_ref_block(j.emitted, prv.start, out)
+    j._emit_cluster(prv, out)
+    j.emitted = prv.end
+  }
+  j._emit_ref_block(j.emitted, j.ref_end, out)
+
+  return nil
+}
+
+// Make sure every sample has a line on the current chromosome, so
+// samples whose streams start on different chromosomes are caught
+// before anything is written for them.
+//
+func (j *GVCFJointRefVar) _start_chrom() error {
+  for ii:=0; ii<len(j.Sample); ii++ {
+    if len(j.buf[ii])==0 {
+      _,e := j._pull(ii)
+      if e!=nil { return e }
+    }
+  }
+
+  for ii:=0; ii<len(j.Sample); ii++ {
+    if len(j.buf[ii])>0 { continue }
+    if j.next[ii]!=nil {
+      return fmt.Errorf("sample %s: chromosome %s differs from %s", j.Sample[ii].Name, j.next[ii].chrom, j.chrom)
+    }
+    if j.chrom!="" {
+      return fmt.Errorf("sample %s: stream ends before chromosome %s", j.Sample[ii].Name, j.chrom)
+    }
+  }
+
+  return nil
+}
+
+// Start on the next chromosome once every sample has finished the
+// current one.  Returns false at the end of the streams.  It's an
+// error for the samples to end, or to carry on, differently.
+//
+func (j *GVCFJointRefVar) _next_chrom() (bool, error) {
+  n_next := 0
+  chrom := ""
+  for ii:=0; ii<len(j.Sample); ii++ {
+    if j.next[ii]==nil { continue }
+    if (n_next>0) && (j.next[ii].chrom != chrom) {
+      return false, fmt.Errorf("sample %s: chromosome %s differs from %s", j.Sample[ii].Name, j.next[ii].chrom, chrom)
+    }
+    chrom = j.next[ii].chrom
+    n_next++
+  }
+
+  if n_next==0 { return false, nil }
+
+  for ii:=0; ii<len(j.Sample); ii++ {
+    if j.next[ii]==nil {
+      return false, fmt.Errorf("sample %s: stream ends before chromosome %s", j.Sample[ii].Name, chrom)
+    }
+  }
+
+  j.chrom = chrom
+  j.emitted = 0
+  j.ref_end = 0
+  for ii:=0; ii<len(j.Sample); ii++ {
+    vi := *j.next[ii]
+    j.buf[ii] = append(j.buf[ii][0:0], vi)
+    if vi.ref_start+vi.ref_len > j.ref_end { j.ref_end = vi.ref_start+vi.ref_len }
+    j.next[ii] = nil
+    j.done[ii] = false
+  }
+
+  return true, nil
+}
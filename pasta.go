@@ -21,6 +21,8 @@ type ControlMessage struct {
   RefLen  int
 
   Comment string
+
+  Annot   map[string]string
 }
 
 
@@ -34,31 +36,45 @@ var Token []byte
 // to pasta character
 // e.g. reference 'c' and alt 'a': SubMap['c']['a'] = '='
 //
-var SubMap map[byte]map[byte]byte
+var SubMap [256][256]byte
 
 // Key is pasta character, value is the lower case
-// sequence value of the reference
+// sequence value of the reference (0 if the character
+// has none)
 //
-var RefMap map[byte]byte
+var RefMap [256]byte
 
 // Key is the pasta character, value is the implied
-// sequence character
+// sequence character (0 if the character has none)
 //
-var AltMap map[byte]byte
+var AltMap [256]byte
 
-var DelMap map[byte]byte
-var InsMap map[byte]byte
-var IsAltDel map[byte]bool
+var DelMap [256]byte
+var InsMap [256]byte
+var IsAltDel [256]bool
 
-var RefDelBP map[byte]int
-var BPState map[byte]int
+var RefDelBP [256]int
+var BPState [256]int
 
+// Token classes, as bits of TokenClass, so a character
+// can be tested against several classes at once.
+//
+const(
+  TOKEN_REF = 1 << iota   // reference base (acgt)
+  TOKEN_NOC = 1 << iota   // no-call (nN, ACGT)
+  TOKEN_SUB = 1 << iota   // substitution, including onto a no-call reference
+  TOKEN_DEL = 1 << iota   // deletion
+  TOKEN_INS = 1 << iota   // insertion
+  TOKEN_NOP = 1 << iota   // nop ('.')
+  TOKEN_WS  = 1 << iota   // whitespace between tokens
+  TOKEN_MSG = 1 << iota   // start of a control message ('>')
+)
 
-// Ref to Alt
+// Any sequence token
 //
-var gRefBP map[byte]byte
-var gAltBP map[byte]byte
-var gPastaBPState map[byte]int
+const TOKEN_SEQ = TOKEN_REF | TOKEN_NOC | TOKEN_SUB | TOKEN_DEL | TOKEN_INS
+
+var TokenClass [256]uint8
 
 
 const(
@@ -81,24 +97,20 @@ const(
   CHROM = iota
   POS = iota
   COMMENT = iota
+  ANNOT = iota
+  MSG_ANNOT = iota
 )
 
 
 func init() {
   Token := []byte("acgtnNACGT~?@=:;#&%*+-QSWd!$7EZ'\",_")
 
-  gPastaBPState = make(map[byte]int)
-
-  DelMap = make(map[byte]byte)
-  InsMap = make(map[byte]byte)
-
   DelMap['a'] = '!'
   DelMap['c'] = '$'
   DelMap['g'] = '7'
   DelMap['t'] = 'E'
   DelMap['n'] = 'z'
 
-  IsAltDel = make(map[byte]bool)
   IsAltDel['!'] = true
   IsAltDel['$'] = true
   IsAltDel['7'] = true
@@ -112,234 +124,237 @@ func init() {
   InsMap['t'] = 'd'
   InsMap['n'] = 'Z'
 
-  gSub := make(map[byte]map[byte]byte)
-
-  gSub['a'] = make(map[byte]byte)
-  gSub['a']['a'] = 'a'
-  gSub['a']['c'] = '~'
-  gSub['a']['g'] = '?'
-  gSub['a']['t'] = '@'
-  gSub['a']['n'] = 'A'
-
-  gSub['c'] = make(map[byte]byte)
-  gSub['c']['a'] = '='
-  gSub['c']['c'] = 'c'
-  gSub['c']['g'] = ':'
-  gSub['c']['t'] = ';'
-  gSub['c']['n'] = 'C'
-
-  gSub['g'] = make(map[byte]byte)
-  gSub['g']['a'] = '#'
-  gSub['g']['c'] = '&'
-  gSub['g']['g'] = 'g'
-  gSub['g']['t'] = '%'
-  gSub['g']['n'] = 'G'
-
-  gSub['t'] = make(map[byte]byte)
-  gSub['t']['a'] = '*'
-  gSub['t']['c'] = '+'
-  gSub['t']['g'] = '-'
-  gSub['t']['t'] = 't'
-  gSub['t']['n'] = 'T'
-
-  gSub['n'] = make(map[byte]byte)
-  gSub['n']['a'] = '\''
-  gSub['n']['c'] = '"'
-  gSub['n']['g'] = ','
-  gSub['n']['t'] = '_'
-  gSub['n']['n'] = 'n'
+  SubMap['a']['a'] = 'a'
+  SubMap['a']['c'] = '~'
+  SubMap['a']['g'] = '?'
+  SubMap['a']['t'] = '@'
+  SubMap['a']['n'] = 'A'
+
+  SubMap['c']['a'] = '='
+  SubMap['c']['c'] = 'c'
+  SubMap['c']['g'] = ':'
+  SubMap['c']['t'] = ';'
+  SubMap['c']['n'] = 'C'
+
+  SubMap['g']['a'] = '#'
+  SubMap['g']['c'] = '&'
+  SubMap['g']['g'] = 'g'
+  SubMap['g']['t'] = '%'
+  SubMap['g']['n'] = 'G'
+
+  SubMap['t']['a'] = '*'
+  SubMap['t']['c'] = '+'
+  SubMap['t']['g'] = '-'
+  SubMap['t']['t'] = 't'
+  SubMap['t']['n'] = 'T'
+
+  SubMap['n']['a'] = '\''
+  SubMap['n']['c'] = '"'
+  SubMap['n']['g'] = ','
+  SubMap['n']['t'] = '_'
+  SubMap['n']['n'] = 'n'
 
   // deletion of reference
   //
-  gSub['a']['-'] = '!'
-  gSub['c']['-'] = '$'
-  gSub['t']['-'] = 'E'
-  gSub['g']['-'] = '7'
-  gSub['n']['-'] = 'z'
+  SubMap['a']['-'] = '!'
+  SubMap['c']['-'] = '$'
+  SubMap['t']['-'] = 'E'
+  SubMap['g']['-'] = '7'
+  SubMap['n']['-'] = 'z'
 
   // insertion
   //
-  gSub['-'] = make(map[byte]byte)
-  gSub['-']['a'] = 'Q'
-  gSub['-']['c'] = 'S'
-  gSub['-']['g'] = 'W'
-  gSub['-']['t'] = 'd'
-  gSub['-']['n'] = 'Z'
-
-  gSub['-']['-'] = '.'
-
-
-  gRefBP = make(map[byte]byte)
-  gAltBP = make(map[byte]byte)
-  gRefBP['a'] = 'a'
-  gRefBP['~'] = 'a'
-  gRefBP['?'] = 'a'
-  gRefBP['@'] = 'a'
-  gRefBP['A'] = 'a'
-
-  gAltBP['a'] = 'a'
-  gAltBP['~'] = 'c'
-  gAltBP['?'] = 'g'
-  gAltBP['@'] = 't'
-  gAltBP['A'] = 'n'
+  SubMap['-']['a'] = 'Q'
+  SubMap['-']['c'] = 'S'
+  SubMap['-']['g'] = 'W'
+  SubMap['-']['t'] = 'd'
+  SubMap['-']['n'] = 'Z'
+
+  SubMap['-']['-'] = '.'
+
+
+  RefMap['a'] = 'a'
+  RefMap['~'] = 'a'
+  RefMap['?'] = 'a'
+  RefMap['@'] = 'a'
+  RefMap['A'] = 'a'
+
+  AltMap['a'] = 'a'
+  AltMap['~'] = 'c'
+  AltMap['?'] = 'g'
+  AltMap['@'] = 't'
+  AltMap['A'] = 'n'
 
   //-
 
-  gRefBP['='] = 'c'
-  gRefBP['c'] = 'c'
-  gRefBP[':'] = 'c'
-  gRefBP[';'] = 'c'
-  gRefBP['C'] = 'c'
+  RefMap['='] = 'c'
+  RefMap['c'] = 'c'
+  RefMap[':'] = 'c'
+  RefMap[';'] = 'c'
+  RefMap['C'] = 'c'
 
-  gAltBP['='] = 'a'
-  gAltBP['c'] = 'c'
-  gAltBP[':'] = 'g'
-  gAltBP[';'] = 't'
-  gAltBP['C'] = 'n'
+  AltMap['='] = 'a'
+  AltMap['c'] = 'c'
+  AltMap[':'] = 'g'
+  AltMap[';'] = 't'
+  AltMap['C'] = 'n'
 
   //-
 
-  gRefBP['#'] = 'g'
-  gRefBP['&'] = 'g'
-  gRefBP['g'] = 'g'
-  gRefBP['%'] = 'g'
-  gRefBP['G'] = 'g'
+  RefMap['#'] = 'g'
+  RefMap['&'] = 'g'
+  RefMap['g'] = 'g'
+  RefMap['%'] = 'g'
+  RefMap['G'] = 'g'
 
-  gAltBP['#'] = 'a'
-  gAltBP['&'] = 'c'
-  gAltBP['g'] = 'g'
-  gAltBP['%'] = 't'
-  gAltBP['G'] = 'n'
+  AltMap['#'] = 'a'
+  AltMap['&'] = 'c'
+  AltMap['g'] = 'g'
+  AltMap['%'] = 't'
+  AltMap['G'] = 'n'
 
   //-
 
-  gRefBP['*'] = 't'
-  gRefBP['+'] = 't'
-  gRefBP['-'] = 't'
-  gRefBP['t'] = 't'
-  gRefBP['T'] = 't'
+  RefMap['*'] = 't'
+  RefMap['+'] = 't'
+  RefMap['-'] = 't'
+  RefMap['t'] = 't'
+  RefMap['T'] = 't'
 
-  gAltBP['*'] = 'a'
-  gAltBP['+'] = 'c'
-  gAltBP['-'] = 'g'
-  gAltBP['t'] = 't'
-  gAltBP['T'] = 'n'
+  AltMap['*'] = 'a'
+  AltMap['+'] = 'c'
+  AltMap['-'] = 'g'
+  AltMap['t'] = 't'
+  AltMap['T'] = 'n'
 
   //--
   // Alt deleitions
 
-  gRefBP['!'] = 'a'
-  gRefBP['$'] = 'c'
-  gRefBP['7'] = 'g'
-  gRefBP['E'] = 't'
-  gRefBP['z'] = 'n'
+  RefMap['!'] = 'a'
+  RefMap['$'] = 'c'
+  RefMap['7'] = 'g'
+  RefMap['E'] = 't'
+  RefMap['z'] = 'n'
 
   // Alt insertions
 
-  gAltBP['Q'] = 'a'
-  gAltBP['S'] = 'c'
-  gAltBP['W'] = 'g'
-  gAltBP['d'] = 't'
-  gAltBP['Z'] = 'n'
+  AltMap['Q'] = 'a'
+  AltMap['S'] = 'c'
+  AltMap['W'] = 'g'
+  AltMap['d'] = 't'
+  AltMap['Z'] = 'n'
 
   //--
 
   // no-call substitutions
 
-  gRefBP['\''] = 'n'
-  gRefBP['"'] = 'n'
-  gRefBP[','] = 'n'
-  gRefBP['_'] = 'n'
+  RefMap['\''] = 'n'
+  RefMap['"'] = 'n'
+  RefMap[','] = 'n'
+  RefMap['_'] = 'n'
 
-  gAltBP['\''] = 'a'
-  gAltBP['"'] = 'c'
-  gAltBP[','] = 'g'
-  gAltBP['_'] = 't'
+  AltMap['\''] = 'a'
+  AltMap['"'] = 'c'
+  AltMap[','] = 'g'
+  AltMap['_'] = 't'
 
   //-
 
-  gRefBP['n'] = 'n'
-  gRefBP['N'] = 'n'
+  RefMap['n'] = 'n'
+  RefMap['N'] = 'n'
 
-  gAltBP['n'] = 'n'
-  gAltBP['N'] = 'n'
+  AltMap['n'] = 'n'
+  AltMap['N'] = 'n'
 
 
   //--
-  gPastaBPState['N'] = NOC
-  gPastaBPState['n'] = NOC
+  BPState['N'] = NOC
+  BPState['n'] = NOC
 
-  gPastaBPState['a'] = REF
-  gPastaBPState['~'] = SUB
-  gPastaBPState['?'] = SUB
-  gPastaBPState['@'] = SUB
-  gPastaBPState['A'] = NOC
+  BPState['a'] = REF
+  BPState['~'] = SUB
+  BPState['?'] = SUB
+  BPState['@'] = SUB
+  BPState['A'] = NOC
 
   //-
 
-  gPastaBPState['='] = SUB
-  gPastaBPState['c'] = REF
-  gPastaBPState[':'] = SUB
-  gPastaBPState[';'] = SUB
-  gPastaBPState['C'] = NOC
+  BPState['='] = SUB
+  BPState['c'] = REF
+  BPState[':'] = SUB
+  BPState[';'] = SUB
+  BPState['C'] = NOC
 
   //-
 
-  gPastaBPState['#'] = SUB
-  gPastaBPState['&'] = SUB
-  gPastaBPState['g'] = REF
-  gPastaBPState['%'] = SUB
-  gPastaBPState['G'] = NOC
+  BPState['#'] = SUB
+  BPState['&'] = SUB
+  BPState['g'] = REF
+  BPState['%'] = SUB
+  BPState['G'] = NOC
 
   //-
 
-  gPastaBPState['*'] = SUB
-  gPastaBPState['+'] = SUB
-  gPastaBPState['-'] = SUB
-  gPastaBPState['t'] = REF
-  gPastaBPState['T'] = NOC
+  BPState['*'] = SUB
+  BPState['+'] = SUB
+  BPState['-'] = SUB
+  BPState['t'] = REF
+  BPState['T'] = NOC
 
   //-
 
   /*
-  gPastaBPState['!'] = INDEL
-  gPastaBPState['$'] = INDEL
-  gPastaBPState['7'] = INDEL
-  gPastaBPState['E'] = INDEL
-
-  gPastaBPState['Q'] = INDEL
-  gPastaBPState['S'] = INDEL
-  gPastaBPState['W'] = INDEL
-  gPastaBPState['d'] = INDEL
+  BPState['!'] = INDEL
+  BPState['$'] = INDEL
+  BPState['7'] = INDEL
+  BPState['E'] = INDEL
+
+  BPState['Q'] = INDEL
+  BPState['S'] = INDEL
+  BPState['W'] = INDEL
+  BPState['d'] = INDEL
   */
 
-  gPastaBPState['!'] = DEL
-  gPastaBPState['$'] = DEL
-  gPastaBPState['7'] = DEL
-  gPastaBPState['E'] = DEL
-  gPastaBPState['z'] = DEL
+  BPState['!'] = DEL
+  BPState['$'] = DEL
+  BPState['7'] = DEL
+  BPState['E'] = DEL
+  BPState['z'] = DEL
 
-  gPastaBPState['Q'] = INS
-  gPastaBPState['S'] = INS
-  gPastaBPState['W'] = INS
-  gPastaBPState['d'] = INS
-  gPastaBPState['Z'] = INS
+  BPState['Q'] = INS
+  BPState['S'] = INS
+  BPState['W'] = INS
+  BPState['d'] = INS
+  BPState['Z'] = INS
 
-  BPState = gPastaBPState
 
-  SubMap = gSub
-  RefMap = gRefBP
-  AltMap = gAltBP
 
-  RefDelBP = make(map[byte]int)
   for i:=0; i<len(Token); i++ {
-    if _,ok := RefMap[Token[i]] ; ok {
+    if RefMap[Token[i]]!=0 {
       RefDelBP[Token[i]] = 1
     } else {
       RefDelBP[Token[i]] = 0
     }
   }
 
+  for i:=0; i<256; i++ {
+    ch := byte(i)
+    if (RefMap[ch]==0) && (AltMap[ch]==0) { continue }
+    switch BPState[ch] {
+    case REF: TokenClass[ch] = TOKEN_REF
+    case NOC: TokenClass[ch] = TOKEN_NOC
+    case DEL: TokenClass[ch] = TOKEN_DEL
+    case INS: TokenClass[ch] = TOKEN_INS
+    default: TokenClass[ch] = TOKEN_SUB
+    }
+  }
+  TokenClass['.'] = TOKEN_NOP
+  TokenClass[' '] = TOKEN_WS
+  TokenClass['\t'] = TOKEN_WS
+  TokenClass['\r'] = TOKEN_WS
+  TokenClass['\n'] = TOKEN_WS
+  TokenClass['>'] = TOKEN_MSG
+
 }
 
 
@@ -488,9 +503,7 @@ func InterleaveStreams(stream_A, stream_B io.Reader, w io.Writer) error {
 
   }
 
-  out.Flush()
-
-  return nil
+  return out.Flush()
 }
 
 
@@ -0,0 +1,90 @@
+#!/bin/bash
+
+function _q {
+  echo $1
+  exit 1
+}
+
+odir="assay/benchmark"
+mkdir -p $odir
+
+# Counts from the benchmark table for a stratum and type:
+# TRUTH.TOTAL TRUTH.TP TRUTH.FN QUERY.TOTAL QUERY.TP QUERY.FP FP.GT
+#
+function _counts {
+  awk -v s="$2" -v t="$3" '($1==s) && ($2==t) { print $3,$4,$5,$6,$7,$8,$9 }' $1
+}
+
+## Hand built streams over the reference
+##
+##   acgtaaaacgtacgtacgtacgtacgtacgtacgt
+##
+## The truth has a homozygous deletion of the first 'a' of the
+## run at 4, a heterozygous SNP (c>a) at 20 and a heterozygous
+## insertion of a 'g' before 28.
+##
+printf '>C{chr1}>P{0}\naaccggtt!!aaaaaaccggttaaccggttaaccggttaa=cggttaaccggttaaW.ccggttaaccggtt\n' > $odir/truth.rot
+
+# Same haplotypes, with the deletion at the end of the run
+#
+printf '>C{chr1}>P{0}\naaccggttaaaaaa!!ccggttaaccggttaaccggttaa=cggttaaccggttaaW.ccggttaaccggtt\n' > $odir/shift.rot
+
+# Homozygous SNP, missing insertion and an extra SNP (c>g) at 32,
+# scored apart with less slack
+#
+printf '>C{chr1}>P{0}\naaccggtt!!aaaaaaccggttaaccggttaaccggttaa==ggttaaccggttaaccggttaac:ggtt\n' > $odir/query.rot
+
+# No-call over the SNP
+#
+printf '>C{chr1}>P{0}\naaccggtt!!aaaaaaccggttaaccggttaaccggttaannggttaaccggttaaW.ccggttaaccggtt\n' > $odir/nocall.rot
+
+printf 'chr1\t0\t10\n' > $odir/run.bed
+
+./pasta -action benchmark -i $odir/truth.rot -i $odir/truth.rot > $odir/self.out || _q "benchmark failed"
+[ "`_counts $odir/self.out '*' ALL`" == "3 3 0 3 3 0 0" ] || _q "truth against itself"
+
+./pasta -action benchmark -i $odir/truth.rot -i $odir/shift.rot > $odir/shift.out || _q "benchmark failed"
+[ "`_counts $odir/shift.out '*' INDEL`" == "2 2 0 2 2 0 0" ] || _q "shifted deletion not matched"
+[ "`_counts $odir/shift.out '*' SNP`" == "1 1 0 1 1 0 0" ] || _q "SNP not matched"
+
+./pasta -action benchmark -i $odir/truth.rot -i $odir/shift.rot -param 'slack=0' > $odir/shift0.out || _q "benchmark failed"
+[ "`_counts $odir/shift0.out '*' INDEL`" == "2 1 1 2 1 1 0" ] || _q "shifted deletion matched without slack"
+
+./pasta -action benchmark -i $odir/truth.rot -i $odir/query.rot -param "slack=2:strata=$odir/run.bed" > $odir/query.out || _q "benchmark failed"
+[ "`_counts $odir/query.out '*' ALL`" == "3 1 2 3 1 2 1" ] || _q "query counts"
+[ "`_counts $odir/query.out '*' SNP`" == "1 0 1 2 0 2 1" ] || _q "query SNP counts"
+[ "`_counts $odir/query.out run ALL`" == "1 1 0 1 1 0 0" ] || _q "query stratum counts"
+[ "`_counts $odir/query.out run SNP`" == "0 0 0 0 0 0 0" ] || _q "query stratum SNP counts"
+grep -q "^run	ALL.*	1.000000	1.000000$" $odir/query.out || _q "query stratum recall/precision"
+
+./pasta -action benchmark -i $odir/truth.rot -i $odir/nocall.rot > $odir/nocall.out || _q "benchmark failed"
+[ "`_counts $odir/nocall.out '*' SNP`" == "1 0 1 0 0 0 0" ] || _q "no-call counts"
+
+## Random streams, through gVCF and back, and against
+## a different sample on the same reference
+##
+./pasta -action rstream -param 'chrom=chr1:p-snp=0.05:p-indel=0.02:p-nocall=0.002:ref-seed=11223344:n=20000:seed=1234' > $odir/rnd.rot
+# gvcf-rotini doesn't write the chromosome
+#
+( echo '>C{chr1}>P{0}' ;
+  ./pasta -action rotini-gvcf -i $odir/rnd.rot | \
+    ./pasta -action gvcf-rotini -refstream <( ./pasta -action ref-rstream -param 'ref-seed=11223344:n=20000:allele=1' ) ) > $odir/rnd-gvcf.rot
+
+./pasta -action benchmark -i $odir/rnd.rot -i $odir/rnd-gvcf.rot > $odir/rnd-gvcf.out || _q "benchmark failed"
+read t_tot t_tp t_fn q_tot q_tp q_fp fp_gt <<< "`_counts $odir/rnd-gvcf.out '*' ALL`"
+[ "$t_tot" -gt 40 ] || _q "too few truth variants ($t_tot)"
+[ "$t_fn" == "0" ] && [ "$q_fp" == "0" ] || _q "gvcf roundtrip differs ($t_fn FN, $q_fp FP)"
+
+./pasta -action rstream -param 'chrom=chr1:p-snp=0.05:p-indel=0.02:p-nocall=0.002:ref-seed=11223344:n=20000:seed=4321' > $odir/rnd2.rot
+./pasta -action benchmark -i $odir/rnd.rot -i $odir/rnd2.rot > $odir/rnd2.out || _q "benchmark failed"
+read t_tot t_tp t_fn q_tot q_tp q_fp fp_gt <<< "`_counts $odir/rnd2.out '*' ALL`"
+[ "$t_fn" -gt 0 ] && [ "$q_fp" -gt 0 ] || _q "unrelated samples match"
+[ "`expr $t_tp + $t_fn`" == "$t_tot" ] || _q "truth counts don't add up"
+
+# Class counts add up to the totals
+#
+awk '($1=="*") && ($2!="ALL") { for (i=3; i<=9; i++) { s[i]+=$i } }
+     ($1=="*") && ($2=="ALL") { for (i=3; i<=9; i++) { a[i]=$i } }
+     END { for (i=3; i<=9; i++) { if (s[i]!=a[i]) { exit 1 } } }' $odir/rnd2.out || _q "class counts don't add up"
+
+echo ok
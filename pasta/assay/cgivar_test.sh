@@ -71,4 +71,30 @@ diff <( ./pasta -action rotini-alt1 -i $odir/cgivar-indel-nocall.inp ) \
   <( ./pasta -action rotini-alt1 -i $odir/cgivar-indel-nocall.out ) || _q "error indel-nocall alt1"
 
 echo ok-indel-nocall
+
+## CGI-VAR over two chromosomes
+##
+ofn_c="cgivar-chrom"
+( echo '>C{chr1}>P{0}>A{PS=1}' ; ./pasta -action rstream -param 'p-indel=0.2:p-snp=0.3:p-nocall=0.1:ref-seed=1234:n=400:seed=7' | sed 's/>C{Unk}>P{0}//' ; \
+  echo '>C{chr2}>P{0}>A{PS=2}' ; ./pasta -action rstream -param 'p-indel=0.2:p-snp=0.3:p-nocall=0.1:ref-seed=99:n=300:seed=3' | sed 's/>C{Unk}>P{0}//' ) > $odir/$ofn_c.inp
+( ./pasta -action ref-rstream -param 'ref-seed=1234:allele=1:n=400' ; ./pasta -action ref-rstream -param 'ref-seed=99:allele=1:n=300' ) > $odir/$ofn_c.ref
+./pasta -action rotini-cgivar -i $odir/$ofn_c.inp > $odir/$ofn_c.cgi
+./pasta -action cgivar-rotini -i $odir/$ofn_c.cgi -refstream $odir/$ofn_c.ref > $odir/$ofn_c.out
+
+diff <( ./pasta -action rotini-ref -i $odir/$ofn_c.inp ) <( ./pasta -action rotini-ref -i $odir/$ofn_c.out ) || _q "cgivar chrom ref"
+diff <( ./pasta -action rotini-alt0 -i $odir/$ofn_c.inp ) <( ./pasta -action rotini-alt0 -i $odir/$ofn_c.out ) || _q "cgivar chrom alt0"
+diff <( ./pasta -action rotini-alt1 -i $odir/$ofn_c.inp ) <( ./pasta -action rotini-alt1 -i $odir/$ofn_c.out ) || _q "cgivar chrom alt1"
+
+## Each chromosome starts at 0 with its own hapLink block, and
+## only phased loci get a hapLink
+##
+grep -P '^\d+\t2\tall\tchr2\t0\t' $odir/$ofn_c.cgi > /dev/null || _q "cgivar chrom begin"
+[ "`awk -F'\t' '$4=="chr1" && $13!="" {print $13}' $odir/$ofn_c.cgi | sort -u | wc -l`" == "2" ] || _q "cgivar chrom hapLink chr1"
+[ "`awk -F'\t' '$4=="chr2" && $13!="" {print $13}' $odir/$ofn_c.cgi | sort -u | tr '\n' ' '`" == "3 4 " ] || _q "cgivar chrom hapLink chr2"
+
+sed 's/>A{PS=[0-9]*}//' $odir/$ofn_c.inp | ./pasta -action rotini-cgivar > $odir/$ofn_c-unphased.cgi
+[ "`awk -F'\t' '/^[0-9]/ && $13!=""' $odir/$ofn_c-unphased.cgi | wc -l`" == "0" ] || _q "cgivar unphased hapLink"
+
+echo ok-chrom
+
 exit 0
@@ -0,0 +1,63 @@
+#!/bin/bash
+
+function _q {
+  echo $1
+  exit 1
+}
+
+
+odir="assay/convert"
+mkdir -p $odir
+
+refparam='ref-seed=11223344:n=1000:allele=1'
+
+./pasta -action rstream -param 'p-nocall=0.1:p-indel=0.3:p-indel-length=0,3:ref-seed=11223344:n=1000:seed=1234' > $odir/convert.inp
+./pasta -action rotini-gvcf -i $odir/convert.inp > $odir/convert.gvcf
+
+## Writers match the rotini-* actions
+##
+diff <( ./pasta -action rotini-gvcf -i $odir/convert.inp ) \
+  <( ./pasta -action convert -from rotini -to gvcf -i $odir/convert.inp ) || _q "convert rotini gvcf"
+diff <( ./pasta -action rotini-gff -i $odir/convert.inp | grep -v '^#' ) \
+  <( ./pasta -action convert -from rotini -to gff -i $odir/convert.inp | grep -v '^#' ) || _q "convert rotini gff"
+diff $odir/convert.inp <( ./pasta -action convert -from rotini -to rotini -i $odir/convert.inp ) || _q "convert rotini rotini"
+diff <( ./pasta -action rotini-alt0 -i $odir/convert.inp | tr -d '\n' ) \
+  <( ./pasta -action convert -from rotini -to fasta -i $odir/convert.inp | tr -d '\n' ) || _q "convert rotini fasta"
+
+echo ok-rotini
+
+## Readers match the *-rotini actions
+##
+diff <( ./pasta -action gvcf-rotini -i $odir/convert.gvcf -refstream <( ./pasta -action ref-rstream -param $refparam ) ) \
+  <( ./pasta -action convert -from gvcf -to rotini -i $odir/convert.gvcf -refstream <( ./pasta -action ref-rstream -param $refparam ) ) || _q "convert gvcf rotini"
+
+echo ok-gvcf
+
+## gVCF to GFF and GVF in one step, same as going through rotini
+##
+for t in gff gvf ; do
+  diff <( ./pasta -action gvcf-rotini -i $odir/convert.gvcf -refstream <( ./pasta -action ref-rstream -param $refparam ) | ./pasta -action rotini-$t | grep -v '^#' ) \
+    <( ./pasta -action convert -from gvcf -to $t -i $odir/convert.gvcf -refstream <( ./pasta -action ref-rstream -param $refparam ) | grep -v '^#' ) || _q "convert gvcf $t"
+done
+
+./pasta -action convert -from gvcf -to gvf -i $odir/convert.gvcf -refstream <( ./pasta -action ref-rstream -param $refparam ) | \
+  ./pasta -action convert -from gvf -to rotini -refstream <( ./pasta -action ref-rstream -param $refparam ) > $odir/convert.out
+
+diff <( ./pasta -action rotini-alt0 -i $odir/convert.inp ) <( ./pasta -action rotini-alt0 -i $odir/convert.out ) || _q "convert gvf alt0"
+diff <( ./pasta -action rotini-alt1 -i $odir/convert.inp ) <( ./pasta -action rotini-alt1 -i $odir/convert.out ) || _q "convert gvf alt1"
+
+echo ok-gff
+
+## Formats that can't be read or written
+##
+./pasta -action convert -from fasta -to gvcf -i $odir/convert.inp 2> /dev/null && _q "convert read fasta"
+./pasta -action convert -from gvcf -to mastervar -i $odir/convert.gvcf 2> /dev/null && _q "convert write mastervar"
+./pasta -action convert -from rotini -to nosuchformat -i $odir/convert.inp 2> /dev/null && _q "convert unknown format"
+
+## Read errors aren't lost in the pipe to the writer
+##
+awk 'BEGIN { OFS="\t" } !/^#/ && !done && ($5!=".") { sub(/^[^:]*/, "9/9", $10) ; done=1 } { print }' $odir/convert.gvcf > $odir/convert-bad.gvcf
+./pasta -action convert -from gvcf -to gff -i $odir/convert-bad.gvcf -refstream <( ./pasta -action ref-rstream -param $refparam ) > /dev/null 2>&1 && _q "convert read error"
+
+echo ok
+exit 0
@@ -0,0 +1,53 @@
+#!/bin/bash
+
+function _q {
+  echo $1
+  exit 1
+}
+
+
+odir="assay/fastj-check"
+mkdir -p $odir
+
+./pasta -action rstream -param 'p-nocall=0:p-indel=0.1:p-indel-length=0,2:ref-seed=99:n=600:seed=7' > $odir/fjc.inp
+ref=`./pasta -action rotini-ref -i $odir/fjc.inp | tr -d '\n'`
+
+## One path of three tiles, the tags taken from the reference
+## at the tile boundaries
+##
+printf ">hg19:chr1:0000\n0000\t200\n0001\t400\n0002\t600\n" > $odir/fjc.asm
+( echo ">0000.00" ; echo ${ref:176:24} ; echo ${ref:376:24} ) > $odir/fjc.tag
+
+./pasta -action rotini-fastj -i $odir/fjc.inp -build hg19 -tilepath 0 -chrom chr1 \
+  -assembly $odir/fjc.asm -tag $odir/fjc.tag > $odir/fjc.fj
+
+./pasta -action fastj-check -i $odir/fjc.fj -tag $odir/fjc.tag -assembly $odir/fjc.asm > $odir/fjc.out || _q "fastj-check valid"
+
+echo ok-valid
+
+## Corrupted tile: a base of the first tile's sequence changed
+##
+awk 'NR==2 { $0 = ((substr($0,1,1)=="a") ? "c" : "a") substr($0,2) } { print }' $odir/fjc.fj > $odir/fjc-corrupt.fj
+./pasta -action fastj-check -i $odir/fjc-corrupt.fj -tag $odir/fjc.tag -assembly $odir/fjc.asm > $odir/fjc-corrupt.out 2> /dev/null && _q "fastj-check corrupted tile"
+grep -q '^0000.00.0000.000	md5sum .* does not match sequence' $odir/fjc-corrupt.out || _q "fastj-check corrupted tile report"
+
+echo ok-corrupt
+
+## Bad md5sum in the first tile's header
+##
+sed '1s/"md5sum":"[0-9a-f]*"/"md5sum":"00000000000000000000000000000000"/' $odir/fjc.fj > $odir/fjc-md5.fj
+./pasta -action fastj-check -i $odir/fjc-md5.fj > $odir/fjc-md5.out 2> /dev/null && _q "fastj-check bad md5sum"
+grep -q '^0000.00.0000.000	md5sum 0* does not match sequence' $odir/fjc-md5.out || _q "fastj-check bad md5sum report"
+
+echo ok-md5
+
+## Span mismatch: the first tile's locus doesn't end where the
+## assembly says its step does
+##
+sed '1s/\(chr1 [0-9]* \)200"/\1199"/' $odir/fjc.fj > $odir/fjc-span.fj
+cmp -s $odir/fjc.fj $odir/fjc-span.fj && _q "fastj-check span mismatch setup"
+./pasta -action fastj-check -i $odir/fjc-span.fj -tag $odir/fjc.tag -assembly $odir/fjc.asm > $odir/fjc-span.out 2> /dev/null && _q "fastj-check span mismatch"
+grep -q '^0000.00.0000.000	locus end 199 does not match assembly end 200' $odir/fjc-span.out || _q "fastj-check span mismatch report"
+
+echo ok
+exit 0
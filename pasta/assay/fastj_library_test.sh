@@ -0,0 +1,63 @@
+#!/bin/bash
+
+function _q {
+  echo $1
+  exit 1
+}
+
+
+odir="assay/fastj-library"
+mkdir -p $odir
+
+refparam='ref-seed=99:n=600'
+
+./pasta -action rstream -param "p-nocall=0:p-indel=0:$refparam:seed=1" > $odir/ref.inp
+ref=`./pasta -action rotini-ref -i $odir/ref.inp | tr -d '\n'`
+
+## One path of three tiles, the tags taken from the reference
+## at the tile boundaries
+##
+printf ">hg19:chr1:0000\n0000\t200\n0001\t400\n0002\t600\n" > $odir/lib.asm
+( echo ">0000.00" ; echo ${ref:176:24} ; echo ${ref:376:24} ) > $odir/lib.tag
+
+for s in 1 2 3 ; do
+  ./pasta -action rstream -param "p-snp=0.02:p-nocall=0.1:p-indel=0.1:p-indel-length=0,2:$refparam:seed=$s" > $odir/s$s.inp
+  ./pasta -action rotini-fastj -i $odir/s$s.inp -build hg19 -tilepath 0 -chrom chr1 \
+    -assembly $odir/lib.asm -tag $odir/lib.tag > $odir/s$s.fj || _q "rotini-fastj s$s"
+done
+
+## The same tiles as s1 with the no-calls written as 'N'
+##
+awk '/^>/ { print ; next } { gsub(/n/, "N") ; print }' $odir/s1.fj > $odir/s4.fj
+grep -v '^>' $odir/s4.fj | grep -q N || _q "fastj-library no-call setup"
+
+./pasta -action fastj-library -tilepath 0 -i $odir/s1.fj -i $odir/s2.fj -i $odir/s3.fj -i $odir/s4.fj \
+  -tile-vector $odir/lib.vec > $odir/lib.fj || _q "fastj-library"
+
+./pasta -action fastj-check -is-library -i $odir/lib.fj -tag $odir/lib.tag -assembly $odir/lib.asm > /dev/null || _q "fastj-library check"
+
+echo ok-library
+
+## Tile vectors: a row per sample allele, a column per step
+##
+steps=`head -n1 $odir/lib.vec | cut -f3- | tr '\t' ' '`
+[ "$steps" == "0000.0000 0000.0001 0000.0002" ] || _q "fastj-library vector steps"
+[ `grep -vc '^#' $odir/lib.vec` == 8 ] || _q "fastj-library vector rows"
+
+## Tiles that only differ in how their no-calls are written
+## get the same variant
+##
+diff <( grep '^s1	' $odir/lib.vec | cut -f2- ) <( grep '^s4	' $odir/lib.vec | cut -f2- ) || _q "fastj-library masked no-calls"
+
+echo ok-vector
+
+## Converting with the library gives the tile variants in the
+## vector
+##
+exp=`awk '$1=="s2" { for (ii=3; ii<=NF; ii++) printf("0000.00.%04x.%03x\n", ii-3, $ii) }' $odir/lib.vec | sort`
+got=`./pasta -action rotini-fastj -i $odir/s2.inp -build hg19 -tilepath 0 -chrom chr1 \
+  -assembly $odir/lib.asm -tag $odir/lib.tag -library $odir/lib.fj | grep -o '"tileID":"[^"]*"' | cut -d'"' -f4 | sort`
+[ "$exp" == "$got" ] || _q "fastj-library tile variant IDs"
+
+echo ok
+exit 0
@@ -155,6 +155,142 @@ diff <( ./pasta -action rotini-gvcf -i $odir/snippet5.pa | ./pasta -action gvcf-
 
 echo 'ok-snippet5'
 
+## Multi-sample gVCF: add a second, homozygous reference, sample
+## column and convert both samples in one pass.
+##
+./pasta -action rstream -param 'p-snp=0.3:p-indel=0.3:ref-seed=11223344:n=1000:seed=1234' > $odir/gvcf-multi.inp
+./pasta -action rotini-gvcf -i $odir/gvcf-multi.inp | \
+  awk 'BEGIN { OFS="\t" } /^##/ { print ; next } /^#CHROM/ { print $0, "HOMREF" ; next } { print $0, "0/0" }' > $odir/gvcf-multi.gvcf
+
+./pasta -action gvcf-rotini -all-samples -i $odir/gvcf-multi.gvcf -o $odir/gvcf-multi. \
+  -refstream <( ./pasta -action ref-rstream -param 'ref-seed=11223344:allele=1' )
+
+diff <( ./pasta -action rotini-alt0 -i $odir/gvcf-multi.inp ) <( ./pasta -action rotini-alt0 -i $odir/gvcf-multi.SAMPLE.rotini ) || echo "multi-sample SAMPLE alt0 failed"
+diff <( ./pasta -action rotini-alt1 -i $odir/gvcf-multi.inp ) <( ./pasta -action rotini-alt1 -i $odir/gvcf-multi.SAMPLE.rotini ) || echo "multi-sample SAMPLE alt1 failed"
+diff <( ./pasta -action rotini-ref -i $odir/gvcf-multi.inp ) <( ./pasta -action rotini-alt0 -i $odir/gvcf-multi.HOMREF.rotini ) || echo "multi-sample HOMREF alt0 failed"
+
+## Sample names can't write outside of the output prefix
+##
+sed '/^#CHROM/s/HOMREF$/..\/HOMREF/' $odir/gvcf-multi.gvcf > $odir/gvcf-multi-path.gvcf
+./pasta -action gvcf-rotini -all-samples -i $odir/gvcf-multi-path.gvcf -o $odir/gvcf-multi-path. \
+  -refstream <( ./pasta -action ref-rstream -param 'ref-seed=11223344:allele=1' )
+cmp -s $odir/gvcf-multi.HOMREF.rotini $odir/gvcf-multi-path.___HOMREF.rotini || echo "multi-sample file name failed"
+
+diff <( ./pasta -action gvcf-rotini -sample 1 -i $odir/gvcf-multi.gvcf -refstream <( ./pasta -action ref-rstream -param 'ref-seed=11223344:allele=1' ) ) \
+  $odir/gvcf-multi.HOMREF.rotini || echo "multi-sample --sample failed"
+
+echo 'ok-multi-sample'
+
+## Records without an END cover the whole of their REF field
+##
+printf 'aaccggttaacc' > $odir/vcf-noend.ref
+printf '##fileformat=VCFv4.2\n#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tSAMPLE\n' > $odir/vcf-noend.vcf
+printf 'chr1\t1\t.\ta\t.\t.\tPASS\tEND=4\tGT\t0/0\n' >> $odir/vcf-noend.vcf
+printf 'chr1\t5\t.\tggtt\tg\t.\tPASS\t.\tGT\t0/1\n' >> $odir/vcf-noend.vcf
+printf 'chr1\t9\t.\ta\t.\t.\tPASS\tEND=12\tGT\t0/0\n' >> $odir/vcf-noend.vcf
+./pasta -action gvcf-rotini -i $odir/vcf-noend.vcf -refstream $odir/vcf-noend.ref > $odir/vcf-noend.rotini
+
+x=`./pasta -action rotini-alt0 -i $odir/vcf-noend.rotini | tr -d '\n'`
+if [[ "$x" != "aaccggttaacc" ]] ; then echo "vcf record without END alt0 failed ($x)" ; fi
+x=`./pasta -action rotini-alt1 -i $odir/vcf-noend.rotini | tr -d '\n'`
+if [[ "$x" != "aaccgaacc" ]] ; then echo "vcf record without END alt1 failed ($x)" ; fi
+
+echo 'ok-vcf-noend'
+
+## Annotated stream: reference runs in the same GQ band should
+## merge into one block and round trip back to the same alleles.
+##
+printf '>C{chr1}>P{0}>A{GQ=30;DP=10}aaaaaaaaaaaaaaaa>A{GQ=35;DP=8;AD=3}cccccccc>A{GQ=70;DP=20}gggggggg>A{GQ=40;DP=9;AD=5,4}#taa' > $odir/gvcf-annot.inp
+./pasta -action rotini-ref -i $odir/gvcf-annot.inp > $odir/gvcf-annot.ref
+./pasta -action rotini-gvcf -gq-bands 5,20,60 -format-fields AD -i $odir/gvcf-annot.inp > $odir/gvcf-annot.gvcf
+
+n=`grep -v '^#' $odir/gvcf-annot.gvcf | grep -c 'GT:GQ:DP:MIN_DP:AD'`
+if [[ "$n" != "3" ]] ; then echo "annotated gvcf ref block banding failed" ; fi
+
+grep -q '^##GVCFBlock0-5=minGQ=0(inclusive),maxGQ=5(exclusive)' $odir/gvcf-annot.gvcf || echo "annotated gvcf lowest GQ band header failed"
+grep -q '^##FORMAT=<ID=MIN_DP' $odir/gvcf-annot.gvcf || echo "annotated gvcf MIN_DP header failed"
+grep -q '^##FORMAT=<ID=GQ\|^##GVCFBlock' $odir/gvcf-multi.gvcf && echo "unannotated gvcf quality headers failed"
+
+## Quality fields are only written, and always declared, when
+## bands are given, however late the annotations start
+##
+./pasta -action rotini-gvcf -i $odir/gvcf-annot.inp | grep -v '^#' | grep -q 'GT:GQ' && echo "annotated gvcf without bands failed"
+printf '>C{chr1}>P{0}aaaaaaaa>A{GQ=30;DP=10}cccccccc' | ./pasta -action rotini-gvcf -gq-bands 5,20,60 > $odir/gvcf-annot-late.gvcf
+grep -v '^#' $odir/gvcf-annot-late.gvcf | grep -q 'GT:GQ:DP:MIN_DP' || echo "late annotated gvcf quality fields failed"
+grep -q '^##FORMAT=<ID=GQ' $odir/gvcf-annot-late.gvcf || echo "late annotated gvcf quality headers failed"
+
+diff <( ./pasta -action gvcf-rotini -i $odir/gvcf-annot.gvcf -refstream $odir/gvcf-annot.ref | ./pasta -action rotini-alt0 ) \
+  <( ./pasta -action rotini-alt0 -i $odir/gvcf-annot.inp ) || echo "annotated gvcf alt0 failed"
+diff <( ./pasta -action gvcf-rotini -i $odir/gvcf-annot.gvcf -refstream $odir/gvcf-annot.ref | ./pasta -action rotini-alt1 ) \
+  <( ./pasta -action rotini-alt1 -i $odir/gvcf-annot.inp ) || echo "annotated gvcf alt1 failed"
+
+echo 'ok-annotated'
+
+## Joint gVCF: write one multi-sample gVCF from several rotini
+## streams and split it back out per sample.
+##
+for s in 1 2 3 ; do
+  ./pasta -action rstream -param "p-snp=0.2:p-indel=0.4:p-nocall=0.1:ref-seed=11223344:n=2000:seed=$s" > $odir/joint$s.inp
+done
+
+./pasta -action rotini-gvcf -i $odir/joint1.inp -i $odir/joint2.inp -i $odir/joint3.inp > $odir/joint.gvcf
+./pasta -action gvcf-rotini -all-samples -i $odir/joint.gvcf -o $odir/joint-out. \
+  -refstream <( ./pasta -action ref-rstream -param 'ref-seed=11223344:n=2000:allele=1' )
+
+for s in 1 2 3 ; do
+  diff <( ./pasta -action rotini-alt0 -i $odir/joint$s.inp ) <( ./pasta -action rotini-alt0 -i $odir/joint-out.joint$s.rotini ) || echo "joint sample $s alt0 failed"
+  diff <( ./pasta -action rotini-alt1 -i $odir/joint$s.inp ) <( ./pasta -action rotini-alt1 -i $odir/joint-out.joint$s.rotini ) || echo "joint sample $s alt1 failed"
+done
+
+## No-call runs (">N{}") come out as missing genotypes
+##
+printf '>C{chr1}>P{0}aaccggttaaccggttaacc\n' > $odir/joint-ref.inp
+printf '>C{chr1}>P{0}aacc>N{6}ttaa\n' > $odir/joint-noc.inp
+./pasta -action rotini-gvcf -i $odir/joint-ref.inp -i $odir/joint-noc.inp | \
+  egrep -q '^chr1	3	.*NOCALL	END=8	GT	0/0	\./\.$' || echo "joint nocall run failed"
+
+## Streams covering several chromosomes give the same records as
+## one joint gVCF per chromosome.  Samples that don't agree on the
+## chromosomes are an error.
+##
+for s in 1 2 ; do
+  sed 's/>C{Unk}/>C{chr1}/' $odir/joint$s.inp > $odir/joint-chr1.$s.inp
+  ./pasta -action rstream -param "p-snp=0.2:p-indel=0.4:p-nocall=0.1:ref-seed=55667788:n=1000:seed=1$s" | \
+    sed 's/>C{Unk}/>C{chr2}/' > $odir/joint-chr2.$s.inp
+  cat $odir/joint-chr1.$s.inp $odir/joint-chr2.$s.inp > $odir/joint-chr12.$s.inp
+done
+
+diff <( ./pasta -action rotini-gvcf -i $odir/joint-chr12.1.inp -i $odir/joint-chr12.2.inp | grep -v '^#' ) \
+  <( ( ./pasta -action rotini-gvcf -i $odir/joint-chr1.1.inp -i $odir/joint-chr1.2.inp ; \
+       ./pasta -action rotini-gvcf -i $odir/joint-chr2.1.inp -i $odir/joint-chr2.2.inp ) | grep -v '^#' ) || echo "joint multiple chromosomes failed"
+
+if ./pasta -action rotini-gvcf -i $odir/joint-chr12.1.inp -i $odir/joint-chr2.2.inp > /dev/null 2>&1 ; then
+  echo "joint chromosome mismatch failed"
+fi
+
+echo 'ok-joint'
+
+## Symbolic alleles: <NON_REF>/<*> are skipped for genotype
+## indexing (nocall if called), <DEL> expands to a deletion.
+##
+printf 'acgtacgtacgtacgtacgt' > $odir/sym.ref
+printf '##fileformat=VCFv4.2\n#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tS1\n' > $odir/sym.gvcf
+printf 'chr1\t1\t.\ta\t<NON_REF>\t.\t.\tEND=3\tGT:DP:GQ\t0/0:10:30\n' >> $odir/sym.gvcf
+printf 'chr1\t4\t.\tt\tg,<NON_REF>\t50\t.\tDP=10\tGT:AD\t0/1:5,5\n' >> $odir/sym.gvcf
+printf 'chr1\t5\t.\ta\t<*>\t.\t.\tEND=6\tGT\t0/0\n' >> $odir/sym.gvcf
+printf 'chr1\t7\t.\tg\t<DEL>\t.\t.\tSVLEN=-3\tGT\t0/1\n' >> $odir/sym.gvcf
+printf 'chr1\t11\t.\tg\t<NON_REF>\t.\t.\tEND=11\tGT\t1/1\n' >> $odir/sym.gvcf
+printf 'chr1\t12\t.\tt\t<DEL>\t.\t.\tEND=14;SVTYPE=DEL\tGT\t1/1\n' >> $odir/sym.gvcf
+printf 'chr1\t15\t.\tg\t<NON_REF>\t.\t.\tEND=20\tGT\t0/0\n' >> $odir/sym.gvcf
+
+x=`./pasta -action gvcf-rotini -i $odir/sym.gvcf -refstream $odir/sym.ref | ./pasta -action rotini-alt0 | tr -d '\n'`
+if [[ "$x" != "acgtacgtacntgtacgt" ]] ; then echo "symbolic allele alt0 failed ($x)" ; fi
+
+x=`./pasta -action gvcf-rotini -i $odir/sym.gvcf -refstream $odir/sym.ref | ./pasta -action rotini-alt1 | tr -d '\n'`
+if [[ "$x" != "acggacgntgtacgt" ]] ; then echo "symbolic allele alt1 failed ($x)" ; fi
+
+echo 'ok-symbolic'
+
 exit 0
 
 #diff $odir/gvcf-nocall.inp $odir/gvcf-nocall.out
@@ -0,0 +1,72 @@
+#!/bin/bash
+
+function _q {
+  echo $1
+  exit 1
+}
+
+
+odir="assay/gvf"
+mkdir -p $odir
+
+## GVF with snps
+##
+./pasta -action rstream -param 'p-snp=0.5:ref-seed=11223344:n=1000:seed=1234' > $odir/gvf-snp.inp
+./pasta -action rotini-gvf -i $odir/gvf-snp.inp \
+  | ./pasta -action gvf-rotini -refstream <( ./pasta -action ref-rstream -param 'ref-seed=11223344:n=1000:allele=1' ) > $odir/gvf-snp.out
+
+diff <( ./pasta -action rotini-ref -i $odir/gvf-snp.inp ) <( ./pasta -action rotini-ref -i $odir/gvf-snp.out ) || _q "gvf snp ref"
+diff <( ./pasta -action rotini-alt0 -i $odir/gvf-snp.inp ) <( ./pasta -action rotini-alt0 -i $odir/gvf-snp.out ) || _q "gvf snp alt0"
+diff <( ./pasta -action rotini-alt1 -i $odir/gvf-snp.inp ) <( ./pasta -action rotini-alt1 -i $odir/gvf-snp.out ) || _q "gvf snp alt1"
+
+echo ok-snp
+
+## GVF with indels
+##
+./pasta -action rstream -param 'p-indel=0.8:p-indel-length=0,3:p-nocall=0:ref-seed=11223344:n=1000:seed=1234' > $odir/gvf-indel.inp
+./pasta -action rotini-gvf -i $odir/gvf-indel.inp | \
+  ./pasta -action gvf-rotini \
+     -refstream <( ./pasta -action ref-rstream \
+     -param 'ref-seed=11223344:n=1000:allele=1' ) \
+     > $odir/gvf-indel.out
+
+diff <( ./pasta -action rotini-alt0 -i $odir/gvf-indel.inp ) <( ./pasta -action rotini-alt0 -i $odir/gvf-indel.out ) || _q "gvf indel alt0"
+diff <( ./pasta -action rotini-alt1 -i $odir/gvf-indel.inp ) <( ./pasta -action rotini-alt1 -i $odir/gvf-indel.out ) || _q "gvf indel alt1"
+
+for t in SNV MNP insertion deletion indel ; do
+  grep -q -P "\t$t\t" <( ./pasta -action rotini-gvf -i $odir/gvf-indel.inp ; ./pasta -action rotini-gvf -i $odir/gvf-snp.inp ) || _q "gvf missing SO type $t"
+done
+
+echo ok-indel
+
+## GVF with indels and nocalls
+##
+./pasta -action rstream -param 'p-nocall=0.3:p-indel=0.5:p-indel-nocall=0.8:ref-seed=11223344:n=1000:seed=1234' > $odir/gvf-indel-nocall.inp
+./pasta -action rotini-gvf -i $odir/gvf-indel-nocall.inp | \
+  ./pasta -action gvf-rotini -refstream <( ./pasta -action ref-rstream -param 'ref-seed=11223344:n=1000:allele=1' ) > $odir/gvf-indel-nocall.out
+
+diff <( ./pasta -action rotini-alt0 -i $odir/gvf-indel-nocall.inp ) \
+  <( ./pasta -action rotini-alt0 -i $odir/gvf-indel-nocall.out ) || _q "gvf indel-nocall alt0"
+diff <( ./pasta -action rotini-alt1 -i $odir/gvf-indel-nocall.inp ) \
+  <( ./pasta -action rotini-alt1 -i $odir/gvf-indel-nocall.out ) || _q "gvf indel-nocall alt1"
+
+echo ok-indel-nocall
+
+## No-call runs are written with an unknown reference and read
+## back as no-calls over the reference stream
+##
+printf '>C{chr1}>P{0}aaccggtt>N{4}aaccggtt' > $odir/gvf-nocall-run.inp
+./pasta -action rotini-gvf -i $odir/gvf-nocall-run.inp > $odir/gvf-nocall-run.gvf
+grep -q -P '\t5\t8\t.*Variant_seq=~;Reference_seq=~' $odir/gvf-nocall-run.gvf || _q "gvf nocall run write"
+[ `./pasta -action gvf-rotini -i $odir/gvf-nocall-run.gvf -refstream <( echo acgtacgtacgt ) | ./pasta -action rotini-alt0` == "acgtnnnnacgt" ] || _q "gvf nocall run read"
+
+echo ok-nocall-run
+
+## Positions start over on a new sequence
+##
+printf 'chr1\tp\tSNV\t8\t8\t.\t+\t.\tVariant_seq=C;Reference_seq=T;Genotype=0:0\nchr2\tp\tSNV\t2\t2\t.\t+\t.\tVariant_seq=G;Reference_seq=T;Genotype=0:0\n' > $odir/gvf-chrom.gvf
+./pasta -action gvf-rotini -i $odir/gvf-chrom.gvf -refstream <( echo acgtacgtttgcaa ) > $odir/gvf-chrom.out || _q "gvf new sequence"
+grep -q '>C{chr2}>P{0}' $odir/gvf-chrom.out || _q "gvf new sequence position"
+
+echo ok-chrom
+exit 0
@@ -0,0 +1,57 @@
+#!/bin/bash
+
+function _q {
+  echo $1
+  exit 1
+}
+
+odir="assay/mastervar"
+mkdir -p $odir
+
+## masterVar with snps, indels and nocalls.  The masterVar below
+## describes the same sample as the rstream.
+##
+./pasta -action rstream -param 'p-indel=0.3:p-snp=0.3:p-nocall=0.2:p-indel-length=0,3:ref-seed=11223344:n=300:seed=5' > $odir/mastervar.inp
+
+cat > $odir/mastervar.mv <<MASTERVAR
+#FORMAT_VERSION	2.0
+#TYPE	VAR-OLPL
+
+>locus	ploidy	chromosome	begin	end	zygosity	varType	reference	allele1Seq	allele2Seq	allele1VarScoreVAF	allele2VarScoreVAF	allele1VarFilter	allele2VarFilter	totalReadCount
+1	2	chr1	0	18	hom	ref	=	=	=	40	35	PASS	VQLOW;AMBIG	10
+2	2	chr1	18	18	het-alt	sub		a	ag	41	35	PASS	PASS	11
+3	2	chr1	18	69	hom	ref	=	=	=	42	35	PASS	PASS	12
+4	2	chr1	69	70	hom	sub	g	t	t	43	35	PASS	PASS	13
+5	2	chr1	70	97	hom	ref	=	=	=	44	35	PASS	PASS	14
+6	2	chr1	97	106	no-call	no-call	=	?	?	45	35	PASS	VQLOW;AMBIG	15
+7	2	chr1	106	133	hom	ref	=	=	=	46	35	PASS	PASS	16
+8	2	chr1	133	142	no-call	no-call	=	?	?	40	35	PASS	PASS	17
+9	2	chr1	142	145	hom	sub	gta	cc	cc	41	35	PASS	PASS	18
+10	2	chr1	145	154	no-call	no-call	=	?	?	42	35	PASS	PASS	19
+11	2	chr1	154	243	hom	ref	=	=	=	43	35	PASS	VQLOW;AMBIG	20
+12	2	chr1	243	251	no-call	no-call	=	?	?	44	35	PASS	PASS	21
+13	2	chr1	251	267	hom	ref	=	=	=	45	35	PASS	PASS	22
+14	2	chr1	267	268	no-call	no-call	=	?	?	46	35	PASS	PASS	10
+15	2	chr1	268	269	het-alt	sub	t		aa	40	35	PASS	PASS	11
+16	2	chr1	269	282	hom	ref	=	=	=	41	35	PASS	VQLOW;AMBIG	12
+17	2	chr1	282	284	het-alt	sub	tc	aa	t	42	35	PASS	PASS	13
+18	2	chr1	284	286	no-call	no-call	=	?	?	43	35	PASS	PASS	14
+19	2	chr1	286	300	hom	ref	=	=	=	44	35	PASS	PASS	15
+MASTERVAR
+
+./pasta -action mastervar-rotini -i $odir/mastervar.mv \
+  -refstream <( ./pasta -action ref-rstream -param 'ref-seed=11223344:allele=1:n=300' ) \
+  > $odir/mastervar.out || _q "mastervar-rotini"
+
+diff <( ./pasta -action rotini-ref -i $odir/mastervar.inp ) <( ./pasta -action rotini-ref -i $odir/mastervar.out ) || _q "mastervar ref"
+diff <( ./pasta -action rotini-alt0 -i $odir/mastervar.inp ) <( ./pasta -action rotini-alt0 -i $odir/mastervar.out ) || _q "mastervar alt0"
+diff <( ./pasta -action rotini-alt1 -i $odir/mastervar.inp ) <( ./pasta -action rotini-alt1 -i $odir/mastervar.out ) || _q "mastervar alt1"
+
+echo ok-mastervar
+
+## Quality columns come through as annotations
+##
+grep -q '>A{DP=11;allele1VarFilter=PASS;allele1VarScoreVAF=41;allele2VarFilter=PASS;allele2VarScoreVAF=35;totalReadCount=11;zygosity=het-alt}' $odir/mastervar.out || _q "mastervar annotation"
+./pasta -action rotini-gvcf -i $odir/mastervar.out -format-fields zygosity | grep -v '^#' | cut -f9,10 | grep -q '^GT:DP:zygosity	1/2:11:het-alt$' || _q "mastervar gvcf annotation"
+
+echo ok-mastervar-annotation
@@ -0,0 +1,34 @@
+#!/bin/bash
+
+function _q {
+  echo $1
+  exit 1
+}
+
+odir="assay/parallel"
+mkdir -p $odir
+
+## Chunked gVCF conversion gives the same output as the
+## sequential conversion, over two chromosomes with quality
+## annotations scattered through the stream.
+##
+for seed in 1 2 3 4 5 ; do
+
+  ./pasta -action rstream -param "p-snp=0.1:p-indel=0.05:p-nocall=0.01:n=4000:chrom=chr1:seed=$seed:ref-seed=$((seed+100))" > $odir/a.rot
+  ./pasta -action rstream -param "p-snp=0.1:p-indel=0.05:n=3000:chrom=chr2:pos=100:seed=$((seed+7)):ref-seed=$((seed+200))" > $odir/b.rot
+
+  cat $odir/a.rot $odir/b.rot | \
+    awk -v seed=$seed 'BEGIN { srand(seed) } (NR>1) && (rand()<0.3) { printf(">A{GQ=%d;DP=%d}", int(rand()*80), int(rand()*40)) } { print }' > $odir/inp.rot
+
+  ./pasta -action rotini-gvcf -gq-bands 5,20,60 -i $odir/inp.rot | grep -v '^##fileDate' > $odir/seq.gvcf
+  [ "`grep -c -v '^#' $odir/seq.gvcf`" -gt 50 ] || _q "too few gVCF lines (seed $seed)"
+  grep -q '^##FORMAT=<ID=GQ' $odir/seq.gvcf || _q "GQ not declared (seed $seed)"
+
+  for chunk in 1 13 200 ; do
+    ./pasta -action rotini-gvcf -gq-bands 5,20,60 -i $odir/inp.rot -max-procs 4 -chunk-size $chunk | grep -v '^##fileDate' > $odir/par.gvcf
+    cmp -s $odir/seq.gvcf $odir/par.gvcf || _q "chunked gVCF differs (seed $seed, chunk size $chunk)"
+  done
+
+done
+
+echo ok
@@ -114,6 +114,81 @@ diff <( ./pasta -action rotini-alt0 -i $ofn_b.inp ) <( ./pasta -action rotini-al
 diff <( ./pasta -action rotini-alt1 -i $ofn_b.inp ) <( ./pasta -action rotini-alt1 -i $ofn_b.out ) || _q "nocall-indel2 alt1 mismatch"
 
 
+## JSON parameter file, two chromosomes with
+## mutation models
+##
+ofn_b="$bdir/config"
+cat > $ofn_b.json <<EOF
+{
+  "ref-seed":1234, "seed":5678,
+  "p-snp":0.05, "ts-tv":2.1, "het-hom-ratio":1.6,
+  "p-indel":0.01, "indel-model":"geometric", "indel-geometric-p":0.5, "p-indel-length":[1,20],
+  "p-nocall":0.001, "p-nocall-cluster":0.002, "nocall-cluster-length":[50,300], "p-nocall-in-cluster":0.3,
+  "chromosomes":[
+    {"chrom":"chr1","n":20000},
+    {"chrom":"chr2","n":10000,"p-snp":0.01,"indel-length-weight":[0,5,3,1]}
+  ]
+}
+EOF
+
+./pasta -action rstream -param-file $ofn_b.json > $ofn_b.inp
+./pasta -action ref-rstream -param-file $ofn_b.json -param 'allele=1' > $ofn_b.ref
+[ "`grep -o '>C{[^}]*}' $ofn_b.inp | tr '\n' ' '`" == ">C{chr1} >C{chr2} " ] || _q "config chromosomes"
+diff <( ./pasta -action rotini-ref -i $ofn_b.inp | tr -d '\n' ) <( grep -v '^>' $ofn_b.ref | tr -d '\n' ) > /dev/null || _q "config ref streams don't match"
+
+./pasta -action rotini-cgivar -i $ofn_b.inp | ./pasta -action cgivar-rotini -refstream $ofn_b.ref > $ofn_b.out
+diff <( ./pasta -action rotini-ref -i $ofn_b.inp ) <( ./pasta -action rotini-ref -i $ofn_b.out ) || _q "config ref mismatch"
+diff <( ./pasta -action rotini-alt0 -i $ofn_b.inp ) <( ./pasta -action rotini-alt0 -i $ofn_b.out ) || _q "config alt0 mismatch"
+diff <( ./pasta -action rotini-alt1 -i $ofn_b.inp ) <( ./pasta -action rotini-alt1 -i $ofn_b.out ) || _q "config alt1 mismatch"
+
+
+## Variants spiked into a reference FASTA region,
+## with a truth VCF
+##
+ofn_b="$bdir/fasta"
+cat > $ofn_b.fa <<EOF
+>chrX test
+ACGTTGCAAGGCTTAACCGGTACGATCGATCGGGGGGGGGGATCCATGCATGCAAAAAAAAAAAAAATTGCA
+CACACACACACACACACACAGTTGACGGATCNNNNNNNNNNNNTTGACCAGTAGCATCGACTTTTTTTTTTGA
+GGACTCAGTCAGAGAGAGAGAGAGAGTTCCAGGTACCATGACGTTAGCAGATTTACGACGGGCATCAGGCATT
+>chrY
+ACGTACGTACGT
+EOF
+
+param="ref-fasta=$ofn_b.fa:region=chrX:11-200:p-snp=0.6:p-indel=0.4:p-indel-length=0,4:seed=1234:truth-vcf=$ofn_b.vcf:sample=S1"
+./pasta -action rstream -param "$param" > $ofn_b.inp
+./pasta -action ref-rstream -param "$param:allele=1" > $ofn_b.ref
+[ "`head -n1 $ofn_b.inp`" == ">C{chrX}>P{10}" ] || _q "fasta region header"
+
+region_seq=`grep -v '^>' $ofn_b.fa | head -n3 | tr -d '\n' | cut -c11-200 | tr 'A-Z' 'a-z'`
+[ "`./pasta -action rotini-ref -i $ofn_b.inp | tr -d '\n'`" == "$region_seq" ] || _q "fasta region ref mismatch"
+[ "`grep -v '^>' $ofn_b.ref | tr -d '\n'`" == "$region_seq" ] || _q "fasta ref-rstream mismatch"
+
+grep -q '^#CHROM.*FORMAT	S1$' $ofn_b.vcf || _q "truth vcf header"
+[ "`grep -vc '^#' $ofn_b.vcf`" -gt 0 ] || _q "truth vcf has no variants"
+
+fa_seq=`grep -v '^>' $ofn_b.fa | head -n3 | tr -d '\n'`
+while read chrom pos id ref alt rest ; do
+  [ "$chrom" == "chrX" ] || _q "truth vcf chrom $chrom"
+  [ "${fa_seq:$((pos-1)):${#ref}}" == "$ref" ] || _q "truth vcf ref mismatch at $pos"
+done < <( grep -v '^#' $ofn_b.vcf )
+
+./pasta -action rotini-cgivar -i $ofn_b.inp | ./pasta -action cgivar-rotini -refstream $ofn_b.ref > $ofn_b.out
+diff <( ./pasta -action rotini-alt0 -i $ofn_b.inp ) <( ./pasta -action rotini-alt0 -i $ofn_b.out ) || _q "fasta alt0 mismatch"
+diff <( ./pasta -action rotini-alt1 -i $ofn_b.inp ) <( ./pasta -action rotini-alt1 -i $ofn_b.out ) || _q "fasta alt1 mismatch"
+
+
+## Output to a file (`--output`) rather than stdout
+##
+ofn_b="$bdir/output"
+./pasta -action rstream -param 'p-snp=0.1:p-indel=0.05:p-nocall=0.01:seed=1234' > $ofn_b.inp
+for a in rotini-diff rotini-ref rotini-gvcf rotini-cgivar ; do
+  ./pasta -action $a -i $ofn_b.inp -o $ofn_b.$a > $ofn_b.$a.stdout
+  [ -s $ofn_b.$a.stdout ] && _q "$a wrote to stdout with --output"
+  diff <( grep -v '^#' $ofn_b.$a ) <( ./pasta -action $a -i $ofn_b.inp | grep -v '^#' ) > /dev/null || _q "$a --output mismatch"
+done
+
+
 ## Everything passed
 #
 echo ok
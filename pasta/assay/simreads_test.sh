@@ -0,0 +1,56 @@
+#!/bin/bash
+
+function _q {
+  echo $1
+  exit 1
+}
+
+odir="assay/simreads"
+mkdir -p $odir
+
+./pasta -action rstream -param 'p-snp=0.05:p-indel=0.01:p-nocall=0:ref-seed=11223344:n=5000:seed=1234' > $odir/sim.inp
+./pasta -action ref-rstream -param 'ref-seed=11223344:n=5000:allele=1' > $odir/sim.ref
+
+./pasta -action rotini-alt0 -i $odir/sim.inp | tr -d '\n' | tr 'a-z' 'A-Z' > $odir/sim.alt0
+./pasta -action rotini-alt1 -i $odir/sim.inp | tr -d '\n' | tr 'a-z' 'A-Z' > $odir/sim.alt1
+
+## Error free reads, interleaved
+##
+./pasta -action simulate-reads -i $odir/sim.inp -refstream $odir/sim.ref \
+  -param 'coverage=20:read-length=100:insert-mean=300:insert-sd=20:p-error=0:seed=1234' > $odir/sim.fq
+
+n_line=`wc -l < $odir/sim.fq`
+[ "`expr $n_line % 8`" == "0" ] || _q "incomplete read pairs"
+n_pair=`expr $n_line / 8`
+[ "$n_pair" -gt 450 ] && [ "$n_pair" -lt 550 ] || _q "unexpected number of read pairs ($n_pair)"
+
+# each forward read is in its haplotype, and read names
+# record the haplotype, strand and no errors
+#
+paste - - - - - - - - < $odir/sim.fq | head -n 200 | while IFS=$'\t' read name1 seq1 p1 q1 name2 seq2 p2 q2 ; do
+  hap=`echo $name1 | cut -f3 -d:`
+  strand=`echo $name1 | cut -f6 -d:`
+  [ "`echo $name1 | cut -f7,8 -d:`" == "0:0/1" ] || _q "errors in error free read $name1"
+  [ "${name1%/1}" == "${name2%/2}" ] || _q "mate names differ $name1 $name2"
+
+  fwd=$seq1
+  [ "$strand" == "-" ] && fwd=$seq2
+  grep -q "$fwd" $odir/sim.alt$hap || _q "read $name1 not in haplotype $hap"
+done || exit 1
+
+## Separate files, with errors
+##
+./pasta -action simulate-reads -i $odir/sim.inp \
+  -param "coverage=5:p-error=0.01:p-error-end=0.05:fastq1=$odir/sim_1.fq:fastq2=$odir/sim_2.fq" > /dev/null
+[ "`wc -l < $odir/sim_1.fq`" == "`wc -l < $odir/sim_2.fq`" ] || _q "paired files differ in length"
+[ "`awk 'NR%4==2' $odir/sim_1.fq | awk '{ n+=length($0) } END { print (n>0) }'`" == "1" ] || _q "no reads"
+n_err=`awk 'NR%4==1' $odir/sim_1.fq | cut -f7 -d: | awk '{ n+=$1 } END { print n+0 }'`
+[ "$n_err" -gt 0 ] || _q "no errors added"
+
+## Reference mismatch is caught
+##
+./pasta -action ref-rstream -param 'ref-seed=1:n=5000:allele=1' > $odir/sim.bad.ref
+./pasta -action simulate-reads -i $odir/sim.inp -refstream $odir/sim.bad.ref > /dev/null 2>&1 && _q "reference mismatch not caught"
+
+echo ok
+exit 0
@@ -8,6 +8,7 @@ import "runtime/pprof"
 
 import "strconv"
 import "strings"
+import "path/filepath"
 import "time"
 import "bufio"
 
@@ -17,6 +18,10 @@ import "github.com/codegangsta/cli"
 import "github.com/abeconnelly/pasta"
 
 import "github.com/abeconnelly/pasta/gvcf"
+import "github.com/abeconnelly/pasta/gff"
+import "github.com/abeconnelly/pasta/cgivar"
+import "github.com/abeconnelly/pasta/fasta"
+import "github.com/abeconnelly/pasta/fastj"
 
 var VERSION_STR string = "0.2.3"
 var gVerboseFlag bool
@@ -27,45 +32,13 @@ var gProfileFile string = "pasta.pprof"
 var gMemProfileFlag bool
 var gMemProfileFile string = "pasta.mprof"
 
-var gFullRefSeqFlag bool = true
-var gFullNocSeqFlag bool = true
-
 var g_debug bool = false
 
-func echo_stream(stream *bufio.Reader) {
-  var e error
-  var ch byte
-  out := bufio.NewWriter(os.Stdout)
-  for ch,e = stream.ReadByte() ; e==nil ; ch,e = stream.ReadByte() {
-    out.WriteByte(ch)
-  }
-}
-
-// to lower [a-z]
-//
-func _tolch(A byte) byte {
-  z := A
-  if A >= 'A' && A <= 'Z' {
-    z = A - 'A' + 'a'
-  } else {
-    z = A
-  }
-  return z
-}
-
-// to lower [a-z]
-//
-func _tou_ch(a byte) byte {
-  z := a
-  if a >= 'a' && a <= 'z' {
-    z = a - 'a' + 'A'
-  } else {
-    z = a
-  }
-  return z
+func echo_stream(stream *bufio.Reader, out *bufio.Writer) error {
+  _,e := io.Copy(out, stream)
+  return e
 }
 
-
 type VarDiff struct {
   Type      string
   RefStart  int
@@ -132,15 +105,15 @@ func InterleaveStreamToVarDiff(stream *bufio.Reader, N ...int) ([]VarDiff, error
       is_first_pass = false
 
       if !is_ref0 || !is_ref1 {
-        if bp,ok := pasta.RefMap[ch0] ; ok {
+        if bp := pasta.RefMap[ch0] ; bp!=0 {
           refseq = append(refseq, bp)
-        } else if bp, ok := pasta.RefMap[ch1] ; ok {
+        } else if bp := pasta.RefMap[ch1] ; bp!=0 {
           refseq = append(refseq, bp)
         }
-      } else if gFullRefSeqFlag {
-        if bp,ok := pasta.RefMap[ch0] ; ok {
+      } else if pasta.FullRefSeqFlag {
+        if bp := pasta.RefMap[ch0] ; bp!=0 {
           refseq = append(refseq, bp)
-        } else if bp, ok := pasta.RefMap[ch1] ; ok {
+        } else if bp := pasta.RefMap[ch1] ; bp!=0 {
           refseq = append(refseq, bp)
         }
       }
@@ -160,7 +133,7 @@ func InterleaveStreamToVarDiff(stream *bufio.Reader, N ...int) ([]VarDiff, error
 
     if !is_refn_cur && is_refn_prv {
 
-      if gFullRefSeqFlag {
+      if pasta.FullRefSeqFlag {
         vardiff = append(vardiff, VarDiff{"REF", ref_start, ref0_len, string(refseq), []string{"",""}})
       } else {
         vardiff = append(vardiff, VarDiff{"REF", ref_start, ref0_len, "", []string{"",""}})
@@ -196,24 +169,24 @@ func InterleaveStreamToVarDiff(stream *bufio.Reader, N ...int) ([]VarDiff, error
     }
 
     if !is_ref0 || !is_ref1 {
-      if bp,ok := pasta.RefMap[ch0] ; ok {
+      if bp := pasta.RefMap[ch0] ; bp!=0 {
         refseq = append(refseq, bp)
-      } else if bp, ok := pasta.RefMap[ch1] ; ok {
+      } else if bp := pasta.RefMap[ch1] ; bp!=0 {
         refseq = append(refseq, bp)
       }
 
-      if bp_val,ok := pasta.AltMap[ch0] ; ok { alt0 = append(alt0, bp_val) }
-      if bp_val,ok := pasta.AltMap[ch1] ; ok { alt1 = append(alt1, bp_val) }
+      if bp_val := pasta.AltMap[ch0] ; bp_val!=0 { alt0 = append(alt0, bp_val) }
+      if bp_val := pasta.AltMap[ch1] ; bp_val!=0 { alt1 = append(alt1, bp_val) }
 
-    } else if gFullRefSeqFlag {
-      if bp,ok := pasta.RefMap[ch0] ; ok {
+    } else if pasta.FullRefSeqFlag {
+      if bp := pasta.RefMap[ch0] ; bp!=0 {
         refseq = append(refseq, bp)
-      } else if bp, ok := pasta.RefMap[ch1] ; ok {
+      } else if bp := pasta.RefMap[ch1] ; bp!=0 {
         refseq = append(refseq, bp)
       }
 
-      if bp_val,ok := pasta.AltMap[ch0] ; ok { alt0 = append(alt0, bp_val) }
-      if bp_val,ok := pasta.AltMap[ch1] ; ok { alt1 = append(alt1, bp_val) }
+      if bp_val := pasta.AltMap[ch0] ; bp_val!=0 { alt0 = append(alt0, bp_val) }
+      if bp_val := pasta.AltMap[ch1] ; bp_val!=0 { alt1 = append(alt1, bp_val) }
 
     }
 
@@ -227,7 +200,7 @@ func InterleaveStreamToVarDiff(stream *bufio.Reader, N ...int) ([]VarDiff, error
   // Final diff line
   //
   if is_refn_prv {
-    if gFullRefSeqFlag {
+    if pasta.FullRefSeqFlag {
       vardiff = append(vardiff, VarDiff{"REF", ref_start, ref0_len, string(refseq), []string{"",""}})
     } else {
       vardiff = append(vardiff, VarDiff{"REF", ref_start, ref0_len, string(""), []string{"",""}})
@@ -291,7 +264,7 @@ func gvcf_refvar_printer(vartype int, ref_start, ref_len int, refseq []byte, alt
   info := info_if.(*GVCFVarInfo) ; _ = info
 
   if info.PrintHeader {
-    fmt.Printf("%s\n", gvcf_header(info))
+    info.Out.Write( []byte(fmt.Sprintf("%s\n", gvcf_header(info))) )
     info.PrintHeader = false
   }
 
@@ -310,7 +283,7 @@ func gvcf_refvar_printer(vartype int, ref_start, ref_len int, refseq []byte, alt
 
   ref_bp := info.RefBP
 
-  out := os.Stdout
+  out := info.Out
 
   if vartype == pasta.REF {
 
@@ -388,7 +361,7 @@ func simple_vcf_printer(vartype int, ref_start, ref_len int, refseq []byte, alts
 
   info := info_if.(*RefVarInfo)
 
-  out := os.Stdout
+  out := info.Out
 
   if vartype == pasta.REF {
 
@@ -616,977 +589,473 @@ func simple_vcf_printer(vartype int, ref_start, ref_len int, refseq []byte, alts
 
 }
 
-func simple_refvar_printer(vartype int, ref_start, ref_len int, refseq []byte, altseq [][]byte, info_if interface{}) error {
-
-  info := info_if.(*RefVarInfo)
-
-  out := os.Stdout
+func _main_diff_to_rotini(c *cli.Context, out *bufio.Writer) error {
+  infn_slice := c.StringSlice("input")
+  if len(infn_slice)<1 {
+    infn_slice = append(infn_slice, "-")
+  }
 
-  chrom := info.Chrom
+  fp := os.Stdin
+  if infn_slice[0]!="-" {
+    var e error
+    fp,e = os.Open(infn_slice[0])
+    if e!=nil { return e }
+    defer fp.Close()
+  }
 
-  if vartype == pasta.REF {
+  return pasta.DiffToInterleave(fp, out)
+}
 
-    if info.RefSeqFlag {
-      out.Write( []byte(fmt.Sprintf("%s\tref\t%d\t%d\t%s\n", chrom, ref_start, ref_start+ref_len, refseq)) )
-    } else {
-      out.Write( []byte(fmt.Sprintf("%s\tref\t%d\t%d\t.\n", chrom, ref_start, ref_start+ref_len)) )
+// Write a joint, multi-sample, gVCF from several rotini streams.  Sample
+// names are taken from `--sample-names` or the input file names.
+//
+func _main_rotini_to_joint_gvcf(c *cli.Context, infn_slice []string, out *bufio.Writer) error {
+  sample_names := []string{}
+  if len(c.String("sample-names"))>0 {
+    sample_names = strings.Split(c.String("sample-names"), ",")
+    if len(sample_names) != len(infn_slice) {
+      return fmt.Errorf("number of sample names (%d) does not match number of inputs (%d)", len(sample_names), len(infn_slice))
     }
+  } else {
+    for ii:=0; ii<len(infn_slice); ii++ {
+      name := filepath.Base(infn_slice[ii])
+      if idx := strings.Index(name, ".") ; idx>0 { name = name[:idx] }
+      sample_names = append(sample_names, name)
+    }
+  }
 
-  } else if vartype == pasta.NOC {
+  streams := []*bufio.Reader{}
+  for ii:=0; ii<len(infn_slice); ii++ {
+    fp,e := os.Open(infn_slice[ii])
+    if e!=nil { return e }
+    defer fp.Close()
+    streams = append(streams, bufio.NewReader(fp))
+  }
 
-    if info.RefSeqFlag {
+  j := gvcf.GVCFJointRefVar{}
+  j.Init(sample_names)
 
-      if info.NocSeqFlag {
-        out.Write( []byte(fmt.Sprintf("%s\tnoc\t%d\t%d\t%s/%s;%s\n", chrom, ref_start, ref_start+ref_len, altseq[0], altseq[1], refseq)) )
-      } else {
-        out.Write( []byte(fmt.Sprintf("%s\tnca\t%d\t%d\t%s/%s;%s\n", chrom, ref_start, ref_start+ref_len, altseq[0], altseq[1], refseq)) )
-      }
+  return j.Run(streams, out)
+}
 
-    } else {
+// Build a tile library for one tile path from the FastJ of many
+// samples.  The library FastJ goes to `out`, the per sample tile
+// variant vectors to the 'tile-vector' file.
+//
+func _main_fastj_library(c *cli.Context, out *bufio.Writer) error {
+  infn_slice := c.StringSlice("input")
+  if len(infn_slice)==0 { return fmt.Errorf("provide one or more FastJ inputs") }
 
-      if info.NocSeqFlag {
-        out.Write( []byte(fmt.Sprintf("%s\tnoc\t%d\t%d\t%s/%s;.\n", chrom, ref_start, ref_start+ref_len, altseq[0], altseq[1])) )
-      } else {
-        out.Write( []byte(fmt.Sprintf("%s\tnoa\t%d\t%d\t.\n", chrom, ref_start, ref_start+ref_len)) )
-      }
+  sample_names := []string{}
+  if len(c.String("sample-names"))>0 {
+    sample_names = strings.Split(c.String("sample-names"), ",")
+    if len(sample_names) != len(infn_slice) {
+      return fmt.Errorf("number of sample names (%d) does not match number of inputs (%d)", len(sample_names), len(infn_slice))
+    }
+  } else {
+    for ii:=0; ii<len(infn_slice); ii++ {
+      name := filepath.Base(infn_slice[ii])
+      if idx := strings.Index(name, ".") ; idx>0 { name = name[:idx] }
+      sample_names = append(sample_names, name)
     }
+  }
 
-  } else if vartype == pasta.ALT {
+  _tilepath,e := strconv.ParseUint(c.String("tilepath"), 16, 64)
+  if e!=nil { return e }
 
-    out.Write( []byte(fmt.Sprintf("%s\talt\t%d\t%d\t%s/%s;%s\n", chrom, ref_start, ref_start+ref_len, altseq[0], altseq[1], refseq)) )
+  lib := fastj.TileLibraryBuilder{}
+  lib.Init(int(_tilepath), 0)
 
-  } else if vartype == pasta.MSG {
+  for ii:=0; ii<len(infn_slice); ii++ {
+    fp,e := os.Open(infn_slice[ii])
+    if e!=nil { return e }
+    e = lib.AddSample(sample_names[ii], bufio.NewReader(fp))
+    fp.Close()
+    if e!=nil { return e }
+  }
 
-    if info.Msg.Type == pasta.REF {
-      out.Write( []byte(fmt.Sprintf("%s\tref\t%d\t%d\t.(msg)\n", chrom, ref_start, ref_start+info.Msg.N)) )
-    } else if info.Msg.Type == pasta.NOC {
-      out.Write( []byte(fmt.Sprintf("%s\tnoc\t%d\t%d\t.(msg)\n", chrom, ref_start, ref_start+info.Msg.N)) )
-    }
+  lib.AssignVarIds()
+
+  e = lib.WriteFastJ(out)
+  if e!=nil { return e }
 
+  if len(c.String("tile-vector"))>0 {
+    vec_fp,e := os.Create(c.String("tile-vector"))
+    if e!=nil { return e }
+    vec_out := bufio.NewWriter(vec_fp)
+
+    e = lib.WriteVectors(vec_out)
+    if e==nil { e = vec_out.Flush() }
+    if e!=nil { vec_fp.Close() ; return e }
+
+    e = vec_fp.Close()
+    if e!=nil { return e }
   }
 
   return nil
-
 }
 
-// Read from an interleaved stream and print out a simplified variant difference format
-//
-// Each token from the stream should be interleaved and aligned.  Each token can be processed
-// two at a time, where the first token is from the first stream and the second is from
-// the second stream.  The resulting difference format spits out contigs of ref, non-ref and
-// alts where appropriate.
+// FastJ to gVCF without an intermediate rotini file.  The PASTA
+// stream from the tile aligner is fed straight to the gVCF writer,
+// annotated with the IDs of the tiles each record came from
+// (INFO field TILEID).
 //
-// The 'process' callback will be called for every variant line that gets processed.
-//
-func interleave_to_diff(stream *bufio.Reader, process RefVarProcesser) error {
-  alt0 := []byte{}
-  alt1 := []byte{}
-  refseq := []byte{}
+func _fastj_to_gvcf(c *cli.Context, fji *fastj.FastJInfo, fastj_stream, ref_stream, assembly_stream *bufio.Reader, out *bufio.Writer) error {
+  fji.AnnotateTileId = true
 
-  ref_start := 0
-  ref0_len := 0
-  ref1_len := 0
+  pr,pw := io.Pipe()
+  read_err := make(chan error, 1)
 
-  stream0_pos:=0
-  stream1_pos:=0
+  go func() {
+    w := bufio.NewWriter(pw)
+    e := fji.PastaAssembly(fastj_stream, ref_stream, assembly_stream, w)
+    if e==nil { e = w.Flush() }
+    pw.CloseWithError(e)
+    read_err <- e
+  }()
 
-  info := RefVarInfo{}
-  //info := GVCFVarInfo{}
-  info.Type = pasta.BEG
-  info.MessageType = pasta.BEG
-  info.RefSeqFlag = gFullRefSeqFlag
-  info.NocSeqFlag = gFullNocSeqFlag
-  info.Out = os.Stdout
-  info.Chrom = "Unk"
-  //info.PrintHeader = true
-  //info.Reference = "hg19"
+  g := gvcf.GVCFRefVar{}
+  g.Init()
+  g.InfoPassthrough = []string{"TILEID"}
 
-  var bp_anchor_ref byte
-  var bp_anchor_prv byte
+  if len(c.String("format-fields"))>0 {
+    g.FormatPassthrough = strings.Split(c.String("format-fields"), ",")
+  }
 
-  if g_debug { fmt.Printf("%v\n", pasta.RefDelBP) }
+  e := pasta.InterleaveToDiffInterface(bufio.NewReader(pr), &g, out)
 
-  curStreamState := pasta.BEG ; _ = curStreamState
-  prvStreamState := pasta.BEG ; _ = prvStreamState
+  // Unblock the writer if we stopped early, then wait for it
+  // so a FastJ error doesn't leave truncated output behind.
+  //
+  pr.Close()
+  re := <-read_err
 
-  var msg pasta.ControlMessage
-  var prev_msg pasta.ControlMessage
-  var e error
+  if e!=nil { return e }
+  return re
+}
 
-  var ch1 byte
-  var e1 error
+// Parse a comma separated list of integers (e.g. "5,20,60").
+//
+func _parse_int_list(s string) ([]int, error) {
+  res := []int{}
+  parts := strings.Split(s, ",")
+  for ii:=0; ii<len(parts); ii++ {
+    if len(parts[ii])==0 { continue }
+    v,e := strconv.Atoi(strings.TrimSpace(parts[ii]))
+    if e!=nil { return nil, e }
+    res = append(res, v)
+  }
+  return res, nil
+}
 
-  var dbp0 int
-  var dbp1 int
+// Set the gVCF writer options from the command line.  Giving
+// either set of bands turns on the GQ, DP and MIN_DP fields.
+//
+func _gvcf_writer_options(c *cli.Context, g *gvcf.GVCFRefVar) error {
+  var e error
 
+  if len(c.String("gq-bands"))>0 {
+    g.GQBands,e = _parse_int_list(c.String("gq-bands"))
+    if e!=nil { return fmt.Errorf("invalid gq-bands: %v", e) }
+    g.QualityFields = true
+  }
+  if len(c.String("dp-bands"))>0 {
+    g.DPBands,e = _parse_int_list(c.String("dp-bands"))
+    if e!=nil { return fmt.Errorf("invalid dp-bands: %v", e) }
+    g.QualityFields = true
+  }
+  if len(c.String("format-fields"))>0 {
+    g.FormatPassthrough = strings.Split(c.String("format-fields"), ",")
+  }
+  return nil
+}
 
-  for {
-    is_ref0 := false
-    is_ref1 := false
+func _main_gvcf_to_rotini(c *cli.Context, out *bufio.Writer) error {
+  var e error
 
-    is_noc0 := false
-    is_noc1 := false
+  infn_slice := c.StringSlice("input")
+  if len(infn_slice)<1 {
+    infn_slice = append(infn_slice, "-")
+  }
 
-    message_processed_flag := false
+  ain,err := autoio.OpenReadScanner(infn_slice[0])
+  if err!=nil { return err }
+  defer ain.Close()
 
-    ch0,e0 := stream.ReadByte()
-    for (e0==nil) && ((ch0=='\n') || (ch0==' ') || (ch0=='\r') || (ch0=='\t')) {
-      ch0,e0 = stream.ReadByte()
-    }
-    if e0!=nil { break }
-
-    if ch0=='>' {
-      msg,e = pasta.ControlMessageProcess(stream)
-      if e!=nil { return fmt.Errorf(fmt.Sprintf("invalid control message %v (%v)", msg, e)) }
-
-      if (msg.Type == pasta.REF) || (msg.Type == pasta.NOC) {
-        curStreamState = pasta.MSG_REF_NOC
-      } else if msg.Type == pasta.CHROM {
-        curStreamState = pasta.MSG_CHROM
-      } else if msg.Type == pasta.POS {
-        curStreamState = pasta.MSG_POS
-      } else {
-        //just ignore
-        continue
-        //return fmt.Errorf("invalid message type")
-      }
+  fp := os.Stdin
+  if c.String("refstream")!="-" {
+    fp,e = os.Open(c.String("refstream"))
+    if e!=nil { return e }
+    defer fp.Close()
+  }
+  ref_stream := bufio.NewReader(fp)
 
-      message_processed_flag = true
-    }
+  g := gvcf.GVCFRefVar{}
+  g.Init()
+  g.Sample = c.String("sample")
 
-    if !message_processed_flag {
-      ch1,e1 = stream.ReadByte()
-      for (e1==nil) && ((ch1=='\n') || (ch1==' ') || (ch1=='\r') || (ch1=='\t')) {
-        ch1,e1 = stream.ReadByte()
-      }
-      if e1!=nil { break }
+  line_no:=0
+  g.PastaBegin(out)
+  for ain.ReadScan() {
+    gvcf_line := ain.ReadText()
+    line_no++
 
-      stream0_pos++
-      stream1_pos++
+    if len(gvcf_line)==0 || gvcf_line=="" { continue }
+    e:=g.Pasta(gvcf_line, ref_stream, out)
+    if e!=nil { return fmt.Errorf("%v at line %v", e, line_no) }
+  }
+  return g.PastaEnd(out)
+}
 
-      // special case: nop
-      //
-      if ch0=='.' && ch1=='.' { continue }
+// Convert every sample in a multi-sample gVCF (or VCF) to its own rotini
+// stream in one pass.  Each sample's stream is written to a file named
+// after the sample, prefixed by the `--output` option if one was given.
+//
+// Sample names come from the gVCF header, so keep them from
+// naming files outside of the output prefix.
+//
+func _sample_file_name(name string) string {
+  name = strings.Replace(name, "/", "_", -1)
+  name = strings.Replace(name, "..", "__", -1)
+  return name
+}
 
-      dbp0 = pasta.RefDelBP[ch0]
-      dbp1 = pasta.RefDelBP[ch1]
+func _main_gvcf_to_rotini_all_samples(c *cli.Context) error {
+  var e error
 
-      if g_debug {
-        fmt.Printf("\n")
-        fmt.Printf(">>> ch0 %c (%d), ch1 %c (%d), dbp0 +%d, dbp1 +%d, ref0_len %d, ref1_len %d\n", ch0, ch0, ch1, ch1, dbp0, dbp1, ref0_len, ref1_len)
-      }
+  infn_slice := c.StringSlice("input")
+  if len(infn_slice)<1 {
+    infn_slice = append(infn_slice, "-")
+  }
 
-      if ch0=='a' || ch0=='c' || ch0=='g' || ch0=='t' {
-        is_ref0 = true
-      } else if ch0=='n' || ch0=='N' || ch0 == 'A' || ch0 == 'C' || ch0 == 'G' || ch0 == 'T' {
-        is_noc0 = true
-      }
+  ain,err := autoio.OpenReadScanner(infn_slice[0])
+  if err!=nil { return err }
+  defer ain.Close()
 
-      if ch1=='a' || ch1=='c' || ch1=='g' || ch1=='t' {
-        is_ref1 = true
-      } else if ch1=='n' || ch1=='N' || ch1 == 'A' || ch1 == 'C' || ch1 == 'G' || ch1 == 'T' {
-        is_noc1 = true
-      }
+  fp := os.Stdin
+  if c.String("refstream")!="-" {
+    fp,e = os.Open(c.String("refstream"))
+    if e!=nil { return e }
+    defer fp.Close()
+  }
+  ref_stream := bufio.NewReader(fp)
 
-      if is_ref0 && is_ref1 {
-        curStreamState = pasta.REF
-      } else if is_noc0 || is_noc1 {
-        curStreamState = pasta.NOC
-      } else {
-        curStreamState = pasta.ALT
-      }
+  ofn_prefix := c.String("output")
+  if ofn_prefix == "-" { ofn_prefix = "" }
 
-    }
+  m := gvcf.GVCFMultiRefVar{}
+  m.Init()
 
-    if curStreamState == pasta.BEG {
+  out := []*bufio.Writer{}
+  out_fp := []*os.File{}
+  defer func() {
+    for ii:=0; ii<len(out_fp); ii++ { out_fp[ii].Close() }
+  }()
 
-      if !is_ref0 || !is_ref1 {
-        if bp,ok := pasta.RefMap[ch0] ; ok {
-          refseq = append(refseq, bp)
-          bp_anchor_ref = bp
-        } else if bp, ok := pasta.RefMap[ch1] ; ok {
-          refseq = append(refseq, bp)
-          bp_anchor_ref = bp
-        }
-      } else if gFullRefSeqFlag {
-        if bp,ok := pasta.RefMap[ch0] ; ok {
-          refseq = append(refseq, bp)
-          bp_anchor_ref = bp
-        } else if bp, ok := pasta.RefMap[ch1] ; ok {
-          refseq = append(refseq, bp)
-          bp_anchor_ref = bp
-        }
-      }
+  line_no:=0
+  for ain.ReadScan() {
+    gvcf_line := ain.ReadText()
+    line_no++
 
-      ref0_len+=dbp0
-      ref1_len+=dbp1
+    if len(gvcf_line)==0 || gvcf_line=="" { continue }
 
-      if bp_val,ok := pasta.AltMap[ch0] ; ok { alt0 = append(alt0, bp_val) }
-      if bp_val,ok := pasta.AltMap[ch1] ; ok { alt1 = append(alt1, bp_val) }
+    if strings.HasPrefix(gvcf_line, "#CHROM") {
+      e = m.Header(gvcf_line)
+      if e!=nil { return fmt.Errorf("%v at line %v", e, line_no) }
 
-      prvStreamState = curStreamState
-      prev_msg = msg
+      for ii:=0; ii<len(m.SampleNames); ii++ {
+        f,e := os.Create(ofn_prefix + _sample_file_name(m.SampleNames[ii]) + ".rotini")
+        if e!=nil { return e }
+        out_fp = append(out_fp, f)
+        out = append(out, bufio.NewWriter(f))
+      }
 
+      e = m.PastaBegin(out)
+      if e!=nil { return e }
       continue
     }
 
-    if !message_processed_flag {
-      if is_ref0 && is_ref1 && ch0!=ch1 {
-        return fmt.Errorf(fmt.Sprintf("ERROR: stream position (%d,%d), stream0 token %c (%d), stream1 token %c (%d)",
-          stream0_pos, stream1_pos, ch0, ch0, ch1, ch1))
-      }
-    }
+    e = m.Pasta(gvcf_line, ref_stream, out)
+    if e!=nil { return fmt.Errorf("%v at line %v", e, line_no) }
+  }
 
-    if (prvStreamState == pasta.REF) && (curStreamState != pasta.REF) {
+  e = m.PastaEnd(out)
+  if e!=nil { return e }
 
-      info.RefBP = bp_anchor_ref
-      process(prvStreamState, ref_start, ref0_len, refseq, nil, &info)
+  for ii:=0; ii<len(out); ii++ {
+    e = out[ii].Flush()
+    if e!=nil { return e }
+  }
 
-      // Save the last ref BP in case the ALT is an indel.
-      //
-      bp_anchor_prv = '-'
-      if len(refseq)>0 { bp_anchor_prv = refseq[len(refseq)-1] }
+  fps := out_fp
+  out_fp = nil
+  for ii:=0; ii<len(fps); ii++ {
+    e = fps[ii].Close()
+    if e!=nil { return e }
+  }
+  return nil
+}
 
-      ref_start += ref0_len
+func _main_gff_to_pasta(c *cli.Context, out *bufio.Writer) error {
+  var e error
 
-      ref0_len=0
-      ref1_len=0
+  infn_slice := c.StringSlice("input")
+  if len(infn_slice)<1 {
+    infn_slice = append(infn_slice, "-")
+  }
 
-      alt0 = alt0[0:0]
-      alt1 = alt1[0:0]
-      refseq = refseq[0:0]
+  ain,err := autoio.OpenReadScanner(infn_slice[0])
+  if err!=nil { return err }
+  defer ain.Close()
 
-    } else if (prvStreamState == pasta.NOC) && (curStreamState != pasta.NOC) {
+  fp := os.Stdin
+  if c.String("refstream")!="-" {
+    fp,e = os.Open(c.String("refstream"))
+    if e!=nil { return e }
+    defer fp.Close()
+  }
+  ref_stream := bufio.NewReader(fp)
 
-      full_noc_flag := gFullNocSeqFlag
-      for ii:=0; ii<len(alt0); ii++ { if alt0[ii]!='n' { full_noc_flag = true ; break; } }
-      if full_noc_flag { for ii:=0; ii<len(alt1); ii++ { if alt1[ii]!='n' { full_noc_flag = true ; break; } } }
+  gff := gff.GFFRefVar{}
+  gff.Init()
+  gff.Allele=1
 
-      a0 := string(alt0)
-      if len(a0) == 0 { a0 = "-" }
+  if len(c.String("chrom"))>0 {
+    gff.Chrom(c.String("chrom"))
+  }
 
-      a1 := string(alt1)
-      if len(a1) == 0 { a1 = "-" }
+  if c.Int("start") > 0 {
+    gff.RefPos = c.Int("start")
+    gff.PrevRefPos = gff.RefPos
+  }
 
-      r := string(refseq)
-      if len(r) == 0 { r = "-" }
+  line_no:=0
+  gff.PastaBegin(out)
+  for ain.ReadScan() {
+    gff_line := ain.ReadText()
+    line_no++
 
+    if len(gff_line)==0 || gff_line=="" { continue }
+    e:=gff.Pasta(gff_line, ref_stream, out)
+    //if e == io.EOF { break }
+    if (e!=io.EOF) && (e!=nil) { return fmt.Errorf("%v at line %v", e, line_no) }
+  }
 
+  e=gff.PastaRefEnd(ref_stream, out)
 
-      info.RefBP = bp_anchor_ref
-      info.NocSeqFlag = full_noc_flag
-      process(prvStreamState, ref_start, ref0_len, []byte(r), [][]byte{[]byte(a0), []byte(a1)}, &info)
+  if (e!=io.EOF) && (e!=nil) {
+    return fmt.Errorf("GFF PastaRefEnd: %v at line %v", e, line_no)
+  }
 
-      // Save the last ref BP in case the ALT is an indel.
-      //
-      bp_anchor_prv = '-'
-      if len(refseq)>0 { bp_anchor_prv = refseq[len(refseq)-1] }
+  return gff.PastaEnd(out)
+}
 
-      ref_start += ref0_len
+func _main_gff_to_rotini(c *cli.Context, out *bufio.Writer) error {
+  var e error
 
-      ref0_len=0
-      ref1_len=0
+  infn_slice := c.StringSlice("input")
+  if len(infn_slice)<1 {
+    infn_slice = append(infn_slice, "-")
+  }
 
-      alt0 = alt0[0:0]
-      alt1 = alt1[0:0]
-      refseq = refseq[0:0]
+  ain,err := autoio.OpenReadScanner(infn_slice[0])
+  if err!=nil { return err }
+  defer ain.Close()
 
-    } else if (prvStreamState == pasta.ALT) && ((curStreamState == pasta.REF) || (curStreamState == pasta.NOC)) {
+  fp := os.Stdin
+  if c.String("refstream")!="-" {
+    fp,e = os.Open(c.String("refstream"))
+    if e!=nil { return e }
+    defer fp.Close()
+  }
+  ref_stream := bufio.NewReader(fp)
 
-      a0 := string(alt0)
-      if len(a0) == 0 { a0 = "-" }
+  gff := gff.GFFRefVar{}
+  gff.Init()
 
-      a1 := string(alt1)
-      if len(a1) == 0 { a1 = "-" }
+  if len(c.String("chrom"))>0 {
+    gff.Chrom(c.String("chrom"))
+  }
 
-      r := string(refseq)
-      if len(r) == 0 { r = "-" }
+  if c.Int("start") > 0 {
+    gff.RefPos = c.Int("start")
+    gff.PrevRefPos = gff.RefPos
+  }
 
-      info.RefBP = bp_anchor_prv
-      process(prvStreamState, ref_start, ref0_len, []byte(r), [][]byte{[]byte(a0), []byte(a1)}, &info)
+  line_no:=0
+  gff.PastaBegin(out)
+  for ain.ReadScan() {
+    gff_line := ain.ReadText()
+    line_no++
 
-      ref_start += ref0_len
+    if len(gff_line)==0 || gff_line=="" { continue }
+    e:=gff.Pasta(gff_line, ref_stream, out)
+    //if e == io.EOF { break }
+    if (e!=io.EOF) && (e!=nil) { return fmt.Errorf("%v at line %v", e, line_no) }
+  }
 
-      ref0_len=0
-      ref1_len=0
+  e=gff.PastaRefEnd(ref_stream, out)
 
-      alt0 = alt0[0:0]
-      alt1 = alt1[0:0]
-      refseq = refseq[0:0]
+  if (e!=io.EOF) && (e!=nil) {
+    return fmt.Errorf("GFF PastaRefEnd: %v at line %v", e, line_no)
+  }
 
-    } else if prvStreamState == pasta.MSG_REF_NOC {
+  return gff.PastaEnd(out)
+}
 
-      info.Msg = prev_msg
-      info.RefBP = bp_anchor_ref
-      process(prvStreamState, ref_start, prev_msg.N, refseq, nil, &info)
+func _main_gvf_to_rotini(c *cli.Context, out *bufio.Writer) error {
+  var e error
 
-      ref_start += prev_msg.N
+  infn_slice := c.StringSlice("input")
+  if len(infn_slice)<1 {
+    infn_slice = append(infn_slice, "-")
+  }
 
-      stream0_pos += prev_msg.N
-      stream1_pos += prev_msg.N
-
-      ref0_len=0
-      ref1_len=0
-      alt0 = alt0[0:0]
-      alt1 = alt1[0:0]
-      refseq = refseq[0:0]
-
-    } else if prvStreamState == pasta.MSG_CHROM {
-      info.Chrom = prev_msg.Chrom
-    } else if prvStreamState == pasta.MSG_POS {
-      ref_start = prev_msg.RefPos
-    } else {
-      // The current state matches the previous state.
-      // Either both the current tokens are non-ref as well as the previous tokens
-      // or both the current token and previous tokens are ref.
-    }
-
-    if !message_processed_flag {
-      if bp_val,ok := pasta.AltMap[ch0] ; ok { alt0 = append(alt0, bp_val) }
-      if bp_val,ok := pasta.AltMap[ch1] ; ok { alt1 = append(alt1, bp_val) }
-
-      if !is_ref0 || !is_ref1 {
-
-        if bp,ok := pasta.RefMap[ch0] ; ok {
-          refseq = append(refseq, bp)
-          if ref0_len==0 { bp_anchor_ref = bp }
-        } else if bp, ok := pasta.RefMap[ch1] ; ok {
-          refseq = append(refseq, bp)
-          if ref0_len==0 { bp_anchor_ref = bp }
-        }
-      } else if gFullRefSeqFlag {
-
-        if bp,ok := pasta.RefMap[ch0] ; ok {
-          refseq = append(refseq, bp)
-          if ref0_len==0 { bp_anchor_ref = bp }
-        } else if bp, ok := pasta.RefMap[ch1] ; ok {
-          refseq = append(refseq, bp)
-          if ref0_len==0 { bp_anchor_ref = bp }
-        }
-      } else if ref0_len==0 {
-
-        if bp,ok := pasta.RefMap[ch0] ; ok {
-          if ref0_len==0 { bp_anchor_ref = bp }
-        } else if bp, ok := pasta.RefMap[ch1] ; ok {
-          if ref0_len==0 { bp_anchor_ref = bp }
-        }
-      }
-
-      ref0_len+=dbp0
-      ref1_len+=dbp1
-
-    }
-
-    prvStreamState = curStreamState
-    prev_msg = msg
-
-  }
-
-  if prvStreamState == pasta.REF {
-
-    info.RefBP = bp_anchor_ref
-    process(prvStreamState, ref_start, ref0_len, refseq, [][]byte{alt0, alt1}, &info)
-
-  } else if prvStreamState == pasta.NOC {
-
-    full_noc_flag := gFullNocSeqFlag
-    for ii:=0; ii<len(alt0); ii++ { if alt0[ii]!='n' { full_noc_flag = true ; break; } }
-    if full_noc_flag { for ii:=0; ii<len(alt1); ii++ { if alt1[ii]!='n' { full_noc_flag = true ; break; } } }
-
-    info.NocSeqFlag = full_noc_flag
-    info.RefBP = bp_anchor_ref
-    process(prvStreamState, ref_start, ref0_len, refseq, [][]byte{alt0, alt1}, &info)
-
-  } else if prvStreamState == pasta.ALT {
-
-    a0 := string(alt0)
-    if len(a0) == 0 { a0 = "-" }
-
-    a1 := string(alt1)
-    if len(a1) == 0 { a1 = "-" }
-
-    r := string(refseq)
-    if len(r) == 0 { r = "-" }
-
-    process(prvStreamState, ref_start, ref0_len, []byte(r), [][]byte{[]byte(a0), []byte(a1)}, &info)
-
-  } else if prvStreamState == pasta.MSG_REF_NOC {
-
-    info.Msg = prev_msg
-    info.RefBP = bp_anchor_ref
-    process(prvStreamState, ref_start, prev_msg.N, nil, nil, &info)
-
-  } else if prvStreamState == pasta.MSG_CHROM {
-    info.Chrom = prev_msg.Chrom
-  }
-
-  return nil
-}
-
-func pasta_to_haploid(stream *bufio.Reader, ind int) error {
-  var msg pasta.ControlMessage
-  var e error
-  var stream0_pos int
-  var dbp0 int ; _ = dbp0
-  var curStreamState int ; _ = curStreamState
-
-  out := bufio.NewWriter(os.Stdout)
-
-  bp_count:=0
-  lfmod := 50
-
-  for {
-    message_processed_flag := false
-
-    ch0,e0 := stream.ReadByte()
-    for (e0==nil) && ((ch0=='\n') || (ch0==' ') || (ch0=='\r') || (ch0=='\t')) {
-      ch0,e0 = stream.ReadByte()
-    }
-    if e0!=nil { break }
-
-    if ch0=='>' {
-      msg,e = pasta.ControlMessageProcess(stream)
-      if e!=nil { return fmt.Errorf("invalid control message") }
-
-      if (msg.Type == pasta.REF) || (msg.Type == pasta.NOC) {
-        curStreamState = pasta.MSG
-      } else {
-        //ignore
-        continue
-      }
-
-      message_processed_flag = true
-      continue
-    }
-
-    if !message_processed_flag {
-
-      stream0_pos++
-
-      // special case: nop
-      //
-      if ch0=='.' { continue }
-
-      is_del := false ; _ = is_del
-      is_ins := false ; _ = is_ins
-      is_ref := false ; _ = is_ref
-      is_noc := false ; _ = is_noc
-
-      if ch0=='!' || ch0=='$' || ch0=='7' || ch0=='E' || ch0=='z' {
-        is_del = true
-      } else if ch0=='Q' || ch0=='S' || ch0=='W' || ch0=='d' || ch0=='Z' {
-        is_ins = true
-      } else if ch0=='a' || ch0=='c' || ch0=='g' || ch0=='t' {
-        is_ref = true
-      } else if ch0=='n' || ch0=='N' || ch0 == 'A' || ch0 == 'C' || ch0 == 'G' || ch0 == 'T' {
-        is_noc = true
-      }
-
-      dbp0 = pasta.RefDelBP[ch0]
-
-      if ind==-1 {
-
-        // ref
-
-        if is_ins { continue }
-        if ch0 != '.' {
-          out.WriteByte(pasta.RefMap[ch0])
-        }
-
-        bp_count++
-        if (lfmod>0) && ((bp_count%lfmod)==0) { out.WriteByte('\n') }
-
-      } else if ind==0 {
-
-        // alt0
-
-        if ch0=='.' { continue }
-        if pasta.IsAltDel[ch0] { continue }
-
-        out.WriteByte(pasta.AltMap[ch0])
-        bp_count++
-        if (lfmod>0) && ((bp_count%lfmod)==0) { out.WriteByte('\n') }
-
-      }
-
-    }
-
-  }
-
-  out.WriteByte('\n')
-  out.Flush()
-
-  return nil
-}
-
-
-func interleave_to_haploid(stream *bufio.Reader, ind int) error {
-  var msg pasta.ControlMessage
-  var e error
-  var stream0_pos, stream1_pos int
-  var dbp0,dbp1 int ; _,_ = dbp0,dbp1
-  var curStreamState int ; _ = curStreamState
-
-  out := bufio.NewWriter(os.Stdout)
-
-  bp_count:=0
-  lfmod := 50
-
-  for {
-    message_processed_flag := false
-
-    var ch1 byte
-    var e1 error
-
-    ch0,e0 := stream.ReadByte()
-    for (e0==nil) && ((ch0=='\n') || (ch0==' ') || (ch0=='\r') || (ch0=='\t')) {
-      ch0,e0 = stream.ReadByte()
-    }
-    if e0!=nil { break }
-
-    if ch0=='>' {
-      msg,e = pasta.ControlMessageProcess(stream)
-      if e!=nil { return fmt.Errorf("invalid control message") }
-
-      if (msg.Type == pasta.REF) || (msg.Type == pasta.NOC) {
-        curStreamState = pasta.MSG
-      } else {
-        //ignore
-        continue
-        //return fmt.Errorf("invalid message type")
-      }
-
-      message_processed_flag = true
-      continue
-    }
-
-    if !message_processed_flag {
-      ch1,e1 = stream.ReadByte()
-      for (e1==nil) && ((ch1=='\n') || (ch1==' ') || (ch1=='\r') || (ch1=='\t')) {
-        ch1,e1 = stream.ReadByte()
-      }
-      if e1!=nil { break }
-
-      stream0_pos++
-      stream1_pos++
-
-      // special case: nop
-      //
-      if ch0=='.' && ch1=='.' { continue }
-
-      dbp0 = pasta.RefDelBP[ch0]
-      dbp1 = pasta.RefDelBP[ch1]
-
-      anch_bp := ch0
-      if anch_bp == '.' { anch_bp = ch1 }
-
-      is_del := []bool{false,false}
-      is_ins := []bool{false,false}
-      is_ref := []bool{false,false} ; _ = is_ref
-      is_noc := []bool{false,false} ; _ = is_noc
-
-      if ch0=='!' || ch0=='$' || ch0=='7' || ch0=='E' || ch0=='z' {
-        is_del[0] = true
-      } else if ch0=='Q' || ch0=='S' || ch0=='W' || ch0=='d' || ch0=='Z' {
-        is_ins[0] = true
-      } else if ch0=='a' || ch0=='c' || ch0=='g' || ch0=='t' {
-        is_ref[0] = true
-      } else if ch0=='n' || ch0=='N' || ch0 == 'A' || ch0 == 'C' || ch0 == 'G' || ch0 == 'T' {
-        is_noc[0] = true
-      }
-
-      if ch1=='!' || ch1=='$' || ch1=='7' || ch1=='E' || ch1=='z' {
-        is_del[1] = true
-      } else if ch1=='Q' || ch1=='S' || ch1=='W' || ch1=='d' || ch1=='Z' {
-        is_ins[1] = true
-      } else if ch1=='a' || ch1=='c' || ch1=='g' || ch1=='t' {
-        is_ref[1] = true
-      } else if ch1=='n' || ch1=='N' || ch1 == 'A' || ch1 == 'C' || ch1 == 'G' || ch1 == 'T' {
-        is_noc[1] = true
-      }
-
-      if (is_ins[0] && (!is_ins[1] && ch1!='.')) ||
-         (is_ins[1] && (!is_ins[0] && ch0!='.')) {
-        out.Flush()
-        return fmt.Errorf( fmt.Sprintf("interleave_to_haploid: insertion mismatch (ch %c,%c ord(%v,%v) @ %v)", ch0, ch1, ch0, ch1, bp_count) )
-      }
-
-      if ind==-1 {
-
-        // ref
-
-        if is_ins[0] || is_ins[1] { continue }
-        if ch0 != '.' {
-
-          och,ok := pasta.RefMap[ch0]
-          if !ok { return fmt.Errorf("interleave_to_haploid: no character found in stream0 RefMap for %c ord(%d) @ %d", ch0, ch0, bp_count) }
-          out.WriteByte(och)
-        } else {
-
-          och,ok := pasta.RefMap[ch1]
-          if !ok { return fmt.Errorf("interleave_to_haploid: no character found in stream1 RefMap for %c ord(%d) @ %d", ch1, ch1, bp_count) }
-          out.WriteByte(och)
-        }
-
-        bp_count++
-        if (lfmod>0) && ((bp_count%lfmod)==0) { out.WriteByte('\n') }
-
-      } else if ind==0 {
-
-        // alt0
-
-        if ch0=='.' { continue }
-        if pasta.IsAltDel[ch0] { continue }
-
-        och,ok := pasta.AltMap[ch0]
-        if !ok { return fmt.Errorf("interleave_to_haploid: no character found in stream0 AltMap for %c ord(%d) @ %d", ch0, ch0, bp_count) }
-        out.WriteByte(och)
-
-        bp_count++
-        if (lfmod>0) && ((bp_count%lfmod)==0) { out.WriteByte('\n') }
-
-      } else if ind==1 {
-
-        // alt1
-
-        if ch1=='.' { continue }
-        if pasta.IsAltDel[ch1] { continue }
-
-        och,ok := pasta.AltMap[ch1]
-        if !ok { return fmt.Errorf("interleave_to_haploid: no character found in stream0 AltMap for %c ord(%d) @ %d", ch1, ch1, bp_count) }
-
-        out.WriteByte(och)
-
-        bp_count++
-        if (lfmod>0) && ((bp_count%lfmod)==0) { out.WriteByte('\n') }
-
-      }
-
-    }
-
-
-  }
-
-  out.WriteByte('\n')
-  out.Flush()
-
-  return nil
-
-}
-
-
-func diff_to_interleave(ain *autoio.AutoioHandle) {
-
-  n_allele := 2
-  lfmod := 50
-  bp_count := 0
-
-  chrom := ""
-  pos := -1
-
-  first_pass := true
-
-  for ain.ReadScan() {
-    l := ain.ReadText()
-
-    if len(l)==0 || l=="" { continue }
-
-    diff_parts := strings.Split(l, "\t")
-
-    chrom_s := diff_parts[0]
-    type_s := diff_parts[1]
-    st_s := diff_parts[2] ; _ = st_s
-    en_s := diff_parts[3] ; _ = en_s
-    field := diff_parts[4]
-
-    control_message := false
-
-    if chrom != chrom_s {
-
-      if !first_pass && !control_message { fmt.Printf("\n") }
-
-      fmt.Printf(">C{%s}", chrom_s)
-      chrom = chrom_s
-
-      control_message = true
-    }
-
-    _st,e := strconv.ParseUint(st_s, 10, 64)
-    if e==nil {
-
-      if pos != int(_st) {
-        if !first_pass && !control_message { fmt.Printf("\n") }
-        fmt.Printf(">P{%d}", _st)
-        pos = int(_st)
-
-        control_message = true
-      }
-
-    }
-
-    if control_message { fmt.Printf("\n") }
-    first_pass = false
-
-    if type_s == "ref" {
-
-      for i:=0; i<len(field); i++ {
-        for a:=0; a<n_allele; a++ {
-          fmt.Printf("%c", field[i])
-
-          bp_count++
-          if (lfmod>0) && ((bp_count%lfmod)==0) {
-            fmt.Printf("\n")
-          }
-        }
-      }
-
-      pos += len(field)
-
-    } else if type_s == "alt" || type_s == "nca"  || type_s == "noc" {
-
-      field_parts := strings.Split(field, ";")
-      alt_parts := strings.Split(field_parts[0], "/")
-      if len(alt_parts)==1 { alt_parts = append(alt_parts, alt_parts[0]) }
-      refseq := field_parts[1]
-
-      mM := len(alt_parts[0])
-      if len(alt_parts[1]) > mM { mM = len(alt_parts[1]) }
-      if len(refseq) > mM { mM = len(refseq) }
-
-      for i:=0; i<mM; i++  {
-
-        for a:=0; a<len(alt_parts); a++ {
-
-          if i<len(alt_parts[a]) {
-            if i<len(refseq) {
-              fmt.Printf("%c", pasta.SubMap[refseq[i]][alt_parts[a][i]])
-            } else {
-              fmt.Printf("%c", pasta.InsMap[alt_parts[a][i]])
-            }
-          } else if i<len(refseq) {
-            fmt.Printf("%c", pasta.DelMap[refseq[i]])
-          } else {
-            fmt.Printf(".")
-          }
-
-          bp_count++
-          if (lfmod>0) && ((bp_count%lfmod)==0) {
-            fmt.Printf("\n")
-          }
-
-        }
-
-      }
-
-      if refseq != "-" {
-        pos += len(refseq)
-      }
-
-    }
-
-  }
-
-  fmt.Printf("\n")
-
-}
-
-func _main_diff_to_rotini( c *cli.Context ) {
-  infn_slice := c.StringSlice("input")
-  if len(infn_slice)<1 {
-    infn_slice = append(infn_slice, "-")
-  }
-
-  ain,err := autoio.OpenReadScanner(infn_slice[0])
-  if err!=nil {
-    fmt.Fprintf(os.Stderr, "%v", err)
-    os.Stderr.Sync()
-    os.Exit(1)
-  }
-  defer ain.Close()
-
-  diff_to_interleave(&ain)
-
-}
-
-func _main_gvcf_to_rotini(c *cli.Context) {
-  var e error
-
-  infn_slice := c.StringSlice("input")
-  if len(infn_slice)<1 {
-    infn_slice = append(infn_slice, "-")
-  }
-
-  ain,err := autoio.OpenReadScanner(infn_slice[0])
-  if err!=nil {
-    fmt.Fprintf(os.Stderr, "%v", err)
-    os.Stderr.Sync()
-    os.Exit(1)
-  }
-  defer ain.Close()
-
-  fp := os.Stdin
-  if c.String("refstream")!="-" {
-    fp,e = os.Open(c.String("refstream"))
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "%v", e)
-      os.Stderr.Sync()
-      os.Exit(1)
-    }
-    defer fp.Close()
-  }
-  ref_stream := bufio.NewReader(fp)
-
-  out := bufio.NewWriter(os.Stdout)
-
-  g := gvcf.GVCFRefVar{}
-  g.Init()
-
-  line_no:=0
-  g.PastaBegin(out)
-  for ain.ReadScan() {
-    gvcf_line := ain.ReadText()
-    line_no++
-
-    if len(gvcf_line)==0 || gvcf_line=="" { continue }
-    e:=g.Pasta(gvcf_line, ref_stream, out)
-    if e!=nil { fmt.Fprintf(os.Stderr, "ERROR: %v at line %v\n", e, line_no); return }
-  }
-  g.PastaEnd(out)
-
-  out.Flush()
-
-}
-
-func _main_gff_to_pasta(c *cli.Context) {
-  var e error
-
-  infn_slice := c.StringSlice("input")
-  if len(infn_slice)<1 {
-    infn_slice = append(infn_slice, "-")
-  }
-
-  ain,err := autoio.OpenReadScanner(infn_slice[0])
-  if err!=nil {
-    fmt.Fprintf(os.Stderr, "%v", err)
-    os.Stderr.Sync()
-    os.Exit(1)
-  }
-  defer ain.Close()
+  ain,err := autoio.OpenReadScanner(infn_slice[0])
+  if err!=nil { return err }
+  defer ain.Close()
 
   fp := os.Stdin
   if c.String("refstream")!="-" {
     fp,e = os.Open(c.String("refstream"))
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "%v", e)
-      os.Stderr.Sync()
-      os.Exit(1)
-    }
+    if e!=nil { return e }
     defer fp.Close()
   }
   ref_stream := bufio.NewReader(fp)
 
-  out := bufio.NewWriter(os.Stdout)
-
-  gff := GFFRefVar{}
-  gff.Init()
-  gff.Allele=1
+  gvf := gff.GVFRefVar{}
+  gvf.Init()
 
   if len(c.String("chrom"))>0 {
-    gff.Chrom(c.String("chrom"))
+    gvf.Chrom(c.String("chrom"))
   }
 
   if c.Int("start") > 0 {
-    gff.RefPos = c.Int("start")
-    gff.PrevRefPos = gff.RefPos
+    gvf.RefPos = c.Int("start")
+    gvf.PrevRefPos = gvf.RefPos
   }
 
   line_no:=0
-  gff.PastaBegin(out)
+  gvf.PastaBegin(out)
   for ain.ReadScan() {
-    gff_line := ain.ReadText()
+    gvf_line := ain.ReadText()
     line_no++
 
-    if len(gff_line)==0 || gff_line=="" { continue }
-    e:=gff.Pasta(gff_line, ref_stream, out)
-    //if e == io.EOF { break }
-    if (e!=io.EOF) && (e!=nil) { fmt.Fprintf(os.Stderr, "ERROR: %v at line %v\n", e, line_no); return }
-  }
-
-  e=gff.PastaRefEnd(ref_stream, out)
-
-  if (e!=io.EOF) && (e!=nil) {
-    fmt.Fprintf(os.Stderr, "ERROR: GFF PastaRefEnd: %v at line %v\n", e, line_no)
-    return
-  }
-
-  gff.PastaEnd(out)
-}
-
-func _main_gff_to_rotini(c *cli.Context) {
-  var e error
-
-  infn_slice := c.StringSlice("input")
-  if len(infn_slice)<1 {
-    infn_slice = append(infn_slice, "-")
+    if len(gvf_line)==0 || gvf_line=="" { continue }
+    e:=gvf.Pasta(gvf_line, ref_stream, out)
+    if (e!=io.EOF) && (e!=nil) { return fmt.Errorf("%v at line %v", e, line_no) }
   }
 
-  ain,err := autoio.OpenReadScanner(infn_slice[0])
-  if err!=nil {
-    fmt.Fprintf(os.Stderr, "%v", err)
-    os.Stderr.Sync()
-    os.Exit(1)
-  }
-  defer ain.Close()
-
-  fp := os.Stdin
-  if c.String("refstream")!="-" {
-    fp,e = os.Open(c.String("refstream"))
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "%v", e)
-      os.Stderr.Sync()
-      os.Exit(1)
-    }
-    defer fp.Close()
-  }
-  ref_stream := bufio.NewReader(fp)
-
-  out := bufio.NewWriter(os.Stdout)
-
-  gff := GFFRefVar{}
-  gff.Init()
-
-  if len(c.String("chrom"))>0 {
-    gff.Chrom(c.String("chrom"))
-  }
-
-  if c.Int("start") > 0 {
-    gff.RefPos = c.Int("start")
-    gff.PrevRefPos = gff.RefPos
-  }
-
-  line_no:=0
-  gff.PastaBegin(out)
-  for ain.ReadScan() {
-    gff_line := ain.ReadText()
-    line_no++
-
-    if len(gff_line)==0 || gff_line=="" { continue }
-    e:=gff.Pasta(gff_line, ref_stream, out)
-    //if e == io.EOF { break }
-    if (e!=io.EOF) && (e!=nil) { fmt.Fprintf(os.Stderr, "ERROR: %v at line %v\n", e, line_no); return }
-  }
-
-  e=gff.PastaRefEnd(ref_stream, out)
+  e=gvf.PastaRefEnd(ref_stream, out)
 
   if (e!=io.EOF) && (e!=nil) {
-    fmt.Fprintf(os.Stderr, "ERROR: GFF PastaRefEnd: %v at line %v\n", e, line_no)
-    return
+    return fmt.Errorf("GVF PastaRefEnd: %v at line %v", e, line_no)
   }
 
-  gff.PastaEnd(out)
+  return gvf.PastaEnd(out)
 }
 
-func _main_cgivar_to_rotini(c *cli.Context) {
+func _main_cgivar_to_rotini(c *cli.Context, out *bufio.Writer) error {
   var e error
 
   infn_slice := c.StringSlice("input")
@@ -1595,28 +1064,18 @@ func _main_cgivar_to_rotini(c *cli.Context) {
   }
 
   ain,err := autoio.OpenReadScanner(infn_slice[0])
-  if err!=nil {
-    fmt.Fprintf(os.Stderr, "%v", err)
-    os.Stderr.Sync()
-    os.Exit(1)
-  }
+  if err!=nil { return err }
   defer ain.Close()
 
   fp := os.Stdin
   if c.String("refstream")!="-" {
     fp,e = os.Open(c.String("refstream"))
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "%v", e)
-      os.Stderr.Sync()
-      os.Exit(1)
-    }
+    if e!=nil { return e }
     defer fp.Close()
   }
   ref_stream := bufio.NewReader(fp)
 
-  out := bufio.NewWriter(os.Stdout)
-
-  cgivar := CGIRefVar{}
+  cgivar := cgivar.CGIRefVar{}
   cgivar.Init()
 
   line_no:=0
@@ -1627,14 +1086,13 @@ func _main_cgivar_to_rotini(c *cli.Context) {
 
     if len(cgivar_line)==0 || cgivar_line=="" { continue }
     e:=cgivar.Pasta(cgivar_line, ref_stream, out)
-    if e!=nil { fmt.Fprintf(os.Stderr, "ERROR: %v at line %v\n", e, line_no); return }
+    if e!=nil { return fmt.Errorf("%v at line %v", e, line_no) }
   }
-  cgivar.PastaEnd(out)
-
+  return cgivar.PastaEnd(out)
 }
 
 
-func _main_cgivar_to_pasta(c *cli.Context) {
+func _main_mastervar_to_rotini(c *cli.Context, out *bufio.Writer) error {
   var e error
 
   infn_slice := c.StringSlice("input")
@@ -1643,28 +1101,54 @@ func _main_cgivar_to_pasta(c *cli.Context) {
   }
 
   ain,err := autoio.OpenReadScanner(infn_slice[0])
-  if err!=nil {
-    fmt.Fprintf(os.Stderr, "%v", err)
-    os.Stderr.Sync()
-    os.Exit(1)
-  }
+  if err!=nil { return err }
   defer ain.Close()
 
   fp := os.Stdin
   if c.String("refstream")!="-" {
     fp,e = os.Open(c.String("refstream"))
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "%v", e)
-      os.Stderr.Sync()
-      os.Exit(1)
-    }
+    if e!=nil { return e }
     defer fp.Close()
   }
   ref_stream := bufio.NewReader(fp)
 
-  out := bufio.NewWriter(os.Stdout)
+  mastervar := cgivar.MasterVarRefVar{}
+  mastervar.Init()
+
+  line_no:=0
+  mastervar.PastaBegin(out)
+  for ain.ReadScan() {
+    mastervar_line := ain.ReadText()
+    line_no++
+
+    if len(mastervar_line)==0 { continue }
+    e:=mastervar.Pasta(mastervar_line, ref_stream, out)
+    if e!=nil { return fmt.Errorf("%v at line %v", e, line_no) }
+  }
+  return mastervar.PastaEnd(out)
+}
+
+func _main_cgivar_to_pasta(c *cli.Context, out *bufio.Writer) error {
+  var e error
+
+  infn_slice := c.StringSlice("input")
+  if len(infn_slice)<1 {
+    infn_slice = append(infn_slice, "-")
+  }
+
+  ain,err := autoio.OpenReadScanner(infn_slice[0])
+  if err!=nil { return err }
+  defer ain.Close()
+
+  fp := os.Stdin
+  if c.String("refstream")!="-" {
+    fp,e = os.Open(c.String("refstream"))
+    if e!=nil { return e }
+    defer fp.Close()
+  }
+  ref_stream := bufio.NewReader(fp)
 
-  cgivar := CGIRefVar{}
+  cgivar := cgivar.CGIRefVar{}
   cgivar.Init()
   cgivar.Ploidy=1
 
@@ -1676,13 +1160,12 @@ func _main_cgivar_to_pasta(c *cli.Context) {
 
     if len(cgivar_line)==0 || cgivar_line=="" { continue }
     e:=cgivar.Pasta(cgivar_line, ref_stream, out)
-    if e!=nil { fmt.Fprintf(os.Stderr, "ERROR: %v at line %v\n", e, line_no); return }
+    if e!=nil { return fmt.Errorf("%v at line %v", e, line_no) }
   }
-  cgivar.PastaEnd(out)
-
+  return cgivar.PastaEnd(out)
 }
 
-func _main_fasta_to_pasta(c *cli.Context) {
+func _main_fasta_to_pasta(c *cli.Context, out *bufio.Writer) error {
 
   var e error
 
@@ -1692,28 +1175,18 @@ func _main_fasta_to_pasta(c *cli.Context) {
   }
 
   ain,err := autoio.OpenReadScanner(infn_slice[0])
-  if err!=nil {
-    fmt.Fprintf(os.Stderr, "%v", err)
-    os.Stderr.Sync()
-    os.Exit(1)
-  }
+  if err!=nil { return err }
   defer ain.Close()
 
   fp := os.Stdin
   if c.String("refstream")!="-" {
     fp,e = os.Open(c.String("refstream"))
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "%v", e)
-      os.Stderr.Sync()
-      os.Exit(1)
-    }
+    if e!=nil { return e }
     defer fp.Close()
   }
   ref_stream := bufio.NewReader(fp)
 
-  out := bufio.NewWriter(os.Stdout)
-
-  fi := FASTAInfo{}
+  fi := fasta.FASTAInfo{}
   fi.Init()
   fi.Allele=0
 
@@ -1725,13 +1198,77 @@ func _main_fasta_to_pasta(c *cli.Context) {
 
     if len(fasta_line)==0 || fasta_line=="" { continue }
     e:=fi.Pasta(fasta_line, ref_stream, out)
-    if e!=nil { fmt.Fprintf(os.Stderr, "ERROR: %v at line %v\n", e, line_no); return }
+    if e!=nil { return fmt.Errorf("%v at line %v", e, line_no) }
   }
-  fi.PastaEnd(out)
+  return fi.PastaEnd(out)
+}
 
 
-}
+// Convert between any two registered formats (see pasta.FormatNames),
+// reading straight into the writer without an intermediate rotini
+// file.  Either side can be "rotini".
+//
+func _main_convert(c *cli.Context, out *bufio.Writer) error {
+  var e error
+
+  from := c.String("from")
+  to := c.String("to")
+  if len(from)==0 || len(to)==0 {
+    return fmt.Errorf("convert needs --from and --to (rotini, %s)", strings.Join(pasta.FormatNames(), ", "))
+  }
+
+  var rd pasta.VariantReader
+  var wr pasta.VariantWriter
+  var ref_stream *bufio.Reader
+
+  if from!="rotini" {
+    f,e := pasta.LookupFormat(from)
+    if e!=nil { return e }
+    if f.NewReader==nil { return fmt.Errorf("format '%s' can't be read", from) }
+    rd = f.NewReader()
 
+    if len(c.String("chrom"))>0 {
+      if p,ok := rd.(interface{ Chrom(string) }) ; ok { p.Chrom(c.String("chrom")) }
+    }
+    if c.Int("start") > 0 {
+      if p,ok := rd.(interface{ Pos(int) }) ; ok { p.Pos(c.Int("start")) }
+    }
+    if g,ok := rd.(*gvcf.GVCFRefVar) ; ok { g.Sample = c.String("sample") }
+
+    fp := os.Stdin
+    if c.String("refstream")!="-" {
+      fp,e = os.Open(c.String("refstream"))
+      if e!=nil { return e }
+      defer fp.Close()
+    }
+    ref_stream = bufio.NewReader(fp)
+  }
+
+  if to!="rotini" {
+    f,e := pasta.LookupFormat(to)
+    if e!=nil { return e }
+    if f.NewWriter==nil { return fmt.Errorf("format '%s' can't be written", to) }
+    wr = f.NewWriter()
+
+
+    if g,ok := wr.(*gvcf.GVCFRefVar) ; ok {
+      e = _gvcf_writer_options(c, g)
+      if e!=nil { return e }
+    }
+
+    wr = pasta.WithFullRefSeq(wr, c.Bool("full-sequence"))
+  }
+
+  infn_slice := c.StringSlice("input")
+  fp := os.Stdin
+  if len(infn_slice)>0 && infn_slice[0]!="-" {
+    fp,e = os.Open(infn_slice[0])
+    if e!=nil { return e }
+    defer fp.Close()
+  }
+
+  return pasta.Convert(rd, wr, fp, ref_stream, out)
+}
 
 func _main( c *cli.Context ) {
   var e error
@@ -1751,26 +1288,79 @@ func _main( c *cli.Context ) {
 
   action = c.String("action")
 
+  // Every sample is written to its own file, named with
+  // `--output` as a prefix.
+  //
+  if (action == "gvcf-rotini") && c.Bool("all-samples") {
+    e = _main_gvcf_to_rotini_all_samples(c)
+    if e!=nil {
+      fmt.Fprintf(os.Stderr, "ERROR: %v\n", e)
+      os.Stderr.Sync()
+      os.Exit(1)
+    }
+    return
+  }
+
+  aout,err := autoio.CreateWriter( c.String("output") )
+  if err!=nil {
+    fmt.Fprintf(os.Stderr, "%v", err)
+    os.Stderr.Sync()
+    os.Exit(1)
+  }
+  defer func() { aout.Flush() ; aout.Close() }()
+
+  out := aout.Writer
+
+  // Flush what has been written before reporting an
+  // error, as os.Exit skips the deferred flush.
+  //
+  exit_on_error := func(e error) {
+    if e==nil { return }
+    aout.Flush()
+    aout.Close()
+    fmt.Fprintf(os.Stderr, "%v\n", e)
+    os.Stderr.Sync()
+    os.Exit(1)
+  }
+
   if action == "diff-rotini" {
-    _main_diff_to_rotini(c)
+    exit_on_error( _main_diff_to_rotini(c, out) )
     return
   } else if action == "gff-rotini" {
-    _main_gff_to_rotini(c)
+    exit_on_error( _main_gff_to_rotini(c, out) )
+    return
+  } else if action == "gvf-rotini" {
+    exit_on_error( _main_gvf_to_rotini(c, out) )
     return
   } else if action == "gff-pasta" {
-    _main_gff_to_pasta(c)
+    exit_on_error( _main_gff_to_pasta(c, out) )
     return
   } else if action == "gvcf-rotini" {
-    _main_gvcf_to_rotini(c)
+    exit_on_error( _main_gvcf_to_rotini(c, out) )
     return
   } else if action == "cgivar-pasta" {
-    _main_cgivar_to_pasta(c)
+    exit_on_error( _main_cgivar_to_pasta(c, out) )
     return
   } else if action == "cgivar-rotini" {
-    _main_cgivar_to_rotini(c)
+    exit_on_error( _main_cgivar_to_rotini(c, out) )
+    return
+  } else if action == "mastervar-rotini" {
+    exit_on_error( _main_mastervar_to_rotini(c, out) )
     return
   } else if action == "fasta-pasta" {
-    _main_fasta_to_pasta(c)
+    exit_on_error( _main_fasta_to_pasta(c, out) )
+    return
+  } else if action == "roundtrip" {
+    exit_on_error( _main_roundtrip(c, out) )
+    return
+  } else if action == "benchmark" {
+    exit_on_error( _main_benchmark(c, out) )
+    return
+  } else if action == "fastj-library" {
+    exit_on_error( _main_fastj_library(c, out) )
+    return
+  } else if action == "convert" {
+    exit_on_error( _main_convert(c, out) )
     return
   }
 
@@ -1782,8 +1372,8 @@ func _main( c *cli.Context ) {
 
   g_debug = c.Bool("debug")
 
-  gFullRefSeqFlag = c.Bool("full-sequence")
-  gFullNocSeqFlag = c.Bool("full-nocall-sequence")
+  pasta.FullRefSeqFlag = c.Bool("full-sequence")
+  pasta.FullNocSeqFlag = c.Bool("full-nocall-sequence")
 
   n_inp_stream := 0
 
@@ -1791,11 +1381,7 @@ func _main( c *cli.Context ) {
     fp := os.Stdin
     if infn_slice[0]!="-" {
       fp,e = os.Open(infn_slice[0])
-      if e!=nil {
-        fmt.Fprintf(os.Stderr, "%v", e)
-        os.Stderr.Sync()
-        os.Exit(1)
-      }
+      exit_on_error(e)
       defer fp.Close()
     }
     stream = bufio.NewReader(fp)
@@ -1805,27 +1391,17 @@ func _main( c *cli.Context ) {
 
   if len(infn_slice)>1 {
     fp,e := os.Open(infn_slice[1])
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "%v", e)
-      os.Stderr.Sync()
-      os.Exit(1)
-    }
+    exit_on_error(e)
     defer fp.Close()
     stream_b = bufio.NewReader(fp)
 
     n_inp_stream++
 
-    action = "interleave"
-  }
-
-
-  aout,err := autoio.CreateWriter( c.String("output") ) ; _ = aout
-  if err!=nil {
-    fmt.Fprintf(os.Stderr, "%v", err)
-    os.Stderr.Sync()
-    os.Exit(1)
+    // Multiple rotini streams are written out as a joint gVCF,
+    // otherwise two streams are interleaved.
+    //
+    if action != "rotini-gvcf" { action = "interleave" }
   }
-  defer func() { aout.Flush() ; aout.Close() }()
 
   if c.Bool( "pprof" ) {
     gProfileFlag = true
@@ -1870,42 +1446,36 @@ func _main( c *cli.Context ) {
   //---
 
   if action == "echo" {
-    echo_stream(stream)
+    e = echo_stream(stream, out)
   } else if action == "filter-pasta" {
-    out := bufio.NewWriter(os.Stdout)
-    pasta_filter(stream, out, c.Int("start"), c.Int("n"))
-    out.Flush()
+    e = pasta_filter(stream, out, c.Int("start"), c.Int("n"))
   } else if action == "filter-rotini" {
-    out := bufio.NewWriter(os.Stdout)
-    interleave_filter(stream, out, c.Int("start"), c.Int("n"))
-    out.Flush()
+    e = interleave_filter(stream, out, c.Int("start"), c.Int("n"))
   } else if action == "interleave" {
-    pasta.InterleaveStreams(stream, stream_b, os.Stdout)
-  } else if action == "ref-rstream" {
+    e = pasta.InterleaveStreams(stream, stream_b, out)
+  } else if (action == "ref-rstream") || (action == "rstream") {
 
     r_ctx := random_stream_context_from_param( c.String("param") )
-    random_ref_stream(r_ctx)
-
-  } else if action == "rstream" {
+    if len(c.String("param-file"))>0 {
+      r_ctx,e = random_stream_context_from_json_file(c.String("param-file"), c.String("param"))
+      exit_on_error(e)
+    }
 
-    r_ctx := random_stream_context_from_param( c.String("param") )
-    random_stream(r_ctx)
+    if action == "ref-rstream" {
+      e = random_ref_stream(r_ctx, out)
+    } else {
+      e = random_stream_write(r_ctx, out)
+    }
 
     //FASTA
   } else if action == "pasta-fasta" {
 
-    fi := FASTAInfo{}
+    fi := fasta.FASTAInfo{}
     fi.Init()
 
-    out := bufio.NewWriter(os.Stdout)
     fi.Header(out)
-    e := fi.Stream(stream, out)
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "\nERROR: %v\n", e)
-      os.Stderr.Sync()
-      os.Exit(1)
-    }
-    fi.PrintEnd(out)
+    e = fi.Stream(stream, out)
+    if e==nil { e = fi.PrintEnd(out) }
 
   } else if action == "diff-rotini" {
 
@@ -1914,93 +1484,137 @@ func _main( c *cli.Context ) {
 
   } else if action == "rotini-diff" {
 
-    e:=interleave_to_diff(stream, simple_refvar_printer)
-    if e!=nil { fmt.Fprintf(os.Stderr, "%v\n", e) ; return }
+    e = pasta.InterleaveToSimpleDiff(stream, out)
   } else if action == "rotini" {
   } else if action == "pasta-ref" {
-    e := pasta_to_haploid(stream, -1)
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "\nERROR: %v\n", e)
-      os.Stderr.Sync()
-      os.Exit(1)
-    }
+    e = pasta.PastaToHaploid(stream, out, -1)
+  } else if action == "simulate-reads" {
+    e = _main_simulate_reads(c, stream, out)
+
   } else if action == "rotini-ref" {
-    e := interleave_to_haploid(stream, -1)
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "\nERROR: %v\n", e)
-      os.Stderr.Sync()
-      os.Exit(1)
-    }
+    e = pasta.InterleaveToHaploid(stream, out, -1)
   } else if action == "rotini-alt0" {
-    interleave_to_haploid(stream, 0)
+    e = pasta.InterleaveToHaploid(stream, out, 0)
   } else if action == "rotini-alt1" {
-    interleave_to_haploid(stream, 1)
+    e = pasta.InterleaveToHaploid(stream, out, 1)
   } else if action == "rotini-gff" {
 
-    gff := GFFRefVar{}
+    gff := gff.GFFRefVar{}
     gff.Init()
 
-    e:=interleave_to_diff_iface(stream, &gff, os.Stdout)
-    if e!=nil { fmt.Fprintf(os.Stderr, "%v\n", e) ; return }
+    e = pasta.InterleaveToDiffInterface(stream, &gff, out)
+
+  } else if action == "rotini-gvf" {
+
+    gvf := gff.GVFRefVar{}
+    gvf.Init()
+
+    e = pasta.InterleaveToDiffInterface(stream, &gvf, out)
+
+  } else if (action == "rotini-gvcf") && (len(infn_slice)>1) {
+
+    e = _main_rotini_to_joint_gvcf(c, infn_slice, out)
 
   } else if action == "rotini-gvcf" {
 
     g := gvcf.GVCFRefVar{}
     g.Init()
 
-    // We need the full reference sequence for beginning and ending bases
-    //
-    gFullRefSeqFlag = true
+    e = _gvcf_writer_options(c, &g)
+    if e!=nil { exit_on_error(e) }
+
+    if c.Int("max-procs") > 1 {
 
-    e:=interleave_to_diff_iface(stream, &g, os.Stdout)
-    if e!=nil { fmt.Fprintf(os.Stderr, "%v\n", e) ; return }
+      // Each chunk gets a copy of the configured printer,
+      // with only the first printing the header.  The slices
+      // are copied so no two chunks share a backing array.
+      //
+      cp := ChunkParallel{}
+      cp.Workers = c.Int("max-procs")
+      cp.ChunkSize = c.Int("chunk-size")
+      cp.Init(func(idx int) pasta.VariantWriter {
+        cg := g
+        cg.GQBands = append([]int{}, g.GQBands...)
+        cg.DPBands = append([]int{}, g.DPBands...)
+        cg.FormatPassthrough = append([]string{}, g.FormatPassthrough...)
+        cg.InfoPassthrough = append([]string{}, g.InfoPassthrough...)
+        cg.SampleNames = append([]string{}, g.SampleNames...)
+        cg.StateHistory = nil
+        cg.PrintHeader = (idx==0)
+        return &cg
+      })
+
+      e = cp.Convert(stream, out)
+    } else {
+      e = pasta.InterleaveToDiffInterface(stream, &g, out)
+    }
 
   } else if action == "rotini-cgivar" {
 
-    cgivar := CGIRefVar{}
+    cgivar := cgivar.CGIRefVar{}
     cgivar.Init()
 
-    e:=interleave_to_diff_iface(stream, &cgivar, os.Stdout)
-    if e!=nil { fmt.Fprintf(os.Stderr, "%v\n", e) ; return }
+    e = pasta.InterleaveToDiffInterface(stream, &cgivar, out)
 
-  } else if action == "fastj-rotini" {
+  } else if (action == "fastj-rotini") || (action == "fastj-gvcf") {
 
     //
-    // FastJ to rotini
+    // FastJ to rotini (or gVCF)
     //
 
     fp := os.Stdin
     if c.String("refstream")!="-" {
       fp,e = os.Open(c.String("refstream"))
-      if e!=nil {
-        fmt.Fprintf(os.Stderr, "ERROR: opening reference stream: %v", e)
-        os.Stderr.Sync()
-        os.Exit(1)
-      }
+      if e!=nil { exit_on_error(fmt.Errorf("ERROR: opening reference stream: %v", e)) }
       defer fp.Close()
     }
     ref_stream := bufio.NewReader(fp)
 
     assembly_fp,e := os.Open(c.String("assembly"))
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "ERROR: opening assembly stream: %v", e)
-      os.Stderr.Sync()
-      os.Exit(1)
-    }
+    if e!=nil { exit_on_error(fmt.Errorf("ERROR: opening assembly stream: %v", e)) }
     defer assembly_fp.Close()
     assembly_stream := bufio.NewReader(assembly_fp)
 
-    out := bufio.NewWriter(os.Stdout)
-
-    fji := FastJInfo{}
+    fji := fastj.FastJInfo{}
     fji.RefPos = c.Int("start")
-    fji.Chrom = c.String("chrom")
 
-    e = fji.Pasta(stream, ref_stream, assembly_stream, out)
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "ERROR: processing PASTA stream: %v\n", e)
-      os.Stderr.Sync()
-      os.Exit(1)
+    if action == "fastj-gvcf" {
+      e = _fastj_to_gvcf(c, &fji, stream, ref_stream, assembly_stream, out)
+    } else {
+      e = fji.PastaAssembly(stream, ref_stream, assembly_stream, out)
+    }
+    if e!=nil { exit_on_error(fmt.Errorf("ERROR: processing PASTA stream: %v", e)) }
+
+  } else if action == "fastj-check" {
+
+    //
+    // FastJ consistency checks, against the tag set and
+    // assembly if given
+    //
+
+    fjc := fastj.FastJChecker{}
+    fjc.Init()
+    fjc.Library = c.Bool("is-library")
+
+    if len(c.String("tag"))>0 {
+      tag_fp,e := os.Open(c.String("tag"))
+      exit_on_error(e)
+      e = fjc.LoadTag(bufio.NewReader(tag_fp))
+      tag_fp.Close()
+      if e!=nil { exit_on_error(fmt.Errorf("ERROR: reading tags: %v", e)) }
+    }
+
+    if len(c.String("assembly"))>0 {
+      assembly_fp,e := os.Open(c.String("assembly"))
+      exit_on_error(e)
+      e = fjc.LoadAssembly(bufio.NewReader(assembly_fp))
+      assembly_fp.Close()
+      if e!=nil { exit_on_error(fmt.Errorf("ERROR: reading assembly: %v", e)) }
+    }
+
+    e = fjc.Check(stream, out)
+    if (e==nil) && (fjc.NError>0) {
+      e = fmt.Errorf("%d problem(s) found in %d tiles", fjc.NError, fjc.NTile)
     }
 
   } else if action == "rotini-fastj" {
@@ -2010,52 +1624,79 @@ func _main( c *cli.Context ) {
     //
 
     tag_fp,e := os.Open(c.String("tag"))
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "%v", e)
-      os.Stderr.Sync()
-      os.Exit(1)
-    }
+    exit_on_error(e)
     defer tag_fp.Close()
 
     assembly_fp,e := os.Open(c.String("assembly"))
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "%v", e)
-      os.Stderr.Sync()
-      os.Exit(1)
-    }
+    exit_on_error(e)
     defer assembly_fp.Close()
 
     tag_reader := bufio.NewReader(tag_fp)
     assembly_reader := bufio.NewReader(assembly_fp)
 
-    fji := FastJInfo{}
+    fji := fastj.FastJInfo{}
     fji.RefPos = c.Int("start")
     fji.RefBuild = c.String("build")
     fji.Chrom = c.String("chrom")
 
     _tilepath,e := strconv.ParseUint(c.String("tilepath"), 16, 64)
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "%v", e)
-      os.Stderr.Sync()
-      os.Exit(1)
-    }
+    exit_on_error(e)
     fji.TagPath = int(_tilepath)
 
-    out := bufio.NewWriter(os.Stdout)
+    if len(c.String("library"))>0 {
+      lib_fp,e := os.Open(c.String("library"))
+      exit_on_error(e)
+
+      fji.Library = &fastj.TileLibrary{}
+      fji.Library.Init()
+      e = fji.Library.Load(bufio.NewReader(lib_fp))
+      lib_fp.Close()
+      exit_on_error(e)
+      fji.LibraryVersion = fji.Library.Version
+    } else if len(c.String("library-out"))>0 {
+      fji.Library = &fastj.TileLibrary{}
+      fji.Library.Init()
+    }
 
-    err := fji.Convert(stream, tag_reader, assembly_reader, out)
-    if err!=nil {
-      fmt.Fprintf(os.Stderr, "%v",err)
-      os.Stderr.Sync()
-      os.Exit(1)
+    if c.Bool("all-paths") {
+
+      // Whole genome, every path in the assembly (and tag set)
+      // converted path by path in parallel.
+      //
+      fjp := fastj.FastJParallel{}
+      fjp.Workers = runtime.NumCPU()
+      if c.Int("max-procs") > 0 { fjp.Workers = c.Int("max-procs") }
+      fjp.RefStart = fji.RefPos
+      fjp.RefBuild = fji.RefBuild
+      fjp.LibraryVersion = fji.LibraryVersion
+      fjp.Library = fji.Library
+      fjp.OutDir = c.String("path-output-dir")
+      fjp.Init(assembly_reader, tag_reader)
+
+      exit_on_error( fjp.Convert(stream, out) )
+    } else {
+
+      // A single tile path, given by --tilepath and --chrom
+      // (or a '>' header in the tag stream).
+      //
+      exit_on_error( fji.Convert(stream, tag_reader, assembly_reader, out) )
+    }
+
+    // Write out the library, with any new tile variants added
+    //
+    if len(c.String("library-out"))>0 {
+      lib_fp,e := os.Create(c.String("library-out"))
+      exit_on_error(e)
+      e = fji.Library.Write(bufio.NewWriter(lib_fp))
+      lib_fp.Close()
+      exit_on_error(e)
     }
 
   } else {
-    fmt.Printf("invalid action (%s)\n", action)
-    os.Stderr.Sync()
-    os.Exit(1)
+    e = fmt.Errorf("invalid action (%s)", action)
   }
 
+  exit_on_error(e)
 }
 
 func main() {
@@ -2088,7 +1729,7 @@ func main() {
 
     cli.StringFlag{
       Name: "action, a",
-      Usage: "Action: rstream, ref-rstream, rotini-(diff|gvcf|gff|cgivar|fastj|ref|alt0|alt1), (diff|gvcf|cgivar|fastj)-rotini, pasta-fasta, interleave, echo",
+      Usage: "Action: rstream, ref-rstream, rotini-(diff|gvcf|gff|gvf|cgivar|fastj|ref|alt0|alt1), (diff|gvcf|gvf|cgivar|mastervar|fastj)-rotini, fastj-library, fastj-check, fastj-gvcf, pasta-fasta, convert, interleave, echo",
     },
 
     cli.StringFlag{
@@ -2112,6 +1753,41 @@ func main() {
       Usage: "Parameter",
     },
 
+    cli.StringFlag{
+      Name: "param-file",
+      Usage: "JSON parameter file (rstream, ref-rstream)",
+    },
+
+    cli.StringFlag{
+      Name: "library",
+      Usage: "Tile library (FastJ or 'tileID md5sum' table) used to assign tile variant IDs",
+    },
+
+    cli.StringFlag{
+      Name: "library-out",
+      Usage: "Write the tile library, including newly seen tile variants, to this file",
+    },
+
+    cli.BoolFlag{
+      Name: "all-paths",
+      Usage: "Convert a whole genome rotini stream to FastJ (rotini-fastj), using every tile path in the tag set and assembly",
+    },
+
+    cli.StringFlag{
+      Name: "path-output-dir",
+      Usage: "Write each tile path to its own FastJ file in this directory (rotini-fastj with all-paths)",
+    },
+
+    cli.BoolFlag{
+      Name: "is-library",
+      Usage: "Input FastJ is a tile library rather than a single sample (fastj-check)",
+    },
+
+    cli.StringFlag{
+      Name: "tile-vector",
+      Usage: "Write per sample tile variant vectors (fastj-library) to this file",
+    },
+
     cli.StringFlag{
       Name: "build",
       Usage: "e.g. hg19",
@@ -2122,6 +1798,57 @@ func main() {
       Usage: "e.g. chr12",
     },
 
+    cli.StringFlag{
+      Name: "sample",
+      Usage: "Sample to convert from a multi-sample (g)VCF, by name or 0-based index",
+    },
+
+    cli.BoolFlag{
+      Name: "all-samples",
+      Usage: "Convert every sample of a multi-sample (g)VCF, one rotini file per sample (OUTPUT is used as the file prefix)",
+    },
+
+    cli.StringFlag{
+      Name: "sample-names",
+      Usage: "Comma separated sample names for a joint gVCF written from multiple rotini inputs (default: input file names)",
+    },
+
+    cli.StringFlag{
+      Name: "gq-bands",
+      Usage: "Comma separated GQ band lower bounds used to merge gVCF reference blocks, writing GQ, DP and MIN_DP from the stream annotations (e.g. 5,20,60)",
+    },
+
+    cli.StringFlag{
+      Name: "dp-bands",
+      Usage: "Comma separated DP band lower bounds used to merge gVCF reference blocks, writing GQ, DP and MIN_DP from the stream annotations",
+    },
+
+    cli.StringFlag{
+      Name: "format-fields",
+      Usage: "Comma separated stream annotation keys to pass through as gVCF FORMAT fields",
+    },
+
+    cli.StringFlag{
+      Name: "from",
+      Usage: "Format to convert from (convert), e.g. gvcf, gff, gvf, cgivar, mastervar or rotini",
+    },
+
+    cli.StringFlag{
+      Name: "to",
+      Usage: "Format to convert to (convert), e.g. gvcf, gff, gvf, cgivar, fasta or rotini",
+    },
+
+    cli.StringFlag{
+      Name: "format",
+      Usage: "Comma separated formats to roundtrip (gvcf,gff,gvf,cgivar,fastj), all if not given",
+    },
+
+    cli.IntFlag{
+      Name: "iterations",
+      Value: 10,
+      Usage: "Number of random streams to roundtrip",
+    },
+
     cli.IntFlag{
       Name: "start, s",
       Usage: "Reference start",
@@ -2153,6 +1880,12 @@ func main() {
       Usage: "MAXPROCS",
     },
 
+    cli.IntFlag{
+      Name: "chunk-size",
+      Value: 1000000,
+      Usage: "Minimum number of stream columns in each chunk when converting in parallel (rotini-gvcf with max-procs above 1)",
+    },
+
     cli.BoolFlag{
       Name: "Verbose, V",
       Usage: "Verbose flag",
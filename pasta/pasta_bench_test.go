@@ -0,0 +1,161 @@
+package main
+
+// Throughput benchmarks for the stream conversions.  Each iteration
+// converts a whole stream, of PASTA_BENCH_SIZE bytes (64M by default,
+// 'K', 'M' and 'G' suffixes are understood), made by repeating a random
+// rotini stream.  For multi-GB streams:
+//
+//   PASTA_BENCH_SIZE=4G go test -run '^$' -bench . -benchtime 1x
+//
+
+import "io"
+import "io/ioutil"
+import "os"
+import "bytes"
+import "bufio"
+import "strconv"
+import "strings"
+import "testing"
+
+import "github.com/abeconnelly/pasta"
+import "github.com/abeconnelly/pasta/gvcf"
+
+var bench_param string = "n=1000000:p-snp=0.01:p-indel=0.002:p-nocall=0.001:seed=1:ref-seed=2"
+
+func _bench_size(b *testing.B) int64 {
+  s := strings.ToUpper(os.Getenv("PASTA_BENCH_SIZE"))
+  if len(s)==0 { return 64<<20 }
+
+  mul := int64(1)
+  switch s[len(s)-1] {
+  case 'K': mul = 1<<10
+  case 'M': mul = 1<<20
+  case 'G': mul = 1<<30
+  }
+  if mul>1 { s = s[:len(s)-1] }
+
+  n,e := strconv.ParseInt(s, 10, 64)
+  if (e!=nil) || (n<1) { b.Fatalf("invalid PASTA_BENCH_SIZE '%s'", os.Getenv("PASTA_BENCH_SIZE")) }
+  return n*mul
+}
+
+var bench_stream_head []byte
+var bench_stream_body []byte
+
+// Header and body (whole lines of columns) of the random
+// stream that gets repeated.
+//
+func _bench_stream(b *testing.B) ([]byte, []byte) {
+  if bench_stream_body!=nil { return bench_stream_head, bench_stream_body }
+
+  var buf bytes.Buffer
+  e := random_stream_write(random_stream_context_from_param(bench_param), &buf)
+  if e!=nil { b.Fatal(e) }
+
+  s := buf.Bytes()
+  nl := bytes.IndexByte(s, '\n')
+  bench_stream_head = s[:nl+1]
+  bench_stream_body = s[nl+1:]
+
+  // Whole number of columns
+  //
+  n := 0
+  for ii:=0; ii<len(bench_stream_body); ii++ {
+    ch := bench_stream_body[ii]
+    if (ch!='\n') && (ch!=' ') && (ch!='\r') && (ch!='\t') { n++ }
+  }
+  if (n%2)!=0 { b.Fatal("odd number of tokens in benchmark stream") }
+
+  return bench_stream_head, bench_stream_body
+}
+
+// Reader for a stream of (about) `size` bytes, the
+// header followed by the body over and over.
+//
+type _bench_reader struct {
+  head []byte
+  body []byte
+  left int64
+  off int
+}
+
+func (r *_bench_reader) Read(p []byte) (int, error) {
+  if len(r.head)>0 {
+    n := copy(p, r.head)
+    r.head = r.head[n:]
+    return n, nil
+  }
+  if r.left<=0 { return 0, io.EOF }
+
+  src := r.body[r.off:]
+  if int64(len(src)) > r.left { src = src[:r.left] }
+
+  n := copy(p, src)
+  r.left -= int64(n)
+  r.off += n
+  if r.off==len(r.body) {
+    r.off = 0
+  } else if r.left<=0 {
+
+    // Finish the line so the stream ends on a column
+    //
+    rest := r.body[r.off:]
+    r.head = rest[:bytes.IndexByte(rest, '\n')+1]
+  }
+  return n, nil
+}
+
+func _bench_run(b *testing.B, f func(stream *bufio.Reader) error) {
+  head,body := _bench_stream(b)
+  size := _bench_size(b)
+
+  b.SetBytes(size)
+  b.ResetTimer()
+  for ii:=0; ii<b.N; ii++ {
+    r := &_bench_reader{ head:head, body:body, left:size }
+    e := f(bufio.NewReaderSize(r, 1<<16))
+    if e!=nil { b.Fatal(e) }
+  }
+}
+
+type _bench_null_printer struct { n int }
+
+func (p *_bench_null_printer) Init() { }
+func (p *_bench_null_printer) Chrom(chr string) { }
+func (p *_bench_null_printer) Pos(pos int) { }
+func (p *_bench_null_printer) GetRefPos() int { return 0 }
+func (p *_bench_null_printer) Header(out *bufio.Writer) error { return nil }
+func (p *_bench_null_printer) PrintEnd(out *bufio.Writer) error { return nil }
+func (p *_bench_null_printer) Pasta(line string, ref_stream *bufio.Reader, out *bufio.Writer) error { return nil }
+func (p *_bench_null_printer) PastaBegin(out *bufio.Writer) error { return nil }
+func (p *_bench_null_printer) PastaEnd(out *bufio.Writer) error { return nil }
+func (p *_bench_null_printer) Print(v *pasta.Variant, out *bufio.Writer) error {
+  p.n++
+  return nil
+}
+
+func BenchmarkRotiniDiff(b *testing.B) {
+  _bench_run(b, func(stream *bufio.Reader) error {
+    return pasta.InterleaveToDiffInterface(stream, &_bench_null_printer{}, ioutil.Discard)
+  })
+}
+
+func BenchmarkRotiniDiffProcessor(b *testing.B) {
+  _bench_run(b, func(stream *bufio.Reader) error {
+    return pasta.InterleaveToDiff(stream, ioutil.Discard, func(v *pasta.Variant, out io.Writer) error {
+      return nil
+    })
+  })
+}
+
+func BenchmarkRotiniGVCF(b *testing.B) {
+  save_flag := pasta.FullRefSeqFlag
+  pasta.FullRefSeqFlag = true
+  defer func() { pasta.FullRefSeqFlag = save_flag }()
+
+  _bench_run(b, func(stream *bufio.Reader) error {
+    g := gvcf.GVCFRefVar{}
+    g.Init()
+    return pasta.InterleaveToDiffInterface(stream, &g, ioutil.Discard)
+  })
+}
@@ -0,0 +1,546 @@
+package main
+
+// Benchmark a query rotini stream against a truth rotini stream.
+//
+// Variants are pulled out of both streams with the same difference
+// machinery the printers use, gathered into clusters of nearby variants
+// and each cluster is scored by comparing the haplotype sequences it
+// produces rather than the variant records themselves, so differences
+// in representation (where an indel is placed in a repeat, how a complex
+// variant is split up) aren't counted as errors.
+//
+// A cluster matches if the query's pair of haplotypes is the truth's,
+// in either order (phase isn't checked).  Truth variants in a matching
+// cluster are true positives, as are the query variants, otherwise the
+// truth variants are false negatives and the query variants are false
+// positives.  A false positive where the query has the right alleles
+// but the wrong genotype is also counted as a genotype error.
+//
+// Truth no-calls aren't scored.  Query no-calls (including half calls)
+// make the truth variants they cluster with false negatives and the
+// query variants in the cluster aren't scored.
+//
+// Variants are classed as SNP, MNP or INDEL (anything that changes
+// length) and counts are given per class, and for each BED file given
+// as a stratum, for the variants overlapping its intervals.
+//
+
+import "fmt"
+import "os"
+import "io"
+import "io/ioutil"
+import "bufio"
+import "bytes"
+import "sort"
+import "strconv"
+import "strings"
+import "path/filepath"
+
+import "github.com/codegangsta/cli"
+
+import "github.com/abeconnelly/pasta"
+
+const (
+  BENCH_SNP = iota
+  BENCH_MNP = iota
+  BENCH_INDEL = iota
+)
+
+var gBenchmarkClassName []string = []string{ "SNP", "MNP", "INDEL" }
+
+type BenchmarkVar struct {
+  Start int
+  Len int
+  Alt [2][]byte
+  NoCall bool
+  Class int
+}
+
+type _bench_ref struct {
+  beg int
+  seq []byte
+}
+
+// Variants and reference sequence collected
+// from a stream, by chromosome.
+//
+type BenchmarkCollector struct {
+  chrom string
+  Chroms []string
+  Var map[string][]BenchmarkVar
+  Ref map[string]*_bench_ref
+}
+
+func (b *BenchmarkCollector) Init() {
+  b.chrom = "unk"
+  b.Chroms = []string{}
+  b.Var = make(map[string][]BenchmarkVar)
+  b.Ref = make(map[string]*_bench_ref)
+}
+
+func (b *BenchmarkCollector) Chrom(chr string) { b.chrom = chr }
+
+// Reference runs are only filled in with the full sequence
+//
+func (b *BenchmarkCollector) FullRefSeq() bool { return true }
+func (b *BenchmarkCollector) Pos(pos int) { }
+func (b *BenchmarkCollector) GetRefPos() int { return 0 }
+func (b *BenchmarkCollector) Header(out *bufio.Writer) error { return nil }
+func (b *BenchmarkCollector) PrintEnd(out *bufio.Writer) error { return nil }
+func (b *BenchmarkCollector) Pasta(line string, ref_stream *bufio.Reader, out *bufio.Writer) error { return nil }
+func (b *BenchmarkCollector) PastaBegin(out *bufio.Writer) error { return nil }
+func (b *BenchmarkCollector) PastaEnd(out *bufio.Writer) error { return nil }
+
+// "-" stands in for an empty sequence
+//
+func _bench_seq(seq []byte) []byte {
+  if (len(seq)==1) && (seq[0]=='-') { return []byte{} }
+  return seq
+}
+
+// Lay down reference sequence at `pos`, checking it against
+// what's already been seen.  Unknown bases are 'n'.
+//
+func (r *_bench_ref) set(chrom string, pos int, seq []byte) error {
+  if len(seq)==0 { return nil }
+
+  if len(r.seq)==0 { r.beg = pos }
+  if pos < r.beg {
+    r.seq = append(bytes.Repeat([]byte{'n'}, r.beg-pos), r.seq...)
+    r.beg = pos
+  }
+  for (r.beg+len(r.seq)) < (pos+len(seq)) { r.seq = append(r.seq, 'n') }
+
+  for ii:=0; ii<len(seq); ii++ {
+    bp := seq[ii]
+    cur := r.seq[pos-r.beg+ii]
+    if bp=='n' { continue }
+    if cur=='n' {
+      r.seq[pos-r.beg+ii] = bp
+    } else if cur!=bp {
+      return fmt.Errorf("reference mismatch at %s:%d (%c != %c)", chrom, pos+ii, cur, bp)
+    }
+  }
+  return nil
+}
+
+func (r *_bench_ref) get(beg, end int) []byte {
+  seq := make([]byte, 0, end-beg)
+  for p:=beg; p<end; p++ {
+    if (p<r.beg) || (p>=(r.beg+len(r.seq))) {
+      seq = append(seq, 'n')
+    } else {
+      seq = append(seq, r.seq[p-r.beg])
+    }
+  }
+  return seq
+}
+
+func (b *BenchmarkCollector) _ref(chrom string) *_bench_ref {
+  r,ok := b.Ref[chrom]
+  if !ok {
+    r = &_bench_ref{}
+    b.Ref[chrom] = r
+    b.Chroms = append(b.Chroms, chrom)
+  }
+  return r
+}
+
+// Class of a variant, from the alleles that differ from the
+// reference once the bases they share with it are trimmed off.
+//
+func _bench_class(ref []byte, alt [2][]byte) int {
+  class := BENCH_SNP
+  for a:=0; a<2; a++ {
+    if bytes.Equal(ref, alt[a]) { continue }
+    if len(ref)!=len(alt[a]) { return BENCH_INDEL }
+
+    beg,end := 0,len(ref)
+    for (beg<end) && (ref[beg]==alt[a][beg]) { beg++ }
+    for (end>beg) && (ref[end-1]==alt[a][end-1]) { end-- }
+    if (end-beg)>1 { class = BENCH_MNP }
+  }
+  return class
+}
+
+func (b *BenchmarkCollector) Print(rec *pasta.Variant, out *bufio.Writer) error {
+  vartype,ref_start,ref_len := rec.Type, rec.Pos, rec.RefLen
+  refseq,altseq := rec.Ref, rec.Alleles
+
+  r := b._ref(b.chrom)
+
+  if vartype==pasta.REF {
+    if len(refseq)==ref_len { return r.set(b.chrom, ref_start, refseq) }
+    return nil
+  }
+  if (vartype!=pasta.ALT) && (vartype!=pasta.NOC) { return nil }
+
+  ref := _bench_seq(refseq)
+  if len(ref)==ref_len {
+    e := r.set(b.chrom, ref_start, ref)
+    if e!=nil { return e }
+  }
+
+  // Records with a no-call on either allele aren't scored.
+  //
+  v := BenchmarkVar{ Start:ref_start, Len:ref_len }
+  for a:=0; a<len(rec.NoCall); a++ {
+    if rec.NoCall[a] { v.NoCall = true }
+  }
+  for a:=0; (a<2) && (a<len(altseq)); a++ {
+    v.Alt[a] = append([]byte{}, _bench_seq(altseq[a])...)
+  }
+  v.Class = _bench_class(ref, v.Alt)
+
+  b.Var[b.chrom] = append(b.Var[b.chrom], v)
+  return nil
+}
+
+func benchmark_collect(stream *bufio.Reader) (*BenchmarkCollector, error) {
+  b := BenchmarkCollector{}
+  b.Init()
+
+  e := pasta.InterleaveToDiffInterface(stream, &b, ioutil.Discard)
+  if e!=nil { return nil, e }
+  return &b, nil
+}
+
+//--
+
+type BenchmarkCount struct {
+  TruthTP int
+  TruthFN int
+  QueryTP int
+  QueryFP int
+  FPGT int
+}
+
+type BenchmarkContext struct {
+
+  // Variants this close (in reference bases) are
+  // compared together.
+  //
+  Slack int
+
+  // BED files to stratify by
+  //
+  StrataFile []string
+  StrataName []string
+  strata []map[string][][2]int
+
+  // Counts by stratum (0 for all variants, then a stratum
+  // per BED file) and class (0 for all classes, then
+  // one per class).
+  //
+  Count [][]BenchmarkCount
+}
+
+func default_benchmark_context() *BenchmarkContext {
+  ctx := BenchmarkContext{}
+  ctx.Slack = 10
+  return &ctx
+}
+
+func benchmark_context_set(ctx *BenchmarkContext, key, val string) {
+  switch key {
+  case "slack":
+    if v,e := strconv.Atoi(val) ; e==nil { ctx.Slack = v }
+  case "strata":
+    for _,fn := range strings.Split(val, ",") {
+      if len(fn)==0 { continue }
+      ctx.StrataFile = append(ctx.StrataFile, fn)
+    }
+  }
+}
+
+func benchmark_context_from_param(param string) *BenchmarkContext {
+  ctx := default_benchmark_context()
+  kv := param_key_val(param)
+  for ii:=0; ii<len(kv); ii++ {
+    benchmark_context_set(ctx, kv[ii][0], kv[ii][1])
+  }
+  return ctx
+}
+
+// Load a BED file as sorted, merged intervals by chromosome.
+//
+func load_bed_intervals(fn string) (map[string][][2]int, error) {
+  fp,e := os.Open(fn)
+  if e!=nil { return nil, e }
+  defer fp.Close()
+
+  ivl := make(map[string][][2]int)
+
+  scanner := bufio.NewScanner(fp)
+  line_no := 0
+  for scanner.Scan() {
+    line_no++
+    line := strings.TrimSpace(scanner.Text())
+    if (len(line)==0) || (line[0]=='#') || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+      continue
+    }
+
+    f := strings.Fields(line)
+    if len(f)<3 { return nil, fmt.Errorf("%s:%d: invalid BED line", fn, line_no) }
+    beg,e0 := strconv.Atoi(f[1])
+    end,e1 := strconv.Atoi(f[2])
+    if (e0!=nil) || (e1!=nil) || (end<beg) { return nil, fmt.Errorf("%s:%d: invalid BED interval", fn, line_no) }
+
+    ivl[f[0]] = append(ivl[f[0]], [2]int{beg,end})
+  }
+  if e:=scanner.Err() ; e!=nil { return nil, e }
+
+  for chrom,v := range ivl {
+    sort.Slice(v, func(i, j int) bool { return v[i][0] < v[j][0] })
+    m := [][2]int{}
+    for ii:=0; ii<len(v); ii++ {
+      if (len(m)>0) && (v[ii][0] <= m[len(m)-1][1]) {
+        if v[ii][1] > m[len(m)-1][1] { m[len(m)-1][1] = v[ii][1] }
+        continue
+      }
+      m = append(m, v[ii])
+    }
+    ivl[chrom] = m
+  }
+
+  return ivl, nil
+}
+
+func (ctx *BenchmarkContext) Init() error {
+  ctx.StrataName = []string{ "*" }
+  ctx.strata = []map[string][][2]int{ nil }
+
+  for ii:=0; ii<len(ctx.StrataFile); ii++ {
+    ivl,e := load_bed_intervals(ctx.StrataFile[ii])
+    if e!=nil { return e }
+
+    name := filepath.Base(ctx.StrataFile[ii])
+    name = strings.TrimSuffix(name, filepath.Ext(name))
+    ctx.StrataName = append(ctx.StrataName, name)
+    ctx.strata = append(ctx.strata, ivl)
+  }
+
+  ctx.Count = make([][]BenchmarkCount, len(ctx.StrataName))
+  for ii:=0; ii<len(ctx.Count); ii++ {
+    ctx.Count[ii] = make([]BenchmarkCount, len(gBenchmarkClassName)+1)
+  }
+  return nil
+}
+
+// Whether the variant overlaps the stratum.  An insertion
+// is taken to cover the reference base after it.
+//
+func (ctx *BenchmarkContext) _in_stratum(s int, chrom string, v *BenchmarkVar) bool {
+  if ctx.strata[s]==nil { return true }
+  ivl := ctx.strata[s][chrom]
+
+  beg,end := v.Start,v.Start+v.Len
+  if end==beg { end++ }
+
+  idx := sort.Search(len(ivl), func(i int) bool { return ivl[i][1] > beg })
+  return (idx<len(ivl)) && (ivl[idx][0] < end)
+}
+
+func (ctx *BenchmarkContext) _count(chrom string, v *BenchmarkVar, f func(c *BenchmarkCount)) {
+  for s:=0; s<len(ctx.strata); s++ {
+    if !ctx._in_stratum(s, chrom, v) { continue }
+    f(&ctx.Count[s][0])
+    f(&ctx.Count[s][v.Class+1])
+  }
+}
+
+// The pair of haplotypes over [beg,end) with the variants applied.
+//
+func _bench_haplotypes(ref *_bench_ref, vars []*BenchmarkVar, beg, end int) [2][]byte {
+  var hap [2][]byte
+  for a:=0; a<2; a++ {
+    pos := beg
+    for ii:=0; ii<len(vars); ii++ {
+      hap[a] = append(hap[a], ref.get(pos, vars[ii].Start)...)
+      hap[a] = append(hap[a], vars[ii].Alt[a]...)
+      pos = vars[ii].Start + vars[ii].Len
+    }
+    hap[a] = append(hap[a], ref.get(pos, end)...)
+  }
+  return hap
+}
+
+// The haplotypes carry the same non-reference
+// alleles, regardless of genotype.
+//
+func _bench_same_alleles(refseq []byte, x, y [2][]byte) bool {
+  set := func(h [2][]byte) map[string]bool {
+    m := make(map[string]bool)
+    for a:=0; a<2; a++ {
+      if !bytes.Equal(h[a], refseq) { m[string(h[a])] = true }
+    }
+    return m
+  }
+
+  sx,sy := set(x),set(y)
+  if (len(sx)==0) || (len(sx)!=len(sy)) { return false }
+  for k := range sx {
+    if !sy[k] { return false }
+  }
+  return true
+}
+
+func (ctx *BenchmarkContext) _score_cluster(chrom string, ref *_bench_ref, truth, query []*BenchmarkVar, beg, end int) {
+  for ii:=0; ii<len(truth); ii++ {
+    if truth[ii].NoCall { return }
+  }
+
+  query_nocall := false
+  query_var := []*BenchmarkVar{}
+  for ii:=0; ii<len(query); ii++ {
+    if query[ii].NoCall { query_nocall = true ; continue }
+    query_var = append(query_var, query[ii])
+  }
+
+  if query_nocall {
+    for ii:=0; ii<len(truth); ii++ {
+      ctx._count(chrom, truth[ii], func(c *BenchmarkCount) { c.TruthFN++ })
+    }
+    return
+  }
+
+  t_hap := _bench_haplotypes(ref, truth, beg, end)
+  q_hap := _bench_haplotypes(ref, query_var, beg, end)
+
+  match := (bytes.Equal(t_hap[0], q_hap[0]) && bytes.Equal(t_hap[1], q_hap[1])) ||
+           (bytes.Equal(t_hap[0], q_hap[1]) && bytes.Equal(t_hap[1], q_hap[0]))
+
+  if match {
+    for ii:=0; ii<len(truth); ii++ {
+      ctx._count(chrom, truth[ii], func(c *BenchmarkCount) { c.TruthTP++ })
+    }
+    for ii:=0; ii<len(query_var); ii++ {
+      ctx._count(chrom, query_var[ii], func(c *BenchmarkCount) { c.QueryTP++ })
+    }
+    return
+  }
+
+  gt_err := _bench_same_alleles(ref.get(beg, end), t_hap, q_hap)
+
+  for ii:=0; ii<len(truth); ii++ {
+    ctx._count(chrom, truth[ii], func(c *BenchmarkCount) { c.TruthFN++ })
+  }
+  for ii:=0; ii<len(query_var); ii++ {
+    ctx._count(chrom, query_var[ii], func(c *BenchmarkCount) {
+      c.QueryFP++
+      if gt_err { c.FPGT++ }
+    })
+  }
+}
+
+type _bench_item struct {
+  v *BenchmarkVar
+  truth bool
+}
+
+// Gather the truth and query variants on a chromosome into clusters,
+// variants no more than `Slack` reference bases apart, and score each
+// cluster.
+//
+func (ctx *BenchmarkContext) _compare_chrom(chrom string, ref *_bench_ref, truth, query []BenchmarkVar) {
+  items := []_bench_item{}
+  for ii:=0; ii<len(truth); ii++ { items = append(items, _bench_item{&truth[ii], true}) }
+  for ii:=0; ii<len(query); ii++ { items = append(items, _bench_item{&query[ii], false}) }
+  sort.SliceStable(items, func(i, j int) bool { return items[i].v.Start < items[j].v.Start })
+
+  for ii:=0; ii<len(items); {
+    beg := items[ii].v.Start
+    end := beg + items[ii].v.Len
+
+    t_var := []*BenchmarkVar{}
+    q_var := []*BenchmarkVar{}
+
+    for ; (ii<len(items)) && (items[ii].v.Start <= end+ctx.Slack) ; ii++ {
+      if e := items[ii].v.Start+items[ii].v.Len ; e>end { end = e }
+      if items[ii].truth {
+        t_var = append(t_var, items[ii].v)
+      } else {
+        q_var = append(q_var, items[ii].v)
+      }
+    }
+
+    ctx._score_cluster(chrom, ref, t_var, q_var, beg, end)
+  }
+}
+
+func (ctx *BenchmarkContext) Compare(truth, query *BenchmarkCollector) error {
+  chroms := append([]string{}, truth.Chroms...)
+  for ii:=0; ii<len(query.Chroms); ii++ {
+    if _,ok := truth.Ref[query.Chroms[ii]] ; !ok { chroms = append(chroms, query.Chroms[ii]) }
+  }
+
+  for _,chrom := range chroms {
+
+    // Reference from both streams, which have to agree
+    //
+    ref := &_bench_ref{}
+    for _,b := range []*BenchmarkCollector{truth, query} {
+      r,ok := b.Ref[chrom]
+      if !ok { continue }
+      e := ref.set(chrom, r.beg, r.seq)
+      if e!=nil { return e }
+    }
+
+    ctx._compare_chrom(chrom, ref, truth.Var[chrom], query.Var[chrom])
+  }
+  return nil
+}
+
+func _bench_ratio(n, d int) string {
+  if d==0 { return "." }
+  return fmt.Sprintf("%0.6f", float64(n)/float64(d))
+}
+
+func (ctx *BenchmarkContext) Print(out *bufio.Writer) {
+  out.WriteString("#STRATUM\tTYPE\tTRUTH.TOTAL\tTRUTH.TP\tTRUTH.FN\tQUERY.TOTAL\tQUERY.TP\tQUERY.FP\tFP.GT\tRECALL\tPRECISION\n")
+  for s:=0; s<len(ctx.Count); s++ {
+    for t:=0; t<len(ctx.Count[s]); t++ {
+      name := "ALL"
+      if t>0 { name = gBenchmarkClassName[t-1] }
+
+      c := ctx.Count[s][t]
+      out.WriteString(fmt.Sprintf("%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n",
+        ctx.StrataName[s], name,
+        c.TruthTP+c.TruthFN, c.TruthTP, c.TruthFN,
+        c.QueryTP+c.QueryFP, c.QueryTP, c.QueryFP, c.FPGT,
+        _bench_ratio(c.TruthTP, c.TruthTP+c.TruthFN),
+        _bench_ratio(c.QueryTP, c.QueryTP+c.QueryFP)))
+    }
+  }
+}
+
+func _bench_open(fn string) (io.ReadCloser, error) {
+  if fn=="-" { return os.Stdin, nil }
+  return os.Open(fn)
+}
+
+func _main_benchmark(c *cli.Context, out *bufio.Writer) error {
+  infn_slice := c.StringSlice("input")
+  if len(infn_slice)!=2 {
+    return fmt.Errorf("benchmark needs a truth and query stream ('-i truth -i query')")
+  }
+
+  ctx := benchmark_context_from_param(c.String("param"))
+  e := ctx.Init()
+  if e!=nil { return e }
+
+  b := [2]*BenchmarkCollector{}
+  for ii:=0; ii<2; ii++ {
+    fp,e := _bench_open(infn_slice[ii])
+    if e!=nil { return e }
+    b[ii],e = benchmark_collect(bufio.NewReader(fp))
+    fp.Close()
+    if e!=nil { return fmt.Errorf("%s: %v", infn_slice[ii], e) }
+  }
+
+  e = ctx.Compare(b[0], b[1])
+  if e!=nil { return e }
+
+  ctx.Print(out)
+  return out.Flush()
+}
@@ -0,0 +1,249 @@
+package main
+
+// Parallel conversion of a rotini stream by position.
+//
+// The stream is cut into chunks at places where a fresh printer picks
+// up exactly where the last one left off, each chunk is converted by a
+// worker with its own printer and the output is written back out in
+// stream order.
+//
+// A chunk is only ended once it holds at least `ChunkSize` columns, and
+// then only before a reference column that follows either
+//
+//   * a chromosome (`>C{}`) or position (`>P{}`) message, with a
+//     reference column before it, or
+//
+//   * a variant record that itself follows a reference record.
+//
+// Records are runs of reference, variant or no-call columns, and are
+// also ended by any message.
+// Printers that anchor variants on neighbouring reference bases (gVCF)
+// have nothing held over at these points.  Each chunk after the first
+// starts with the chromosome, position and annotation in effect where
+// it was cut.
+//
+// Only rotini-gvcf is converted this way.  The CGI-Var and GVF
+// writers number their lines across the whole stream and the
+// benchmark collects the whole stream before scoring it, so their
+// chunks aren't independent.
+//
+
+import "fmt"
+import "io"
+import "sync"
+import "bytes"
+import "bufio"
+
+import "github.com/abeconnelly/pasta"
+
+type StreamChunk struct {
+  Index int
+
+  Rotini []byte
+
+  Out bytes.Buffer
+  Err error
+}
+
+type ChunkParallel struct {
+  Workers int
+
+  // Minimum number of columns in a chunk
+  //
+  ChunkSize int
+
+  // Printer for each chunk.  `idx` is the chunk
+  // number, so only the first prints a header.
+  //
+  NewPrinter func(idx int) pasta.VariantWriter
+}
+
+func (p *ChunkParallel) Init(new_printer func(idx int) pasta.VariantWriter) {
+  if p.Workers < 1 { p.Workers = 1 }
+  if p.ChunkSize < 1 { p.ChunkSize = 1 }
+  p.NewPrinter = new_printer
+}
+
+func (p *ChunkParallel) _convert(job *StreamChunk) {
+  printer := p.NewPrinter(job.Index)
+  job.Err = pasta.InterleaveToDiffInterface(bufio.NewReader(bytes.NewReader(job.Rotini)), printer, &job.Out)
+  if job.Err!=nil { job.Err = fmt.Errorf("chunk %d: %v", job.Index, job.Err) }
+  job.Rotini = nil
+}
+
+// Split the rotini stream and convert each chunk, writing
+// the output of each chunk to `out` in order.
+//
+func (p *ChunkParallel) Convert(stream *bufio.Reader, out *bufio.Writer) error {
+  job_ch := make(chan *StreamChunk, p.Workers)
+  done_ch := make(chan *StreamChunk, p.Workers)
+
+  // Bound the number of chunks held in memory, including
+  // finished chunks waiting on an earlier one to be written.
+  //
+  slot := make(chan bool, 2*p.Workers)
+
+  var wg sync.WaitGroup
+  for ii:=0; ii<p.Workers; ii++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for job := range job_ch {
+        p._convert(job)
+        done_ch <- job
+      }
+    }()
+  }
+
+  // Write finished chunks in order
+  //
+  write_err := make(chan error, 1)
+  go func() {
+    var err error
+    pending := make(map[int]*StreamChunk)
+    next_idx := 0
+    for job := range done_ch {
+      pending[job.Index] = job
+      for {
+        j,ok := pending[next_idx]
+        if !ok { break }
+        delete(pending, next_idx)
+        next_idx++
+
+        if err==nil { err = j.Err }
+        if err==nil { _,err = out.Write(j.Out.Bytes()) }
+        <-slot
+      }
+    }
+    if err==nil { err = out.Flush() }
+    write_err <- err
+  }()
+
+  split_err := p._split(stream, job_ch, slot)
+
+  close(job_ch)
+  wg.Wait()
+  close(done_ch)
+
+  err := <-write_err
+  if split_err!=nil { return split_err }
+  return err
+}
+
+func (p *ChunkParallel) _split(stream *bufio.Reader, job_ch chan *StreamChunk, slot chan bool) error {
+  var chunk bytes.Buffer
+  cw := bufio.NewWriter(&chunk)
+
+  n_sent := 0
+  n_col := 0
+
+  send := func() {
+    cw.Flush()
+    if chunk.Len()==0 { return }
+    job := &StreamChunk{ Index:n_sent, Rotini:append([]byte{}, chunk.Bytes()...) }
+    n_sent++
+    chunk.Reset()
+    n_col = 0
+    slot <- true
+    job_ch <- job
+  }
+
+  // State at the end of the last column
+  //
+  chrom := ""
+  ref_pos := 0
+  var annot map[string]string
+
+  // Messages read since the last column
+  //
+  msgs := []pasta.ControlMessage{}
+  boundary := false
+
+  // States of the record the last column is in
+  // and of the record before it.
+  //
+  cur_state := pasta.BEG
+  prv_state := pasta.BEG
+
+  tr := pasta.NewTokenReader(stream)
+
+  for {
+    ch0,e0 := tr.Next()
+    if e0==io.EOF { break }
+    if e0!=nil { return e0 }
+
+    if ch0=='>' {
+      msg,e := pasta.ControlMessageProcess(stream)
+      if e!=nil { return fmt.Errorf("invalid control message %v (%v)", msg, e) }
+      msgs = append(msgs, msg)
+      if (msg.Type==pasta.CHROM) || (msg.Type==pasta.POS) { boundary = true }
+      continue
+    }
+
+    ch1,e1 := tr.Next()
+    if e1==io.EOF { return fmt.Errorf("odd number of tokens in stream") }
+    if e1!=nil { return e1 }
+
+    state := cur_state
+    if (ch0!='.') || (ch1!='.') {
+      cls0 := pasta.TokenClass[ch0]
+      cls1 := pasta.TokenClass[ch1]
+
+      if (cls0 & cls1 & pasta.TOKEN_REF)!=0 {
+        state = pasta.REF
+      } else if ((cls0 | cls1) & pasta.TOKEN_NOC)!=0 {
+        state = pasta.NOC
+      } else {
+        state = pasta.ALT
+      }
+    }
+
+    if (n_col >= p.ChunkSize) && (state==pasta.REF) {
+      if (boundary && (cur_state==pasta.REF)) ||
+         (!boundary && (cur_state==pasta.ALT) && (prv_state==pasta.REF)) {
+        send()
+
+        if len(chrom)>0 { cw.WriteString(fmt.Sprintf(">C{%s}", chrom)) }
+        cw.WriteString(fmt.Sprintf(">P{%d}", ref_pos))
+        if annot!=nil { cw.WriteString(fmt.Sprintf(">A{%s}", pasta.AnnotationString(annot))) }
+        cw.WriteByte('\n')
+      }
+    }
+
+    // Messages end the record before them
+    //
+    if (state!=cur_state) || (len(msgs)>0) {
+      prv_state = cur_state
+      cur_state = state
+    }
+
+    for ii:=0; ii<len(msgs); ii++ {
+      switch msgs[ii].Type {
+      case pasta.CHROM:
+        if (len(chrom)>0) && (msgs[ii].Chrom!=chrom) { ref_pos = 0 }
+        chrom = msgs[ii].Chrom
+      case pasta.POS:
+        ref_pos = msgs[ii].RefPos
+      case pasta.REF, pasta.NOC:
+        ref_pos += msgs[ii].N
+      case pasta.ANNOT:
+        annot = msgs[ii].Annot
+      }
+      pasta.ControlMessagePrint(&msgs[ii], cw)
+    }
+    if len(msgs)>0 { cw.WriteByte('\n') }
+    msgs = msgs[0:0]
+    boundary = false
+
+    cw.WriteByte(ch0)
+    cw.WriteByte(ch1)
+    n_col++
+    if (n_col%25)==0 { cw.WriteByte('\n') }
+
+    ref_pos += pasta.RefDelBP[ch0]
+  }
+
+  for ii:=0; ii<len(msgs); ii++ { pasta.ControlMessagePrint(&msgs[ii], cw) }
+  send()
+  return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import "fmt"
+import "io"
+import "bytes"
+import "bufio"
+import "testing"
+
+import "github.com/abeconnelly/pasta"
+import "github.com/abeconnelly/pasta/gvcf"
+
+// Reader that gives `b` and then fails
+//
+type _chunk_err_reader struct {
+  b *bytes.Reader
+}
+
+func (r *_chunk_err_reader) Read(p []byte) (int, error) {
+  n,e := r.b.Read(p)
+  if e==io.EOF { return n, fmt.Errorf("read failed") }
+  return n, e
+}
+
+func _chunk_test_convert(r io.Reader) ([]byte, error) {
+  var b bytes.Buffer
+  out := bufio.NewWriter(&b)
+
+  cp := ChunkParallel{}
+  cp.Workers = 4
+  cp.ChunkSize = 13
+  cp.Init(func(idx int) pasta.VariantWriter {
+    g := gvcf.GVCFRefVar{}
+    g.Init()
+    g.PrintHeader = (idx==0)
+    return &g
+  })
+
+  e := cp.Convert(bufio.NewReader(r), out)
+  return b.Bytes(), e
+}
+
+func TestChunkParallelReadError(t *testing.T) {
+  rotini := _roundtrip_test_stream(t, "n=2000:p-snp=0.1:p-indel=0.05:seed=1:ref-seed=101")
+
+  _,e := _chunk_test_convert(bytes.NewReader(rotini))
+  if e!=nil { t.Fatalf("Convert: %v", e) }
+
+  // Cut at the end of a line so the read error falls between
+  // columns
+  //
+  cut := len(rotini)/2 + bytes.IndexByte(rotini[len(rotini)/2:], '\n') + 1
+
+  _,e = _chunk_test_convert(&_chunk_err_reader{ b:bytes.NewReader(rotini[:cut]) })
+  if e==nil { t.Errorf("read error in the middle of the stream not reported") }
+}
@@ -0,0 +1,559 @@
+package main
+
+// Round trip conformance.  Random rotini streams are converted out to
+// each format and read back in, and the reference and both alternate
+// haplotypes compared.  A stream that fails is minimized, by reverting
+// variant loci to reference and trimming the tail, to the smallest
+// stream that still fails the same format.
+//
+
+import "fmt"
+import "os"
+import "io/ioutil"
+import "bufio"
+import "bytes"
+import "strings"
+
+import "github.com/codegangsta/cli"
+
+import "github.com/abeconnelly/pasta"
+import "github.com/abeconnelly/pasta/gvcf"
+import "github.com/abeconnelly/pasta/gff"
+import "github.com/abeconnelly/pasta/cgivar"
+import "github.com/abeconnelly/pasta/fastj"
+
+// Parameters for the random streams, ahead of any given
+// on the command line.
+//
+var gRoundTripParam string = "n=2000:p-snp=0.05:p-indel=0.02:p-nocall=0.005"
+
+// Tile spacing and tag length for the FastJ round trip.
+//
+var gRoundTripTileLen int = 250
+var gRoundTripTagLen int = 24
+
+type RoundTripFormat struct {
+  Name string
+
+  // Convert the rotini stream out to the format and back.
+  // `ref` is the reference sequence for the readers.
+  //
+  Convert func(rotini, ref []byte) ([]byte, error)
+}
+
+var RoundTripFormats []RoundTripFormat = []RoundTripFormat{
+  { "gvcf", roundtrip_gvcf },
+  { "gff", roundtrip_gff },
+  { "gvf", roundtrip_gvf },
+  { "cgivar", roundtrip_cgivar },
+  { "fastj", roundtrip_fastj },
+}
+
+// Read back a converted stream into a rotini stream.
+//
+func _roundtrip_read(r pasta.VariantReader, b, ref []byte) ([]byte, error) {
+  var out_buf bytes.Buffer
+  ref_stream := bufio.NewReader(bytes.NewReader(ref))
+
+  e := pasta.ReadVariants(r, bytes.NewReader(b), ref_stream, &out_buf)
+  if e!=nil { return nil, e }
+
+  return out_buf.Bytes(), nil
+}
+
+func _roundtrip_write(p pasta.VariantWriter, rotini []byte, full_ref_seq bool) ([]byte, error) {
+  var b bytes.Buffer
+
+  e := pasta.WriteVariants(pasta.WithFullRefSeq(p, full_ref_seq), bytes.NewReader(rotini), &b)
+  return b.Bytes(), e
+}
+
+func roundtrip_gvcf(rotini, ref []byte) ([]byte, error) {
+  w := gvcf.GVCFRefVar{}
+  w.Init()
+  b,e := _roundtrip_write(&w, rotini, true)
+  if e!=nil { return nil, e }
+
+  r := gvcf.GVCFRefVar{}
+  r.Init()
+  return _roundtrip_read(&r, b, ref)
+}
+
+func roundtrip_cgivar(rotini, ref []byte) ([]byte, error) {
+  w := cgivar.CGIRefVar{}
+  w.Init()
+  b,e := _roundtrip_write(&w, rotini, false)
+  if e!=nil { return nil, e }
+
+  r := cgivar.CGIRefVar{}
+  r.Init()
+  return _roundtrip_read(&r, b, ref)
+}
+
+// GFF and GVF reading need the start of the stream, as with `-start`.
+//
+func roundtrip_gff(rotini, ref []byte) ([]byte, error) {
+  s,e := parse_roundtrip_stream(rotini)
+  if e!=nil { return nil, e }
+
+  w := gff.GFFRefVar{}
+  w.Init()
+  b,e := _roundtrip_write(&w, rotini, false)
+  if e!=nil { return nil, e }
+
+  r := gff.GFFRefVar{}
+  r.Init()
+  r.RefPos = s.Pos
+  r.PrevRefPos = s.Pos
+  return _roundtrip_read(&r, b, ref)
+}
+
+func roundtrip_gvf(rotini, ref []byte) ([]byte, error) {
+  s,e := parse_roundtrip_stream(rotini)
+  if e!=nil { return nil, e }
+
+  w := gff.GVFRefVar{}
+  w.Init()
+  b,e := _roundtrip_write(&w, rotini, false)
+  if e!=nil { return nil, e }
+
+  r := gff.GVFRefVar{}
+  r.Init()
+  r.RefPos = s.Pos
+  r.PrevRefPos = s.Pos
+  return _roundtrip_read(&r, b, ref)
+}
+
+// Assembly and tag set for a single tile path over the
+// reference, with tiles every gRoundTripTileLen bases.
+//
+func roundtrip_fastj_assembly(ref []byte, chrom string, ref_start int) ([]byte, []byte) {
+  seq := bytes.Replace(ref, []byte("\n"), nil, -1)
+
+  var assembly,tag bytes.Buffer
+  assembly.WriteString(fmt.Sprintf(">hg19:%s:0000\n", chrom))
+  tag.WriteString(">0000.00\n")
+
+  step := 0
+  for end:=gRoundTripTileLen; end<len(seq); end+=gRoundTripTileLen {
+    assembly.WriteString(fmt.Sprintf("%04x\t%d\n", step, ref_start+end))
+    tag.Write(seq[end-gRoundTripTagLen:end])
+    tag.WriteByte('\n')
+    step++
+  }
+  assembly.WriteString(fmt.Sprintf("%04x\t%d\n", step, ref_start+len(seq)))
+
+  return assembly.Bytes(), tag.Bytes()
+}
+
+func roundtrip_fastj(rotini, ref []byte) ([]byte, error) {
+  s,e := parse_roundtrip_stream(rotini)
+  if e!=nil { return nil, e }
+
+  assembly,tag := roundtrip_fastj_assembly(ref, s.Chrom, s.Pos)
+
+  var fj_buf bytes.Buffer
+  fj_out := bufio.NewWriter(&fj_buf)
+
+  fjp := fastj.FastJParallel{}
+  fjp.Workers = 1
+  fjp.RefStart = s.Pos
+  fjp.RefBuild = "hg19"
+  fjp.Init(bufio.NewReader(bytes.NewReader(assembly)), bufio.NewReader(bytes.NewReader(tag)))
+  e = fjp.Convert(bufio.NewReader(bytes.NewReader(rotini)), fj_out)
+  if e!=nil { return nil, e }
+  fj_out.Flush()
+
+  var out_buf bytes.Buffer
+  out := bufio.NewWriter(&out_buf)
+
+  fji := fastj.FastJInfo{}
+  fji.RefPos = s.Pos
+  e = fji.PastaAssembly(bufio.NewReader(&fj_buf), bufio.NewReader(bytes.NewReader(ref)),
+    bufio.NewReader(bytes.NewReader(assembly)), out)
+  if e!=nil { return nil, e }
+  out.Flush()
+
+  return out_buf.Bytes(), nil
+}
+
+//--
+
+// A single segment rotini stream, held as columns (a token
+// from each allele) so it can be cut down.
+//
+type RoundTripStream struct {
+  Chrom string
+  Pos int
+  Col [][2]byte
+}
+
+func _is_pasta_ins(ch byte) bool {
+  return (ch=='Q') || (ch=='S') || (ch=='W') || (ch=='d') || (ch=='Z')
+}
+
+func _is_pasta_ref(ch byte) bool {
+  return (ch=='a') || (ch=='c') || (ch=='g') || (ch=='t')
+}
+
+func parse_roundtrip_stream(b []byte) (*RoundTripStream, error) {
+  s := RoundTripStream{}
+  s.Chrom = "Unk"
+
+  stream := bufio.NewReader(bytes.NewReader(b))
+  seen_col := false
+  var col [2]byte
+  n := 0
+
+  for {
+    ch,e := stream.ReadByte()
+    if e!=nil { break }
+    if (ch=='\n') || (ch==' ') || (ch=='\r') || (ch=='\t') { continue }
+
+    if ch=='>' {
+      msg,e := pasta.ControlMessageProcess(stream)
+      if e!=nil { return nil, fmt.Errorf("invalid control message") }
+
+      if (msg.Type==pasta.CHROM) || (msg.Type==pasta.POS) {
+        if seen_col { return nil, fmt.Errorf("only single segment streams are supported") }
+        if msg.Type==pasta.CHROM { s.Chrom = msg.Chrom }
+        if msg.Type==pasta.POS { s.Pos = msg.RefPos }
+      }
+      continue
+    }
+
+    seen_col = true
+    col[n] = ch
+    n++
+    if n==2 {
+      s.Col = append(s.Col, col)
+      n = 0
+    }
+  }
+
+  if n!=0 { return nil, fmt.Errorf("odd number of tokens in stream") }
+  return &s, nil
+}
+
+func (s *RoundTripStream) Bytes() []byte {
+  var b bytes.Buffer
+  b.WriteString(fmt.Sprintf(">C{%s}>P{%d}\n", s.Chrom, s.Pos))
+
+  lfmod := 50
+  for ii:=0; ii<len(s.Col); ii++ {
+    b.Write(s.Col[ii][:])
+    if ((2*(ii+1))%lfmod)==0 { b.WriteByte('\n') }
+  }
+  b.WriteByte('\n')
+
+  return b.Bytes()
+}
+
+// Column ranges, [beg,end), of runs with a variant or
+// no-call in either allele.
+//
+func (s *RoundTripStream) VariantLoci() [][2]int {
+  loci := [][2]int{}
+  beg := -1
+  for ii:=0; ii<=len(s.Col); ii++ {
+    is_ref := (ii==len(s.Col)) || (_is_pasta_ref(s.Col[ii][0]) && _is_pasta_ref(s.Col[ii][1]))
+    if !is_ref && (beg<0) { beg = ii }
+    if is_ref && (beg>=0) {
+      loci = append(loci, [2]int{beg, ii})
+      beg = -1
+    }
+  }
+  return loci
+}
+
+// Copy of the stream, cut at column `n`, with the variant
+// loci not kept reverted to reference.
+//
+func (s *RoundTripStream) Revert(loci [][2]int, keep []bool, n int) *RoundTripStream {
+  z := RoundTripStream{}
+  z.Chrom = s.Chrom
+  z.Pos = s.Pos
+
+  revert := make([]bool, len(s.Col))
+  for ii:=0; ii<len(loci); ii++ {
+    if keep[ii] { continue }
+    for jj:=loci[ii][0]; jj<loci[ii][1]; jj++ { revert[jj] = true }
+  }
+
+  if n>len(s.Col) { n = len(s.Col) }
+  for ii:=0; ii<n; ii++ {
+    col := s.Col[ii]
+    if !revert[ii] {
+      z.Col = append(z.Col, col)
+      continue
+    }
+
+    if _is_pasta_ins(col[0]) || _is_pasta_ins(col[1]) || ((col[0]=='.') && (col[1]=='.')) { continue }
+
+    ref_bp := pasta.RefMap[col[0]]
+    if col[0]=='.' { ref_bp = pasta.RefMap[col[1]] }
+    z.Col = append(z.Col, [2]byte{ref_bp, ref_bp})
+  }
+
+  return &z
+}
+
+// Reference and alternate haplotypes of a rotini stream.
+// Control messages are skipped.
+//
+func rotini_haplotypes(b []byte) ([3][]byte, error) {
+  var hap [3][]byte
+
+  stream := bufio.NewReader(bytes.NewReader(b))
+  var col [2]byte
+  n := 0
+
+  for {
+    ch,e := stream.ReadByte()
+    if e!=nil { break }
+    if (ch=='\n') || (ch==' ') || (ch=='\r') || (ch=='\t') { continue }
+
+    if ch=='>' {
+      _,e := pasta.ControlMessageProcess(stream)
+      if e!=nil { return hap, fmt.Errorf("invalid control message") }
+      continue
+    }
+
+    col[n] = ch
+    n++
+    if n<2 { continue }
+    n = 0
+
+    if (col[0]=='.') && (col[1]=='.') { continue }
+
+    if !_is_pasta_ins(col[0]) && !_is_pasta_ins(col[1]) {
+      ref_ch := col[0]
+      if ref_ch=='.' { ref_ch = col[1] }
+      ref_bp := pasta.RefMap[ref_ch]
+      ok := ref_bp!=0
+      if !ok { return hap, fmt.Errorf("invalid token %c", ref_ch) }
+      hap[0] = append(hap[0], ref_bp)
+    }
+
+    for a:=0; a<2; a++ {
+      if (col[a]=='.') || pasta.IsAltDel[col[a]] { continue }
+      alt_bp := pasta.AltMap[col[a]]
+      ok := alt_bp!=0
+      if !ok { return hap, fmt.Errorf("invalid token %c", col[a]) }
+      hap[a+1] = append(hap[a+1], alt_bp)
+    }
+  }
+
+  if n!=0 { return hap, fmt.Errorf("odd number of tokens in stream") }
+  return hap, nil
+}
+
+var gRoundTripHapName []string = []string{ "ref", "alt0", "alt1" }
+
+// Convert the stream through the format and back, returning
+// an error if it fails or the haplotypes differ.
+//
+func roundtrip_check(f RoundTripFormat, rotini []byte) (err error) {
+  defer func() {
+    if r := recover(); r!=nil { err = fmt.Errorf("panic: %v", r) }
+  }()
+
+  hap,e := rotini_haplotypes(rotini)
+  if e!=nil { return e }
+
+  ref := append(append([]byte{}, hap[0]...), '\n')
+  out,e := f.Convert(rotini, ref)
+  if e!=nil { return e }
+
+  out_hap,e := rotini_haplotypes(out)
+  if e!=nil { return e }
+
+  for ii:=0; ii<3; ii++ {
+    if bytes.Equal(hap[ii], out_hap[ii]) { continue }
+
+    pos := 0
+    for (pos<len(hap[ii])) && (pos<len(out_hap[ii])) && (hap[ii][pos]==out_hap[ii][pos]) { pos++ }
+    return fmt.Errorf("%s haplotype mismatch at %d (length %d, got %d)",
+      gRoundTripHapName[ii], pos, len(hap[ii]), len(out_hap[ii]))
+  }
+
+  return nil
+}
+
+// Minimize a failing stream.  Variant loci are reverted to reference
+// by delta debugging, then the reference after the last variant
+// locus left is trimmed, as long as the format still fails.
+//
+func roundtrip_minimize(f RoundTripFormat, s *RoundTripStream) (*RoundTripStream, error) {
+  loci := s.VariantLoci()
+  n_col := len(s.Col)
+
+  _fails := func(keep []bool, n int) error {
+    return roundtrip_check(f, s.Revert(loci, keep, n).Bytes())
+  }
+
+  keep := make([]bool, len(loci))
+  for ii:=0; ii<len(keep); ii++ { keep[ii] = true }
+
+  err := _fails(keep, n_col)
+  if err==nil { return s, nil }
+
+  // Indexes of the loci kept
+  //
+  cur := []int{}
+  for ii:=0; ii<len(loci); ii++ { cur = append(cur, ii) }
+
+  _keep := func(idx []int) []bool {
+    k := make([]bool, len(loci))
+    for ii:=0; ii<len(idx); ii++ { k[idx[ii]] = true }
+    return k
+  }
+
+  // The stream may fail without any variants at all
+  //
+  if e := _fails(_keep(nil), n_col) ; e!=nil {
+    cur,err = cur[:0],e
+  }
+
+  n_chunk := 2
+  for len(cur)>=2 {
+    if n_chunk>len(cur) { n_chunk = len(cur) }
+    chunk_len := (len(cur)+n_chunk-1)/n_chunk
+
+    reduced := false
+    for beg:=0; (beg<len(cur)) && !reduced; beg+=chunk_len {
+      end := beg+chunk_len
+      if end>len(cur) { end = len(cur) }
+
+      chunk := append([]int{}, cur[beg:end]...)
+      if e := _fails(_keep(chunk), n_col) ; e!=nil {
+        cur,err,n_chunk,reduced = chunk,e,2,true
+      }
+    }
+
+    // With two chunks the complements are the chunks themselves
+    //
+    for beg:=0; (n_chunk>2) && (beg<len(cur)) && !reduced; beg+=chunk_len {
+      end := beg+chunk_len
+      if end>len(cur) { end = len(cur) }
+
+      rest := append(append([]int{}, cur[:beg]...), cur[end:]...)
+      if e := _fails(_keep(rest), n_col) ; e!=nil {
+        cur,err,n_chunk,reduced = rest,e,n_chunk-1,true
+      }
+    }
+
+    if reduced { continue }
+    if n_chunk>=len(cur) { break }
+    n_chunk *= 2
+  }
+
+  keep = _keep(cur)
+
+  // Trim the tail
+  //
+  last := 0
+  for ii:=0; ii<len(cur); ii++ {
+    if loci[cur[ii]][1] > last { last = loci[cur[ii]][1] }
+  }
+  for _,pad := range []int{1, gRoundTripTagLen, gRoundTripTileLen} {
+    if last+pad >= n_col { break }
+    if e := _fails(keep, last+pad) ; e!=nil {
+      n_col,err = last+pad,e
+      break
+    }
+  }
+
+  return s.Revert(loci, keep, n_col), err
+}
+
+// Run every format over the stream.  Failures are minimized and
+// written to `out`, with the error as a comment.  Returns the
+// number of formats that failed.
+//
+func roundtrip_stream(rotini []byte, label string, formats []RoundTripFormat, out *bufio.Writer) (int, error) {
+  s,e := parse_roundtrip_stream(rotini)
+  if e!=nil { return 0, e }
+
+  n_fail := 0
+  for ii:=0; ii<len(formats); ii++ {
+    if roundtrip_check(formats[ii], rotini)==nil { continue }
+    n_fail++
+
+    m,err := roundtrip_minimize(formats[ii], s)
+    out.WriteString(fmt.Sprintf("# %s: %s: %v (%d variant loci)\n", formats[ii].Name, label, err, len(m.VariantLoci())))
+    out.Write(m.Bytes())
+  }
+
+  return n_fail, nil
+}
+
+func _roundtrip_formats(names string) ([]RoundTripFormat, error) {
+  if len(names)==0 { return RoundTripFormats, nil }
+
+  formats := []RoundTripFormat{}
+  for _,name := range strings.Split(names, ",") {
+    found := false
+    for ii:=0; ii<len(RoundTripFormats); ii++ {
+      if RoundTripFormats[ii].Name==name {
+        formats = append(formats, RoundTripFormats[ii])
+        found = true
+      }
+    }
+    if !found { return nil, fmt.Errorf("unknown roundtrip format '%s'", name) }
+  }
+  return formats, nil
+}
+
+// Round trip the input stream if one is given, otherwise
+// `iterations` random streams, one seed after the other.
+//
+func _main_roundtrip(c *cli.Context, out *bufio.Writer) error {
+  formats,e := _roundtrip_formats(c.String("format"))
+  if e!=nil { return e }
+
+
+  n_stream,n_fail := 0,0
+
+  infn_slice := c.StringSlice("input")
+  if len(infn_slice)>0 {
+    for ii:=0; ii<len(infn_slice); ii++ {
+      var b []byte
+      if infn_slice[ii]=="-" {
+        b,e = ioutil.ReadAll(os.Stdin)
+      } else {
+        b,e = ioutil.ReadFile(infn_slice[ii])
+      }
+      if e!=nil { return e }
+
+      n,e := roundtrip_stream(b, infn_slice[ii], formats, out)
+      if e!=nil { return e }
+      n_stream++
+      n_fail += n
+    }
+  } else {
+    param := gRoundTripParam
+    if len(c.String("param"))>0 { param += ":" + c.String("param") }
+    ctx := random_stream_context_from_param(param)
+
+    for ii:=0; ii<c.Int("iterations"); ii++ {
+      seed_param := fmt.Sprintf(":seed=%d:ref-seed=%d", ctx.Seed+int64(ii), ctx.RefSeed+int64(ii))
+      r_ctx := random_stream_context_from_param(param + seed_param)
+
+      var b bytes.Buffer
+      e = random_stream_write(r_ctx, &b)
+      if e!=nil { return e }
+
+      n,e := roundtrip_stream(b.Bytes(), "param " + param + seed_param, formats, out)
+      if e!=nil { return e }
+      n_stream++
+      n_fail += n
+    }
+  }
+
+  out.WriteString(fmt.Sprintf("# %d stream(s), %d format(s), %d failure(s)\n", n_stream, len(formats), n_fail))
+  out.Flush()
+
+  if n_fail>0 { return fmt.Errorf("%d roundtrip failure(s)", n_fail) }
+  return nil
+}
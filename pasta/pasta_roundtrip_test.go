@@ -0,0 +1,120 @@
+package main
+
+import "fmt"
+import "bytes"
+import "strings"
+import "testing"
+
+import "github.com/abeconnelly/pasta"
+
+var roundtrip_test_param []string = []string{
+  "n=2000:p-snp=0.05:p-indel=0.02",
+  "n=2000:p-snp=0.2:p-snp-locked=0.2:p-indel=0.1:p-indel-locked=0.5",
+  "n=2000:p-snp=0.05:p-indel=0.02:p-nocall=0.02:p-snp-nocall=0.1:p-indel-nocall=0.1",
+  "n=2000:chrom=chr2:pos=10000:p-snp=0.1:p-indel=0.05",
+  "n=3000:p-snp=0.05:ts-tv=2.1:het-hom-ratio=1.6:p-indel=0.05:indel-model=geometric:p-indel-length=1,20",
+}
+
+func _roundtrip_test_stream(t *testing.T, param string) []byte {
+  var b bytes.Buffer
+  e := random_stream_write(random_stream_context_from_param(param), &b)
+  if e!=nil { t.Fatalf("random_stream_write(%s): %v", param, e) }
+  return b.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+  for _,f := range RoundTripFormats {
+    for _,param := range roundtrip_test_param {
+      for seed:=1; seed<=3; seed++ {
+        p := fmt.Sprintf("%s:seed=%d:ref-seed=%d", param, seed, 100+seed)
+        rotini := _roundtrip_test_stream(t, p)
+
+        e := roundtrip_check(f, rotini)
+        if e==nil { continue }
+
+        s,_ := parse_roundtrip_stream(rotini)
+        m,err := roundtrip_minimize(f, s)
+        t.Errorf("%s (%s): %v\nminimized (%v):\n%s", f.Name, p, e, err, m.Bytes())
+      }
+    }
+  }
+}
+
+func TestRoundTripHaplotypes(t *testing.T) {
+  // ref    acgtac-gt
+  // alt0   aTgt--Agt
+  // alt1   acgtnc-g-
+  //
+  col := [][2]byte{
+    {'a','a'},
+    {pasta.SubMap['c']['t'],'c'},
+    {'g','g'},
+    {'t','t'},
+    {pasta.SubMap['a']['-'],pasta.SubMap['a']['n']},
+    {pasta.SubMap['c']['-'],'c'},
+    {pasta.SubMap['-']['a'],'.'},
+    {'g','g'},
+    {'t',pasta.SubMap['t']['-']},
+  }
+
+  s := RoundTripStream{ Chrom:"chr1", Pos:100, Col:col }
+  hap,e := rotini_haplotypes(s.Bytes())
+  if e!=nil { t.Fatal(e) }
+
+  expect := []string{ "acgtacgt", "atgtagt", "acgtncg" }
+  for ii:=0; ii<3; ii++ {
+    if string(hap[ii])!=expect[ii] {
+      t.Errorf("%s haplotype: got %s, expected %s", gRoundTripHapName[ii], hap[ii], expect[ii])
+    }
+  }
+
+  z,e := parse_roundtrip_stream(s.Bytes())
+  if e!=nil { t.Fatal(e) }
+  if (z.Chrom!="chr1") || (z.Pos!=100) || (len(z.Col)!=len(col)) {
+    t.Errorf("parse: got %s %d (%d columns)", z.Chrom, z.Pos, len(z.Col))
+  }
+
+  loci := z.VariantLoci()
+  if (len(loci)!=3) || (loci[0]!=[2]int{1,2}) || (loci[1]!=[2]int{4,7}) || (loci[2]!=[2]int{8,9}) {
+    t.Errorf("variant loci: got %v", loci)
+  }
+
+  r := z.Revert(loci, []bool{false, true, false}, len(z.Col))
+  hap,e = rotini_haplotypes(r.Bytes())
+  if e!=nil { t.Fatal(e) }
+  if (string(hap[0])!="acgtacgt") || (string(hap[1])!="acgtagt") || (string(hap[2])!="acgtncgt") {
+    t.Errorf("revert: got %s %s %s", hap[0], hap[1], hap[2])
+  }
+}
+
+// A format that loses the first allele of every insertion
+// should be caught and minimized down to a single insertion.
+//
+func TestRoundTripMinimize(t *testing.T) {
+  broken := RoundTripFormat{ "broken", func(rotini, ref []byte) ([]byte, error) {
+    s,e := parse_roundtrip_stream(rotini)
+    if e!=nil { return nil, e }
+    for ii:=0; ii<len(s.Col); ii++ {
+      if _is_pasta_ins(s.Col[ii][0]) && _is_pasta_ins(s.Col[ii][1]) { s.Col[ii][0] = '.' }
+    }
+    return s.Bytes(), nil
+  } }
+
+  rotini := _roundtrip_test_stream(t, "n=5000:p-snp=0.1:p-indel=0.05:p-indel-locked=1:seed=7")
+  if roundtrip_check(broken, rotini)==nil { t.Fatal("broken format passed") }
+
+  s,e := parse_roundtrip_stream(rotini)
+  if e!=nil { t.Fatal(e) }
+  m,err := roundtrip_minimize(broken, s)
+  if err==nil { t.Fatal("minimized stream passes") }
+  if !strings.Contains(err.Error(), "alt0") { t.Errorf("unexpected error: %v", err) }
+
+  loci := m.VariantLoci()
+  if len(loci)!=1 { t.Errorf("expected a single variant locus, got %d:\n%s", len(loci), m.Bytes()) }
+  if len(m.Col) >= len(s.Col) { t.Errorf("tail not trimmed (%d columns)", len(m.Col)) }
+  if roundtrip_check(broken, m.Bytes())==nil { t.Errorf("minimized stream passes") }
+
+  for _,f := range RoundTripFormats {
+    if e := roundtrip_check(f, m.Bytes()) ; e!=nil { t.Errorf("%s: minimized stream: %v", f.Name, e) }
+  }
+}
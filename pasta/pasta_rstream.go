@@ -4,10 +4,14 @@ import "math/rand"
 import "fmt"
 import "strconv"
 import "strings"
+import "sort"
 import "bufio"
 import "os"
+import "io"
+import "io/ioutil"
 
 import "github.com/abeconnelly/pasta"
+import "github.com/abeconnelly/sloppyjson"
 
 
 type RandomStreamContext struct {
@@ -31,6 +35,54 @@ type RandomStreamContext struct {
   PIndelLocked    float64
   IndelLen        []int
 
+  // Indel length model, one of "uniform" (over IndelLen),
+  // "geometric" (from IndelLen[0] with success probability
+  // IndelGeomP, capped at IndelLen[1]) or "empirical" (weights
+  // in IndelLenWeight, indexed by length).
+  //
+  IndelModel      string
+  IndelGeomP      float64
+  IndelLenWeight  []float64
+
+  // Substitution weights, indexed by reference base then
+  // alternate base, in 'a','c','t','g' order (see _ibp).
+  // nil picks the SNP base uniformly.
+  //
+  SubWeight       [][]float64
+
+  // No-call clusters.  A cluster starts with probability
+  // PNocallCluster at each locus, spans NocallClusterLen
+  // reference bases and raises the no-call probability to
+  // PNocallInCluster within it.
+  //
+  PNocallCluster    float64
+  NocallClusterLen  []int
+  PNocallInCluster  float64
+
+  // Per chromosome segments, each holding the parameters
+  // that differ from the top level (e.g. "chrom", "n").
+  //
+  Segment         []map[string]string
+
+  // Region of a reference FASTA (e.g. "chr1:1001-2000") to
+  // use in place of the random reference.  The region sets
+  // the chromosome, position and length of the stream.
+  //
+  RefFasta        string
+  Region          string
+  RefSeq          []byte
+  RefAnchor       byte
+
+  // Truth VCF of the variants generated, if set
+  //
+  TruthVCF        string
+  Sample          string
+  Truth           *RandomTruthVCF
+
+  // Reference bases generated so far in the segment
+  //
+  ref_count       int
+
   Chrom           string
   Pos             uint64
   Comment         string
@@ -63,9 +115,21 @@ func default_random_stream_context() *RandomStreamContext {
   ctx.PIndelLocked = 0.125
   ctx.IndelLen = []int{0,10}
 
+  ctx.IndelModel = "uniform"
+  ctx.IndelGeomP = 0.5
+  ctx.IndelLenWeight = nil
+
+  ctx.SubWeight = nil
+
+  ctx.PNocallCluster = 0
+  ctx.NocallClusterLen = []int{100, 1000}
+  ctx.PNocallInCluster = 0.5
+
   ctx.Chrom = "Unk"
   ctx.Pos = 0
 
+  ctx.Sample = "SAMPLE"
+
   ctx.LFMod = 50
 
   src := rand.NewSource(ctx.Seed)
@@ -109,87 +173,161 @@ func parsef(val string, def_val float64) float64 {
   return float64(z)
 }
 
-func random_stream_context_from_param(param string) *RandomStreamContext {
-  ctx := default_random_stream_context()
-
-  orig_seed := ctx.Seed
-  orig_ref_seed := ctx.RefSeed
+func parse_range(val string, r []int) {
+  l_parts := strings.Split(val, ",")
+  L := len(l_parts)
+  if L >= 2 { L = 2 }
+  for ii:=0; ii<L; ii++ {
+    r[ii] = parsei(l_parts[ii], r[ii])
+  }
+}
 
-  if param=="" { return ctx }
+func parsef_list(val string) []float64 {
+  v := []float64{}
+  for _,f := range strings.Split(val, ",") {
+    v = append(v, parsef(strings.TrimSpace(f), 0))
+  }
+  return v
+}
 
-  param_parts := strings.Split(param, ":")
-  for i:=0; i<len(param_parts); i++ {
-    val_parts := strings.Split(param_parts[i], "=")
-    if len(val_parts)!=2 { continue }
+// Substitution weights with transitions (a<->g, c<->t) weighted
+// so that the expected transition/transversion ratio is `tstv`.
+//
+func tstv_sub_weight(tstv float64) [][]float64 {
+  w := make([][]float64, 4)
+  for r:=0; r<4; r++ {
+    w[r] = []float64{0.5, 0.5, 0.5, 0.5}
+    w[r][r] = 0
+  }
+  w[0][3] = tstv ; w[3][0] = tstv
+  w[1][2] = tstv ; w[2][1] = tstv
+  return w
+}
 
-    if val_parts[0] == "allele" {
-      ctx.Allele = parsei(val_parts[1], ctx.Allele)
-    } else if val_parts[0] == "n" {
-      ctx.N = parsei(val_parts[1], ctx.N)
-    } else if val_parts[0] == "seed" {
-      ctx.Seed = parsei64(val_parts[1], ctx.Seed)
-    } else if val_parts[0] == "ref-seed" {
-      ctx.RefSeed = parsei64(val_parts[1], ctx.RefSeed)
-    } else if val_parts[0] == "pos" {
-      ctx.Pos = parseui64(val_parts[1], ctx.Pos)
-    } else if val_parts[0] == "chrom" {
-      ctx.Chrom = val_parts[1]
-    } else if val_parts[0] == "comment" {
-      ctx.Comment = val_parts[1]
-    } else if val_parts[0] == "lfmod" {
-      ctx.LFMod = parsei(val_parts[1], ctx.LFMod)
-
-    } else if val_parts[0] == "p-nocall-locked" {
-      ctx.PNocallLocked = parsef(val_parts[1], ctx.PNocallLocked)
-    } else if val_parts[0] == "p-nocall" {
-      ctx.PNocall = parsef(val_parts[1], ctx.PNocall)
-    } else if val_parts[0] == "p-nocall-length" {
-      l_parts := strings.Split(val_parts[1], ",")
-      L := len(l_parts)
-      if L >= 2 { L = 2 }
-      for ii:=0; ii<L; ii++ {
-        ctx.NocallLen[ii] = parsei(l_parts[ii], ctx.NocallLen[ii])
-      }
+// Set a single parameter.  Keys are the same for the
+// parameter string and the JSON parameter file.
+//
+func random_stream_context_set(ctx *RandomStreamContext, key, val string) {
+
+  if key == "allele" {
+    ctx.Allele = parsei(val, ctx.Allele)
+  } else if key == "n" {
+    ctx.N = parsei(val, ctx.N)
+  } else if key == "seed" {
+    ctx.Seed = parsei64(val, ctx.Seed)
+  } else if key == "ref-seed" {
+    ctx.RefSeed = parsei64(val, ctx.RefSeed)
+  } else if key == "pos" {
+    ctx.Pos = parseui64(val, ctx.Pos)
+  } else if key == "chrom" {
+    ctx.Chrom = val
+  } else if key == "comment" {
+    ctx.Comment = val
+  } else if key == "lfmod" {
+    ctx.LFMod = parsei(val, ctx.LFMod)
+  } else if key == "ref-fasta" {
+    ctx.RefFasta = val
+  } else if key == "region" {
+    ctx.Region = val
+  } else if key == "truth-vcf" {
+    ctx.TruthVCF = val
+  } else if key == "sample" {
+    ctx.Sample = val
+
+  } else if key == "p-nocall-locked" {
+    ctx.PNocallLocked = parsef(val, ctx.PNocallLocked)
+  } else if key == "p-nocall" {
+    ctx.PNocall = parsef(val, ctx.PNocall)
+  } else if key == "p-nocall-length" {
+    parse_range(val, ctx.NocallLen)
+
+  } else if key == "p-nocall-cluster" {
+    ctx.PNocallCluster = parsef(val, ctx.PNocallCluster)
+  } else if key == "nocall-cluster-length" {
+    parse_range(val, ctx.NocallClusterLen)
+  } else if key == "p-nocall-in-cluster" {
+    ctx.PNocallInCluster = parsef(val, ctx.PNocallInCluster)
+
+  } else if key == "p-snp-locked" {
+    ctx.PSnpLocked = parsef(val, ctx.PSnpLocked)
+  } else if key == "p-snp" {
+    ctx.PSnp = parsef(val, ctx.PSnp)
+  } else if key == "p-snp-nocall" {
+    ctx.PSnpNocall = parsef(val, ctx.PSnpNocall)
+
+  } else if key == "p-indel-locked" {
+    ctx.PIndelLocked = parsef(val, ctx.PIndelLocked)
+  } else if key == "p-indel" {
+    ctx.PIndel = parsef(val, ctx.PIndel)
+  } else if key == "p-indel-nocall" {
+    ctx.PIndelNocall = parsef(val, ctx.PIndelNocall)
+  } else if key == "p-indel-length" {
+    parse_range(val, ctx.IndelLen)
+
+  } else if key == "indel-model" {
+    ctx.IndelModel = val
+  } else if key == "indel-geometric-p" {
+    ctx.IndelGeomP = parsef(val, ctx.IndelGeomP)
+  } else if key == "indel-length-weight" {
+    ctx.IndelLenWeight = parsef_list(val)
+    ctx.IndelModel = "empirical"
+
+  // Ratio of heterozygous to homozygous variants.  Sets
+  // the locked (homozygous) probability of SNPs and indels.
+  //
+  } else if key == "het-hom-ratio" {
+    r := parsef(val, -1)
+    if r >= 0 {
+      ctx.PSnpLocked = 1.0/(1.0+r)
+      ctx.PIndelLocked = 1.0/(1.0+r)
+    }
 
-    } else if val_parts[0] == "p-snp-locked" {
-      ctx.PSnpLocked = parsef(val_parts[1], ctx.PSnpLocked)
-    } else if val_parts[0] == "p-snp" {
-      ctx.PSnp = parsef(val_parts[1], ctx.PSnp)
-    } else if val_parts[0] == "p-snp-nocall" {
-      ctx.PSnpNocall = parsef(val_parts[1], ctx.PSnpNocall)
-
-    } else if val_parts[0] == "p-indel-locked" {
-      ctx.PIndelLocked = parsef(val_parts[1], ctx.PIndelLocked)
-    } else if val_parts[0] == "p-indel" {
-      ctx.PIndel = parsef(val_parts[1], ctx.PIndel)
-    } else if val_parts[0] == "p-indel-length" {
-      l_parts := strings.Split(val_parts[1], ",")
-      L := len(l_parts)
-      if L >= 2 { L = 2 }
-      for ii:=0; ii<L; ii++ {
-        ctx.IndelLen[ii] = parsei(l_parts[ii], ctx.IndelLen[ii])
+  } else if key == "ts-tv" {
+    r := parsef(val, -1)
+    if r >= 0 { ctx.SubWeight = tstv_sub_weight(r) }
+
+  // 16 weights, reference base by row, alternate base by
+  // column, both in 'a','c','g','t' order.
+  //
+  } else if key == "substitution" {
+    w := parsef_list(val)
+    if len(w)==16 {
+      ord := []int{0,1,3,2}
+      ctx.SubWeight = make([][]float64, 4)
+      for r:=0; r<4; r++ {
+        ctx.SubWeight[ord[r]] = make([]float64, 4)
+        for c:=0; c<4; c++ {
+          ctx.SubWeight[ord[r]][ord[c]] = w[4*r+c]
+        }
+        ctx.SubWeight[ord[r]][ord[r]] = 0
       }
+    }
+  }
 
+}
 
-    } else if val_parts[0] == "p-indel-locked" {
-      ctx.PIndelLocked = parsef(val_parts[1], ctx.PIndelLocked)
-    } else if val_parts[0] == "p-indel" {
-      ctx.PIndel = parsef(val_parts[1], ctx.PIndel)
-    } else if val_parts[0] == "p-indel-nocall" {
-      ctx.PIndelNocall = parsef(val_parts[1], ctx.PIndelNocall)
-    } else if val_parts[0] == "p-indel-length" {
-      l_parts := strings.Split(val_parts[1], ",")
-      L := len(l_parts)
-      if L >= 2 { L = 2 }
-      for ii:=0; ii<L; ii++ {
-        ctx.IndelLen[ii] = parsei(l_parts[ii], ctx.IndelLen[ii])
-      }
+// Parameters that set others ("het-hom-ratio", "ts-tv") are applied
+// first so the more specific parameters take precedence, the rest in
+// key order.
+//
+func random_stream_context_set_map(ctx *RandomStreamContext, m map[string]string) {
+  first := []string{"het-hom-ratio", "ts-tv"}
 
+  keys := []string{}
+  for key := range m { keys = append(keys, key) }
+  sort.Strings(keys)
 
-    }
+  for ii:=0; ii<len(first); ii++ {
+    if val,ok := m[first[ii]] ; ok { random_stream_context_set(ctx, first[ii], val) }
+  }
 
+  for ii:=0; ii<len(keys); ii++ {
+    if (keys[ii]==first[0]) || (keys[ii]==first[1]) { continue }
+    random_stream_context_set(ctx, keys[ii], m[keys[ii]])
   }
+}
 
+func random_stream_context_seed(ctx *RandomStreamContext, orig_seed, orig_ref_seed int64) {
   if ctx.Seed != orig_seed {
     src := rand.NewSource(ctx.Seed)
     rnd := rand.New(src)
@@ -201,11 +339,208 @@ func random_stream_context_from_param(param string) *RandomStreamContext {
     rnd := rand.New(src)
     ctx.RefRnd = rnd
   }
+}
+
+func random_stream_context_from_param(param string) *RandomStreamContext {
+  ctx := default_random_stream_context()
+
+  orig_seed := ctx.Seed
+  orig_ref_seed := ctx.RefSeed
+
+  if param=="" { return ctx }
+
+  random_stream_param_apply(ctx, param)
+  random_stream_context_seed(ctx, orig_seed, orig_ref_seed)
 
   return ctx
 
 }
 
+// Parameters are ':' separated key=value pairs.  A part without an
+// '=' is taken to be the continuation of the previous value, so values
+// can hold a ':' (e.g. "region=chr1:1001-2000").
+//
+func param_key_val(param string) [][2]string {
+  kv := [][2]string{}
+  param_parts := strings.Split(param, ":")
+  for i:=0; i<len(param_parts); i++ {
+    for (i+1<len(param_parts)) && !strings.Contains(param_parts[i+1], "=") {
+      param_parts[i+1] = param_parts[i] + ":" + param_parts[i+1]
+      i++
+    }
+
+    val_parts := strings.SplitN(param_parts[i], "=", 2)
+    if len(val_parts)!=2 { continue }
+    kv = append(kv, [2]string{val_parts[0], val_parts[1]})
+  }
+  return kv
+}
+
+func random_stream_param_apply(ctx *RandomStreamContext, param string) {
+  kv := param_key_val(param)
+  for ii:=0; ii<len(kv); ii++ {
+    random_stream_context_set(ctx, kv[ii][0], kv[ii][1])
+  }
+}
+
+// JSON values as parameter strings.  Lists (and lists of
+// lists) are flattened to comma separated values.
+//
+func _json_param_str(sj *sloppyjson.SloppyJSON) string {
+  if sj.L!=nil {
+    v := []string{}
+    for ii:=0; ii<len(sj.L); ii++ { v = append(v, _json_param_str(sj.L[ii])) }
+    return strings.Join(v, ",")
+  }
+  if len(sj.S)>0 { return sj.S }
+  if (sj.Y=="true") || (sj.Y=="false") { return sj.Y }
+  return strconv.FormatFloat(sj.P, 'f', -1, 64)
+}
+
+// Random stream context from a JSON parameter file.  Top level
+// keys are the same as the parameter string.  "chromosomes" holds
+// a list of segments, each with its own parameters (e.g. "chrom",
+// "n", "p-snp"), generated one after the other.  `param`, if
+// non-empty, is applied after the file.
+//
+//   {
+//     "ref-seed":1234, "seed":5678,
+//     "ts-tv":2.1, "het-hom-ratio":1.6,
+//     "indel-model":"geometric", "indel-geometric-p":0.6, "p-indel-length":[1,20],
+//     "p-nocall-cluster":0.002, "nocall-cluster-length":[50,300],
+//     "chromosomes":[ {"chrom":"chr1","n":5000}, {"chrom":"chr2","n":3000,"p-snp":0.01} ]
+//   }
+//
+func random_stream_context_from_json_file(fn string, param string) (*RandomStreamContext, error) {
+  ctx := default_random_stream_context()
+
+  orig_seed := ctx.Seed
+  orig_ref_seed := ctx.RefSeed
+
+  b,e := ioutil.ReadFile(fn)
+  if e!=nil { return nil, e }
+
+  sj,e := sloppyjson.Loads(string(b))
+  if e!=nil { return nil, fmt.Errorf("%s: %v", fn, e) }
+  if sj.O==nil { return nil, fmt.Errorf("%s: expected JSON object", fn) }
+
+  m := make(map[string]string)
+  for key,val := range sj.O {
+    if key == "chromosomes" { continue }
+    m[key] = _json_param_str(val)
+  }
+  random_stream_context_set_map(ctx, m)
+
+  if seg,ok := sj.O["chromosomes"] ; ok {
+    for ii:=0; ii<len(seg.L); ii++ {
+      if seg.L[ii].O==nil { return nil, fmt.Errorf("%s: chromosome segment %d is not an object", fn, ii) }
+      m := make(map[string]string)
+      for key,val := range seg.L[ii].O {
+        if (key=="seed") || (key=="ref-seed") { return nil, fmt.Errorf("%s: seeds can only be set at the top level", fn) }
+        m[key] = _json_param_str(val)
+      }
+      ctx.Segment = append(ctx.Segment, m)
+    }
+  }
+
+  if len(param)>0 { random_stream_param_apply(ctx, param) }
+  random_stream_context_seed(ctx, orig_seed, orig_ref_seed)
+
+  return ctx, nil
+}
+
+// Load the reference region for the segment, if there is one.
+//
+func random_stream_load_region(ctx *RandomStreamContext) error {
+  if (len(ctx.RefFasta)==0) && (len(ctx.Region)==0) { return nil }
+  if len(ctx.RefFasta)==0 { return fmt.Errorf("region '%s' given without ref-fasta", ctx.Region) }
+  if len(ctx.Region)==0 { return fmt.Errorf("ref-fasta given without region") }
+
+  chrom,beg,seq,anchor,e := load_fasta_region(ctx.RefFasta, ctx.Region)
+  if e!=nil { return e }
+
+  ctx.Chrom = chrom
+  ctx.Pos = uint64(beg)
+  ctx.N = len(seq)
+  ctx.RefSeq = seq
+  ctx.RefAnchor = anchor
+  return nil
+}
+
+// Parameters for each segment to be generated.  Segments share
+// the random number generators so they follow on from each other.
+//
+func random_stream_segments(ctx *RandomStreamContext) ([]*RandomStreamContext, error) {
+  if len(ctx.Segment)==0 {
+    e := random_stream_load_region(ctx)
+    return []*RandomStreamContext{ctx}, e
+  }
+
+  seg_ctx := []*RandomStreamContext{}
+  for ii:=0; ii<len(ctx.Segment); ii++ {
+    s := *ctx
+    s.NocallLen = append([]int{}, ctx.NocallLen...)
+    s.IndelLen = append([]int{}, ctx.IndelLen...)
+    s.NocallClusterLen = append([]int{}, ctx.NocallClusterLen...)
+    s.Segment = nil
+
+    random_stream_context_set_map(&s, ctx.Segment[ii])
+    e := random_stream_load_region(&s)
+    if e!=nil { return nil, e }
+
+    seg_ctx = append(seg_ctx, &s)
+  }
+
+  return seg_ctx, nil
+}
+
+// Indel length, in reference or alternate bases, from
+// the configured model.
+//
+func random_indel_len(ctx *RandomStreamContext) int {
+  rnd := ctx.Rnd
+
+  if ctx.IndelModel == "geometric" {
+    l := ctx.IndelLen[0]
+    for (l < ctx.IndelLen[1]-1) && (rnd.Float64() >= ctx.IndelGeomP) { l++ }
+    return l
+  }
+
+  if (ctx.IndelModel == "empirical") && (len(ctx.IndelLenWeight)>0) {
+    tot := 0.0
+    for ii:=0; ii<len(ctx.IndelLenWeight); ii++ { tot += ctx.IndelLenWeight[ii] }
+    p := rnd.Float64()*tot
+    for ii:=0; ii<len(ctx.IndelLenWeight); ii++ {
+      p -= ctx.IndelLenWeight[ii]
+      if p < 0 { return ii }
+    }
+    return len(ctx.IndelLenWeight)-1
+  }
+
+  return rnd.Intn(ctx.IndelLen[1] - ctx.IndelLen[0]) + ctx.IndelLen[0]
+}
+
+// Alternate base for a SNP on `ref_bp` from the
+// substitution weights.
+//
+func random_sub_bp(ctx *RandomStreamContext, ref_bp byte) byte {
+  r := 0
+  for ; r<4; r++ { if _ibp(r)==ref_bp { break } }
+  if r==4 { return random_alt_bp(ctx) }
+
+  w := ctx.SubWeight[r]
+  tot := 0.0
+  for ii:=0; ii<4; ii++ { tot += w[ii] }
+  if tot <= 0 { return ref_bp }
+
+  p := ctx.Rnd.Float64()*tot
+  for ii:=0; ii<4; ii++ {
+    p -= w[ii]
+    if p < 0 { return _ibp(ii) }
+  }
+  return _ibp(3)
+}
+
 func random_state_pick(ctx *RandomStreamContext) (int,[]int) {
 
   rnd := ctx.Rnd
@@ -229,6 +564,17 @@ func random_state_pick(ctx *RandomStreamContext) (int,[]int) {
     return pasta.NOC, _z
   }
 
+  // With substitution weights the SNP base depends on the
+  // reference base, so it's picked when the stream is written
+  // (code 5).  Heterozygous SNPs then have a single alternate
+  // allele, the others being reference (code -1).
+  //
+  snp_code := func(a int) int {
+    if ctx.SubWeight==nil { return rnd.Intn(4) }
+    if a==0 { return 5 }
+    return -1
+  }
+
   p = rnd.Float64()
   if p < ctx.PSnp {
     p = rnd.Float64()
@@ -237,7 +583,7 @@ func random_state_pick(ctx *RandomStreamContext) (int,[]int) {
     if p_snp_noc < ctx.PSnpNocall {
       _z = append(_z, 4)
     } else {
-      _z = append(_z, rnd.Intn(4))
+      _z = append(_z, snp_code(0))
     }
     if p >= ctx.PSnpLocked {
       for a:=1; a<ctx.Allele; a++ {
@@ -245,9 +591,13 @@ func random_state_pick(ctx *RandomStreamContext) (int,[]int) {
         if p_snp_noc < ctx.PSnpNocall {
           _z = append(_z, 4)
         } else {
-          _z = append(_z, rnd.Intn(4))
+          _z = append(_z, snp_code(a))
         }
       }
+      if ctx.SubWeight!=nil {
+        k := rnd.Intn(ctx.Allele)
+        _z[0],_z[k] = _z[k],_z[0]
+      }
     } else {
       for a:=1; a<ctx.Allele; a++ {
         _z = append(_z, _z[0])
@@ -258,14 +608,14 @@ func random_state_pick(ctx *RandomStreamContext) (int,[]int) {
 
   p = rnd.Float64()
   if p < ctx.PIndel {
-    _z = append(_z, rnd.Intn(ctx.IndelLen[1] - ctx.IndelLen[0]) + ctx.IndelLen[0])
+    _z = append(_z, random_indel_len(ctx))
     p = rnd.Float64()
     if p >= ctx.PIndelLocked {
       for a:=0; a<ctx.Allele; a++ {
-        _z = append(_z, rnd.Intn(ctx.IndelLen[1] - ctx.IndelLen[0]) + ctx.IndelLen[0])
+        _z = append(_z, random_indel_len(ctx))
       }
     } else {
-      _z = append(_z, rnd.Intn(ctx.IndelLen[1] - ctx.IndelLen[0]) + ctx.IndelLen[0])
+      _z = append(_z, random_indel_len(ctx))
       for a:=1; a<ctx.Allele; a++ {
         _z = append(_z, _z[1])
       }
@@ -278,7 +628,33 @@ func random_state_pick(ctx *RandomStreamContext) (int,[]int) {
   return pasta.REF, _z
 }
 
+// Whether the next `n` reference bases from the reference
+// FASTA region (at least one) include an unknown base.  Without
+// a known base before them to anchor on, the base after them is
+// included.
+//
+func random_ref_unknown(ctx *RandomStreamContext, prev_ref_bp byte, n int) bool {
+  if ctx.RefSeq==nil { return false }
+
+  if n<1 { n = 1 }
+  if (prev_ref_bp==0) || (prev_ref_bp=='n') { n++ }
+
+  for ii:=ctx.ref_count; (ii<ctx.ref_count+n) && (ii<len(ctx.RefSeq)); ii++ {
+    if ctx.RefSeq[ii]=='n' { return true }
+  }
+  return false
+}
+
 func random_ref_bp(ctx *RandomStreamContext) byte {
+
+  if ctx.RefSeq!=nil {
+    ref_bp := byte('n')
+    if ctx.ref_count < len(ctx.RefSeq) { ref_bp = ctx.RefSeq[ctx.ref_count] }
+    ctx.ref_count++
+    if ctx.Truth!=nil { ctx.Truth.RefBase(ref_bp) }
+    return ref_bp
+  }
+
   //rnd := ctx.Rnd
   rnd := ctx.RefRnd
 
@@ -296,6 +672,9 @@ func random_ref_bp(ctx *RandomStreamContext) byte {
     ref_bp = 'g'
   }
 
+  ctx.ref_count++
+  if ctx.Truth!=nil { ctx.Truth.RefBase(ref_bp) }
+
   return ref_bp
 }
 
@@ -335,9 +714,27 @@ func _ibp(_i int) byte {
   return '-'
 }
 
-func random_ref_stream(ctx *RandomStreamContext) {
+func random_ref_stream(ctx *RandomStreamContext, w io.Writer) error {
+
+  out := bufio.NewWriter(w)
+
+  // Segments are marked with the chromosome and
+  // position they start at.
+  //
+  segs,e := random_stream_segments(ctx)
+  if e!=nil { return e }
+  for ii:=0; ii<len(segs); ii++ {
+    if len(ctx.Segment)>0 {
+      out.WriteString( fmt.Sprintf(">C{%s}>P{%d}\n", segs[ii].Chrom, segs[ii].Pos) )
+    }
+    random_ref_stream_segment(segs[ii], out)
+  }
+
+  return out.Flush()
+}
+
+func random_ref_stream_segment(ctx *RandomStreamContext, out *bufio.Writer) {
 
-  out := bufio.NewWriter(os.Stdout)
   o_count:=0
   for bp_count:=0; bp_count<ctx.N; bp_count++ {
 
@@ -353,17 +750,42 @@ func random_ref_stream(ctx *RandomStreamContext) {
   }
 
   out.WriteByte('\n')
-  out.Flush()
 }
 
-func random_stream(ctx *RandomStreamContext) {
+func random_stream_write(ctx *RandomStreamContext, w io.Writer) error {
 
-  out := bufio.NewWriter(os.Stdout)
+  out := bufio.NewWriter(w)
 
   if ctx==nil {
     ctx = default_random_stream_context()
   }
 
+  segs,e := random_stream_segments(ctx)
+  if e!=nil { return e }
+
+  if len(ctx.TruthVCF)>0 {
+    fp,e := os.Create(ctx.TruthVCF)
+    if e!=nil { return e }
+    defer fp.Close()
+
+    truth := &RandomTruthVCF{}
+    truth.Out = bufio.NewWriter(fp)
+    truth.Sample = ctx.Sample
+    truth.Header(segs)
+    for ii:=0; ii<len(segs); ii++ { segs[ii].Truth = truth }
+    defer truth.Flush()
+  }
+
+  for ii:=0; ii<len(segs); ii++ {
+    if segs[ii].Truth!=nil { segs[ii].Truth.Segment(segs[ii].Chrom, segs[ii].Pos) }
+    random_stream_segment(segs[ii], out)
+  }
+
+  return out.Flush()
+}
+
+func random_stream_segment(ctx *RandomStreamContext, out *bufio.Writer) {
+
   out.WriteString( fmt.Sprintf(">C{%s}>P{%d}", ctx.Chrom, ctx.Pos) )
   if len(ctx.Comment)>0 {
     out.WriteString( fmt.Sprintf(">#{%s}", ctx.Comment) )
@@ -372,10 +794,44 @@ func random_stream(ctx *RandomStreamContext) {
 
   o_count:=0
 
+  // No-call probability outside of clusters
+  //
+  p_nocall := ctx.PNocall
+  defer func() { ctx.PNocall = p_nocall }()
+
+  cluster_rem := 0
+  last_count := 0
+
+  // Reference base before the current locus, to
+  // anchor indels in the truth VCF
+  //
+  prev_ref_bp := ctx.RefAnchor
+
   for ref_bp_count:=0; ref_bp_count<ctx.N; {
 
+    cluster_rem -= ref_bp_count - last_count
+    last_count = ref_bp_count
+
+    if ctx.PNocallCluster > 0 {
+      if (cluster_rem<=0) && (ctx.Rnd.Float64() < ctx.PNocallCluster) {
+        cluster_rem = ctx.Rnd.Intn(ctx.NocallClusterLen[1] - ctx.NocallClusterLen[0]) + ctx.NocallClusterLen[0]
+      }
+
+      ctx.PNocall = p_nocall
+      if cluster_rem>0 { ctx.PNocall = ctx.PNocallInCluster }
+    }
+
     state,lparts := random_state_pick(ctx)
 
+    // No variants on unknown reference bases, as
+    // they couldn't be reported in the truth VCF
+    //
+    if (state==pasta.SNP) || (state==pasta.INDEL) {
+      if random_ref_unknown(ctx, prev_ref_bp, lparts[0]) {
+        state,lparts = pasta.REF, []int{1}
+      }
+    }
+
     /*
     for a:=0; a<len(lparts); a++ {
       if ref_bp_count+lparts[a] > ctx.N { lparts[a] = ctx.N-ref_bp_count }
@@ -400,6 +856,7 @@ func random_stream(ctx *RandomStreamContext) {
           }
         }
         ref_bp_count++
+        prev_ref_bp = ref_bp
 
       }
 
@@ -408,12 +865,22 @@ func random_stream(ctx *RandomStreamContext) {
     } else if state==pasta.SNP {
 
       ref_bp := random_ref_bp(ctx)
+      sub_bp := byte(0)
+      snp_alt := [][]byte{}
 
       for a:=0; a<ctx.Allele; a++ {
 
-        // lparts holds mapping of int value 0-5 -> (a,c,g,t,n)
+        // lparts holds mapping of int value 0-5 -> (a,c,g,t,n),
+        // 5 for a base picked from the substitution weights
+        // (shared by all alleles with it) or -1 for reference.
         //
         snp := _ibp(lparts[a])
+        if lparts[a] == 5 {
+          if sub_bp == 0 { sub_bp = random_sub_bp(ctx, ref_bp) }
+          snp = sub_bp
+        } else if lparts[a] < 0 {
+          snp = ref_bp
+        }
 
         if ref_bp == snp {
           out.WriteByte(ref_bp)
@@ -421,13 +888,17 @@ func random_stream(ctx *RandomStreamContext) {
           out.WriteByte(pasta.SubMap[ref_bp][snp])
         }
         o_count++
+        snp_alt = append(snp_alt, []byte{snp})
 
         if (ctx.LFMod>0) && (o_count>0) && ((o_count%ctx.LFMod)==0) {
           out.WriteByte('\n')
         }
 
       }
+
+      if ctx.Truth!=nil { ctx.Truth.Variant(ref_bp_count, prev_ref_bp, []byte{ref_bp}, snp_alt) }
       ref_bp_count++
+      prev_ref_bp = ref_bp
 
     } else if state==pasta.NOC {
 
@@ -447,23 +918,29 @@ func random_stream(ctx *RandomStreamContext) {
           }
         }
         ref_bp_count++
+        prev_ref_bp = ref_bp
 
       }
       continue
 
     } else if state==pasta.INDEL {
 
+      // chop off overflowing parts (both ref and alt parts)
+      // so the reference stays in step with ref-rstream
+      //
+      for a:=0; a<len(lparts); a++ {
+        if ref_bp_count+lparts[a] > ctx.N { lparts[a] = ctx.N-ref_bp_count }
+      }
+
       ref_len := lparts[0]
       max_len := lparts[0]
       for ii:=1; ii<len(lparts); ii++ {
         if max_len < lparts[ii] { max_len = lparts[ii] }
       }
 
-      // chop off overflowing parts (both ref and alt parts)
-      //
-      for a:=0; a<len(lparts); a++ {
-        if ref_bp_count+lparts[a] > ctx.N { lparts[a] = ctx.N-ref_bp_count }
-      }
+      indel_pos := ref_bp_count
+      indel_ref := []byte{}
+      indel_alt := make([][]byte, ctx.Allele)
 
       for ii:=0; ii<max_len; ii++ {
 
@@ -471,6 +948,7 @@ func random_stream(ctx *RandomStreamContext) {
         if ii<ref_len {
           ref_bp = random_ref_bp(ctx)
           ref_bp_count++
+          indel_ref = append(indel_ref, ref_bp)
         }
 
         for a:=0; a<ctx.Allele; a++ {
@@ -488,6 +966,7 @@ func random_stream(ctx *RandomStreamContext) {
 
           out.WriteByte(pasta.SubMap[ref_bp][alt_bp])
           o_count++
+          if alt_bp!='-' { indel_alt[a] = append(indel_alt[a], alt_bp) }
 
           if (ctx.LFMod>0) && (o_count>0) && ((o_count%ctx.LFMod)==0) {
             out.WriteByte('\n')
@@ -496,6 +975,9 @@ func random_stream(ctx *RandomStreamContext) {
 
       }
 
+      if ctx.Truth!=nil { ctx.Truth.Variant(indel_pos, prev_ref_bp, indel_ref, indel_alt) }
+      if len(indel_ref)>0 { prev_ref_bp = indel_ref[len(indel_ref)-1] }
+
       continue
 
     }
@@ -503,7 +985,6 @@ func random_stream(ctx *RandomStreamContext) {
   }
 
   out.WriteByte('\n')
-  out.Flush()
 
 }
 
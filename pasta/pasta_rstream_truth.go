@@ -0,0 +1,279 @@
+package main
+
+// Random variants on a real reference.  A region of a reference FASTA
+// is used in place of the random reference and the variants spiked into
+// it are written out as a truth VCF alongside the rotini stream.
+//
+
+import "fmt"
+import "bytes"
+import "bufio"
+import "strconv"
+import "strings"
+
+import "github.com/abeconnelly/autoio"
+
+import "github.com/abeconnelly/pasta"
+
+// Parse a region of the form "chr1:1001-2000" (1-based, inclusive)
+// or "chr1" for the whole sequence.  Returns the 0-based start
+// and end, with -1 for the end of the sequence.
+//
+func _parse_region(region string) (string, int, int, error) {
+  colon := strings.LastIndex(region, ":")
+  if colon<0 { return region, 0, -1, nil }
+
+  chrom := region[:colon]
+  r := strings.Split(strings.Replace(region[colon+1:], ",", "", -1), "-")
+  if len(r)!=2 { return "", 0, 0, fmt.Errorf("invalid region '%s'", region) }
+
+  beg,e := strconv.Atoi(r[0])
+  if e!=nil { return "", 0, 0, fmt.Errorf("invalid region '%s': %v", region, e) }
+  end,e := strconv.Atoi(r[1])
+  if e!=nil { return "", 0, 0, fmt.Errorf("invalid region '%s': %v", region, e) }
+  if (beg<1) || (end<beg) { return "", 0, 0, fmt.Errorf("invalid region '%s'", region) }
+
+  return chrom, beg-1, end, nil
+}
+
+// Reference bases, lower case, with anything other than
+// 'a', 'c', 'g' or 't' taken as 'n'.
+//
+func _ref_fasta_bp(ch byte) byte {
+  ch = pasta.ToLower(ch)
+  if (ch=='a') || (ch=='c') || (ch=='g') || (ch=='t') { return ch }
+  return 'n'
+}
+
+// Load the region of the FASTA file.  The base before the region,
+// if any, is returned as the anchor for variants at the start of
+// the region (0 otherwise).
+//
+func load_fasta_region(fn, region string) (string, int, []byte, byte, error) {
+  chrom,beg,end,e := _parse_region(region)
+  if e!=nil { return "", 0, nil, 0, e }
+
+  ain,e := autoio.OpenReadScanner(fn)
+  if e!=nil { return "", 0, nil, 0, e }
+  defer ain.Close()
+
+  seq := []byte{}
+  var anchor byte
+  found := false
+  pos := 0
+
+  for ain.ReadScan() {
+    line := strings.TrimSpace(ain.ReadText())
+    if len(line)==0 { continue }
+
+    if line[0]=='>' {
+      if found { break }
+      name := strings.Fields(line[1:])
+      found = (len(name)>0) && (name[0]==chrom)
+      continue
+    }
+    if !found { continue }
+
+    for ii:=0; ii<len(line); ii++ {
+      if (end>=0) && (pos>=end) { break }
+      if pos==beg-1 { anchor = _ref_fasta_bp(line[ii]) }
+      if pos>=beg { seq = append(seq, _ref_fasta_bp(line[ii])) }
+      pos++
+    }
+    if (end>=0) && (pos>=end) { break }
+  }
+
+  if !found { return "", 0, nil, 0, fmt.Errorf("sequence '%s' not found in %s", chrom, fn) }
+  if (end>=0) && (pos<end) { return "", 0, nil, 0, fmt.Errorf("region '%s' runs past the end of '%s' (%d)", region, chrom, pos) }
+  if len(seq)==0 { return "", 0, nil, 0, fmt.Errorf("region '%s' is empty", region) }
+
+  return chrom, beg, seq, anchor, nil
+}
+
+type _truth_rec struct {
+  pos uint64
+  ref []byte
+  alt [][]byte
+
+  // End of the record, in reference bases from the
+  // start of the segment (exclusive)
+  //
+  end int
+}
+
+// Truth VCF for the variants generated by rstream.  Genotypes
+// are phased, in the allele order of the rotini stream.
+//
+type RandomTruthVCF struct {
+  Out *bufio.Writer
+  Sample string
+
+  chrom string
+  start uint64
+
+  // Last record, held back in case the next variant needs
+  // to be anchored on its last base.
+  //
+  last *_truth_rec
+
+  // Indel waiting on the base after it, for
+  // lack of a base before it to anchor on.
+  //
+  pending *_truth_rec
+}
+
+func (t *RandomTruthVCF) Header(segs []*RandomStreamContext) {
+  t.Out.WriteString("##fileformat=VCFv4.2\n")
+  t.Out.WriteString("##source=pasta rstream\n")
+  seen := make(map[string]bool)
+  for ii:=0; ii<len(segs); ii++ {
+    if seen[segs[ii].Chrom] { continue }
+    seen[segs[ii].Chrom] = true
+    t.Out.WriteString(fmt.Sprintf("##contig=<ID=%s>\n", segs[ii].Chrom))
+  }
+  t.Out.WriteString("##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">\n")
+  t.Out.WriteString("#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t" + t.Sample + "\n")
+}
+
+// Start of a segment (chromosome) at reference position `start`.
+//
+func (t *RandomTruthVCF) Segment(chrom string, start uint64) {
+  t.Flush()
+  t.chrom = chrom
+  t.start = start
+}
+
+func (t *RandomTruthVCF) _write(rec *_truth_rec) {
+  ref := bytes.ToUpper(rec.ref)
+
+  alt := [][]byte{}
+  gt := []string{}
+  for a:=0; a<len(rec.alt); a++ {
+    seq := bytes.ToUpper(rec.alt[a])
+    if bytes.IndexByte(seq, 'N')>=0 {
+      gt = append(gt, ".")
+      continue
+    }
+    if bytes.Equal(seq, ref) {
+      gt = append(gt, "0")
+      continue
+    }
+
+    idx := -1
+    for ii:=0; ii<len(alt); ii++ {
+      if bytes.Equal(alt[ii], seq) { idx = ii ; break }
+    }
+    if idx<0 {
+      alt = append(alt, seq)
+      idx = len(alt)-1
+    }
+    gt = append(gt, fmt.Sprintf("%d", idx+1))
+  }
+
+  if len(alt)==0 { return }
+
+  alt_str := []string{}
+  for ii:=0; ii<len(alt); ii++ { alt_str = append(alt_str, string(alt[ii])) }
+
+  t.Out.WriteString(fmt.Sprintf("%s\t%d\t.\t%s\t%s\t.\tPASS\t.\tGT\t%s\n",
+    t.chrom, rec.pos, ref, strings.Join(alt_str, ","), strings.Join(gt, "|")))
+}
+
+func (t *RandomTruthVCF) _hold(rec *_truth_rec) {
+  if t.last!=nil { t._write(t.last) }
+  t.last = rec
+}
+
+// Record a variant at `offset` reference bases into the segment.
+// `anchor` is the reference base before it (0 if none), used when
+// the alleles differ in length.  A variant that needs anchoring
+// right after the previous one is merged into it, so records don't
+// overlap.  Variants on unknown reference bases are not reported.
+//
+func (t *RandomTruthVCF) Variant(offset int, anchor byte, ref []byte, alt [][]byte) {
+  if bytes.IndexByte(ref, 'n')>=0 { return }
+
+  rec := _truth_rec{}
+  rec.pos = t.start + uint64(offset) + 1
+  rec.ref = append([]byte{}, ref...)
+  rec.end = offset + len(ref)
+
+  same_len := len(ref)>0
+  for a:=0; a<len(alt); a++ {
+    rec.alt = append(rec.alt, append([]byte{}, alt[a]...))
+    if len(alt[a])!=len(ref) { same_len = false }
+  }
+
+  // Insertion before the base a pending record is waiting on
+  //
+  if (t.pending!=nil) && (len(t.pending.alt)==len(rec.alt)) {
+    t.pending.ref = append(t.pending.ref, rec.ref...)
+    for a:=0; a<len(rec.alt); a++ { t.pending.alt[a] = append(t.pending.alt[a], rec.alt[a]...) }
+    t.pending.end = rec.end
+    return
+  }
+
+  // The previous record was anchored on the base after it, which
+  // turns out to be this variant.  The anchor is swapped for this
+  // variant and the record anchored on the next base instead.
+  //
+  if (t.last!=nil) && (t.last.end>offset) && (len(t.last.alt)==len(rec.alt)) {
+    t.last.ref = append(t.last.ref[:len(t.last.ref)-1], rec.ref...)
+    for a:=0; a<len(rec.alt); a++ {
+      t.last.alt[a] = append(t.last.alt[a][:len(t.last.alt[a])-1], rec.alt[a]...)
+    }
+    t.last.end = rec.end
+    t.pending = t.last
+    t.last = nil
+    return
+  }
+
+  if same_len {
+    t._hold(&rec)
+    return
+  }
+
+  if (t.last!=nil) && (t.last.end==offset) && (len(t.last.alt)==len(rec.alt)) {
+    t.last.ref = append(t.last.ref, rec.ref...)
+    for a:=0; a<len(rec.alt); a++ { t.last.alt[a] = append(t.last.alt[a], rec.alt[a]...) }
+    t.last.end = rec.end
+    return
+  }
+
+  if (anchor!=0) && (anchor!='n') {
+    rec.pos--
+    rec.ref = append([]byte{anchor}, rec.ref...)
+    for a:=0; a<len(rec.alt); a++ { rec.alt[a] = append([]byte{anchor}, rec.alt[a]...) }
+    t._hold(&rec)
+    return
+  }
+
+  if t.last!=nil { t._write(t.last) }
+  t.last = nil
+  t.pending = &rec
+}
+
+// Called with each reference base as it's generated, to
+// anchor a pending indel on the base after it.
+//
+func (t *RandomTruthVCF) RefBase(bp byte) {
+  if t.pending==nil { return }
+  rec := t.pending
+  t.pending = nil
+
+  if bp=='n' { return }
+  rec.ref = append(rec.ref, bp)
+  for a:=0; a<len(rec.alt); a++ { rec.alt[a] = append(rec.alt[a], bp) }
+  rec.end++
+  t._hold(rec)
+}
+
+// An indel left pending at the end of a segment has no
+// reference base on either side and isn't reported.
+//
+func (t *RandomTruthVCF) Flush() error {
+  if t.last!=nil { t._write(t.last) }
+  t.last = nil
+  t.pending = nil
+  return t.Out.Flush()
+}
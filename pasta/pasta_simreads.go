@@ -0,0 +1,370 @@
+package main
+
+// Paired-end read simulation from the haplotypes of a rotini stream.
+//
+// Fragments are drawn uniformly from each haplotype with a normally
+// distributed insert size and read from both ends, one read on each
+// strand.  Sequencing errors are substitutions, with a per base error
+// rate that ramps linearly from `p-error` at the start of the read to
+// `p-error-end` at the end, and the base quality reflects it.
+//
+// Each read name records where the pair came from:
+//
+//   @<prefix><n>:<chrom>:<hap>:<pos1>:<pos2>:<strand>:<err1>:<err2>/1
+//
+// `hap` is the allele (0 or 1), `pos1` and `pos2` are the (0-based)
+// reference positions of the leftmost base of read 1 and read 2,
+// `strand` is '+' if read 1 is on the forward strand of the haplotype
+// and '-' otherwise, and `err1` and `err2` are the number of errors
+// added to each read.  Inserted bases take the reference position of
+// the reference base after them.
+//
+
+import "fmt"
+import "os"
+import "math"
+import "math/rand"
+import "bufio"
+
+import "github.com/codegangsta/cli"
+
+import "github.com/abeconnelly/pasta"
+
+type SimReadsContext struct {
+  Coverage float64
+  ReadLen int
+  InsertMean float64
+  InsertSD float64
+
+  PError float64
+  PErrorEnd float64
+
+  Seed int64
+  Rnd *rand.Rand
+
+  Prefix string
+
+  // Write read 1 and read 2 to separate files,
+  // otherwise pairs are interleaved on the output.
+  //
+  Fastq1 string
+  Fastq2 string
+}
+
+func default_sim_reads_context() *SimReadsContext {
+  ctx := SimReadsContext{}
+  ctx.Coverage = 10
+  ctx.ReadLen = 100
+  ctx.InsertMean = 300
+  ctx.InsertSD = 30
+
+  ctx.PError = 0.001
+  ctx.PErrorEnd = 0.01
+
+  ctx.Seed = 0xabecafe
+  ctx.Prefix = "sim"
+
+  return &ctx
+}
+
+func sim_reads_context_set(ctx *SimReadsContext, key, val string) {
+  if key == "coverage" {
+    ctx.Coverage = parsef(val, ctx.Coverage)
+  } else if key == "read-length" {
+    ctx.ReadLen = parsei(val, ctx.ReadLen)
+  } else if key == "insert-mean" {
+    ctx.InsertMean = parsef(val, ctx.InsertMean)
+  } else if key == "insert-sd" {
+    ctx.InsertSD = parsef(val, ctx.InsertSD)
+  } else if key == "p-error" {
+    ctx.PError = parsef(val, ctx.PError)
+    ctx.PErrorEnd = ctx.PError
+  } else if key == "p-error-end" {
+    ctx.PErrorEnd = parsef(val, ctx.PErrorEnd)
+  } else if key == "seed" {
+    ctx.Seed = int64(parsei(val, int(ctx.Seed)))
+  } else if key == "prefix" {
+    ctx.Prefix = val
+  } else if key == "fastq1" {
+    ctx.Fastq1 = val
+  } else if key == "fastq2" {
+    ctx.Fastq2 = val
+  }
+}
+
+// `p-error` sets the error rate along the whole read, so
+// `p-error-end` needs to come after it.
+//
+func sim_reads_context_from_param(param string) *SimReadsContext {
+  ctx := default_sim_reads_context()
+
+  kv := param_key_val(param)
+  for ii:=0; ii<len(kv); ii++ {
+    if kv[ii][0]=="p-error-end" { continue }
+    sim_reads_context_set(ctx, kv[ii][0], kv[ii][1])
+  }
+  for ii:=0; ii<len(kv); ii++ {
+    if kv[ii][0]!="p-error-end" { continue }
+    sim_reads_context_set(ctx, kv[ii][0], kv[ii][1])
+  }
+
+  ctx.Rnd = rand.New(rand.NewSource(ctx.Seed))
+  return ctx
+}
+
+// Haplotypes of one segment (chromosome and position) of
+// the stream, with the reference position of each base.
+//
+type SimReadsSegment struct {
+  Chrom string
+  RefStart int
+  RefLen int
+
+  Hap [2][]byte
+  HapPos [2][]int
+}
+
+func _sim_read_ref_bp(ref_stream *bufio.Reader) (byte, error) {
+  for {
+    ch,e := ref_stream.ReadByte()
+    if e!=nil { return 0, e }
+    if (ch=='\n') || (ch==' ') || (ch=='\r') || (ch=='\t') { continue }
+    if ch=='>' {
+      _,e = pasta.ControlMessageProcess(ref_stream)
+      if e!=nil { return 0, fmt.Errorf("invalid control message in reference stream") }
+      continue
+    }
+    return pasta.ToLower(ch), nil
+  }
+}
+
+// Split the rotini stream into segments of haplotype sequence.  If
+// `ref_stream` isn't nil, the reference bases of the stream are checked
+// against it.
+//
+func sim_reads_segments(stream *bufio.Reader, ref_stream *bufio.Reader) ([]*SimReadsSegment, error) {
+  segs := []*SimReadsSegment{}
+  cur := &SimReadsSegment{ Chrom:"Unk" }
+  ref_pos := 0
+
+  var col [2]byte
+  n := 0
+
+  for {
+    ch,e := stream.ReadByte()
+    if e!=nil { break }
+    if (ch=='\n') || (ch==' ') || (ch=='\r') || (ch=='\t') { continue }
+
+    if ch=='>' {
+      msg,e := pasta.ControlMessageProcess(stream)
+      if e!=nil { return nil, fmt.Errorf("invalid control message") }
+      if (msg.Type!=pasta.CHROM) && (msg.Type!=pasta.POS) { continue }
+
+      if (cur.RefLen>0) || (len(cur.Hap[0])>0) || (len(cur.Hap[1])>0) {
+        segs = append(segs, cur)
+        cur = &SimReadsSegment{ Chrom:cur.Chrom }
+      }
+
+      if msg.Type==pasta.CHROM { cur.Chrom = msg.Chrom }
+      if msg.Type==pasta.POS {
+        ref_pos = msg.RefPos
+        cur.RefStart = ref_pos
+      }
+      continue
+    }
+
+    col[n] = ch
+    n++
+    if n<2 { continue }
+    n = 0
+
+    if (col[0]=='.') && (col[1]=='.') { continue }
+
+    for a:=0; a<2; a++ {
+      if (col[a]=='.') || pasta.IsAltDel[col[a]] { continue }
+      alt_bp := pasta.AltMap[col[a]]
+      ok := alt_bp!=0
+      if !ok { return nil, fmt.Errorf("invalid token %c at %s:%d", col[a], cur.Chrom, ref_pos) }
+      cur.Hap[a] = append(cur.Hap[a], alt_bp)
+      cur.HapPos[a] = append(cur.HapPos[a], ref_pos)
+    }
+
+    if _is_pasta_ins(col[0]) || _is_pasta_ins(col[1]) { continue }
+
+    if ref_stream!=nil {
+      ref_ch := col[0]
+      if ref_ch=='.' { ref_ch = col[1] }
+
+      bp,e := _sim_read_ref_bp(ref_stream)
+      if e!=nil { return nil, fmt.Errorf("reference stream ended at %s:%d", cur.Chrom, ref_pos) }
+      if (bp!='n') && (pasta.RefMap[ref_ch]!='n') && (bp!=pasta.RefMap[ref_ch]) {
+        return nil, fmt.Errorf("reference mismatch at %s:%d (stream %c, reference %c)", cur.Chrom, ref_pos, pasta.RefMap[ref_ch], bp)
+      }
+    }
+
+    ref_pos++
+    cur.RefLen++
+  }
+
+  if n!=0 { return nil, fmt.Errorf("odd number of tokens in stream") }
+  if (cur.RefLen>0) || (len(cur.Hap[0])>0) || (len(cur.Hap[1])>0) {
+    segs = append(segs, cur)
+  }
+  return segs, nil
+}
+
+// Complement of each base, 'N' for anything that isn't one
+//
+var gSimReadsComp [256]byte
+
+func init() {
+  for ii:=0; ii<256; ii++ { gSimReadsComp[ii] = 'N' }
+
+  fwd := "acgtnACGTN"
+  rev := "tgcanTGCAN"
+  for ii:=0; ii<len(fwd); ii++ { gSimReadsComp[fwd[ii]] = rev[ii] }
+}
+
+func _sim_revcomp(seq []byte) []byte {
+  z := make([]byte, len(seq))
+  for ii:=0; ii<len(seq); ii++ {
+    z[ii] = gSimReadsComp[seq[len(seq)-ii-1]]
+  }
+  return z
+}
+
+// Add sequencing errors to the read (upper case), returning
+// the read, its qualities and the number of errors.
+//
+func (ctx *SimReadsContext) _sequence(seq []byte) ([]byte, []byte, int) {
+  read := make([]byte, len(seq))
+  qual := make([]byte, len(seq))
+  n_err := 0
+
+  for ii:=0; ii<len(seq); ii++ {
+    p := ctx.PError
+    if len(seq)>1 { p += (ctx.PErrorEnd-ctx.PError)*float64(ii)/float64(len(seq)-1) }
+
+    q := 41
+    if p>0 { q = int(-10*math.Log10(p)) }
+    if q<2 { q = 2 }
+    if q>41 { q = 41 }
+    qual[ii] = byte(q+33)
+
+    bp := pasta.ToUpper(seq[ii])
+    if (bp!='N') && (ctx.Rnd.Float64() < p) {
+      bp = "ACGT"[(_sim_bp_idx(bp)+1+ctx.Rnd.Intn(3))%4]
+      n_err++
+    }
+    read[ii] = bp
+  }
+
+  return read, qual, n_err
+}
+
+func _sim_bp_idx(bp byte) int {
+  switch bp {
+  case 'A': return 0
+  case 'C': return 1
+  case 'G': return 2
+  case 'T': return 3
+  }
+  return 0
+}
+
+func _sim_write_fastq(out *bufio.Writer, name string, mate int, read, qual []byte) {
+  out.WriteString(fmt.Sprintf("@%s/%d\n", name, mate))
+  out.Write(read)
+  out.WriteString("\n+\n")
+  out.Write(qual)
+  out.WriteByte('\n')
+}
+
+// Simulate read pairs from both haplotypes of the segment.  Coverage
+// is the total over both haplotypes.  Returns the number of pairs.
+//
+func (ctx *SimReadsContext) Simulate(seg *SimReadsSegment, read_idx int, out1, out2 *bufio.Writer) int {
+  n_pair := 0
+  L := ctx.ReadLen
+
+  for h:=0; h<2; h++ {
+    hap := seg.Hap[h]
+    if len(hap) < L { continue }
+
+    f := ctx.Coverage*float64(len(hap))/float64(2*L*2)
+    n := int(math.Floor(f + ctx.Rnd.Float64()))
+
+    for ii:=0; ii<n; ii++ {
+      frag_len := int(ctx.Rnd.NormFloat64()*ctx.InsertSD + ctx.InsertMean + 0.5)
+      if frag_len < L { frag_len = L }
+      if frag_len > len(hap) { frag_len = len(hap) }
+
+      beg := ctx.Rnd.Intn(len(hap)-frag_len+1)
+      end := beg+frag_len
+
+      fwd := hap[beg:beg+L]
+      rev := _sim_revcomp(hap[end-L:end])
+      fwd_pos,rev_pos := seg.HapPos[h][beg],seg.HapPos[h][end-L]
+
+      strand := "+"
+      seq1,seq2 := fwd,rev
+      pos1,pos2 := fwd_pos,rev_pos
+      if ctx.Rnd.Intn(2)==1 {
+        strand = "-"
+        seq1,seq2 = rev,fwd
+        pos1,pos2 = rev_pos,fwd_pos
+      }
+
+      read1,qual1,err1 := ctx._sequence(seq1)
+      read2,qual2,err2 := ctx._sequence(seq2)
+
+      name := fmt.Sprintf("%s%d:%s:%d:%d:%d:%s:%d:%d",
+        ctx.Prefix, read_idx+n_pair, seg.Chrom, h, pos1, pos2, strand, err1, err2)
+      _sim_write_fastq(out1, name, 1, read1, qual1)
+      _sim_write_fastq(out2, name, 2, read2, qual2)
+
+      n_pair++
+    }
+  }
+
+  return n_pair
+}
+
+func _main_simulate_reads(c *cli.Context, stream *bufio.Reader, out *bufio.Writer) error {
+  ctx := sim_reads_context_from_param(c.String("param"))
+  if ctx.ReadLen < 1 { return fmt.Errorf("invalid read-length %d", ctx.ReadLen) }
+
+  var ref_stream *bufio.Reader
+  if c.String("refstream")!="-" {
+    fp,e := os.Open(c.String("refstream"))
+    if e!=nil { return e }
+    defer fp.Close()
+    ref_stream = bufio.NewReader(fp)
+  }
+
+  segs,e := sim_reads_segments(stream, ref_stream)
+  if e!=nil { return e }
+
+  out1,out2 := out,out
+  if (len(ctx.Fastq1)>0) != (len(ctx.Fastq2)>0) {
+    return fmt.Errorf("fastq1 and fastq2 must be given together")
+  }
+  if len(ctx.Fastq1)>0 {
+    fp1,e := os.Create(ctx.Fastq1)
+    if e!=nil { return e }
+    defer fp1.Close()
+    fp2,e := os.Create(ctx.Fastq2)
+    if e!=nil { return e }
+    defer fp2.Close()
+    out1,out2 = bufio.NewWriter(fp1),bufio.NewWriter(fp2)
+  }
+
+  n_read := 0
+  for ii:=0; ii<len(segs); ii++ {
+    n_read += ctx.Simulate(segs[ii], n_read, out1, out2)
+  }
+
+  e = out1.Flush()
+  if e!=nil { return e }
+  return out2.Flush()
+}
@@ -0,0 +1,100 @@
+package main
+
+import "io"
+import "bufio"
+import "bytes"
+import "testing"
+
+import "github.com/abeconnelly/pasta"
+
+func TestVariantIterator(t *testing.T) {
+  var b bytes.Buffer
+
+  col := func(ch0, ch1 byte) { b.WriteByte(ch0) ; b.WriteByte(ch1) }
+
+  b.WriteString(">C{chr2}>P{100}\n")
+  col('a','a')
+  col('c',pasta.SubMap['c']['t'])
+  col('g','g')
+  b.WriteString("\n>A{PS=7}\n")
+  col(pasta.SubMap['t']['g'],pasta.SubMap['t']['g'])
+  col('a','a')
+  col(pasta.SubMap['c']['a'],pasta.SubMap['c']['g'])
+  col('g','g')
+  col(pasta.SubMap['t']['n'],'t')
+  col('a','a')
+  b.WriteByte('\n')
+
+  type rec struct {
+    vartype int
+    pos int
+    gt [2]int
+    ps int
+  }
+
+  expect := []rec{
+    { pasta.REF, 100, [2]int{0,0}, 0 },
+    { pasta.ALT, 101, [2]int{0,1}, 0 },
+    { pasta.REF, 102, [2]int{0,0}, 0 },
+    { pasta.ALT, 103, [2]int{1,1}, 7 },
+    { pasta.REF, 104, [2]int{0,0}, 7 },
+    { pasta.ALT, 105, [2]int{1,2}, 7 },
+    { pasta.REF, 106, [2]int{0,0}, 7 },
+    { pasta.NOC, 107, [2]int{-1,0}, 7 },
+    { pasta.REF, 108, [2]int{0,0}, 7 },
+  }
+
+  it := pasta.VariantIterator{}
+  it.Init(bufio.NewReader(&b), 0)
+
+  for ii:=0; ; ii++ {
+    v,e := it.Next()
+    if e==io.EOF {
+      if ii!=len(expect) { t.Errorf("got %d records, expected %d", ii, len(expect)) }
+      break
+    }
+    if e!=nil { t.Fatalf("record %d: %v", ii, e) }
+    if ii>=len(expect) { t.Fatalf("unexpected record %d: %+v", ii, *v) }
+
+    x := expect[ii]
+    if (v.Type!=x.vartype) || (v.Pos!=x.pos) || (v.Chrom!="chr2") || (v.PhaseSet!=x.ps) ||
+       (v.Genotype[0]!=x.gt[0]) || (v.Genotype[1]!=x.gt[1]) {
+      t.Errorf("record %d: got type %d chrom %s pos %d gt %v ps %d, expected %+v",
+        ii, v.Type, v.Chrom, v.Pos, v.Genotype, v.PhaseSet, x)
+    }
+  }
+}
+
+// An ALT at the very end of the stream is anchored on the
+// base before it, the same as one in the middle.
+//
+func TestVariantIteratorTrailingAlt(t *testing.T) {
+  alt_ref_bp := func(trailing_ref bool) (byte, bool) {
+    var b bytes.Buffer
+    b.WriteString(">C{chr1}>P{0}")
+    b.WriteString("aagg")
+    b.WriteByte(pasta.SubMap['c']['t'])
+    b.WriteByte(pasta.SubMap['c']['t'])
+    if trailing_ref { b.WriteString("tt") }
+    b.WriteByte('\n')
+
+    it := pasta.VariantIterator{}
+    it.Init(bufio.NewReader(&b), 0)
+
+    for {
+      v,e := it.Next()
+      if e==io.EOF { break }
+      if e!=nil { t.Fatalf("%v", e) }
+      if v.Type==pasta.ALT { return v.RefBP, true }
+    }
+    return 0, false
+  }
+
+  mid,ok := alt_ref_bp(true)
+  if !ok { t.Fatalf("no ALT record mid-stream") }
+  end,ok := alt_ref_bp(false)
+  if !ok { t.Fatalf("no ALT record at the end of the stream") }
+
+  if mid!='g' { t.Errorf("mid-stream ALT anchored on '%c', expected 'g'", mid) }
+  if end!=mid { t.Errorf("trailing ALT anchored on '%c', expected '%c'", end, mid) }
+}
@@ -0,0 +1,382 @@
+package pasta
+
+// Stream to stream conversions.  Each reads from an io.Reader,
+// writes to an io.Writer and returns an error rather than
+// printing it, so they can be used outside of the command line
+// tool.
+//
+
+import "fmt"
+import "io"
+import "bufio"
+import "strings"
+import "strconv"
+
+
+// Use `r` directly if it's already buffered so nothing
+// peeked or buffered by the caller is lost.
+//
+func bufio_reader(r io.Reader) *bufio.Reader {
+  if b,ok := r.(*bufio.Reader) ; ok { return b }
+  return bufio.NewReader(r)
+}
+
+func bufio_writer(w io.Writer) *bufio.Writer {
+  if b,ok := w.(*bufio.Writer) ; ok { return b }
+  return bufio.NewWriter(w)
+}
+
+// Print a variant line of the simple difference format:
+//
+//   chrom  type  start  end  seq
+//
+// where `type` is one of ref, alt, noc (nca or noa if the
+// no-call sequence isn't printed) and `seq` is the reference
+// sequence for ref lines or `alt0/alt1;ref` otherwise.
+//
+func SimpleRefVarPrinter(v *Variant, out io.Writer) error {
+  var e error
+
+  chrom := v.Chrom
+  ref_start := v.Pos
+  ref_end := v.Pos + v.RefLen
+
+  if v.Type == REF {
+
+    if FullRefSeqFlag {
+      _,e = out.Write( []byte(fmt.Sprintf("%s\tref\t%d\t%d\t%s\n", chrom, ref_start, ref_end, v.Ref)) )
+    } else {
+      _,e = out.Write( []byte(fmt.Sprintf("%s\tref\t%d\t%d\t.\n", chrom, ref_start, ref_end)) )
+    }
+
+  } else if v.Type == NOC {
+
+    // The no-call sequence is printed if asked for or if
+    // the first allele has called bases.
+    //
+    noc_seq_flag := FullNocSeqFlag || !_all_noc(v.Alleles[0])
+
+    if FullRefSeqFlag {
+
+      if noc_seq_flag {
+        _,e = out.Write( []byte(fmt.Sprintf("%s\tnoc\t%d\t%d\t%s/%s;%s\n", chrom, ref_start, ref_end, v.Alleles[0], v.Alleles[1], v.Ref)) )
+      } else {
+        _,e = out.Write( []byte(fmt.Sprintf("%s\tnca\t%d\t%d\t%s/%s;%s\n", chrom, ref_start, ref_end, v.Alleles[0], v.Alleles[1], v.Ref)) )
+      }
+
+    } else {
+
+      if noc_seq_flag {
+        _,e = out.Write( []byte(fmt.Sprintf("%s\tnoc\t%d\t%d\t%s/%s;.\n", chrom, ref_start, ref_end, v.Alleles[0], v.Alleles[1])) )
+      } else {
+        _,e = out.Write( []byte(fmt.Sprintf("%s\tnoa\t%d\t%d\t.\n", chrom, ref_start, ref_end)) )
+      }
+    }
+
+  } else if v.Type == ALT {
+
+    _,e = out.Write( []byte(fmt.Sprintf("%s\talt\t%d\t%d\t%s/%s;%s\n", chrom, ref_start, ref_end, v.Alleles[0], v.Alleles[1], v.Ref)) )
+
+  } else if v.Type == MSG {
+
+    if v.Msg.Type == REF {
+      _,e = out.Write( []byte(fmt.Sprintf("%s\tref\t%d\t%d\t.(msg)\n", chrom, ref_start, ref_start+v.Msg.N)) )
+    } else if v.Msg.Type == NOC {
+      _,e = out.Write( []byte(fmt.Sprintf("%s\tnoc\t%d\t%d\t.(msg)\n", chrom, ref_start, ref_start+v.Msg.N)) )
+    }
+
+  }
+
+  return e
+}
+
+// Convert an interleaved (rotini) stream to the simple
+// difference format (see SimpleRefVarPrinter).
+//
+func InterleaveToSimpleDiff(r io.Reader, w io.Writer) error {
+  out := bufio_writer(w)
+  e := InterleaveToDiff(bufio_reader(r), out, SimpleRefVarPrinter)
+  if e!=nil { return e }
+  return out.Flush()
+}
+
+// Convert the simple difference format back to an
+// interleaved (rotini) stream.
+//
+func DiffToInterleave(r io.Reader, w io.Writer) error {
+  n_allele := 2
+  lfmod := 50
+  bp_count := 0
+
+  chrom := ""
+  pos := -1
+
+  first_pass := true
+
+  out := bufio_writer(w)
+
+  scanner := bufio.NewScanner(r)
+  scanner.Buffer(make([]byte, 1<<20), 1<<30)
+
+  line_no := 0
+  for scanner.Scan() {
+    l := scanner.Text()
+    line_no++
+
+    if len(l)==0 { continue }
+
+    diff_parts := strings.Split(l, "\t")
+    if len(diff_parts)<5 { return fmt.Errorf("not enough fields at line %d", line_no) }
+
+    chrom_s := diff_parts[0]
+    type_s := diff_parts[1]
+    st_s := diff_parts[2]
+    field := diff_parts[4]
+
+    control_message := false
+
+    if chrom != chrom_s {
+
+      if !first_pass && !control_message { out.WriteByte('\n') }
+
+      out.WriteString(fmt.Sprintf(">C{%s}", chrom_s))
+      chrom = chrom_s
+
+      control_message = true
+    }
+
+    _st,e := strconv.ParseUint(st_s, 10, 64)
+    if e==nil {
+
+      if pos != int(_st) {
+        if !first_pass && !control_message { out.WriteByte('\n') }
+        out.WriteString(fmt.Sprintf(">P{%d}", _st))
+        pos = int(_st)
+
+        control_message = true
+      }
+
+    }
+
+    if control_message { out.WriteByte('\n') }
+    first_pass = false
+
+    if type_s == "ref" {
+
+      for i:=0; i<len(field); i++ {
+        for a:=0; a<n_allele; a++ {
+          out.WriteByte(field[i])
+
+          bp_count++
+          if (lfmod>0) && ((bp_count%lfmod)==0) { out.WriteByte('\n') }
+        }
+      }
+
+      pos += len(field)
+
+    } else if type_s == "alt" || type_s == "nca"  || type_s == "noc" {
+
+      field_parts := strings.Split(field, ";")
+      if len(field_parts)<2 { return fmt.Errorf("invalid sequence field '%s' at line %d", field, line_no) }
+      alt_parts := strings.Split(field_parts[0], "/")
+      if len(alt_parts)==1 { alt_parts = append(alt_parts, alt_parts[0]) }
+      refseq := field_parts[1]
+
+      mM := len(alt_parts[0])
+      if len(alt_parts[1]) > mM { mM = len(alt_parts[1]) }
+      if len(refseq) > mM { mM = len(refseq) }
+
+      for i:=0; i<mM; i++  {
+
+        for a:=0; a<len(alt_parts); a++ {
+
+          if i<len(alt_parts[a]) {
+            if i<len(refseq) {
+              out.WriteByte(SubMap[refseq[i]][alt_parts[a][i]])
+            } else {
+              out.WriteByte(InsMap[alt_parts[a][i]])
+            }
+          } else if i<len(refseq) {
+            out.WriteByte(DelMap[refseq[i]])
+          } else {
+            out.WriteByte('.')
+          }
+
+          bp_count++
+          if (lfmod>0) && ((bp_count%lfmod)==0) { out.WriteByte('\n') }
+
+        }
+
+      }
+
+      if refseq != "-" {
+        pos += len(refseq)
+      }
+
+    }
+
+  }
+  if e := scanner.Err() ; e!=nil { return e }
+
+  out.WriteByte('\n')
+  return out.Flush()
+}
+
+// Write out the reference (`ind` -1) or alternate (`ind` 0)
+// sequence of a PASTA stream, 50 bases to a line.
+//
+func PastaToHaploid(r io.Reader, w io.Writer, ind int) error {
+  stream := bufio_reader(r)
+  out := bufio_writer(w)
+
+  bp_count:=0
+  lfmod := 50
+
+  for {
+
+    ch0,e0 := stream.ReadByte()
+    for (e0==nil) && ((ch0=='\n') || (ch0==' ') || (ch0=='\r') || (ch0=='\t')) {
+      ch0,e0 = stream.ReadByte()
+    }
+    if e0!=nil { break }
+
+    if ch0=='>' {
+      _,e := ControlMessageProcess(stream)
+      if e!=nil { return fmt.Errorf("invalid control message") }
+      continue
+    }
+
+    // special case: nop
+    //
+    if ch0=='.' { continue }
+
+    if ind==-1 {
+
+      // ref
+
+      if (TokenClass[ch0]&TOKEN_INS)!=0 { continue }
+      out.WriteByte(RefMap[ch0])
+
+      bp_count++
+      if (lfmod>0) && ((bp_count%lfmod)==0) { out.WriteByte('\n') }
+
+    } else if ind==0 {
+
+      // alt0
+
+      if IsAltDel[ch0] { continue }
+
+      out.WriteByte(AltMap[ch0])
+      bp_count++
+      if (lfmod>0) && ((bp_count%lfmod)==0) { out.WriteByte('\n') }
+
+    }
+
+  }
+
+  out.WriteByte('\n')
+  return out.Flush()
+}
+
+// Write out the reference (`ind` -1) or one of the alternate
+// (`ind` 0 or 1) sequences of an interleaved (rotini) stream,
+// 50 bases to a line.
+//
+func InterleaveToHaploid(r io.Reader, w io.Writer, ind int) error {
+  stream := bufio_reader(r)
+  out := bufio_writer(w)
+
+  bp_count:=0
+  lfmod := 50
+
+  for {
+
+    var ch1 byte
+    var e1 error
+
+    ch0,e0 := stream.ReadByte()
+    for (e0==nil) && ((ch0=='\n') || (ch0==' ') || (ch0=='\r') || (ch0=='\t')) {
+      ch0,e0 = stream.ReadByte()
+    }
+    if e0!=nil { break }
+
+    if ch0=='>' {
+      _,e := ControlMessageProcess(stream)
+      if e!=nil { return fmt.Errorf("invalid control message") }
+      continue
+    }
+
+    ch1,e1 = stream.ReadByte()
+    for (e1==nil) && ((ch1=='\n') || (ch1==' ') || (ch1=='\r') || (ch1=='\t')) {
+      ch1,e1 = stream.ReadByte()
+    }
+    if e1!=nil { break }
+
+    // special case: nop
+    //
+    if ch0=='.' && ch1=='.' { continue }
+
+    is_ins0 := (TokenClass[ch0]&TOKEN_INS)!=0
+    is_ins1 := (TokenClass[ch1]&TOKEN_INS)!=0
+
+    if (is_ins0 && (!is_ins1 && ch1!='.')) ||
+       (is_ins1 && (!is_ins0 && ch0!='.')) {
+      out.Flush()
+      return fmt.Errorf("InterleaveToHaploid: insertion mismatch (ch %c,%c ord(%v,%v) @ %v)", ch0, ch1, ch0, ch1, bp_count)
+    }
+
+    if ind==-1 {
+
+      // ref
+
+      if is_ins0 || is_ins1 { continue }
+      if ch0 != '.' {
+
+        och := RefMap[ch0]
+        if och==0 { return fmt.Errorf("InterleaveToHaploid: no character found in stream0 RefMap for %c ord(%d) @ %d", ch0, ch0, bp_count) }
+        out.WriteByte(och)
+      } else {
+
+        och := RefMap[ch1]
+        if och==0 { return fmt.Errorf("InterleaveToHaploid: no character found in stream1 RefMap for %c ord(%d) @ %d", ch1, ch1, bp_count) }
+        out.WriteByte(och)
+      }
+
+      bp_count++
+      if (lfmod>0) && ((bp_count%lfmod)==0) { out.WriteByte('\n') }
+
+    } else if ind==0 {
+
+      // alt0
+
+      if ch0=='.' { continue }
+      if IsAltDel[ch0] { continue }
+
+      och := AltMap[ch0]
+      if och==0 { return fmt.Errorf("InterleaveToHaploid: no character found in stream0 AltMap for %c ord(%d) @ %d", ch0, ch0, bp_count) }
+      out.WriteByte(och)
+
+      bp_count++
+      if (lfmod>0) && ((bp_count%lfmod)==0) { out.WriteByte('\n') }
+
+    } else if ind==1 {
+
+      // alt1
+
+      if ch1=='.' { continue }
+      if IsAltDel[ch1] { continue }
+
+      och := AltMap[ch1]
+      if och==0 { return fmt.Errorf("InterleaveToHaploid: no character found in stream1 AltMap for %c ord(%d) @ %d", ch1, ch1, bp_count) }
+      out.WriteByte(och)
+
+      bp_count++
+      if (lfmod>0) && ((bp_count%lfmod)==0) { out.WriteByte('\n') }
+
+    }
+
+  }
+
+  out.WriteByte('\n')
+  return out.Flush()
+}
@@ -0,0 +1,165 @@
+package pasta
+
+// Registry of the variant formats that can be read into, or
+// written out from, a rotini stream.  Format packages register
+// themselves in their init() so that importing them (even for
+// side effects only) is enough to make them available to
+// Convert.
+//
+
+import "fmt"
+import "io"
+import "bufio"
+import "sort"
+
+
+// A variant format.  `NewReader` and `NewWriter` return
+// initialized readers and writers and are nil if the format
+// can't be read or written.  Writers that need the full
+// reference sequence of each variant say so with
+// FullRefSeqWriter.
+//
+type Format struct {
+  Name string
+  Description string
+
+  NewReader func() VariantReader
+  NewWriter func() VariantWriter
+}
+
+var gFormat map[string]*Format = make(map[string]*Format)
+
+// Add a format to the registry.  Registering the same name twice
+// is a programming error and panics.
+//
+func RegisterFormat(f Format) {
+  if _,ok := gFormat[f.Name] ; ok {
+    panic(fmt.Sprintf("pasta: format %s registered twice", f.Name))
+  }
+  gFormat[f.Name] = &f
+}
+
+func LookupFormat(name string) (*Format, error) {
+  f,ok := gFormat[name]
+  if !ok { return nil, fmt.Errorf("unknown format '%s'", name) }
+  return f, nil
+}
+
+// Sorted names of the registered formats.
+//
+func FormatNames() []string {
+  names := []string{}
+  for name := range gFormat {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names
+}
+
+// Read the line oriented input `r` with `rd`, writing the
+// resulting rotini stream to `w`.  Empty lines are skipped.
+// Readers that implement VariantRefEndReader have the rest of
+// the reference filled in after the last line.
+//
+func ReadVariants(rd VariantReader, r io.Reader, ref_stream *bufio.Reader, w io.Writer) error {
+  out := bufio_writer(w)
+
+  scanner := bufio.NewScanner(r)
+  scanner.Buffer(make([]byte, 1<<20), 1<<30)
+
+  re,has_ref_end := rd.(VariantRefEndReader)
+
+  e := rd.PastaBegin(out)
+  if e!=nil { return e }
+
+  line_no := 0
+  for scanner.Scan() {
+    line := scanner.Text()
+    line_no++
+
+    if len(line)==0 { continue }
+    e = rd.Pasta(line, ref_stream, out)
+    if has_ref_end && e==io.EOF { continue }
+    if e!=nil { return fmt.Errorf("%v at line %v", e, line_no) }
+  }
+  if e := scanner.Err() ; e!=nil { return e }
+
+  if has_ref_end {
+    e = re.PastaRefEnd(ref_stream, out)
+    if (e!=io.EOF) && (e!=nil) { return fmt.Errorf("PastaRefEnd: %v at line %v", e, line_no) }
+  }
+
+  e = rd.PastaEnd(out)
+  if e!=nil { return e }
+  return out.Flush()
+}
+
+// Write out the variants of the rotini stream `r` with `wr`.
+//
+func WriteVariants(wr VariantWriter, r io.Reader, w io.Writer) error {
+  return InterleaveToDiffInterface(bufio_reader(r), wr, w)
+}
+
+// Convert the input `r` read by `rd` to the output of `wr`
+// in-process.  A nil `rd` means the input is already a rotini
+// stream and a nil `wr` means the rotini stream is written out
+// as is.  An error from either side of the conversion is
+// returned.
+//
+func Convert(rd VariantReader, wr VariantWriter, r io.Reader, ref_stream *bufio.Reader, w io.Writer) error {
+
+  if rd==nil && wr==nil {
+    out := bufio_writer(w)
+    _,e := io.Copy(out, r)
+    if e!=nil { return e }
+    return out.Flush()
+  }
+
+  if rd==nil { return WriteVariants(wr, r, w) }
+  if wr==nil { return ReadVariants(rd, r, ref_stream, w) }
+
+  pr,pw := io.Pipe()
+  read_err := make(chan error, 1)
+
+  go func() {
+    e := ReadVariants(rd, r, ref_stream, pw)
+    pw.CloseWithError(e)
+    read_err <- e
+  }()
+
+  e := WriteVariants(wr, pr, w)
+
+  // Unblock the reader if we stopped early, then wait for it
+  // so its error isn't lost.
+  //
+  pr.Close()
+  re := <-read_err
+
+  if e!=nil { return e }
+  return re
+}
+
+// Convert between the registered formats `from` and `to`.
+// "rotini" can be used for either to read or write a rotini
+// stream directly.
+//
+func ConvertFormat(from, to string, r io.Reader, ref_stream *bufio.Reader, w io.Writer) error {
+  var rd VariantReader
+  var wr VariantWriter
+
+  if from!="rotini" {
+    f,e := LookupFormat(from)
+    if e!=nil { return e }
+    if f.NewReader==nil { return fmt.Errorf("format '%s' can't be read", from) }
+    rd = f.NewReader()
+  }
+
+  if to!="rotini" {
+    f,e := LookupFormat(to)
+    if e!=nil { return e }
+    if f.NewWriter==nil { return fmt.Errorf("format '%s' can't be written", to) }
+    wr = f.NewWriter()
+  }
+
+  return Convert(rd, wr, r, ref_stream, w)
+}
@@ -3,15 +3,68 @@ package pasta
 import "bufio"
 
 
-type RefVarPrinter interface {
+// Writes out the variants of a rotini stream in some format.  The
+// variants are handed over, in order, by InterleaveToDiffInterface
+// and are only valid for the duration of the call to Print.
+//
+type VariantWriter interface {
   Header(out *bufio.Writer) error
-  Print(vartype int, ref_start, ref_len int, refseq []byte, altseq [][]byte, out *bufio.Writer) error
+  Print(v *Variant, out *bufio.Writer) error
   PrintEnd(out *bufio.Writer) error
-  Pasta(line string, ref_stream *bufio.Reader, out *bufio.Writer) error
-  PastaBegin(out *bufio.Writer) error
-  PastaEnd(out *bufio.Writer) error
   Chrom(chr string)
   Pos(pos int)
   GetRefPos() int
   Init()
 }
+
+// Writers that implement this interface decide whether every
+// record carries its full reference sequence (e.g. for anchor
+// bases), whatever FullRefSeqFlag is set to.  It's an option of
+// the conversion rather than a change to the global flag, so
+// conversions can run concurrently.
+//
+type FullRefSeqWriter interface {
+  FullRefSeq() bool
+}
+
+type _full_ref_seq_writer struct {
+  VariantWriter
+  full_ref_seq bool
+}
+
+func (w *_full_ref_seq_writer) FullRefSeq() bool { return w.full_ref_seq }
+
+// Give `wr` the full reference sequence of every record, or not,
+// as `full_ref_seq` says.  Writers that need it always get it.
+//
+func WithFullRefSeq(wr VariantWriter, full_ref_seq bool) VariantWriter {
+  if f,ok := wr.(FullRefSeqWriter) ; ok && f.FullRefSeq() { return wr }
+  return &_full_ref_seq_writer{wr, full_ref_seq}
+}
+
+// Reads a line oriented format into a rotini stream, a line at a
+// time, taking reference bases from `ref_stream` as needed.
+//
+type VariantReader interface {
+  Pasta(line string, ref_stream *bufio.Reader, out *bufio.Writer) error
+  PastaBegin(out *bufio.Writer) error
+  PastaEnd(out *bufio.Writer) error
+  Init()
+}
+
+// Readers of formats that only have records for part of the
+// reference (GFF, GVF) fill in the reference after the last record
+// with PastaRefEnd.  io.EOF from Pasta or PastaRefEnd just means
+// the reference stream has run out.
+//
+type VariantRefEndReader interface {
+  VariantReader
+  PastaRefEnd(ref_stream *bufio.Reader, out *bufio.Writer) error
+}
+
+// Formats that can be both read and written.
+//
+type RefVarPrinter interface {
+  VariantWriter
+  VariantReader
+}
@@ -3,6 +3,8 @@ package pasta
 import "fmt"
 import "bufio"
 import "strconv"
+import "strings"
+import "sort"
 
 
 func ControlMessagePrint(msg *ControlMessage, out *bufio.Writer) {
@@ -12,15 +14,114 @@ func ControlMessagePrint(msg *ControlMessage, out *bufio.Writer) {
   } else if msg.Type == POS {
     out.WriteString(fmt.Sprintf(">P{%d}", msg.RefPos))
   } else if msg.Type == NOC {
-    out.WriteString(fmt.Sprintf(">P{%d}", msg.N))
+    out.WriteString(fmt.Sprintf(">N{%d}", msg.N))
   } else if msg.Type == CHROM {
     out.WriteString(fmt.Sprintf(">C{%s}", msg.Chrom))
   } else if msg.Type == COMMENT {
     out.WriteString(fmt.Sprintf(">#{%s}", msg.Comment))
+  } else if msg.Type == ANNOT {
+    out.WriteString(fmt.Sprintf(">A{%s}", AnnotationString(msg.Annot)))
   }
 
 }
 
+// Parse the body of an annotation message (e.g. "GQ=30;DP=12").
+// Keys without a value are stored with an empty value.
+//
+func ParseAnnotation(s string) map[string]string {
+  annot := make(map[string]string)
+  if len(s)==0 { return annot }
+
+  parts := strings.Split(s, ";")
+  for ii:=0; ii<len(parts); ii++ {
+    if len(parts[ii])==0 { continue }
+    kv := strings.SplitN(parts[ii], "=", 2)
+    if len(kv)==1 {
+      annot[kv[0]] = ""
+    } else {
+      annot[kv[0]] = kv[1]
+    }
+  }
+
+  return annot
+}
+
+// Format an annotation map as the body of an annotation
+// message, with keys sorted so the output is stable.
+//
+func AnnotationString(annot map[string]string) string {
+  keys := make([]string, 0, len(annot))
+  for k := range annot { keys = append(keys, k) }
+  sort.Strings(keys)
+
+  parts := make([]string, 0, len(keys))
+  for ii:=0; ii<len(keys); ii++ {
+    if len(annot[keys[ii]])==0 {
+      parts = append(parts, keys[ii])
+    } else {
+      parts = append(parts, keys[ii] + "=" + annot[keys[ii]])
+    }
+  }
+  return strings.Join(parts, ";")
+}
+
+// TokenReader reads the tokens of a stream a buffer at a time,
+// skipping whitespace, rather than a byte at a time.  When it
+// returns the '>' starting a control message the underlying
+// reader is left just after it, so the message can be read with
+// ControlMessageProcess before asking for the next token.  The
+// underlying reader shouldn't be read from otherwise.
+//
+type TokenReader struct {
+  Stream *bufio.Reader
+
+  win []byte
+  off int
+}
+
+func NewTokenReader(stream *bufio.Reader) *TokenReader {
+  return &TokenReader{ Stream:stream }
+}
+
+func (t *TokenReader) Next() (byte, error) {
+  for {
+    for t.off < len(t.win) {
+      ch := t.win[t.off]
+      t.off++
+      if (TokenClass[ch]&TOKEN_WS)!=0 { continue }
+
+      if ch=='>' {
+        t.Stream.Discard(t.off)
+        t.win = nil
+        t.off = 0
+      }
+      return ch, nil
+    }
+
+    if t.off>0 { t.Stream.Discard(t.off) }
+    t.win = nil
+    t.off = 0
+
+    _,e := t.Stream.Peek(1)
+    if e!=nil { return 0, e }
+    t.win,_ = t.Stream.Peek(t.Stream.Buffered())
+  }
+}
+
+// to lower [a-z]
+//
+func ToLower(A byte) byte {
+  if A >= 'A' && A <= 'Z' { return A - 'A' + 'a' }
+  return A
+}
+
+// to upper [A-Z]
+//
+func ToUpper(a byte) byte {
+  if a >= 'a' && a <= 'z' { return a - 'a' + 'A' }
+  return a
+}
+
 func ControlMessageProcess(stream *bufio.Reader) (ControlMessage, error) {
   var msg ControlMessage
 
@@ -39,6 +140,8 @@ func ControlMessageProcess(stream *bufio.Reader) (ControlMessage, error) {
     msg.Type = POS
   } else if ch == '#' {
     msg.Type = COMMENT
+  } else if ch == 'A' {
+    msg.Type = ANNOT
   } else {
     return msg, fmt.Errorf("Invalid control character %c", ch)
   }
@@ -79,6 +182,8 @@ func ControlMessageProcess(stream *bufio.Reader) (ControlMessage, error) {
     msg.Chrom = string(field_str)
   } else if msg.Type == COMMENT {
     msg.Comment = string(field_str)
+  } else if msg.Type == ANNOT {
+    msg.Annot = ParseAnnotation(string(field_str))
   }
   return msg, nil
 
@@ -0,0 +1,450 @@
+package pasta
+
+import "fmt"
+import "io"
+import "bufio"
+import "bytes"
+import "strconv"
+
+
+// A single record of an interleaved (rotini) stream: a run of
+// reference, a no-call region, an alternate region or a reference
+// or no-call control message (`Type` REF, NOC, ALT or MSG_REF_NOC).
+//
+// `Alleles` holds the sequence of each haplotype over the record
+// ("-" if empty) and is nil for reference runs before the end of
+// the stream.  `Genotype` gives, for each haplotype, 0 if it
+// matches `Ref`, otherwise 1 plus the index of its sequence among
+// the distinct non-reference alleles, or -1 if it has any
+// no-call bases (also flagged in `NoCall`).
+//
+// Rotini haplotypes are phased over the whole stream so
+// `PhaseSet` is 0 unless the annotation in effect gives a "PS".
+//
+type Variant struct {
+  Type int
+  Chrom string
+  Pos int
+  RefLen int
+  Ref []byte
+  Alleles [][]byte
+  Genotype []int
+  PhaseSet int
+  Annot map[string]string
+  NoCall []bool
+
+  // Reference base anchoring the record (the base before it for
+  // alternate records following a reference run).
+  //
+  RefBP byte
+
+  // The control message of MSG_REF_NOC records.
+  //
+  Msg ControlMessage
+}
+
+// Steps through the records of an interleaved (rotini) stream.
+// The *Variant returned by Next, and the slices it holds, are
+// only valid until the next call to Next.
+//
+type VariantIterator struct {
+
+  // Collect the full reference sequence of reference runs rather
+  // than just their extent.  Init sets it from FullRefSeqFlag.
+  //
+  FullRefSeq bool
+
+  tr *TokenReader
+  stream *bufio.Reader
+
+  chrom string
+  chrom_seen bool
+  annot map[string]string
+
+  alt0 []byte
+  alt1 []byte
+  refseq []byte
+
+  ref_start int
+  ref0_len int
+  ref1_len int
+
+  stream0_pos int
+  stream1_pos int
+
+  bp_anchor_ref byte
+  bp_anchor_prv byte
+
+  curStreamState int
+  prvStreamState int
+
+  msg ControlMessage
+  prev_msg ControlMessage
+
+  done bool
+
+  v Variant
+  v_ref []byte
+  v_gt [2]int
+  v_noc [2]bool
+}
+
+// Start iterating over `stream` with the reference at `ref_start`.
+//
+func (it *VariantIterator) Init(stream *bufio.Reader, ref_start int) {
+  it.FullRefSeq = FullRefSeqFlag
+
+  it.tr = NewTokenReader(stream)
+  it.stream = stream
+
+  it.chrom = "Unk"
+  it.chrom_seen = false
+  it.annot = nil
+
+  it.alt0 = []byte{}
+  it.alt1 = []byte{}
+  it.refseq = []byte{}
+
+  it.ref_start = ref_start
+  it.ref0_len = 0
+  it.ref1_len = 0
+
+  it.stream0_pos = 0
+  it.stream1_pos = 0
+
+  it.curStreamState = BEG
+  it.prvStreamState = BEG
+
+  it.done = false
+}
+
+// Chromosome in effect at the current point of the stream.
+//
+func (it *VariantIterator) Chrom() string { return it.chrom }
+
+// Whether `seq` is all no-call, an empty allele ("-") counting
+// as no-call.
+//
+func _all_noc(seq []byte) bool {
+  for ii:=0; ii<len(seq); ii++ {
+    if (seq[ii]!='n') && (seq[ii]!='-') { return false }
+  }
+  return true
+}
+
+func _dash_seq(seq []byte) []byte {
+  if len(seq)==0 { return []byte("-") }
+  return []byte(string(seq))
+}
+
+// Fill in the current variant.  `refseq` is copied as the
+// iterator's buffer is reused for the next record.
+//
+func (it *VariantIterator) _emit(vartype, ref_start, ref_len int, refseq []byte, altseq [][]byte, ref_bp byte) *Variant {
+  v := &it.v
+
+  it.v_ref = append(it.v_ref[0:0], refseq...)
+
+  v.Type = vartype
+  v.Chrom = it.chrom
+  v.Pos = ref_start
+  v.RefLen = ref_len
+  v.Ref = it.v_ref
+  if refseq==nil { v.Ref = nil }
+  v.Alleles = altseq
+  v.Annot = it.annot
+  v.RefBP = ref_bp
+
+  v.Msg = ControlMessage{}
+  if vartype==MSG_REF_NOC { v.Msg = it.prev_msg }
+
+  v.PhaseSet = 0
+  if it.annot!=nil {
+    if ps,e := strconv.Atoi(it.annot["PS"]) ; e==nil { v.PhaseSet = ps }
+  }
+
+  v.Genotype = it.v_gt[:]
+  v.NoCall = it.v_noc[:]
+
+  for ii:=0; ii<2; ii++ {
+    v.NoCall[ii] = false
+    v.Genotype[ii] = 0
+
+    if (vartype==MSG_REF_NOC) && (it.prev_msg.Type==NOC) { v.NoCall[ii] = true }
+
+    if ii>=len(altseq) { continue }
+    if bytes.IndexByte(altseq[ii], 'n')>=0 { v.NoCall[ii] = true }
+  }
+
+  for ii:=0; ii<2; ii++ {
+    if v.NoCall[ii] { v.Genotype[ii] = -1 ; continue }
+    if (vartype==REF) || (ii>=len(altseq)) || bytes.Equal(altseq[ii], refseq) { continue }
+
+    v.Genotype[ii] = 1
+    for jj:=0; jj<ii; jj++ {
+      if (v.Genotype[jj]>0) && bytes.Equal(altseq[jj], altseq[ii]) {
+        v.Genotype[ii] = v.Genotype[jj]
+        break
+      } else if v.Genotype[jj]>0 {
+        v.Genotype[ii]++
+      }
+    }
+  }
+
+  return v
+}
+
+func (it *VariantIterator) _reset() {
+  it.ref0_len=0
+  it.ref1_len=0
+
+  it.alt0 = it.alt0[0:0]
+  it.alt1 = it.alt1[0:0]
+  it.refseq = it.refseq[0:0]
+}
+
+// Whatever record is left at the end of the stream, nil if none.
+//
+func (it *VariantIterator) _end() *Variant {
+
+  if it.prvStreamState == REF {
+
+    return it._emit(REF, it.ref_start, it.ref0_len, it.refseq, [][]byte{it.alt0, it.alt1}, it.bp_anchor_ref)
+
+  } else if it.prvStreamState == NOC {
+
+    return it._emit(NOC, it.ref_start, it.ref0_len, it.refseq, [][]byte{it.alt0, it.alt1}, it.bp_anchor_ref)
+
+  } else if it.prvStreamState == ALT {
+
+    return it._emit(ALT, it.ref_start, it.ref0_len, _dash_seq(it.refseq), [][]byte{_dash_seq(it.alt0), _dash_seq(it.alt1)}, it.bp_anchor_prv)
+
+  } else if it.prvStreamState == MSG_REF_NOC {
+
+    return it._emit(MSG_REF_NOC, it.ref_start, it.prev_msg.N, nil, nil, it.bp_anchor_ref)
+
+  } else if it.prvStreamState == MSG_CHROM {
+    it.chrom = it.prev_msg.Chrom
+  }
+
+  return nil
+}
+
+// Return the next record of the stream, or io.EOF once
+// the stream is exhausted.  Any other error reading the
+// stream is returned as is.
+//
+func (it *VariantIterator) Next() (*Variant, error) {
+  var e error
+
+  var ch1 byte
+  var e1 error
+
+  var dbp0 int
+  var dbp1 int
+
+  for !it.done {
+    var v *Variant
+
+    is_ref0 := false
+    is_ref1 := false
+
+    is_noc0 := false
+    is_noc1 := false
+
+    message_processed_flag := false
+
+    ch0,e0 := it.tr.Next()
+    if (e0!=nil) && (e0!=io.EOF) { return nil, e0 }
+    if e0!=nil {
+      it.done = true
+      if v = it._end() ; v!=nil { return v, nil }
+      break
+    }
+
+    if ch0=='>' {
+      it.msg,e = ControlMessageProcess(it.stream)
+      if e!=nil { return nil, fmt.Errorf(fmt.Sprintf("invalid control message %v (%v)", it.msg, e)) }
+
+      if (it.msg.Type == REF) || (it.msg.Type == NOC) {
+        it.curStreamState = MSG_REF_NOC
+      } else if it.msg.Type == CHROM {
+        it.curStreamState = MSG_CHROM
+      } else if it.msg.Type == POS {
+        it.curStreamState = MSG_POS
+      } else if it.msg.Type == ANNOT {
+        it.curStreamState = MSG_ANNOT
+      } else {
+        //just ignore
+        continue
+      }
+
+      message_processed_flag = true
+    }
+
+    if !message_processed_flag {
+      ch1,e1 = it.tr.Next()
+      if (e1!=nil) && (e1!=io.EOF) { return nil, e1 }
+      if e1!=nil {
+        it.done = true
+        if v = it._end() ; v!=nil { return v, nil }
+        break
+      }
+
+      it.stream0_pos++
+      it.stream1_pos++
+
+      // special case: nop
+      //
+      if ch0=='.' && ch1=='.' { continue }
+
+      dbp0 = RefDelBP[ch0]
+      dbp1 = RefDelBP[ch1]
+
+      is_ref0 = (TokenClass[ch0]&TOKEN_REF)!=0
+      is_noc0 = (TokenClass[ch0]&TOKEN_NOC)!=0
+
+      is_ref1 = (TokenClass[ch1]&TOKEN_REF)!=0
+      is_noc1 = (TokenClass[ch1]&TOKEN_NOC)!=0
+
+      if is_ref0 && is_ref1 {
+        it.curStreamState = REF
+      } else if is_noc0 || is_noc1 {
+        it.curStreamState = NOC
+      } else {
+        it.curStreamState = ALT
+      }
+
+    }
+
+    if it.curStreamState == BEG {
+
+      if !is_ref0 || !is_ref1 || it.FullRefSeq {
+        if bp := RefMap[ch0] ; bp!=0 {
+          it.refseq = append(it.refseq, bp)
+          it.bp_anchor_ref = bp
+        } else if bp := RefMap[ch1] ; bp!=0 {
+          it.refseq = append(it.refseq, bp)
+          it.bp_anchor_ref = bp
+        }
+      }
+
+      it.ref0_len+=dbp0
+      it.ref1_len+=dbp1
+
+      if bp_val := AltMap[ch0] ; bp_val!=0 { it.alt0 = append(it.alt0, bp_val) }
+      if bp_val := AltMap[ch1] ; bp_val!=0 { it.alt1 = append(it.alt1, bp_val) }
+
+      it.prvStreamState = it.curStreamState
+      it.prev_msg = it.msg
+
+      continue
+    }
+
+    if !message_processed_flag {
+      if is_ref0 && is_ref1 && ch0!=ch1 {
+        return nil, fmt.Errorf(fmt.Sprintf("ERROR: stream position (%d,%d), stream0 token %c (%d), stream1 token %c (%d)",
+          it.stream0_pos, it.stream1_pos, ch0, ch0, ch1, ch1))
+      }
+    }
+
+    prv := it.prvStreamState
+    cur := it.curStreamState
+
+    if (prv == REF) && (cur != REF) {
+
+      v = it._emit(REF, it.ref_start, it.ref0_len, it.refseq, nil, it.bp_anchor_ref)
+
+      // Save the last ref BP in case the ALT is an indel.
+      //
+      it.bp_anchor_prv = '-'
+      if len(it.refseq)>0 { it.bp_anchor_prv = it.refseq[len(it.refseq)-1] }
+
+      it.ref_start += it.ref0_len
+      it._reset()
+
+    } else if (prv == NOC) && (cur != NOC) {
+
+      v = it._emit(NOC, it.ref_start, it.ref0_len, _dash_seq(it.refseq), [][]byte{_dash_seq(it.alt0), _dash_seq(it.alt1)}, it.bp_anchor_ref)
+
+      // Save the last ref BP in case the ALT is an indel.
+      //
+      it.bp_anchor_prv = '-'
+      if len(it.refseq)>0 { it.bp_anchor_prv = it.refseq[len(it.refseq)-1] }
+
+      it.ref_start += it.ref0_len
+      it._reset()
+
+    } else if (prv == ALT) && ((cur == REF) || (cur == NOC) || (cur == MSG_ANNOT) ||
+        (cur == MSG_CHROM) || (cur == MSG_POS)) {
+
+      v = it._emit(ALT, it.ref_start, it.ref0_len, _dash_seq(it.refseq), [][]byte{_dash_seq(it.alt0), _dash_seq(it.alt1)}, it.bp_anchor_prv)
+
+      it.ref_start += it.ref0_len
+      it._reset()
+
+    } else if prv == MSG_REF_NOC {
+
+      v = it._emit(MSG_REF_NOC, it.ref_start, it.prev_msg.N, it.refseq, nil, it.bp_anchor_ref)
+
+      it.ref_start += it.prev_msg.N
+
+      it.stream0_pos += it.prev_msg.N
+      it.stream1_pos += it.prev_msg.N
+
+      it._reset()
+
+    } else if prv == MSG_CHROM {
+
+      // A new chromosome starts over at position 0,
+      // unless a position message follows.
+      //
+      if it.chrom_seen && (it.prev_msg.Chrom != it.chrom) { it.ref_start = 0 }
+      it.chrom_seen = true
+
+      it.chrom = it.prev_msg.Chrom
+    } else if prv == MSG_POS {
+      it.ref_start = it.prev_msg.RefPos
+    } else if prv == MSG_ANNOT {
+      it.annot = it.prev_msg.Annot
+    } else {
+      // The current state matches the previous state.
+      // Either both the current tokens are non-ref as well as the previous tokens
+      // or both the current token and previous tokens are ref.
+    }
+
+    if !message_processed_flag {
+      if bp_val := AltMap[ch0] ; bp_val!=0 { it.alt0 = append(it.alt0, bp_val) }
+      if bp_val := AltMap[ch1] ; bp_val!=0 { it.alt1 = append(it.alt1, bp_val) }
+
+      if !is_ref0 || !is_ref1 || it.FullRefSeq {
+
+        if bp := RefMap[ch0] ; bp!=0 {
+          it.refseq = append(it.refseq, bp)
+          if it.ref0_len==0 { it.bp_anchor_ref = bp }
+        } else if bp := RefMap[ch1] ; bp!=0 {
+          it.refseq = append(it.refseq, bp)
+          if it.ref0_len==0 { it.bp_anchor_ref = bp }
+        }
+      } else if it.ref0_len==0 {
+
+        if bp := RefMap[ch0] ; bp!=0 {
+          it.bp_anchor_ref = bp
+        } else if bp := RefMap[ch1] ; bp!=0 {
+          it.bp_anchor_ref = bp
+        }
+      }
+
+      it.ref0_len+=dbp0
+      it.ref1_len+=dbp1
+
+    }
+
+    it.prvStreamState = it.curStreamState
+    if message_processed_flag { it.prev_msg = it.msg }
+
+    if v!=nil { return v, nil }
+  }
+
+  return nil, io.EOF
+}